@@ -32,12 +32,67 @@ package options
 
 import (
 	"flag"
+	"fmt"
+	"k8s.io/apimachinery/pkg/labels"
 	klog "k8s.io/klog/v2"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// Default units of the memory quota defined in quota trees
+const DefaultQuotaMemoryUnit = "Mi"
+
+// Rounding of fractional millicore CPU demands converted to quota demands
+const (
+	QuotaCPURoundingCeil  = "ceil"
+	QuotaCPURoundingTrunc = "trunc"
+)
+
+// Order of the preemption targets of equal priority
+const (
+	PreemptionOrderYoungestFirst = "YoungestFirst"
+	PreemptionOrderOldestFirst   = "OldestFirst"
+	PreemptionOrderLargestFirst  = "LargestFirst"
+)
+
+// Default prefix of the AppWrapper annotation keys designating quota groups, followed by the tree name
+const DefaultQuotaAnnotationPrefix = "quota.mcad.io/"
+
+// Number of bytes of the supported quota memory units
+var quotaMemoryUnitBytes = map[string]float64{
+	"bytes": 1,
+	"M":     1000 * 1000,
+	"Mi":    1024 * 1024,
+	"Gi":    1024 * 1024 * 1024,
+}
+
+// Canonical resource types quota tree resource types are mapped to
+const (
+	QuotaResourceCPU       = "cpu"
+	QuotaResourceMemory    = "memory"
+	QuotaResourceGPU       = "gpu"
+	QuotaResourceGPUMemory = "gpu-memory"
+	// Physical GPUs of time-sliced nodes, the GPU replicas divided by the GPU sharing factor of the nodes
+	QuotaResourcePhysicalGPU = "physical-gpu"
+)
+
+// Default mapping of quota tree resource types to canonical resource types
+var defaultQuotaResourceAliases = map[string]string{
+	"cpu":                   QuotaResourceCPU,
+	"vcpu":                  QuotaResourceCPU,
+	"cores":                 QuotaResourceCPU,
+	"memory":                QuotaResourceMemory,
+	"mem":                   QuotaResourceMemory,
+	"gpu":                   QuotaResourceGPU,
+	"nvidia.com/gpu":        QuotaResourceGPU,
+	"gpu-memory":            QuotaResourceGPUMemory,
+	"gpumem":                QuotaResourceGPUMemory,
+	"nvidia.com/gpu-memory": QuotaResourceGPUMemory,
+	"physical-gpu":          QuotaResourcePhysicalGPU,
+}
+
 // ServerOption is the main context object for the controller manager.
 type ServerOption struct {
 	Master          string
@@ -52,9 +107,34 @@ type ServerOption struct {
 	// Head of line job will not be bumped away for at least HeadOfLineHoldingTime seconds by higher priority jobs.
 	// Default setting to 0 disables this mechanism.
 	HeadOfLineHoldingTime int
-	QuotaEnabled          bool	// Controller is to evaluate quota per request
-	QuotaRestURL          string
-	HealthProbeListenAddr string
+	// AppWrappers dispatched less than MinPreemptionAge seconds ago are not preempted to free quota.
+	// Default setting to 0 disables this mechanism.
+	MinPreemptionAge int
+	// Quota preemptions are not allowed when their cost exceeds PreemptionCostThreshold seconds of work of
+	// the preempting AppWrapper, see quota.PreemptionCost.  Default setting to 0 disables this mechanism.
+	PreemptionCostThreshold int
+	PreemptionOrder         string // Order of the preemption targets of equal priority: YoungestFirst, OldestFirst or LargestFirst
+	// Seconds an AppWrapper waits for quota before its priority is boosted: a default deadline and
+	// tree=seconds deadlines of specific trees separated by commas(,), e.g. "3600,gpu-tree=600".
+	// Default setting of empty or 0 disables this mechanism.
+	QuotaAllocationDeadline            string
+	QuotaEnabled                       bool // Controller is to evaluate quota per request
+	QuotaRestURL                       string
+	QuotaMemoryUnit                    string // Units of the memory quota defined in quota trees: bytes, M, Mi or Gi
+	QuotaTreeFile                      string // ResourcePlanList file defining static quota trees, replaces the ResourcePlan informer
+	QuotaResourceAliases               string // Additional quota tree resource type aliases: alias=canonical separated by commas(,)
+	QuotaGPUVendorResources            string // Vendor GPU resource names of quota tree resource types: type=name[|name] separated by commas(,)
+	QuotaTreeRemap                     string // Legacy quota label keys of renamed quota trees: old=new separated by commas(,)
+	QuotaAnnotationPrefix              string // Prefix of the annotation keys designating quota groups, empty to only use labels
+	QuotaCPURounding                   string // Rounding of fractional millicore CPU demands: ceil or trunc
+	QuotaLoadWorkers                   int    // Number of workers replaying the dispatched AppWrappers into the quota manager at startup
+	QuotaLoadTimeout                   int    // Seconds before the replay of the dispatched AppWrappers is abandoned, 0 for no timeout
+	QuotaAppWrapperSelector            string // Label selector of the AppWrappers subject to quota, empty for all AppWrappers
+	DefaultQuotaTree                   string // Quota tree of the AppWrappers designating no quota group, empty to reject them
+	DefaultQuotaGroup                  string // Quota group of the DefaultQuotaTree designated to the AppWrappers designating no quota group
+	AutoReleaseOnDelete                bool   // Quota of deleted AppWrappers is released on the AppWrapper delete event
+	AllowQuotaExemption                bool   // AppWrappers annotated quota.mcad.io/exempt=true bypass quota
+	HealthProbeListenAddr              string
 	DispatchResourceReservationTimeout int64
 }
 
@@ -78,8 +158,26 @@ func (s *ServerOption) AddFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&s.Preemption, "preemption", s.Preemption, "Set controller to allow preemption if set to true. Note: when set to true, the Kubernetes Scheduler must be configured to enable preemption.  Default is false.")
 	fs.IntVar(&s.BackoffTime, "backofftime", s.BackoffTime, "Number of seconds a job will go away for, if it can not be scheduled.  Default is 20.")
 	fs.IntVar(&s.HeadOfLineHoldingTime, "headoflineholdingtime", s.HeadOfLineHoldingTime, "Number of seconds a job can stay at the Head Of Line without being bumped.  Default is 0.")
+	fs.IntVar(&s.MinPreemptionAge, "minPreemptionAge", s.MinPreemptionAge, "Number of seconds since dispatch before an AppWrapper can be preempted to free quota.  Default is 0.")
+	fs.IntVar(&s.PreemptionCostThreshold, "preemptionCostThreshold", s.PreemptionCostThreshold, "Number of seconds of work of an AppWrapper its quota preemptions may throw away: the sum of the quota share times the seconds since dispatch of the preempted AppWrappers must not exceed the quota share of the AppWrapper times this threshold.  Default is 0, no limit.")
+	fs.StringVar(&s.QuotaAllocationDeadline, "quotaAllocationDeadline", s.QuotaAllocationDeadline, "Number of seconds an AppWrapper waits for the quota of a tree before its priority in the tree is boosted to the highest priority of the consumers of the tree, e.g. '3600,gpu-tree=600' for a default deadline and the deadline of a specific tree.  Default is none.")
+	fs.StringVar(&s.PreemptionOrder, "preemptionOrder", s.PreemptionOrder, "Order of the preemption targets of equal priority, YoungestFirst, OldestFirst or LargestFirst.  Remaining ties are broken by most recent dispatch, then by name.  Default is YoungestFirst.")
 	fs.BoolVar(&s.QuotaEnabled,"quotaEnabled", s.QuotaEnabled,"Enable quota policy evaluation.  Default is false.")
 	fs.StringVar(&s.QuotaRestURL, "quotaURL", s.QuotaRestURL, "URL for ReST quota management.  Default is none.")
+	fs.StringVar(&s.QuotaMemoryUnit, "quotaMemoryUnit", s.QuotaMemoryUnit, "Units of the memory quota defined in quota trees, one of bytes, M, Mi or Gi.  Default is Mi.")
+	fs.StringVar(&s.QuotaTreeFile, "quotaTreeFile", s.QuotaTreeFile, "Path to a JSON or YAML ResourcePlanList file defining static quota trees.  ResourcePlans are not watched when set.  Default is none.")
+	fs.StringVar(&s.QuotaResourceAliases, "quotaResourceAliases", s.QuotaResourceAliases, "Quota tree resource type aliases of the cpu, memory, gpu, gpu-memory and physical-gpu resource types, e.g. 'vcpu=cpu,mem=memory', added to the default aliases.  Default is none.")
+	fs.StringVar(&s.QuotaGPUVendorResources, "quotaGPUVendorResources", s.QuotaGPUVendorResources, "GPU resource names consumed by quota tree resource types, e.g. 'nvidia-gpu=nvidia.com/gpu,amd-gpu=amd.com/gpu', multiple resource names of a type are separated by '|'.  Resource types not listed keep the default gpu matching.  Default is none.")
+	fs.StringVar(&s.QuotaTreeRemap, "quotaTreeRemap", s.QuotaTreeRemap, "Quota label keys of renamed quota trees, e.g. 'old-tree=new-tree', resolving legacy AppWrapper labels to the renamed trees.  Default is none.")
+	fs.StringVar(&s.QuotaAnnotationPrefix, "quotaAnnotationPrefix", s.QuotaAnnotationPrefix, "Prefix of the AppWrapper annotation keys designating quota groups, followed by the quota tree name.  Quota labels take precedence over annotations.  An empty prefix disables quota annotations.  Default is quota.mcad.io/.")
+	fs.StringVar(&s.QuotaCPURounding, "quotaCPURounding", s.QuotaCPURounding, "Rounding of fractional millicore CPU demands evaluated against quota, ceil to round up or trunc to round down.  Default is ceil.")
+	fs.IntVar(&s.QuotaLoadWorkers, "quotaLoadWorkers", s.QuotaLoadWorkers, "Number of workers converting the demands of the dispatched AppWrappers replayed into the quota manager at startup, the allocations are serialized.  Default is 1.")
+	fs.IntVar(&s.QuotaLoadTimeout, "quotaLoadTimeout", s.QuotaLoadTimeout, "Number of seconds before the replay of the dispatched AppWrappers into the quota manager at startup is abandoned.  Default is 0, no timeout.")
+	fs.StringVar(&s.QuotaAppWrapperSelector, "quotaAppWrapperSelector", s.QuotaAppWrapperSelector, "Label selector of the AppWrappers subject to quota, e.g. 'team in (a,b)'.  AppWrappers not matching the selector fit without quota being applied.  Default is none, all AppWrappers are subject to quota.")
+	fs.StringVar(&s.DefaultQuotaTree, "defaultQuotaTree", s.DefaultQuotaTree, "Quota tree designated to the AppWrappers whose labels and annotations designate no valid quota group, along with the defaultQuotaGroup.  Default is none, such AppWrappers do not fit.")
+	fs.StringVar(&s.DefaultQuotaGroup, "defaultQuotaGroup", s.DefaultQuotaGroup, "Quota group of the defaultQuotaTree designated to the AppWrappers whose labels and annotations designate no valid quota group.  Default is none.")
+	fs.BoolVar(&s.AutoReleaseOnDelete, "autoReleaseOnDelete", s.AutoReleaseOnDelete, "Release the quota of AppWrappers when their delete event is received, e.g. after a forced deletion.  Default is false.")
+	fs.BoolVar(&s.AllowQuotaExemption, "allowQuotaExemption", s.AllowQuotaExemption, "Allow AppWrappers annotated quota.mcad.io/exempt=true, e.g. critical system AppWrappers, to always fit without allocating quota.  Exempt AppWrappers are never preempted to free quota.  Default is false.")
 	fs.IntVar(&s.SecurePort, "secure-port", 6443, "The port on which to serve secured, authenticated access for metrics.")
 	fs.StringVar(&s.HealthProbeListenAddr, "healthProbeListenAddr", ":8081", "Listen address for health probes. Defaults to ':8081'")
 	fs.Int64Var(&s.DispatchResourceReservationTimeout, "dispatchResourceReservationTimeout", s.DispatchResourceReservationTimeout, "Resource reservation timeout for pods to be created once AppWrapper is dispatched, in millisecond.  Defaults to '300000', 5 minutes")
@@ -127,6 +225,36 @@ func (s *ServerOption) loadDefaultsFromEnvVars() {
 		}
 	}
 
+	minPreemptionAgeString, envVarExists := os.LookupEnv("MIN_PREEMPTION_AGE")
+	s.MinPreemptionAge = 0
+	if envVarExists {
+		minPreemptionAgeInt, err := strconv.Atoi(minPreemptionAgeString)
+		if err == nil {
+			s.MinPreemptionAge = minPreemptionAgeInt
+		}
+	}
+
+	preemptionCostThresholdString, envVarExists := os.LookupEnv("PREEMPTION_COST_THRESHOLD")
+	s.PreemptionCostThreshold = 0
+	if envVarExists {
+		preemptionCostThresholdInt, err := strconv.Atoi(preemptionCostThresholdString)
+		if err == nil {
+			s.PreemptionCostThreshold = preemptionCostThresholdInt
+		}
+	}
+
+	quotaAllocationDeadlineString, envVarExists := os.LookupEnv("QUOTA_ALLOCATION_DEADLINE")
+	s.QuotaAllocationDeadline = ""
+	if envVarExists {
+		s.QuotaAllocationDeadline = quotaAllocationDeadlineString
+	}
+
+	preemptionOrderString, envVarExists := os.LookupEnv("PREEMPTION_ORDER")
+	s.PreemptionOrder = PreemptionOrderYoungestFirst
+	if envVarExists {
+		s.PreemptionOrder = preemptionOrderString
+	}
+
 	enabledQuota, envVarExists := os.LookupEnv("QUOTA_ENABLED")
 	s.QuotaEnabled = false
 	if envVarExists && strings.EqualFold(enabledQuota, "true") {
@@ -139,6 +267,96 @@ func (s *ServerOption) loadDefaultsFromEnvVars() {
 		s.QuotaRestURL = quotaRestURLString
 	}
 
+	quotaMemoryUnitString, envVarExists := os.LookupEnv("QUOTA_MEMORY_UNIT")
+	s.QuotaMemoryUnit = DefaultQuotaMemoryUnit
+	if envVarExists {
+		s.QuotaMemoryUnit = quotaMemoryUnitString
+	}
+
+	quotaTreeFileString, envVarExists := os.LookupEnv("QUOTA_TREE_FILE")
+	s.QuotaTreeFile = ""
+	if envVarExists {
+		s.QuotaTreeFile = quotaTreeFileString
+	}
+
+	quotaResourceAliasesString, envVarExists := os.LookupEnv("QUOTA_RESOURCE_ALIASES")
+	s.QuotaResourceAliases = ""
+	if envVarExists {
+		s.QuotaResourceAliases = quotaResourceAliasesString
+	}
+
+	quotaGPUVendorResourcesString, envVarExists := os.LookupEnv("QUOTA_GPU_VENDOR_RESOURCES")
+	s.QuotaGPUVendorResources = ""
+	if envVarExists {
+		s.QuotaGPUVendorResources = quotaGPUVendorResourcesString
+	}
+
+	quotaTreeRemapString, envVarExists := os.LookupEnv("QUOTA_TREE_REMAP")
+	s.QuotaTreeRemap = ""
+	if envVarExists {
+		s.QuotaTreeRemap = quotaTreeRemapString
+	}
+
+	quotaAnnotationPrefixString, envVarExists := os.LookupEnv("QUOTA_ANNOTATION_PREFIX")
+	s.QuotaAnnotationPrefix = DefaultQuotaAnnotationPrefix
+	if envVarExists {
+		s.QuotaAnnotationPrefix = quotaAnnotationPrefixString
+	}
+
+	quotaCPURoundingString, envVarExists := os.LookupEnv("QUOTA_CPU_ROUNDING")
+	s.QuotaCPURounding = QuotaCPURoundingCeil
+	if envVarExists {
+		s.QuotaCPURounding = quotaCPURoundingString
+	}
+
+	quotaLoadWorkersString, envVarExists := os.LookupEnv("QUOTA_LOAD_WORKERS")
+	s.QuotaLoadWorkers = 1
+	if envVarExists {
+		quotaLoadWorkersInt, err := strconv.Atoi(quotaLoadWorkersString)
+		if err == nil {
+			s.QuotaLoadWorkers = quotaLoadWorkersInt
+		}
+	}
+
+	quotaLoadTimeoutString, envVarExists := os.LookupEnv("QUOTA_LOAD_TIMEOUT")
+	s.QuotaLoadTimeout = 0
+	if envVarExists {
+		quotaLoadTimeoutInt, err := strconv.Atoi(quotaLoadTimeoutString)
+		if err == nil {
+			s.QuotaLoadTimeout = quotaLoadTimeoutInt
+		}
+	}
+
+	quotaAppWrapperSelectorString, envVarExists := os.LookupEnv("QUOTA_APPWRAPPER_SELECTOR")
+	s.QuotaAppWrapperSelector = ""
+	if envVarExists {
+		s.QuotaAppWrapperSelector = quotaAppWrapperSelectorString
+	}
+
+	defaultQuotaTreeString, envVarExists := os.LookupEnv("DEFAULT_QUOTA_TREE")
+	s.DefaultQuotaTree = ""
+	if envVarExists {
+		s.DefaultQuotaTree = defaultQuotaTreeString
+	}
+
+	defaultQuotaGroupString, envVarExists := os.LookupEnv("DEFAULT_QUOTA_GROUP")
+	s.DefaultQuotaGroup = ""
+	if envVarExists {
+		s.DefaultQuotaGroup = defaultQuotaGroupString
+	}
+
+	autoReleaseOnDeleteString, envVarExists := os.LookupEnv("AUTO_RELEASE_ON_DELETE")
+	s.AutoReleaseOnDelete = false
+	if envVarExists && strings.EqualFold(autoReleaseOnDeleteString, "true") {
+		s.AutoReleaseOnDelete = true
+	}
+
+	allowQuotaExemptionString, envVarExists := os.LookupEnv("ALLOW_QUOTA_EXEMPTION")
+	s.AllowQuotaExemption = false
+	if envVarExists && strings.EqualFold(allowQuotaExemptionString, "true") {
+		s.AllowQuotaExemption = true
+	}
+
 	dispatchResourceReservationTimeoutString, envVarExists := os.LookupEnv("DISPATCH_RESOURCE_RESERVATION_TIMEOUT")
 	s.DispatchResourceReservationTimeout = 300000
 	if envVarExists {
@@ -150,5 +368,185 @@ func (s *ServerOption) loadDefaultsFromEnvVars() {
 }
 
 func (s *ServerOption) CheckOptionOrDie() {
+	if _, err := s.QuotaMemoryUnitBytes(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaMemoryUnit option, err=%v", err)
+	}
+	if _, err := s.QuotaResourceAliasTable(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaResourceAliases option, err=%v", err)
+	}
+	if _, err := s.QuotaGPUVendorTable(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaGPUVendorResources option, err=%v", err)
+	}
+	if _, err := s.QuotaTreeRemapTable(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaTreeRemap option, err=%v", err)
+	}
+	if s.QuotaCPURounding != QuotaCPURoundingCeil && s.QuotaCPURounding != QuotaCPURoundingTrunc {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaCPURounding option %q, supported roundings are %s and %s",
+			s.QuotaCPURounding, QuotaCPURoundingCeil, QuotaCPURoundingTrunc)
+	}
+	if s.PreemptionOrder != PreemptionOrderYoungestFirst && s.PreemptionOrder != PreemptionOrderOldestFirst &&
+		s.PreemptionOrder != PreemptionOrderLargestFirst {
+		klog.Fatalf("[CheckOptionOrDie] Invalid preemptionOrder option %q, supported orders are %s, %s and %s",
+			s.PreemptionOrder, PreemptionOrderYoungestFirst, PreemptionOrderOldestFirst, PreemptionOrderLargestFirst)
+	}
+	if s.PreemptionCostThreshold < 0 {
+		klog.Fatalf("[CheckOptionOrDie] Invalid preemptionCostThreshold option %d, the threshold cannot be negative", s.PreemptionCostThreshold)
+	}
+	if _, _, err := s.QuotaAllocationDeadlineTable(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaAllocationDeadline option, err=%v", err)
+	}
+	if s.QuotaLoadWorkers < 1 {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaLoadWorkers option %d, at least 1 worker is required", s.QuotaLoadWorkers)
+	}
+	if s.QuotaLoadTimeout < 0 {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaLoadTimeout option %d, the timeout cannot be negative", s.QuotaLoadTimeout)
+	}
+	if _, err := s.QuotaAppWrapperLabelSelector(); err != nil {
+		klog.Fatalf("[CheckOptionOrDie] Invalid quotaAppWrapperSelector option, err=%v", err)
+	}
+	if (len(s.DefaultQuotaTree) > 0) != (len(s.DefaultQuotaGroup) > 0) {
+		klog.Fatalf("[CheckOptionOrDie] Invalid defaultQuotaTree %q and defaultQuotaGroup %q options, both or none must be set",
+			s.DefaultQuotaTree, s.DefaultQuotaGroup)
+	}
+}
+
+// QuotaMemoryUnitBytes returns the number of bytes in the QuotaMemoryUnit.
+func (s *ServerOption) QuotaMemoryUnitBytes() (float64, error) {
+	unitBytes, found := quotaMemoryUnitBytes[s.QuotaMemoryUnit]
+	if !found {
+		return 0, fmt.Errorf("quota memory unit %q is not supported, supported units are bytes, M, Mi and Gi", s.QuotaMemoryUnit)
+	}
+	return unitBytes, nil
+}
+
+// QuotaResourceAliasTable returns the mapping of lower case quota tree resource types to canonical
+// resource types: the default aliases overridden by the QuotaResourceAliases.
+func (s *ServerOption) QuotaResourceAliasTable() (map[string]string, error) {
+	aliases := make(map[string]string)
+	for alias, canonical := range defaultQuotaResourceAliases {
+		aliases[alias] = canonical
+	}
+
+	if len(strings.TrimSpace(s.QuotaResourceAliases)) <= 0 {
+		return aliases, nil
+	}
+	for _, entry := range strings.Split(s.QuotaResourceAliases, ",") {
+		pair := strings.Split(entry, "=")
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("quota resource alias %q is not of the form alias=canonical", entry)
+		}
+		alias := strings.ToLower(strings.TrimSpace(pair[0]))
+		canonical := strings.ToLower(strings.TrimSpace(pair[1]))
+		switch canonical {
+		case QuotaResourceCPU, QuotaResourceMemory, QuotaResourceGPU, QuotaResourceGPUMemory, QuotaResourcePhysicalGPU:
+		default:
+			return nil, fmt.Errorf("quota resource alias %q maps to unsupported resource type %q, supported types are %s, %s, %s, %s and %s",
+				alias, canonical, QuotaResourceCPU, QuotaResourceMemory, QuotaResourceGPU, QuotaResourceGPUMemory,
+				QuotaResourcePhysicalGPU)
+		}
+		if len(alias) <= 0 {
+			return nil, fmt.Errorf("quota resource alias %q has an empty alias", entry)
+		}
+		aliases[alias] = canonical
+	}
+	return aliases, nil
+}
+
+// QuotaGPUVendorTable returns the GPU resource names consumed by lower case quota tree resource types
+// defined by the QuotaGPUVendorResources, e.g. a "nvidia-gpu" resource type consuming only nvidia.com/gpu
+// demands.  Resource types not in the table keep the default gpu matching.
+func (s *ServerOption) QuotaGPUVendorTable() (map[string][]string, error) {
+	vendorResources := make(map[string][]string)
+	if len(strings.TrimSpace(s.QuotaGPUVendorResources)) <= 0 {
+		return vendorResources, nil
+	}
+
+	for _, entry := range strings.Split(s.QuotaGPUVendorResources, ",") {
+		pair := strings.Split(entry, "=")
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("quota GPU vendor resources %q is not of the form type=name[|name]", entry)
+		}
+		resourceType := strings.ToLower(strings.TrimSpace(pair[0]))
+		if len(resourceType) <= 0 {
+			return nil, fmt.Errorf("quota GPU vendor resources %q has an empty resource type", entry)
+		}
+		for _, resourceName := range strings.Split(pair[1], "|") {
+			resourceName = strings.TrimSpace(resourceName)
+			if len(resourceName) <= 0 {
+				return nil, fmt.Errorf("quota GPU vendor resources %q has an empty resource name", entry)
+			}
+			vendorResources[resourceType] = append(vendorResources[resourceType], resourceName)
+		}
+	}
+	return vendorResources, nil
+}
 
+// QuotaAllocationDeadlineTable returns the default allocation deadline and the allocation deadlines of
+// specific trees, keyed by tree name, defined by the QuotaAllocationDeadline.  A zero deadline disables
+// the priority boost.
+func (s *ServerOption) QuotaAllocationDeadlineTable() (time.Duration, map[string]time.Duration, error) {
+	var defaultDeadline time.Duration
+	treeDeadlines := make(map[string]time.Duration)
+	if len(strings.TrimSpace(s.QuotaAllocationDeadline)) <= 0 {
+		return defaultDeadline, treeDeadlines, nil
+	}
+
+	for _, entry := range strings.Split(s.QuotaAllocationDeadline, ",") {
+		pair := strings.Split(entry, "=")
+		if len(pair) > 2 {
+			return 0, nil, fmt.Errorf("quota allocation deadline %q is not of the form seconds or tree=seconds", entry)
+		}
+		seconds, err := strconv.Atoi(strings.TrimSpace(pair[len(pair)-1]))
+		if err != nil || seconds < 0 {
+			return 0, nil, fmt.Errorf("quota allocation deadline %q is not a non-negative number of seconds", entry)
+		}
+		deadline := time.Duration(seconds) * time.Second
+		if len(pair) == 1 {
+			defaultDeadline = deadline
+			continue
+		}
+		treeName := strings.TrimSpace(pair[0])
+		if len(treeName) <= 0 {
+			return 0, nil, fmt.Errorf("quota allocation deadline %q has an empty tree name", entry)
+		}
+		treeDeadlines[treeName] = deadline
+	}
+	return defaultDeadline, treeDeadlines, nil
+}
+
+// QuotaTreeRemapTable returns the tree names of legacy quota label keys defined by the QuotaTreeRemap,
+// keyed by label key.
+func (s *ServerOption) QuotaTreeRemapTable() (map[string]string, error) {
+	remap := make(map[string]string)
+	if len(strings.TrimSpace(s.QuotaTreeRemap)) <= 0 {
+		return remap, nil
+	}
+
+	for _, entry := range strings.Split(s.QuotaTreeRemap, ",") {
+		pair := strings.Split(entry, "=")
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("quota tree remap %q is not of the form old=new", entry)
+		}
+		oldName := strings.TrimSpace(pair[0])
+		newName := strings.TrimSpace(pair[1])
+		if len(oldName) <= 0 || len(newName) <= 0 {
+			return nil, fmt.Errorf("quota tree remap %q has an empty tree name", entry)
+		}
+		remap[oldName] = newName
+	}
+	return remap, nil
+}
+
+// QuotaAppWrapperLabelSelector returns the label selector parsed from the QuotaAppWrapperSelector, or nil
+// when all AppWrappers are subject to quota.
+func (s *ServerOption) QuotaAppWrapperLabelSelector() (labels.Selector, error) {
+	if len(strings.TrimSpace(s.QuotaAppWrapperSelector)) <= 0 {
+		return nil, nil
+	}
+
+	selector, err := labels.Parse(s.QuotaAppWrapperSelector)
+	if err != nil {
+		return nil, fmt.Errorf("quota AppWrapper selector %q is not a valid label selector: %w", s.QuotaAppWrapperSelector, err)
+	}
+	return selector, nil
 }
@@ -69,6 +69,11 @@ type AppWrapperSpec struct {
 	// +optional
 	Priority int32 `json:"priority,omitempty"`
 
+	// PriorityClassName is the name of the PriorityClass defining the priority of the AppWrapper,
+	// Priority is used when not set
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
 	// +kubebuilder:validation:Type=number
 	// +kubebuilder:validation:Format=float
 	// +optional
@@ -0,0 +1,176 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package api
+
+import (
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ClusterSnapshot aggregates the resources of the nodes of a cluster, e.g. to compute quota in percent
+// of the cluster capacity.  Only the usable nodes, see NodeInfo.UsableCapacity, are aggregated: the nodes
+// not ready, unschedulable or cordoned are counted as excluded.
+type ClusterSnapshot struct {
+	allocatable *Resource
+	idle        *Resource
+	used        *Resource
+
+	// Number of usable nodes providing a resource, keyed by resource name
+	nodeCounts map[v1.ResourceName]int
+
+	usableNodes   int
+	excludedNodes int
+
+	// Idle resources and pod label keys of the usable nodes, for the placement estimates
+	nodes []*snapshotNode
+}
+
+// snapshotNode is the placement state of a usable node.
+type snapshotNode struct {
+	idle *Resource
+	// Label keys of the pods running on the node
+	podLabelKeys map[string]bool
+}
+
+// NewClusterSnapshot creates a snapshot without nodes.
+func NewClusterSnapshot() *ClusterSnapshot {
+	return &ClusterSnapshot{
+		allocatable: EmptyResource(),
+		idle:        EmptyResource(),
+		used:        EmptyResource(),
+		nodeCounts:  make(map[v1.ResourceName]int),
+	}
+}
+
+// AddNode adds the resources of a node to the snapshot, unless the node is not usable.
+func (cs *ClusterSnapshot) AddNode(ni *NodeInfo) {
+	if ni == nil {
+		return
+	}
+	if !ni.isUsable() {
+		cs.excludedNodes++
+		return
+	}
+	cs.usableNodes++
+
+	cs.allocatable.Add(ni.Allocatable)
+	cs.idle.Add(ni.Idle)
+	cs.used.Add(ni.Used)
+
+	node := &snapshotNode{
+		idle:         ni.Idle.Clone(),
+		podLabelKeys: make(map[string]bool),
+	}
+	for _, task := range ni.Tasks {
+		if task.Pod == nil {
+			continue
+		}
+		for key := range task.Pod.Labels {
+			node.podLabelKeys[key] = true
+		}
+	}
+	cs.nodes = append(cs.nodes, node)
+
+	for _, rn := range ResourceNames() {
+		if quantity, _ := ni.Allocatable.Get(rn); quantity > 0 {
+			cs.nodeCounts[rn]++
+		}
+	}
+	for rn, quantity := range ni.Allocatable.ScalarResources {
+		if quantity > 0 {
+			cs.nodeCounts[rn]++
+		}
+	}
+}
+
+// TotalAllocatable returns the sum of the allocatable resources of the usable nodes.
+func (cs *ClusterSnapshot) TotalAllocatable() *Resource {
+	return cs.allocatable.Clone()
+}
+
+// TotalIdle returns the sum of the idle resources of the usable nodes.
+func (cs *ClusterSnapshot) TotalIdle() *Resource {
+	return cs.idle.Clone()
+}
+
+// TotalUsed returns the sum of the used resources of the usable nodes.
+func (cs *ClusterSnapshot) TotalUsed() *Resource {
+	return cs.used.Clone()
+}
+
+// NodeCount returns the number of usable nodes with a positive allocatable amount of a resource.
+func (cs *ClusterSnapshot) NodeCount(rn v1.ResourceName) int {
+	return cs.nodeCounts[rn]
+}
+
+// UsableNodes returns the number of nodes aggregated by the snapshot.
+func (cs *ClusterSnapshot) UsableNodes() int {
+	return cs.usableNodes
+}
+
+// ExcludedNodes returns the number of nodes added to the snapshot but not aggregated, because they are
+// not ready, unschedulable or cordoned.
+func (cs *ClusterSnapshot) ExcludedNodes() int {
+	return cs.excludedNodes
+}
+
+// MaxFittablePods returns the number of pods of the demand that fit in the idle resources of the usable
+// nodes.  With an anti-affinity key, the pods carry a label with the key and repel each other and the pods
+// already running with the label: at most one pod fits per node, none on the nodes running such pods.
+// With an empty key the pods are packed on the nodes.  A demand without any positive resource fits no pod.
+func (cs *ClusterSnapshot) MaxFittablePods(demand *Resource, antiAffinityKey string) int {
+	if demand == nil {
+		return 0
+	}
+
+	total := 0
+	for _, node := range cs.nodes {
+		if len(antiAffinityKey) > 0 && node.podLabelKeys[antiAffinityKey] {
+			continue
+		}
+		fittable := fittablePods(demand, node.idle)
+		if len(antiAffinityKey) > 0 && fittable > 1 {
+			fittable = 1
+		}
+		total += fittable
+	}
+	return total
+}
+
+// fittablePods returns the number of pods of the demand fitting in the idle resources, the smallest ratio
+// of an idle resource to the positive demand of the resource.
+func fittablePods(demand *Resource, idle *Resource) int {
+	fittable := math.Inf(1)
+	fit := func(demanded, available float64) {
+		if demanded > 0 {
+			fittable = math.Min(fittable, math.Floor(math.Max(available, 0)/demanded))
+		}
+	}
+
+	fit(demand.MilliCPU, idle.MilliCPU)
+	fit(demand.Memory, idle.Memory)
+	fit(float64(demand.GPU), float64(idle.GPU))
+	fit(float64(demand.GPUMemory), float64(idle.GPUMemory))
+	for rName, rQuant := range demand.ScalarResources {
+		fit(rQuant, idle.ScalarResources[rName])
+	}
+
+	if math.IsInf(fittable, 1) {
+		return 0
+	}
+	return int(fittable)
+}
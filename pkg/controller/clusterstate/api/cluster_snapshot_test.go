@@ -0,0 +1,255 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func buildReadyNode(name string, alloc v1.ResourceList) *v1.Node {
+	node := buildNode(name, alloc)
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	return node
+}
+
+func TestClusterSnapshot_AddNode(t *testing.T) {
+	gpuResources := buildResourceList("8000m", "10G")
+	gpuResources[GPUResourceName] = resource.MustParse("4")
+
+	ready := NewNodeInfo(buildReadyNode("ready", buildResourceList("4000m", "8G")))
+	pod := buildPod("c1", "p1", "ready", v1.PodRunning, buildResourceList("1000m", "2G"), []metav1.OwnerReference{}, make(map[string]string))
+	if err := ready.AddTask(NewTaskInfo(pod)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gpu := NewNodeInfo(buildReadyNode("gpu", gpuResources))
+
+	notReady := NewNodeInfo(buildNode("not-ready", buildResourceList("16000m", "32G")))
+	unschedulableNode := buildReadyNode("unschedulable", buildResourceList("16000m", "32G"))
+	unschedulableNode.Spec.Unschedulable = true
+	unschedulable := NewNodeInfo(unschedulableNode)
+	cordonedNode := buildReadyNode("cordoned", gpuResources)
+	cordonedNode.Spec.Taints = []v1.Taint{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}}
+	cordoned := NewNodeInfo(cordonedNode)
+
+	expectedAllocatable := buildResource("12000m", "18G")
+	expectedAllocatable.GPU = 4
+	expectedIdle := buildResource("11000m", "16G")
+	expectedIdle.GPU = 4
+
+	tests := []struct {
+		name                string
+		nodes               []*NodeInfo
+		expectedAllocatable *Resource
+		expectedIdle        *Resource
+		expectedUsed        *Resource
+		expectedCounts      map[v1.ResourceName]int
+		expectedUsable      int
+		expectedExcluded    int
+	}{
+		{
+			name:                "no nodes",
+			expectedAllocatable: EmptyResource(),
+			expectedIdle:        EmptyResource(),
+			expectedUsed:        EmptyResource(),
+			expectedCounts:      map[v1.ResourceName]int{},
+		},
+		{
+			name:                "ready nodes",
+			nodes:               []*NodeInfo{ready, gpu},
+			expectedAllocatable: expectedAllocatable,
+			expectedIdle:        expectedIdle,
+			expectedUsed:        buildResource("1000m", "2G"),
+			expectedCounts:      map[v1.ResourceName]int{v1.ResourceCPU: 2, v1.ResourceMemory: 2, GPUResourceName: 1},
+			expectedUsable:      2,
+		},
+		{
+			name:                "ready and unusable nodes",
+			nodes:               []*NodeInfo{notReady, ready, unschedulable, gpu, cordoned, nil},
+			expectedAllocatable: expectedAllocatable,
+			expectedIdle:        expectedIdle,
+			expectedUsed:        buildResource("1000m", "2G"),
+			expectedCounts:      map[v1.ResourceName]int{v1.ResourceCPU: 2, v1.ResourceMemory: 2, GPUResourceName: 1},
+			expectedUsable:      2,
+			expectedExcluded:    3,
+		},
+		{
+			name:                "unusable nodes",
+			nodes:               []*NodeInfo{notReady, unschedulable, cordoned},
+			expectedAllocatable: EmptyResource(),
+			expectedIdle:        EmptyResource(),
+			expectedUsed:        EmptyResource(),
+			expectedCounts:      map[v1.ResourceName]int{},
+			expectedExcluded:    3,
+		},
+	}
+
+	for i, test := range tests {
+		cs := NewClusterSnapshot()
+		for _, ni := range test.nodes {
+			cs.AddNode(ni)
+		}
+
+		if allocatable := cs.TotalAllocatable(); !reflect.DeepEqual(allocatable, test.expectedAllocatable) {
+			t.Errorf("case %d (%s): \n expected allocatable %v, \n got %v \n", i, test.name, test.expectedAllocatable, allocatable)
+		}
+		if idle := cs.TotalIdle(); !reflect.DeepEqual(idle, test.expectedIdle) {
+			t.Errorf("case %d (%s): \n expected idle %v, \n got %v \n", i, test.name, test.expectedIdle, idle)
+		}
+		if used := cs.TotalUsed(); !reflect.DeepEqual(used, test.expectedUsed) {
+			t.Errorf("case %d (%s): \n expected used %v, \n got %v \n", i, test.name, test.expectedUsed, used)
+		}
+		for _, rn := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory, GPUResourceName} {
+			if count := cs.NodeCount(rn); count != test.expectedCounts[rn] {
+				t.Errorf("case %d (%s): \n expected %d nodes with %s, \n got %d \n", i, test.name, test.expectedCounts[rn], rn, count)
+			}
+		}
+		if cs.UsableNodes() != test.expectedUsable || cs.ExcludedNodes() != test.expectedExcluded {
+			t.Errorf("case %d (%s): \n expected %d usable and %d excluded nodes, \n got %d and %d \n", i, test.name,
+				test.expectedUsable, test.expectedExcluded, cs.UsableNodes(), cs.ExcludedNodes())
+		}
+	}
+
+	// The totals are copies
+	cs := NewClusterSnapshot()
+	cs.AddNode(ready)
+	cs.TotalAllocatable().MilliCPU = 0
+	if cs.TotalAllocatable().MilliCPU != 4000 {
+		t.Errorf("expected the total allocatable not to be modified through a returned total")
+	}
+}
+
+func TestClusterSnapshot_MaxFittablePods(t *testing.T) {
+	buildGPUNode := func(name string, gpus string) *NodeInfo {
+		alloc := buildResourceList("8000m", "16G")
+		alloc[GPUResourceName] = resource.MustParse(gpus)
+		return NewNodeInfo(buildReadyNode(name, alloc))
+	}
+
+	// Idle capacity spread out over four nodes with one GPU each
+	var spread []*NodeInfo
+	for _, name := range []string{"n1", "n2", "n3", "n4"} {
+		spread = append(spread, buildGPUNode(name, "1"))
+	}
+	// The same idle GPUs on a single node
+	packed := []*NodeInfo{buildGPUNode("big", "4")}
+
+	// A node running a pod with the anti-affinity label
+	labeled := buildGPUNode("labeled", "4")
+	pod := buildPod("c1", "p1", "labeled", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{},
+		map[string]string{"app": "trainer"})
+	if err := labeled.AddTask(NewTaskInfo(pod)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cordonedNode := buildReadyNode("cordoned", buildResourceList("8000m", "16G"))
+	cordonedNode.Spec.Unschedulable = true
+
+	gpuDemand := buildResource("1000m", "1G")
+	gpuDemand.GPU = 1
+
+	tests := []struct {
+		name            string
+		nodes           []*NodeInfo
+		demand          *Resource
+		antiAffinityKey string
+		expected        int
+	}{
+		{
+			name:     "spread out idle capacity",
+			nodes:    spread,
+			demand:   gpuDemand,
+			expected: 4,
+		},
+		{
+			name:            "spread out idle capacity with anti-affinity",
+			nodes:           spread,
+			demand:          gpuDemand,
+			antiAffinityKey: "app",
+			expected:        4,
+		},
+		{
+			name:     "packed idle capacity",
+			nodes:    packed,
+			demand:   gpuDemand,
+			expected: 4,
+		},
+		{
+			name:            "packed idle capacity with anti-affinity",
+			nodes:           packed,
+			demand:          gpuDemand,
+			antiAffinityKey: "app",
+			expected:        1,
+		},
+		{
+			name:     "cpu bound demand",
+			nodes:    packed,
+			demand:   buildResource("3000m", "1G"),
+			expected: 2,
+		},
+		{
+			name:            "node running a pod with the anti-affinity label",
+			nodes:           append([]*NodeInfo{labeled}, spread...),
+			demand:          gpuDemand,
+			antiAffinityKey: "app",
+			expected:        4,
+		},
+		{
+			name:            "node running a pod with another label",
+			nodes:           append([]*NodeInfo{labeled}, spread...),
+			demand:          gpuDemand,
+			antiAffinityKey: "role",
+			expected:        5,
+		},
+		{
+			name:     "unusable node",
+			nodes:    []*NodeInfo{NewNodeInfo(cordonedNode)},
+			demand:   buildResource("1000m", "1G"),
+			expected: 0,
+		},
+		{
+			name:     "demand larger than any node",
+			nodes:    spread,
+			demand:   buildResource("16000m", "1G"),
+			expected: 0,
+		},
+		{
+			name:     "empty demand",
+			nodes:    spread,
+			demand:   EmptyResource(),
+			expected: 0,
+		},
+		{
+			name:     "nil demand",
+			nodes:    spread,
+			expected: 0,
+		},
+	}
+
+	for i, test := range tests {
+		cs := NewClusterSnapshot()
+		for _, ni := range test.nodes {
+			cs.AddNode(ni)
+		}
+
+		if fittable := cs.MaxFittablePods(test.demand, test.antiAffinityKey); fittable != test.expected {
+			t.Errorf("case %d (%s): \n expected %d pods, \n got %d \n", i, test.name, test.expected, fittable)
+		}
+	}
+}
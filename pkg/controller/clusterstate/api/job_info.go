@@ -58,13 +58,26 @@ type TaskInfo struct {
 	Pod *v1.Pod
 }
 
-func NewTaskInfo(pod *v1.Pod) *TaskInfo {
+// getPodResourceRequest returns the effective resource request of a pod, matching the quota demand of
+// its template: the larger of the sum of the regular containers and of the largest init container, plus
+// the pod overhead.
+func getPodResourceRequest(pod *v1.Pod) *Resource {
 	req := EmptyResource()
-
-	// TODO(k82cn): also includes initContainers' resource.
 	for _, c := range pod.Spec.Containers {
 		req.Add(NewResource(c.Resources.Requests))
 	}
+	// Init containers run one at a time before the regular containers
+	for _, c := range pod.Spec.InitContainers {
+		req = req.Max(NewResource(c.Resources.Requests))
+	}
+	if pod.Spec.Overhead != nil {
+		req.Add(NewResource(pod.Spec.Overhead))
+	}
+	return req
+}
+
+func NewTaskInfo(pod *v1.Pod) *TaskInfo {
+	req := getPodResourceRequest(pod)
 
 	pi := &TaskInfo{
 		UID:       TaskID(pod.UID),
@@ -206,3 +206,52 @@ func TestDeleteTaskInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestNewTaskInfoResourceRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		initContainers []v1.Container
+		overhead       v1.ResourceList
+		expected       *Resource
+	}{
+		{
+			name:     "regular containers only",
+			expected: buildResource("1000m", "1G"),
+		},
+		{
+			name: "smaller init container",
+			initContainers: []v1.Container{
+				{Resources: v1.ResourceRequirements{Requests: buildResourceList("500m", "500M")}},
+			},
+			expected: buildResource("1000m", "1G"),
+		},
+		{
+			name: "larger init containers",
+			initContainers: []v1.Container{
+				{Resources: v1.ResourceRequirements{Requests: buildResourceList("3000m", "500M")}},
+				{Resources: v1.ResourceRequirements{Requests: buildResourceList("500m", "2G")}},
+			},
+			expected: buildResource("3000m", "2G"),
+		},
+		{
+			name: "init container and pod overhead",
+			initContainers: []v1.Container{
+				{Resources: v1.ResourceRequirements{Requests: buildResourceList("2000m", "500M")}},
+			},
+			overhead: buildResourceList("250m", "100M"),
+			expected: buildResource("2250m", "1100M"),
+		},
+	}
+
+	for i, test := range tests {
+		pod := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), nil, make(map[string]string))
+		pod.Spec.InitContainers = test.initContainers
+		pod.Spec.Overhead = test.overhead
+
+		ti := NewTaskInfo(pod)
+		if !reflect.DeepEqual(ti.Resreq, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n",
+				i, test.name, test.expected, ti.Resreq)
+		}
+	}
+}
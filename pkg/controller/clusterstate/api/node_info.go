@@ -32,11 +32,23 @@ package api
 
 import (
 	"fmt"
+	"strconv"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
+const (
+	// Node labels declaring the factor by which the allocatable CPU and memory of the node are
+	// overcommitted, e.g. "2" to dispatch twice the allocatable CPU.  GPUs are never overcommitted.
+	CPUOvercommitLabel    = "mcad.io/cpu-overcommit"
+	MemoryOvercommitLabel = "mcad.io/memory-overcommit"
+
+	// Node label declaring the number of GPU replicas advertised per physical GPU of a time-sliced node,
+	// e.g. "4" when each physical GPU is shared by 4 replicas
+	GPUSharingFactorLabel = "mcad.io/gpu-sharing-factor"
+)
+
 // NodeInfo is node level aggregated information.
 type NodeInfo struct {
 	Name string
@@ -62,6 +74,12 @@ type NodeInfo struct {
 	// Taints for potential filtering
 	Taints []v1.Taint
 
+	// Track Ready condition, nodes not ready provide no usable capacity
+	Ready bool
+
+	// Number of pods of the tasks on the node, bounded by the "pods" allocatable of the node
+	PodCount int64
+
 	Tasks map[TaskID]*TaskInfo
 }
 
@@ -88,25 +106,112 @@ func NewNodeInfo(node *v1.Node) *NodeInfo {
 		Node: node,
 
 		Releasing: EmptyResource(),
-		Idle:      NewResource(node.Status.Allocatable),
+		Idle:      getOvercommittedAllocatable(node),
 		Used:      EmptyResource(),
 
-		Allocatable: NewResource(node.Status.Allocatable),
-		Capability:  NewResource(node.Status.Capacity),
+		Allocatable: NewResourceOf("node "+node.Name, node.Status.Allocatable),
+		Capability:  NewResourceOf("node "+node.Name, node.Status.Capacity),
 
 		Labels: node.GetLabels(),
 		Unschedulable: node.Spec.Unschedulable,
-		Taints: node.Spec.Taints,
+		Taints:        node.Spec.Taints,
+		Ready:         isNodeReady(node),
 
 		Tasks: make(map[TaskID]*TaskInfo),
 	}
 }
 
+// isNodeReady returns true if the Ready condition of the node is True.
+func isNodeReady(node *v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isCordoned returns true if the node is unschedulable or tainted as unschedulable, e.g. by kubectl cordon.
+func (ni *NodeInfo) isCordoned() bool {
+	if ni.Unschedulable {
+		return true
+	}
+	for _, taint := range ni.Taints {
+		if taint.Key == v1.TaintNodeUnschedulable && taint.Effect == v1.TaintEffectNoSchedule {
+			return true
+		}
+	}
+	return false
+}
+
+// isUsable returns true if the node is ready and neither unschedulable nor cordoned.
+func (ni *NodeInfo) isUsable() bool {
+	return ni.Node != nil && ni.Ready && !ni.isCordoned()
+}
+
+// UsableCapacity returns the allocatable resources of the node, or no resources when the node is not
+// ready, unschedulable or cordoned.
+func (ni *NodeInfo) UsableCapacity() *Resource {
+	if !ni.isUsable() {
+		return EmptyResource()
+	}
+	return ni.Allocatable.Clone()
+}
+
+// getOvercommitFactor returns the overcommit factor declared by a node label, 1 when the label is
+// missing or invalid.  Factors lower than 1 are ignored.
+func getOvercommitFactor(node *v1.Node, label string) float64 {
+	value, found := node.Labels[label]
+	if !found {
+		return 1
+	}
+
+	factor, err := strconv.ParseFloat(value, 64)
+	if err != nil || factor < 1 {
+		klog.Warningf("[getOvercommitFactor] Invalid overcommit factor %s=%s of node %s ignored.", label, value, node.Name)
+		return 1
+	}
+	return factor
+}
+
+// GPUSharingFactor returns the number of GPU replicas advertised per physical GPU of the node, 1 when the
+// node GPUs are not time-sliced or the label is invalid.
+func (ni *NodeInfo) GPUSharingFactor() int {
+	if ni.Node == nil {
+		return 1
+	}
+	value, found := ni.Node.Labels[GPUSharingFactorLabel]
+	if !found {
+		return 1
+	}
+
+	factor, err := strconv.Atoi(value)
+	if err != nil || factor < 1 {
+		klog.Warningf("[GPUSharingFactor] Invalid GPU sharing factor %s=%s of node %s ignored.", GPUSharingFactorLabel, value, ni.Name)
+		return 1
+	}
+	return factor
+}
+
+// getOvercommittedAllocatable returns the allocatable resources of the node with the CPU and memory
+// overcommit factors of the node labels applied.
+func getOvercommittedAllocatable(node *v1.Node) *Resource {
+	allocatable := NewResourceOf("node "+node.Name, node.Status.Allocatable)
+	allocatable.MilliCPU = allocatable.MilliCPU * getOvercommitFactor(node, CPUOvercommitLabel)
+	allocatable.Memory = allocatable.Memory * getOvercommitFactor(node, MemoryOvercommitLabel)
+	return allocatable
+}
+
 func (ni *NodeInfo) Clone() *NodeInfo {
 	res := NewNodeInfo(ni.Node)
 
-	for _, p := range ni.Tasks {
-		res.AddTask(p)
+	// Copy the node accounting as is, re-adding the tasks would drop those of an over-committed node
+	res.Releasing = ni.Releasing.Clone()
+	res.Idle = ni.Idle.Clone()
+	res.Used = ni.Used.Clone()
+	res.PodCount = ni.PodCount
+	for key, task := range ni.Tasks {
+		res.Tasks[key] = task.Clone()
 	}
 
 	return res
@@ -114,29 +219,31 @@ func (ni *NodeInfo) Clone() *NodeInfo {
 
 func (ni *NodeInfo) SetNode(node *v1.Node) {
 	if ni.Node == nil {
-		ni.Idle = NewResource(node.Status.Allocatable)
-
+		// Compute the node resources of the tasks first, then commit them.  The tasks are already on the
+		// node, tasks exceeding its idle resources clamp them at zero.
+		releasing, idle, used := EmptyResource(), getOvercommittedAllocatable(node), EmptyResource()
 		for _, task := range ni.Tasks {
-			if task.Status == Releasing {
-				ni.Releasing.Add(task.Resreq)
-			}
-
-			_, err := ni.Idle.Sub(task.Resreq)
+			var err error
+			releasing, idle, used, err = addTaskResources(releasing, idle, used, task)
 			if err != nil {
-				klog.Warningf("[SetNode] Node idle amount subtraction err=%v", err)
+				klog.Warningf("[SetNode] Node %s idle resources clamped at zero adding task %s/%s, err=%v",
+					node.Name, task.Namespace, task.Name, err)
 			}
-
-			ni.Used.Add(task.Resreq)
 		}
+
+		ni.Releasing.Replace(releasing)
+		ni.Idle.Replace(idle)
+		ni.Used.Replace(used)
 	}
 
 	ni.Name = node.Name
 	ni.Node = node
-	ni.Allocatable = NewResource(node.Status.Allocatable)
-	ni.Capability = NewResource(node.Status.Capacity)
+	ni.Allocatable = NewResourceOf("node "+node.Name, node.Status.Allocatable)
+	ni.Capability = NewResourceOf("node "+node.Name, node.Status.Capacity)
 	ni.Labels = NewStringsMap(node.Labels)
 	ni.Unschedulable = node.Spec.Unschedulable
 	ni.Taints = NewTaints(node.Spec.Taints)
+	ni.Ready = isNodeReady(node)
 }
 
 func (ni *NodeInfo) PipelineTask(task *TaskInfo) error {
@@ -158,11 +265,23 @@ func (ni *NodeInfo) PipelineTask(task *TaskInfo) error {
 	}
 
 	ni.Tasks[key] = ti
+	ni.PodCount++
 
 	return nil
 }
 
 func (ni *NodeInfo) AddTask(task *TaskInfo) error {
+	return ni.addTask(task, false)
+}
+
+// AddBoundTask adds the task of a pod bound to the node, e.g. observed by the cache.  Unlike AddTask, a
+// task exceeding the idle resources of the node, e.g. of an over-committed node, is still added and the
+// idle resources are clamped at zero, so the node accounting reflects the pods running on the node.
+func (ni *NodeInfo) AddBoundTask(task *TaskInfo) error {
+	return ni.addTask(task, true)
+}
+
+func (ni *NodeInfo) addTask(task *TaskInfo, clampIdle bool) error {
 	key := PodKey(task.Pod)
 	if _, found := ni.Tasks[key]; found {
 		return fmt.Errorf("task <%v/%v> already on node <%v>",
@@ -174,22 +293,40 @@ func (ni *NodeInfo) AddTask(task *TaskInfo) error {
 	ti := task.Clone()
 
 	if ni.Node != nil {
-		if ti.Status == Releasing {
-			ni.Releasing.Add(ti.Resreq)
-		}
-		_, err := ni.Idle.Sub(ti.Resreq)
+		// Compute the new node resources first so a failure leaves the node untouched
+		releasing, idle, used, err := addTaskResources(ni.Releasing, ni.Idle, ni.Used, ti)
 		if err != nil {
 			klog.Warningf("[AddTask] Idle resource subtract err=%v", err)
+			if !clampIdle {
+				return fmt.Errorf("failed to add task <%v/%v> to node <%v>: %v",
+					task.Namespace, task.Name, ni.Name, err)
+			}
 		}
 
-		ni.Used.Add(ti.Resreq)
+		ni.Releasing.Replace(releasing)
+		ni.Idle.Replace(idle)
+		ni.Used.Replace(used)
 	}
 
 	ni.Tasks[key] = ti
+	ni.PodCount++
 
 	return nil
 }
 
+// addTaskResources returns copies of the releasing, idle and used resources of a node with the request of
+// the task added, for the caller to commit.  An error is returned with the idle resources clamped at zero
+// when the task exceeds them.
+func addTaskResources(releasing, idle, used *Resource, ti *TaskInfo) (*Resource, *Resource, *Resource, error) {
+	releasing, idle, used = releasing.Clone(), idle.Clone(), used.Clone()
+	if ti.Status == Releasing {
+		releasing.Add(ti.Resreq)
+	}
+	_, err := idle.Sub(ti.Resreq)
+	used.Add(ti.Resreq)
+	return releasing, idle, used, err
+}
+
 func (ni *NodeInfo) RemoveTask(ti *TaskInfo) error {
 	klog.V(10).Infof("Attempting to remove task: %s on node: %s", ti.Name,  ni.Name)
 
@@ -203,34 +340,160 @@ func (ni *NodeInfo) RemoveTask(ti *TaskInfo) error {
 
 	if ni.Node != nil {
 		klog.V(10).Infof("Found node for task: %s, node: %s, task status: %v", task.Name,  ni.Name, task.Status)
+		// The task is removed even when the node accounting drifted, clamping the node usage at zero
 		if task.Status == Releasing {
-			_, err := ni.Releasing.Sub(task.Resreq)
-			if err != nil {
-				klog.Warningf("[RemoveTask] Node release subtraction err=%v", err)
+			if !task.Resreq.LessEqual(ni.Releasing) {
+				klog.Warningf("[RemoveTask] Node %s releasing resources %v clamped at zero removing task %s/%s with request %v.",
+					ni.Name, ni.Releasing, ti.Namespace, ti.Name, task.Resreq)
 			}
+			ni.Releasing.SubClamp(task.Resreq)
 		}
 
-		ni.Idle.Add(task.Resreq)
-		_, err := ni.Used.Sub(task.Resreq)
-		if err != nil {
-			klog.Warningf("[RemoveTask] Node usage subtraction err=%v", err)
+		if !task.Resreq.LessEqual(ni.Used) {
+			klog.Warningf("[RemoveTask] Node %s used resources %v clamped at zero removing task %s/%s with request %v.",
+				ni.Name, ni.Used, ti.Namespace, ti.Name, task.Resreq)
 		}
+		ni.Idle.Add(task.Resreq)
+		ni.Used.SubClamp(task.Resreq)
 	} else {
 		klog.V(10).Infof("No node info found for task: %s, node: %s", task.Name,  ni.Name)
 	}
 
 	delete(ni.Tasks, key)
+	if ni.PodCount > 0 {
+		ni.PodCount--
+	}
 
 	return nil
 }
 
+// UpdateTask replaces the task of a pod on the node.  The request of the task is recomputed from the
+// pod spec, so the node accounting follows a change of the pod requests, e.g. a vertical scaling.  A new
+// request exceeding the idle resources of the node plus the old request fails, leaving the node untouched.
 func (ni *NodeInfo) UpdateTask(ti *TaskInfo) error {
 	klog.V(10).Infof("Attempting to update task: %s on node: %s", ti.Name,  ni.Name)
+	current, found := ni.Tasks[PodKey(ti.Pod)]
+	if !found {
+		return fmt.Errorf("failed to find task <%v/%v> on host <%v>",
+			ti.Namespace, ti.Name, ni.Name)
+	}
+
+	task := ti.Clone()
+	if task.Pod != nil {
+		task.Resreq = getPodResourceRequest(task.Pod)
+	}
+
+	// Validate the new request before removing the task, so a failure keeps the current task
+	if ni.Node != nil {
+		idle := ni.Idle.Clone().Add(current.Resreq)
+		if _, err := idle.Sub(task.Resreq); err != nil {
+			klog.Warningf("[UpdateTask] Idle resource subtract err=%v", err)
+			return fmt.Errorf("failed to update task <%v/%v> on node <%v>: %v",
+				ti.Namespace, ti.Name, ni.Name, err)
+		}
+	}
+
 	if err := ni.RemoveTask(ti); err != nil {
 		return err
 	}
+	return ni.AddTask(task)
+}
+
+// FutureIdle returns the resources that will be idle on the node once the releasing tasks
+// terminate, i.e. Idle plus Releasing.  The result is a new Resource owned by the caller.
+func (ni *NodeInfo) FutureIdle() *Resource {
+	if ni.Node == nil {
+		return EmptyResource()
+	}
+
+	return ni.Idle.Clone().Add(ni.Releasing)
+}
+
+// Tolerates returns true if a pod with the given tolerations tolerates all the NoSchedule and
+// NoExecute taints of the node.
+func (ni *NodeInfo) Tolerates(tolerations []v1.Toleration) bool {
+	for i := range ni.Taints {
+		taint := &ni.Taints[i]
+		if taint.Effect != v1.TaintEffectNoSchedule && taint.Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AllocatableForTolerations returns a copy of the allocatable resources of the node when it is usable, see
+// UsableCapacity, and a pod with the given tolerations tolerates its taints, otherwise an empty resource.
+func (ni *NodeInfo) AllocatableForTolerations(tolerations []v1.Toleration) *Resource {
+	if !ni.isUsable() || !ni.Tolerates(tolerations) {
+		return EmptyResource()
+	}
+
+	return ni.Allocatable.Clone()
+}
+
+// MatchesNodeSelector returns true if the node labels match all the key/value pairs of the selector.
+func (ni *NodeInfo) MatchesNodeSelector(selector map[string]string) bool {
+	for key, value := range selector {
+		if nodeValue, found := ni.Labels[key]; !found || nodeValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IdleGPU returns the number of idle GPUs on the node.
+func (ni *NodeInfo) IdleGPU() int64 {
+	if ni.Idle == nil {
+		return 0
+	}
+
+	return ni.Idle.GPU
+}
+
+// IdlePods returns the number of pods that can still be placed on the node, i.e. the "pods" allocatable
+// of the node minus the pods of its tasks, zero when the node declares no pod capacity.
+func (ni *NodeInfo) IdlePods() int64 {
+	if ni.Node == nil || ni.Allocatable == nil {
+		return 0
+	}
+
+	idlePods := int64(ni.Allocatable.ScalarResources[v1.ResourcePods]) - ni.PodCount
+	if idlePods < 0 {
+		return 0
+	}
+	return idlePods
+}
+
+// UsedGPU returns the number of GPUs used by the tasks on the node.
+func (ni *NodeInfo) UsedGPU() int64 {
+	if ni.Used == nil {
+		return 0
+	}
+
+	return ni.Used.GPU
+}
+
+// GPUUtilization returns the ratio of used to allocatable GPUs on the node, zero when the node has no
+// allocatable GPUs.
+func (ni *NodeInfo) GPUUtilization() float64 {
+	if ni.Allocatable == nil || ni.Allocatable.GPU <= 0 {
+		return 0
+	}
 
-	return ni.AddTask(ti)
+	return float64(ni.UsedGPU()) / float64(ni.Allocatable.GPU)
 }
 
 func (ni NodeInfo) String() string {
@@ -35,6 +35,7 @@ import (
 	"testing"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -70,6 +71,7 @@ func TestNodeInfo_AddPod(t *testing.T) {
 				Releasing:   EmptyResource(),
 				Allocatable: buildResource("8000m", "10G"),
 				Capability:  buildResource("8000m", "10G"),
+				PodCount:    2,
 				Tasks: map[TaskID]*TaskInfo{
 					"c1/p1": NewTaskInfo(case01_pod1),
 					"c1/p2": NewTaskInfo(case01_pod2),
@@ -120,6 +122,7 @@ func TestNodeInfo_RemovePod(t *testing.T) {
 				Releasing:   EmptyResource(),
 				Allocatable: buildResource("8000m", "10G"),
 				Capability:  buildResource("8000m", "10G"),
+				PodCount:    2,
 				Tasks: map[TaskID]*TaskInfo{
 					"c1/p1": NewTaskInfo(case01_pod1),
 					"c1/p3": NewTaskInfo(case01_pod3),
@@ -147,3 +150,612 @@ func TestNodeInfo_RemovePod(t *testing.T) {
 		}
 	}
 }
+
+func TestNodeInfo_PodCount(t *testing.T) {
+	nodeResources := buildResourceList("8000m", "10G")
+	nodeResources[v1.ResourcePods] = resource.MustParse("4")
+	node := buildNode("n1", nodeResources)
+
+	ni := NewNodeInfo(node)
+	if idlePods := ni.IdlePods(); idlePods != 4 {
+		t.Errorf("expected 4 idle pods on empty node, got %d", idlePods)
+	}
+
+	var pods []*v1.Pod
+	for _, name := range []string{"p1", "p2", "p3"} {
+		pod := buildPod("c1", name, "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+		pods = append(pods, pod)
+		if err := ni.AddTask(NewTaskInfo(pod)); err != nil {
+			t.Fatalf("unexpected error adding pod %s: %v", name, err)
+		}
+	}
+	if ni.PodCount != 3 || ni.IdlePods() != 1 {
+		t.Errorf("expected 3 pods and 1 idle pod, got %d pods and %d idle pods", ni.PodCount, ni.IdlePods())
+	}
+
+	// Adding a task already on the node does not count it twice
+	if err := ni.AddTask(NewTaskInfo(pods[0])); err == nil {
+		t.Errorf("expected error adding pod p1 twice")
+	}
+	if ni.PodCount != 3 {
+		t.Errorf("expected 3 pods after duplicate add, got %d", ni.PodCount)
+	}
+
+	if err := ni.RemoveTask(NewTaskInfo(pods[1])); err != nil {
+		t.Fatalf("unexpected error removing pod p2: %v", err)
+	}
+	if ni.PodCount != 2 || ni.IdlePods() != 2 {
+		t.Errorf("expected 2 pods and 2 idle pods after remove, got %d pods and %d idle pods", ni.PodCount, ni.IdlePods())
+	}
+
+	if clone := ni.Clone(); clone.PodCount != 2 {
+		t.Errorf("expected 2 pods on clone, got %d", clone.PodCount)
+	}
+}
+
+func TestNodeInfo_AddPodExtendedResource(t *testing.T) {
+	hugepages := v1.ResourceName("hugepages-2Mi")
+
+	nodeResources := buildResourceList("8000m", "10G")
+	nodeResources[hugepages] = resource.MustParse("1Gi")
+	node := buildNode("n1", nodeResources)
+
+	podResources := buildResourceList("1000m", "1G")
+	podResources[hugepages] = resource.MustParse("256Mi")
+	pod := buildPod("c1", "p1", "n1", v1.PodRunning, podResources, []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	ni.AddTask(NewTaskInfo(pod))
+
+	expectedIdle := buildResource("7000m", "9G")
+	expectedIdle.SetScalar(hugepages, float64(768*1024*1024))
+	if !reflect.DeepEqual(ni.Idle, expectedIdle) {
+		t.Errorf("node idle: \n expected %v, \n got %v \n", expectedIdle, ni.Idle)
+	}
+
+	expectedUsed := buildResource("1000m", "1G")
+	expectedUsed.SetScalar(hugepages, float64(256*1024*1024))
+	if !reflect.DeepEqual(ni.Used, expectedUsed) {
+		t.Errorf("node used: \n expected %v, \n got %v \n", expectedUsed, ni.Used)
+	}
+}
+
+func TestNodeInfo_FutureIdle(t *testing.T) {
+	// case1
+	case01_node := buildNode("n1", buildResourceList("8000m", "10G"))
+	case01_pod1 := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+	case01_pod2 := buildPod("c1", "p2", "n1", v1.PodRunning, buildResourceList("2000m", "2G"), []metav1.OwnerReference{}, make(map[string]string))
+	case01_pod2.DeletionTimestamp = &metav1.Time{}
+
+	tests := []struct {
+		name              string
+		node              *v1.Node
+		pods              []*v1.Pod
+		expected          *Resource
+		expectedIdle      *Resource
+		expectedReleasing *Resource
+	}{
+		{
+			name:              "one running and one releasing pod",
+			node:              case01_node,
+			pods:              []*v1.Pod{case01_pod1, case01_pod2},
+			expected:          buildResource("7000m", "9G"),
+			expectedIdle:      buildResource("5000m", "7G"),
+			expectedReleasing: buildResource("2000m", "2G"),
+		},
+		{
+			name:              "no node",
+			node:              nil,
+			pods:              []*v1.Pod{},
+			expected:          EmptyResource(),
+			expectedIdle:      EmptyResource(),
+			expectedReleasing: EmptyResource(),
+		},
+	}
+
+	for i, test := range tests {
+		ni := NewNodeInfo(test.node)
+
+		for _, pod := range test.pods {
+			pi := NewTaskInfo(pod)
+			ni.AddTask(pi)
+		}
+
+		futureIdle := ni.FutureIdle()
+		if !reflect.DeepEqual(futureIdle, test.expected) {
+			t.Errorf("node info %d: \n expected %v, \n got %v \n",
+				i, test.expected, futureIdle)
+		}
+
+		// Changing the result must not change the node resources
+		futureIdle.Add(buildResource("1000m", "1G"))
+		if !reflect.DeepEqual(ni.Idle, test.expectedIdle) {
+			t.Errorf("node info %d idle: \n expected %v, \n got %v \n",
+				i, test.expectedIdle, ni.Idle)
+		}
+		if !reflect.DeepEqual(ni.Releasing, test.expectedReleasing) {
+			t.Errorf("node info %d releasing: \n expected %v, \n got %v \n",
+				i, test.expectedReleasing, ni.Releasing)
+		}
+	}
+}
+
+func TestNodeInfo_AddPodExceedingIdle(t *testing.T) {
+	node := buildNode("n1", buildResourceList("2000m", "2G"))
+	pod1 := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+	pod2 := buildPod("c1", "p2", "n1", v1.PodRunning, buildResourceList("2000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	if err := ni.AddTask(NewTaskInfo(pod1)); err != nil {
+		t.Fatalf("unexpected error adding task: %v", err)
+	}
+	expected := ni.Clone()
+
+	if err := ni.AddTask(NewTaskInfo(pod2)); err == nil {
+		t.Errorf("expected error adding task exceeding node idle resources")
+	}
+	if !nodeInfoEqual(ni, expected) {
+		t.Errorf("node info: \n expected %v, \n got %v \n", expected, ni)
+	}
+}
+
+func TestNodeInfo_AddBoundPodExceedingIdle(t *testing.T) {
+	node := buildNode("n1", buildResourceList("2000m", "2G"))
+	pod1 := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+	pod2 := buildPod("c1", "p2", "n1", v1.PodRunning, buildResourceList("2000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	for _, pod := range []*v1.Pod{pod1, pod2} {
+		if err := ni.AddBoundTask(NewTaskInfo(pod)); err != nil {
+			t.Fatalf("unexpected error adding bound task: %v", err)
+		}
+	}
+	if expected := buildResource("0m", "0G"); !reflect.DeepEqual(ni.Idle, expected) {
+		t.Errorf("node idle: \n expected %v, \n got %v \n", expected, ni.Idle)
+	}
+	if expected := buildResource("3000m", "2G"); !reflect.DeepEqual(ni.Used, expected) {
+		t.Errorf("node used: \n expected %v, \n got %v \n", expected, ni.Used)
+	}
+
+	// Clones of an over-committed node keep all its tasks
+	if clone := ni.Clone(); !nodeInfoEqual(clone, ni) {
+		t.Errorf("node info clone: \n expected %v, \n got %v \n", ni, clone)
+	}
+}
+
+func TestNodeInfo_SetNodeAfterPods(t *testing.T) {
+	node := buildNode("n1", buildResourceList("2000m", "2G"))
+	pod1 := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+	pod2 := buildPod("c1", "p2", "n1", v1.PodRunning, buildResourceList("2000m", "500M"), []metav1.OwnerReference{}, make(map[string]string))
+
+	// Pods observed before their node are accounted once the node is set
+	ni := NewNodeInfo(nil)
+	for _, pod := range []*v1.Pod{pod1, pod2} {
+		if err := ni.AddTask(NewTaskInfo(pod)); err != nil {
+			t.Fatalf("unexpected error adding task: %v", err)
+		}
+	}
+	ni.SetNode(node)
+
+	if expected := buildResource("0m", "500M"); !reflect.DeepEqual(ni.Idle, expected) {
+		t.Errorf("node idle: \n expected %v, \n got %v \n", expected, ni.Idle)
+	}
+	if expected := buildResource("3000m", "1500M"); !reflect.DeepEqual(ni.Used, expected) {
+		t.Errorf("node used: \n expected %v, \n got %v \n", expected, ni.Used)
+	}
+	if !reflect.DeepEqual(ni.Releasing, EmptyResource()) {
+		t.Errorf("node releasing: \n expected %v, \n got %v \n", EmptyResource(), ni.Releasing)
+	}
+}
+
+func TestNodeInfo_RemovePodExceedingUsed(t *testing.T) {
+	node := buildNode("n1", buildResourceList("2000m", "2G"))
+	pod := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	if err := ni.AddTask(NewTaskInfo(pod)); err != nil {
+		t.Fatalf("unexpected error adding task: %v", err)
+	}
+	// Node usage drifted below the task request
+	ni.Used = buildResource("500m", "1G")
+
+	if err := ni.RemoveTask(NewTaskInfo(pod)); err != nil {
+		t.Fatalf("unexpected error removing task: %v", err)
+	}
+	if !reflect.DeepEqual(ni.Used, EmptyResource()) {
+		t.Errorf("node used: \n expected %v, \n got %v \n", EmptyResource(), ni.Used)
+	}
+	if len(ni.Tasks) != 0 {
+		t.Errorf("expected task to be removed, got %v", ni.Tasks)
+	}
+}
+
+func TestNodeInfo_UpdatePodRequest(t *testing.T) {
+	node := buildNode("n1", buildResourceList("4000m", "4G"))
+	pod := buildPod("c1", "p1", "n1", v1.PodRunning, buildResourceList("1000m", "1G"), []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	task := NewTaskInfo(pod)
+	if err := ni.AddTask(task); err != nil {
+		t.Fatalf("unexpected error adding task: %v", err)
+	}
+
+	// The CPU request of the pod increased, the task still holds the old request
+	scaledPod := pod.DeepCopy()
+	scaledPod.Spec.Containers[0].Resources.Requests = buildResourceList("3000m", "1G")
+	task.Pod = scaledPod
+
+	if err := ni.UpdateTask(task); err != nil {
+		t.Fatalf("unexpected error updating task: %v", err)
+	}
+	if expected := buildResource("1000m", "3G"); !reflect.DeepEqual(ni.Idle, expected) {
+		t.Errorf("node idle: \n expected %v, \n got %v \n", expected, ni.Idle)
+	}
+	if expected := buildResource("3000m", "1G"); !reflect.DeepEqual(ni.Used, expected) {
+		t.Errorf("node used: \n expected %v, \n got %v \n", expected, ni.Used)
+	}
+	if updated := ni.Tasks[PodKey(scaledPod)]; updated == nil || !reflect.DeepEqual(updated.Resreq, buildResource("3000m", "1G")) {
+		t.Errorf("expected the task request to be recomputed, got %v", updated)
+	}
+
+	// A scale-up exceeding the node fails and keeps the current task
+	expected := ni.Clone()
+	oversizedPod := scaledPod.DeepCopy()
+	oversizedPod.Spec.Containers[0].Resources.Requests = buildResourceList("5000m", "1G")
+	oversizedTask := NewTaskInfo(scaledPod)
+	oversizedTask.Pod = oversizedPod
+	if err := ni.UpdateTask(oversizedTask); err == nil {
+		t.Errorf("expected error updating task exceeding node idle resources")
+	}
+	if !nodeInfoEqual(ni, expected) {
+		t.Errorf("node info: \n expected %v, \n got %v \n", expected, ni)
+	}
+
+	// Removing the updated task releases the new request
+	if err := ni.RemoveTask(NewTaskInfo(scaledPod)); err != nil {
+		t.Fatalf("unexpected error removing task: %v", err)
+	}
+	if expected := buildResource("4000m", "4G"); !reflect.DeepEqual(ni.Idle, expected) {
+		t.Errorf("node idle: \n expected %v, \n got %v \n", expected, ni.Idle)
+	}
+}
+
+func TestNodeInfo_Tolerates(t *testing.T) {
+	node := buildNode("n1", buildResourceList("8000m", "10G"))
+	node.Spec.Taints = []v1.Taint{
+		{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+		{Key: "maintenance", Effect: v1.TaintEffectPreferNoSchedule},
+	}
+
+	tests := []struct {
+		name        string
+		tolerations []v1.Toleration
+		expected    bool
+	}{
+		{
+			name:        "no tolerations",
+			tolerations: nil,
+			expected:    false,
+		},
+		{
+			name: "tolerates NoSchedule taint",
+			tolerations: []v1.Toleration{
+				{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "gpu", Effect: v1.TaintEffectNoSchedule},
+			},
+			expected: true,
+		},
+		{
+			name: "tolerates all taints with exists operator",
+			tolerations: []v1.Toleration{
+				{Operator: v1.TolerationOpExists},
+			},
+			expected: true,
+		},
+		{
+			name: "toleration value mismatch",
+			tolerations: []v1.Toleration{
+				{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "cpu", Effect: v1.TaintEffectNoSchedule},
+			},
+			expected: false,
+		},
+	}
+
+	ni := NewNodeInfo(node)
+	for i, test := range tests {
+		if got := ni.Tolerates(test.tolerations); got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestNodeInfo_MatchesNodeSelector(t *testing.T) {
+	node := buildNode("n1", buildResourceList("8000m", "10G"))
+	node.Labels = map[string]string{"zone": "us-east-1a", "gpu": "true"}
+
+	tests := []struct {
+		name     string
+		selector map[string]string
+		expected bool
+	}{
+		{
+			name:     "empty selector",
+			selector: map[string]string{},
+			expected: true,
+		},
+		{
+			name:     "matching selector",
+			selector: map[string]string{"zone": "us-east-1a", "gpu": "true"},
+			expected: true,
+		},
+		{
+			name:     "value mismatch",
+			selector: map[string]string{"zone": "us-east-1b"},
+			expected: false,
+		},
+		{
+			name:     "missing label",
+			selector: map[string]string{"arch": "arm64"},
+			expected: false,
+		},
+	}
+
+	ni := NewNodeInfo(node)
+	for i, test := range tests {
+		if got := ni.MatchesNodeSelector(test.selector); got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestNodeInfo_AllocatableForTolerations(t *testing.T) {
+	taintedNode := buildReadyNode("n1", buildResourceList("8000m", "10G"))
+	taintedNode.Spec.Taints = []v1.Taint{
+		{Key: "node-role.kubernetes.io/master", Effect: v1.TaintEffectNoSchedule},
+	}
+	unschedulableNode := buildReadyNode("n2", buildResourceList("8000m", "10G"))
+	unschedulableNode.Spec.Unschedulable = true
+	cordonedNode := buildReadyNode("n3", buildResourceList("8000m", "10G"))
+	cordonedNode.Spec.Taints = []v1.Taint{
+		{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule},
+	}
+	notReadyNode := buildNode("n4", buildResourceList("8000m", "10G"))
+
+	masterToleration := v1.Toleration{
+		Key:      "node-role.kubernetes.io/master",
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffectNoSchedule,
+	}
+	otherToleration := v1.Toleration{
+		Key:      "dedicated",
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffectNoSchedule,
+	}
+
+	tests := []struct {
+		name        string
+		node        *v1.Node
+		tolerations []v1.Toleration
+		expected    *Resource
+	}{
+		{
+			name:        "tainted node with matching toleration",
+			node:        taintedNode,
+			tolerations: []v1.Toleration{masterToleration},
+			expected:    buildResource("8000m", "10G"),
+		},
+		{
+			name:        "tainted node with non matching toleration",
+			node:        taintedNode,
+			tolerations: []v1.Toleration{otherToleration},
+			expected:    EmptyResource(),
+		},
+		{
+			name:        "tainted node without tolerations",
+			node:        taintedNode,
+			tolerations: nil,
+			expected:    EmptyResource(),
+		},
+		{
+			name:        "unschedulable node",
+			node:        unschedulableNode,
+			tolerations: []v1.Toleration{masterToleration},
+			expected:    EmptyResource(),
+		},
+		{
+			name:        "cordoned node with matching toleration",
+			node:        cordonedNode,
+			tolerations: []v1.Toleration{{Key: v1.TaintNodeUnschedulable, Operator: v1.TolerationOpExists}},
+			expected:    EmptyResource(),
+		},
+		{
+			name:        "node not ready",
+			node:        notReadyNode,
+			tolerations: nil,
+			expected:    EmptyResource(),
+		},
+	}
+
+	for i, test := range tests {
+		ni := NewNodeInfo(test.node)
+		allocatable := ni.AllocatableForTolerations(test.tolerations)
+		if !reflect.DeepEqual(allocatable, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, allocatable)
+		}
+	}
+}
+
+func TestNodeInfo_GPU(t *testing.T) {
+	nodeResources := buildResourceList("8000m", "10G")
+	nodeResources[GPUResourceName] = resource.MustParse("4")
+	node := buildNode("n1", nodeResources)
+
+	podResources := buildResourceList("1000m", "1G")
+	podResources[GPUResourceName] = resource.MustParse("1")
+	pod := buildPod("c1", "p1", "n1", v1.PodRunning, podResources, []metav1.OwnerReference{}, make(map[string]string))
+
+	ni := NewNodeInfo(node)
+	if ni.Allocatable.GPU != 4 || ni.IdleGPU() != 4 {
+		t.Errorf("expected 4 allocatable and idle GPUs from node status, got allocatable %d, idle %d",
+			ni.Allocatable.GPU, ni.IdleGPU())
+	}
+
+	if err := ni.AddTask(NewTaskInfo(pod)); err != nil {
+		t.Fatalf("unexpected error adding task: %v", err)
+	}
+	if ni.IdleGPU() != 3 {
+		t.Errorf("idle GPU: \n expected %v, \n got %v \n", 3, ni.IdleGPU())
+	}
+	if ni.UsedGPU() != 1 {
+		t.Errorf("used GPU: \n expected %v, \n got %v \n", 1, ni.UsedGPU())
+	}
+	if ni.GPUUtilization() != 0.25 {
+		t.Errorf("GPU utilization: \n expected %v, \n got %v \n", 0.25, ni.GPUUtilization())
+	}
+
+	// Nodes without GPUs
+	if utilization := NewNodeInfo(buildNode("n2", buildResourceList("8000m", "10G"))).GPUUtilization(); utilization != 0 {
+		t.Errorf("GPU utilization without GPUs: \n expected %v, \n got %v \n", 0, utilization)
+	}
+}
+
+func TestNodeInfo_GPUSharingFactor(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected int
+	}{
+		{name: "no time-slicing", labels: map[string]string{}, expected: 1},
+		{name: "time-sliced", labels: map[string]string{GPUSharingFactorLabel: "4"}, expected: 4},
+		{name: "invalid factor", labels: map[string]string{GPUSharingFactorLabel: "four"}, expected: 1},
+		{name: "factor below 1", labels: map[string]string{GPUSharingFactorLabel: "0"}, expected: 1},
+	}
+
+	for i, test := range tests {
+		node := buildNode("n1", buildResourceList("8000m", "10G"))
+		node.Labels = test.labels
+		if factor := NewNodeInfo(node).GPUSharingFactor(); factor != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, factor)
+		}
+	}
+
+	if factor := NewNodeInfo(nil).GPUSharingFactor(); factor != 1 {
+		t.Errorf("expected a GPU sharing factor of 1 without node, got %d", factor)
+	}
+}
+
+func TestNodeInfo_Overcommit(t *testing.T) {
+	nodeResources := buildResourceList("8000m", "10G")
+	nodeResources[GPUResourceName] = resource.MustParse("2")
+
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		expectedIdle *Resource
+	}{
+		{
+			name:         "no overcommit",
+			labels:       map[string]string{},
+			expectedIdle: &Resource{MilliCPU: 8000, Memory: 10000000000, GPU: 2},
+		},
+		{
+			name:         "cpu overcommit",
+			labels:       map[string]string{CPUOvercommitLabel: "2"},
+			expectedIdle: &Resource{MilliCPU: 16000, Memory: 10000000000, GPU: 2},
+		},
+		{
+			name:         "cpu and memory overcommit",
+			labels:       map[string]string{CPUOvercommitLabel: "1.5", MemoryOvercommitLabel: "2"},
+			expectedIdle: &Resource{MilliCPU: 12000, Memory: 20000000000, GPU: 2},
+		},
+		{
+			name:         "invalid overcommit",
+			labels:       map[string]string{CPUOvercommitLabel: "0.5", MemoryOvercommitLabel: "lots"},
+			expectedIdle: &Resource{MilliCPU: 8000, Memory: 10000000000, GPU: 2},
+		},
+	}
+
+	for i, test := range tests {
+		node := buildNode("n1", nodeResources)
+		node.Labels = test.labels
+
+		ni := NewNodeInfo(node)
+		if !reflect.DeepEqual(ni.Idle, test.expectedIdle) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expectedIdle, ni.Idle)
+		}
+		if ni.Allocatable.MilliCPU != 8000 {
+			t.Errorf("case %d (%s): expected allocatable not to be overcommitted, got %v", i, test.name, ni.Allocatable)
+		}
+	}
+}
+
+func TestNodeInfo_UsableCapacity(t *testing.T) {
+	tests := []struct {
+		name          string
+		readyStatus   v1.ConditionStatus
+		unschedulable bool
+		taints        []v1.Taint
+		expectedReady bool
+		expected      *Resource
+	}{
+		{
+			name:          "ready",
+			readyStatus:   v1.ConditionTrue,
+			expectedReady: true,
+			expected:      buildResource("8000m", "10G"),
+		},
+		{
+			name:          "not ready",
+			readyStatus:   v1.ConditionFalse,
+			expectedReady: false,
+			expected:      EmptyResource(),
+		},
+		{
+			name:          "ready unknown",
+			readyStatus:   v1.ConditionUnknown,
+			expectedReady: false,
+			expected:      EmptyResource(),
+		},
+		{
+			name:          "unschedulable",
+			readyStatus:   v1.ConditionTrue,
+			unschedulable: true,
+			expectedReady: true,
+			expected:      EmptyResource(),
+		},
+		{
+			name:          "cordoned",
+			readyStatus:   v1.ConditionTrue,
+			taints:        []v1.Taint{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}},
+			expectedReady: true,
+			expected:      EmptyResource(),
+		},
+	}
+
+	for i, test := range tests {
+		node := buildNode("n1", buildResourceList("8000m", "10G"))
+		node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: test.readyStatus}}
+		node.Spec.Unschedulable = test.unschedulable
+		node.Spec.Taints = test.taints
+
+		ni := NewNodeInfo(node)
+		if ni.Ready != test.expectedReady {
+			t.Errorf("case %d (%s): \n expected ready %v, \n got %v \n", i, test.name, test.expectedReady, ni.Ready)
+		}
+		if capacity := ni.UsableCapacity(); !reflect.DeepEqual(capacity, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, capacity)
+		}
+	}
+
+	// Readiness follows node updates
+	node := buildNode("n1", buildResourceList("8000m", "10G"))
+	ni := NewNodeInfo(node)
+	if ni.Ready {
+		t.Errorf("node without ready condition: expected not ready")
+	}
+	node = node.DeepCopy()
+	node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	ni.SetNode(node)
+	if !ni.Ready {
+		t.Errorf("node updated with ready condition: expected ready")
+	}
+}
@@ -31,23 +31,40 @@ limitations under the License.
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
 )
 
 type Resource struct {
 	MilliCPU float64
 	Memory   float64
 	GPU      int64
+
+	// GPUMemory tracks GPU memory requested separately from whole GPU counts, e.g. by partitioned GPUs
+	GPUMemory int64
+
+	// ScalarResources tracks extended resources (e.g. hugepages, MIG devices) by resource name
+	ScalarResources map[v1.ResourceName]float64
 }
 
 const (
 	// need to follow https://github.com/NVIDIA/k8s-device-plugin/blob/66a35b71ac4b5cbfb04714678b548bd77e5ba719/server.go#L20
 	GPUResourceName = "nvidia.com/gpu"
+
+	// Suffix of extended resources representing a shareable GPU, requested in fractional quantities (e.g. "0.5")
+	SharedGPUResourceSuffix = "-shared"
 )
 
+// Name fragments identifying GPU memory resources (e.g. "nvidia.com/gpu-memory")
+var gpuMemoryResourceNames = []string{"gpu-memory", "gpumem"}
+
 func EmptyResource() *Resource {
 	return &Resource{
 		MilliCPU: 0,
@@ -56,11 +73,20 @@ func EmptyResource() *Resource {
 	}
 }
 
+// Clone returns a deep copy of the resource, including its scalar resources, so the copy can be handed to
+// callers without exposing the internal accounting.  Cloning a nil resource returns nil.
 func (r *Resource) Clone() *Resource {
+	if r == nil {
+		return nil
+	}
 	clone := &Resource{
-		MilliCPU: r.MilliCPU,
-		Memory:   r.Memory,
-		GPU:      r.GPU,
+		MilliCPU:  r.MilliCPU,
+		Memory:    r.Memory,
+		GPU:       r.GPU,
+		GPUMemory: r.GPUMemory,
+	}
+	for rName, rQuant := range r.ScalarResources {
+		clone.SetScalar(rName, rQuant)
 	}
 	return clone
 }
@@ -68,9 +94,22 @@ func (r *Resource) Clone() *Resource {
 var minMilliCPU float64 = 10
 var minMemory float64 = 10 * 1024 * 1024
 
+// NewResource converts a ResourceList into a Resource.  Negative quantities, e.g. of a malformed node
+// object, are clamped at zero.
 func NewResource(rl v1.ResourceList) *Resource {
+	return NewResourceOf("", rl)
+}
+
+// NewResourceOf converts a ResourceList of the named owner, e.g. "node worker-1", into a Resource.
+// Negative quantities are clamped at zero and logged with the owner and resource name.
+func NewResourceOf(owner string, rl v1.ResourceList) *Resource {
 	r := EmptyResource()
 	for rName, rQuant := range rl {
+		if rQuant.Sign() < 0 {
+			klog.Warningf("[NewResource] Negative quantity %s of resource %s of %q clamped to zero.",
+				rQuant.String(), rName, owner)
+			continue
+		}
 		switch rName {
 		case v1.ResourceCPU:
 			r.MilliCPU += float64(rQuant.MilliValue())
@@ -79,13 +118,63 @@ func NewResource(rl v1.ResourceList) *Resource {
 		case GPUResourceName:
 			q, _ := rQuant.AsInt64()
 			r.GPU += q
+		default:
+			if IsGPUMemoryResource(rName) {
+				r.GPUMemory += rQuant.Value()
+			} else if IsSharedGPUResource(rName) {
+				// Keep fractional quantities of shared GPUs instead of rounding them up
+				r.AddScalar(rName, float64(rQuant.MilliValue())/1000)
+			} else {
+				r.AddScalar(rName, float64(rQuant.Value()))
+			}
 		}
 	}
 	return r
 }
 
+// IsSharedGPUResource returns true if the named resource is a shareable GPU, i.e. a GPU resource
+// name ending with SharedGPUResourceSuffix.
+func IsSharedGPUResource(name v1.ResourceName) bool {
+	lowerName := strings.ToLower(string(name))
+	return strings.Contains(lowerName, "gpu") && strings.HasSuffix(lowerName, SharedGPUResourceSuffix)
+}
+
+// IsGPUMemoryResource returns true if the named resource is GPU memory, i.e. a resource name containing
+// "gpu-memory" or "gpumem".
+func IsGPUMemoryResource(name v1.ResourceName) bool {
+	lowerName := strings.ToLower(string(name))
+	for _, gpuMemoryResourceName := range gpuMemoryResourceNames {
+		if strings.Contains(lowerName, gpuMemoryResourceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddScalar adds a quantity to the named extended resource.
+func (r *Resource) AddScalar(name v1.ResourceName, quantity float64) {
+	r.SetScalar(name, r.ScalarResources[name]+quantity)
+}
+
+// SetScalar sets the quantity of the named extended resource.
+func (r *Resource) SetScalar(name v1.ResourceName, quantity float64) {
+	if r.ScalarResources == nil {
+		r.ScalarResources = map[v1.ResourceName]float64{}
+	}
+	r.ScalarResources[name] = quantity
+}
+
+// IsEmpty returns true if no resource, including scalar resources, is requested.
 func (r *Resource) IsEmpty() bool {
-	return r.MilliCPU < minMilliCPU && r.Memory < minMemory && r.GPU == 0
+	if r.MilliCPU >= minMilliCPU || r.Memory >= minMemory || r.GPU != 0 || r.GPUMemory != 0 {
+		return false
+	}
+	for _, quantity := range r.ScalarResources {
+		if quantity != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func (r *Resource) IsZero(rn v1.ResourceName) (bool, error) {
@@ -97,30 +186,138 @@ func (r *Resource) IsZero(rn v1.ResourceName) (bool, error) {
 	case GPUResourceName:
 		return r.GPU == 0, nil
 	default:
+		if rQuant, found := r.ScalarResources[rn]; found {
+			return rQuant == 0, nil
+		}
 		e := fmt.Errorf("unknown resource %v", rn)
 		return false, e
 	}
 }
 
+// Add adds rr to r.  Sums larger than math.MaxInt64, e.g. the capacity reported by a malformed node, are
+// saturated at math.MaxInt64 instead of wrapping to negative amounts.
 func (r *Resource) Add(rr *Resource) *Resource {
-	r.MilliCPU += rr.MilliCPU
-	r.Memory += rr.Memory
-	r.GPU += rr.GPU
+	r.MilliCPU = addSaturatedFloat64(r.MilliCPU, rr.MilliCPU, "milliCPU")
+	r.Memory = addSaturatedFloat64(r.Memory, rr.Memory, "memory")
+	r.GPU = addSaturatedInt64(r.GPU, rr.GPU, "GPU")
+	r.GPUMemory = addSaturatedInt64(r.GPUMemory, rr.GPUMemory, "GPU memory")
+	for rName, rQuant := range rr.ScalarResources {
+		r.SetScalar(rName, addSaturatedFloat64(r.ScalarResources[rName], rQuant, string(rName)))
+	}
 	return r
 }
 
+// addSaturatedInt64 returns a+b, saturated at math.MaxInt64 and math.MinInt64.
+func addSaturatedInt64(a, b int64, rName string) int64 {
+	if b > 0 && a > math.MaxInt64-b {
+		klog.Warningf("[Add] Sum of %d and %d of resource %s saturated at %d.", a, b, rName, int64(math.MaxInt64))
+		return math.MaxInt64
+	}
+	if b < 0 && a < math.MinInt64-b {
+		klog.Warningf("[Add] Sum of %d and %d of resource %s saturated at %d.", a, b, rName, int64(math.MinInt64))
+		return math.MinInt64
+	}
+	return a + b
+}
+
+// addSaturatedFloat64 returns a+b, capped at math.MaxInt64 so the sum converts to int64 without wrapping.
+func addSaturatedFloat64(a, b float64, rName string) float64 {
+	sum := a + b
+	if sum > math.MaxInt64 {
+		klog.Warningf("[Add] Sum of %v and %v of resource %s saturated at %d.", a, b, rName, int64(math.MaxInt64))
+		return math.MaxInt64
+	}
+	return sum
+}
+
 func (r *Resource) Replace(rr *Resource) *Resource {
 	r.MilliCPU = rr.MilliCPU
 	r.Memory = rr.Memory
 	r.GPU = rr.GPU
+	r.GPUMemory = rr.GPUMemory
+	r.ScalarResources = nil
+	for rName, rQuant := range rr.ScalarResources {
+		r.SetScalar(rName, rQuant)
+	}
 	return r
 }
 
-//Sub subtracts two Resource objects.
+// Scale returns a new Resource with every resource multiplied by factor.  GPU counts are rounded down.
+func (r *Resource) Scale(factor float64) *Resource {
+	scaled := &Resource{
+		MilliCPU:  r.MilliCPU * factor,
+		Memory:    r.Memory * factor,
+		GPU:       int64(math.Floor(float64(r.GPU) * factor)),
+		GPUMemory: int64(math.Floor(float64(r.GPUMemory) * factor)),
+	}
+	for rName, rQuant := range r.ScalarResources {
+		scaled.SetScalar(rName, rQuant*factor)
+	}
+	return scaled
+}
+
+// Max returns a new Resource with the element-wise maximum of both Resources, e.g. the envelope of the
+// demands of several pod templates.  Scalar resources missing from either Resource count as zero.
+func (r *Resource) Max(rr *Resource) *Resource {
+	max := &Resource{
+		MilliCPU:  math.Max(r.MilliCPU, rr.MilliCPU),
+		Memory:    math.Max(r.Memory, rr.Memory),
+		GPU:       r.GPU,
+		GPUMemory: r.GPUMemory,
+	}
+	if rr.GPU > max.GPU {
+		max.GPU = rr.GPU
+	}
+	if rr.GPUMemory > max.GPUMemory {
+		max.GPUMemory = rr.GPUMemory
+	}
+	for rName, rQuant := range r.ScalarResources {
+		max.SetScalar(rName, rQuant)
+	}
+	for rName, rQuant := range rr.ScalarResources {
+		if rQuant > max.ScalarResources[rName] {
+			max.SetScalar(rName, rQuant)
+		}
+	}
+	return max
+}
+
+// Min returns a new Resource with the element-wise minimum of both Resources.  Scalar resources missing
+// from either Resource count as zero and are omitted.
+func (r *Resource) Min(rr *Resource) *Resource {
+	min := &Resource{
+		MilliCPU:  math.Min(r.MilliCPU, rr.MilliCPU),
+		Memory:    math.Min(r.Memory, rr.Memory),
+		GPU:       r.GPU,
+		GPUMemory: r.GPUMemory,
+	}
+	if rr.GPU < min.GPU {
+		min.GPU = rr.GPU
+	}
+	if rr.GPUMemory < min.GPUMemory {
+		min.GPUMemory = rr.GPUMemory
+	}
+	for rName, rQuant := range r.ScalarResources {
+		if rrQuant, found := rr.ScalarResources[rName]; found {
+			min.SetScalar(rName, math.Min(rQuant, rrQuant))
+		}
+	}
+	return min
+}
+
+// Sub subtracts two Resource objects.  Accounting paths where a negative result reveals an inconsistency,
+// e.g. allocating a task on a node, use Sub and handle the error.
 func (r *Resource) Sub(rr *Resource) (*Resource, error) {
 	return r.NonNegSub(rr)
 }
 
+// SubClamp subtracts two Resource objects, clamping every resource at zero.  Accounting paths that may
+// legitimately over-subtract, e.g. removing a task from a node, use SubClamp.
+func (r *Resource) SubClamp(rr *Resource) *Resource {
+	clamped, _ := r.NonNegSub(rr)
+	return clamped
+}
+
 //Sub subtracts two Resource objects and return zero for negative subtractions.
 func (r *Resource) NonNegSub(rr *Resource) (*Resource, error) {
 	// Check for negative calculation
@@ -153,6 +350,28 @@ func (r *Resource) NonNegSub(rr *Resource) (*Resource, error) {
 	} else {
 		r.GPU -= rr.GPU
 	}
+
+	if r.GPUMemory < rr.GPUMemory {
+		r.GPUMemory = 0
+		isNegative = true
+		if rCopy == nil {
+			rCopy = r.Clone()
+		}
+	} else {
+		r.GPUMemory -= rr.GPUMemory
+	}
+
+	for rName, rQuant := range rr.ScalarResources {
+		if r.ScalarResources[rName] < rQuant {
+			r.SetScalar(rName, 0)
+			isNegative = true
+			if rCopy == nil {
+				rCopy = r.Clone()
+			}
+		} else {
+			r.SetScalar(rName, r.ScalarResources[rName]-rQuant)
+		}
+	}
 	if isNegative {
 		err = fmt.Errorf("resource subtraction resulted in negative value, total resource: %v, subtracting resource: %v", rCopy, rr)
 	}
@@ -163,15 +382,168 @@ func (r *Resource) Less(rr *Resource) bool {
 	return r.MilliCPU < rr.MilliCPU && r.Memory < rr.Memory && r.GPU < rr.GPU
 }
 
+// LessEqual returns true if every resource, including the extended resources, is less than or equal
+// to the corresponding resource of rr.  Extended resources missing from rr are zero.
 func (r *Resource) LessEqual(rr *Resource) bool {
-	return (r.MilliCPU < rr.MilliCPU || math.Abs(rr.MilliCPU-r.MilliCPU) < 0.01) &&
+	if !((r.MilliCPU < rr.MilliCPU || math.Abs(rr.MilliCPU-r.MilliCPU) < 0.01) &&
 		(r.Memory < rr.Memory || math.Abs(rr.Memory-r.Memory) < 1) &&
-		(r.GPU <= rr.GPU)
+		(r.GPU <= rr.GPU) && (r.GPUMemory <= rr.GPUMemory)) {
+		return false
+	}
+
+	for rName, rQuant := range r.ScalarResources {
+		if rQuant > rr.ScalarResources[rName] {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the amounts by which each resource of r exceeds the resource of rr, and the amounts by
+// which it falls short of the resource of rr.  Both results are new non negative Resources.
+func (r *Resource) Diff(rr *Resource) (*Resource, *Resource) {
+	increased := EmptyResource()
+	decreased := EmptyResource()
+
+	if r.MilliCPU > rr.MilliCPU {
+		increased.MilliCPU = r.MilliCPU - rr.MilliCPU
+	} else {
+		decreased.MilliCPU = rr.MilliCPU - r.MilliCPU
+	}
+	if r.Memory > rr.Memory {
+		increased.Memory = r.Memory - rr.Memory
+	} else {
+		decreased.Memory = rr.Memory - r.Memory
+	}
+	if r.GPU > rr.GPU {
+		increased.GPU = r.GPU - rr.GPU
+	} else {
+		decreased.GPU = rr.GPU - r.GPU
+	}
+	if r.GPUMemory > rr.GPUMemory {
+		increased.GPUMemory = r.GPUMemory - rr.GPUMemory
+	} else {
+		decreased.GPUMemory = rr.GPUMemory - r.GPUMemory
+	}
+
+	for rName, rQuant := range r.ScalarResources {
+		if rrQuant := rr.ScalarResources[rName]; rQuant > rrQuant {
+			increased.SetScalar(rName, rQuant-rrQuant)
+		} else if rQuant < rrQuant {
+			decreased.SetScalar(rName, rrQuant-rQuant)
+		}
+	}
+	for rName, rrQuant := range rr.ScalarResources {
+		if _, found := r.ScalarResources[rName]; !found && rrQuant > 0 {
+			decreased.SetScalar(rName, rrQuant)
+		}
+	}
+
+	return increased, decreased
 }
 
+// String formats the resource with units: cpu in cores, or millicores when not a whole number of cores,
+// and memory with binary unit suffixes.
 func (r *Resource) String() string {
-	return fmt.Sprintf("cpu %0.2f, memory %0.2f, GPU %d",
+	res := fmt.Sprintf("cpu %s, memory %s, GPU %d",
+		formatMilliCPU(r.MilliCPU), formatMemory(r.Memory), r.GPU)
+	if r.GPUMemory > 0 {
+		res = fmt.Sprintf("%s, GPU memory %d", res, r.GPUMemory)
+	}
+	for _, rName := range r.scalarResourceNames() {
+		res = fmt.Sprintf("%s, %s %s", res, rName, strconv.FormatFloat(r.ScalarResources[rName], 'f', -1, 64))
+	}
+	return res
+}
+
+// RawString formats the raw resource values, cpu in millicores and memory in bytes, for debugging.
+func (r *Resource) RawString() string {
+	res := fmt.Sprintf("cpu %0.2f, memory %0.2f, GPU %d",
 		r.MilliCPU, r.Memory, r.GPU)
+	if r.GPUMemory > 0 {
+		res = fmt.Sprintf("%s, GPU memory %d", res, r.GPUMemory)
+	}
+	for _, rName := range r.scalarResourceNames() {
+		res = fmt.Sprintf("%s, %s %0.2f", res, rName, r.ScalarResources[rName])
+	}
+	return res
+}
+
+// resourceJSON is the JSON form of a Resource, its field names do not depend on the Resource fields.
+type resourceJSON struct {
+	MilliCPU  float64                     `json:"millicpu"`
+	Memory    float64                     `json:"memory"`
+	GPU       int64                       `json:"gpu"`
+	GPUMemory int64                       `json:"gpuMemory"`
+	Scalars   map[v1.ResourceName]float64 `json:"scalars"`
+}
+
+// MarshalJSON encodes the resource as an object with the millicpu, memory, gpu and gpuMemory amounts and
+// the scalar resources as a nested object keyed by resource name, e.g. for status reporting.
+func (r *Resource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resourceJSON{
+		MilliCPU:  r.MilliCPU,
+		Memory:    r.Memory,
+		GPU:       r.GPU,
+		GPUMemory: r.GPUMemory,
+		Scalars:   r.ScalarResources,
+	})
+}
+
+// UnmarshalJSON decodes a resource encoded by MarshalJSON.
+func (r *Resource) UnmarshalJSON(data []byte) error {
+	var decoded resourceJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = Resource{
+		MilliCPU:        decoded.MilliCPU,
+		Memory:          decoded.Memory,
+		GPU:             decoded.GPU,
+		GPUMemory:       decoded.GPUMemory,
+		ScalarResources: decoded.Scalars,
+	}
+	return nil
+}
+
+// scalarResourceNames returns the names of the scalar resources in sorted order.
+func (r *Resource) scalarResourceNames() []v1.ResourceName {
+	var names []v1.ResourceName
+	for rName := range r.ScalarResources {
+		names = append(names, rName)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// formatMilliCPU formats millicores as whole cores, e.g. "2", or as millicores, e.g. "2500m".
+func formatMilliCPU(milliCPU float64) string {
+	if math.Mod(milliCPU, 1000) == 0 {
+		return strconv.FormatFloat(milliCPU/1000, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(milliCPU, 'f', -1, 64) + "m"
+}
+
+// Binary memory units used to format memory, largest first
+var memoryUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"Ti", 1024 * 1024 * 1024 * 1024},
+	{"Gi", 1024 * 1024 * 1024},
+	{"Mi", 1024 * 1024},
+	{"Ki", 1024},
+}
+
+// formatMemory formats bytes in the largest binary unit not exceeding the value, rounded to two
+// decimals, e.g. "1.5Gi".  Values below 1Ki are formatted in bytes.
+func formatMemory(memory float64) string {
+	for _, unit := range memoryUnits {
+		if memory >= unit.bytes {
+			return strconv.FormatFloat(math.Round(memory/unit.bytes*100)/100, 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatFloat(memory, 'f', -1, 64)
 }
 
 func (r *Resource) Get(rn v1.ResourceName) (float64, error) {
@@ -183,6 +555,9 @@ func (r *Resource) Get(rn v1.ResourceName) (float64, error) {
 	case GPUResourceName:
 		return float64(r.GPU), nil
 	default:
+		if rQuant, found := r.ScalarResources[rn]; found {
+			return rQuant, nil
+		}
 		err := fmt.Errorf("resource not supported %v", rn)
 		return 0.0, err
 	}
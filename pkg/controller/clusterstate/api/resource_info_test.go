@@ -0,0 +1,500 @@
+/*
+Copyright 2019, 2021 The Multi-Cluster App Dispatcher Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+func TestNewResource_SharedGPU(t *testing.T) {
+	tests := []struct {
+		name     string
+		rName    v1.ResourceName
+		quantity string
+		expected float64
+	}{
+		{
+			name:     "half shared gpu",
+			rName:    "nvidia.com/gpu-shared",
+			quantity: "0.5",
+			expected: 0.5,
+		},
+		{
+			name:     "milli shared gpu",
+			rName:    "nvidia.com/gpu-shared",
+			quantity: "250m",
+			expected: 0.25,
+		},
+		{
+			name:     "non shared extended resource",
+			rName:    "example.com/device",
+			quantity: "500m",
+			expected: 1,
+		},
+	}
+
+	for i, test := range tests {
+		r := NewResource(v1.ResourceList{test.rName: resource.MustParse(test.quantity)})
+		if got := r.ScalarResources[test.rName]; got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestNewResource_NegativeQuantity(t *testing.T) {
+	var logs bytes.Buffer
+	klog.LogToStderr(false)
+	klog.SetOutput(&logs)
+	defer klog.LogToStderr(true)
+
+	r := NewResourceOf("node worker-1", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("-500m"),
+		v1.ResourceMemory: resource.MustParse("1G"),
+	})
+	klog.Flush()
+
+	expected := &Resource{Memory: 1000000000}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("negative quantity: \n expected %v, \n got %v \n", expected, r)
+	}
+	if !strings.Contains(logs.String(), "Negative quantity -500m of resource cpu of \"node worker-1\"") {
+		t.Errorf("expected a warning naming the node and resource, got %q", logs.String())
+	}
+}
+
+func TestNewResource_GPUMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		rName    v1.ResourceName
+		quantity string
+		expected int64
+	}{
+		{
+			name:     "gpu memory",
+			rName:    "nvidia.com/gpu-memory",
+			quantity: "16",
+			expected: 16,
+		},
+		{
+			name:     "gpumem",
+			rName:    "example.com/GPUMem",
+			quantity: "8",
+			expected: 8,
+		},
+		{
+			name:     "whole gpu",
+			rName:    GPUResourceName,
+			quantity: "2",
+			expected: 0,
+		},
+	}
+
+	for i, test := range tests {
+		r := NewResource(v1.ResourceList{test.rName: resource.MustParse(test.quantity)})
+		if r.GPUMemory != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, r.GPUMemory)
+		}
+		if _, found := r.ScalarResources[test.rName]; found {
+			t.Errorf("case %d (%s): unexpected scalar resource %s", i, test.name, test.rName)
+		}
+	}
+}
+
+func TestResource_LessEqual(t *testing.T) {
+	gpuNode := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("4"),
+		v1.ResourceMemory: resource.MustParse("8Gi"),
+		GPUResourceName:   resource.MustParse("1"),
+		"example.com/dev": resource.MustParse("2"),
+	})
+
+	tests := []struct {
+		name     string
+		demand   *Resource
+		expected bool
+	}{
+		{
+			name:     "equal",
+			demand:   gpuNode.Clone(),
+			expected: true,
+		},
+		{
+			name: "strictly less",
+			demand: NewResource(v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("1Gi"),
+				"example.com/dev": resource.MustParse("1"),
+			}),
+			expected: true,
+		},
+		{
+			name: "cpu fits but gpu does not",
+			demand: NewResource(v1.ResourceList{
+				v1.ResourceCPU:  resource.MustParse("1"),
+				GPUResourceName: resource.MustParse("2"),
+			}),
+			expected: false,
+		},
+		{
+			name: "extended resource does not fit",
+			demand: NewResource(v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				"example.com/dev": resource.MustParse("3"),
+			}),
+			expected: false,
+		},
+		{
+			name: "missing extended resource",
+			demand: NewResource(v1.ResourceList{
+				"example.com/other": resource.MustParse("1"),
+			}),
+			expected: false,
+		},
+	}
+
+	for i, test := range tests {
+		if got := test.demand.LessEqual(gpuNode); got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestResource_Diff(t *testing.T) {
+	r := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("2"),
+		GPUResourceName:   resource.MustParse("1"),
+		"example.com/dev": resource.MustParse("3"),
+	})
+	rr := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse("1G"),
+		GPUResourceName:   resource.MustParse("2"),
+		"example.com/dev": resource.MustParse("3"),
+	})
+
+	expectedIncreased := &Resource{MilliCPU: 1000}
+	expectedDecreased := &Resource{Memory: 1000000000, GPU: 1}
+
+	increased, decreased := r.Diff(rr)
+	if !reflect.DeepEqual(increased, expectedIncreased) {
+		t.Errorf("increased: \n expected %v, \n got %v \n", expectedIncreased, increased)
+	}
+	if !reflect.DeepEqual(decreased, expectedDecreased) {
+		t.Errorf("decreased: \n expected %v, \n got %v \n", expectedDecreased, decreased)
+	}
+}
+
+func TestResource_Scale(t *testing.T) {
+	r := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("2"),
+		v1.ResourceMemory: resource.MustParse("1G"),
+		GPUResourceName:   resource.MustParse("3"),
+		"example.com/dev": resource.MustParse("4"),
+	})
+
+	expected := &Resource{MilliCPU: 3000, Memory: 1500000000, GPU: 4}
+	expected.SetScalar("example.com/dev", 6)
+
+	scaled := r.Scale(1.5)
+	if !reflect.DeepEqual(scaled, expected) {
+		t.Errorf("scaled resource: \n expected %v, \n got %v \n", expected, scaled)
+	}
+	if r.MilliCPU != 2000 {
+		t.Errorf("expected the scaled resource to be unchanged, got %v", r)
+	}
+}
+
+func TestResource_MaxMin(t *testing.T) {
+	// r dominates in CPU, rr dominates in GPU
+	r := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("4"),
+		v1.ResourceMemory: resource.MustParse("1G"),
+		GPUResourceName:   resource.MustParse("1"),
+		"example.com/dev": resource.MustParse("2"),
+	})
+	rr := NewResource(v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse("1G"),
+		GPUResourceName:   resource.MustParse("4"),
+		"example.com/nic": resource.MustParse("1"),
+	})
+
+	expectedMax := &Resource{MilliCPU: 4000, Memory: 1000000000, GPU: 4}
+	expectedMax.SetScalar("example.com/dev", 2)
+	expectedMax.SetScalar("example.com/nic", 1)
+	if max := r.Max(rr); !reflect.DeepEqual(max, expectedMax) {
+		t.Errorf("max resource: \n expected %v, \n got %v \n", expectedMax, max)
+	}
+
+	expectedMin := &Resource{MilliCPU: 1000, Memory: 1000000000, GPU: 1}
+	if min := r.Min(rr); !reflect.DeepEqual(min, expectedMin) {
+		t.Errorf("min resource: \n expected %v, \n got %v \n", expectedMin, min)
+	}
+
+	// Max and Min are commutative and leave their operands unchanged
+	if !reflect.DeepEqual(rr.Max(r), expectedMax) || !reflect.DeepEqual(rr.Min(r), expectedMin) {
+		t.Errorf("expected Max and Min to be commutative")
+	}
+	if r.MilliCPU != 4000 || r.GPU != 1 || rr.MilliCPU != 1000 || rr.GPU != 4 {
+		t.Errorf("expected the operands to be unchanged, got %v and %v", r, rr)
+	}
+}
+
+func TestResource_Clone(t *testing.T) {
+	r := &Resource{MilliCPU: 4000, Memory: 8e9, GPU: 2, GPUMemory: 16}
+	r.SetScalar("example.com/dev", 3)
+	expected := &Resource{MilliCPU: 4000, Memory: 8e9, GPU: 2, GPUMemory: 16}
+	expected.SetScalar("example.com/dev", 3)
+
+	clone := r.Clone()
+	if !reflect.DeepEqual(clone, r) {
+		t.Fatalf("clone: \n expected %v, \n got %v \n", r, clone)
+	}
+
+	// Mutating the clone leaves the original unchanged
+	clone.MilliCPU = 1000
+	clone.Memory = 1e9
+	clone.GPU = 0
+	clone.GPUMemory = 0
+	clone.SetScalar("example.com/dev", 1)
+	clone.SetScalar("example.com/other", 1)
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("original after mutating the clone: \n expected %v, \n got %v \n", expected, r)
+	}
+
+	var nilResource *Resource
+	if nilClone := nilResource.Clone(); nilClone != nil {
+		t.Errorf("expected nil clone of nil resource, got %v", nilClone)
+	}
+}
+
+func TestResource_IsEmpty(t *testing.T) {
+	scalar := EmptyResource()
+	scalar.SetScalar("example.com/dev", 1)
+
+	tests := []struct {
+		name     string
+		resource *Resource
+		expected bool
+	}{
+		{name: "empty", resource: EmptyResource(), expected: true},
+		{name: "cpu", resource: buildResource("100m", "0"), expected: false},
+		{name: "memory", resource: buildResource("0", "1G"), expected: false},
+		{name: "gpu", resource: &Resource{GPU: 1}, expected: false},
+		{name: "scalar", resource: scalar, expected: false},
+	}
+
+	for i, test := range tests {
+		if empty := test.resource.IsEmpty(); empty != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, empty)
+		}
+	}
+}
+
+func TestResource_SubClamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        *Resource
+		rr       *Resource
+		expected *Resource
+	}{
+		{
+			name:     "subtraction above zero",
+			r:        &Resource{MilliCPU: 2000, Memory: 2000, GPU: 2},
+			rr:       &Resource{MilliCPU: 1000, Memory: 500, GPU: 1},
+			expected: &Resource{MilliCPU: 1000, Memory: 1500, GPU: 1},
+		},
+		{
+			name:     "subtraction to zero",
+			r:        &Resource{MilliCPU: 1000, Memory: 1000, GPU: 1},
+			rr:       &Resource{MilliCPU: 1000, Memory: 1000, GPU: 1},
+			expected: &Resource{},
+		},
+		{
+			name:     "subtraction below zero clamped",
+			r:        &Resource{MilliCPU: 1000, Memory: 1000, GPU: 1},
+			rr:       &Resource{MilliCPU: 1001, Memory: 2000, GPU: 2},
+			expected: &Resource{},
+		},
+		{
+			name:     "partial subtraction below zero clamped",
+			r:        &Resource{MilliCPU: 1000, Memory: 1000, GPU: 1},
+			rr:       &Resource{MilliCPU: 2000, Memory: 500},
+			expected: &Resource{Memory: 500, GPU: 1},
+		},
+	}
+
+	for i, test := range tests {
+		if clamped := test.r.Clone().SubClamp(test.rr); !reflect.DeepEqual(clamped, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, clamped)
+		}
+	}
+
+	// Scalar resources are clamped at zero as well
+	r := &Resource{}
+	r.SetScalar("example.com/dev", 1)
+	rr := &Resource{}
+	rr.SetScalar("example.com/dev", 2)
+	if clamped := r.SubClamp(rr); clamped.ScalarResources["example.com/dev"] != 0 {
+		t.Errorf("expected scalar resource clamped at zero, got %v", clamped)
+	}
+}
+
+func TestResource_AddSaturated(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        *Resource
+		rr       *Resource
+		expected *Resource
+	}{
+		{
+			name:     "addition below max",
+			r:        &Resource{MilliCPU: 1000, Memory: 1000, GPU: 1, GPUMemory: 1},
+			rr:       &Resource{MilliCPU: 1000, Memory: 500, GPU: 2, GPUMemory: 2},
+			expected: &Resource{MilliCPU: 2000, Memory: 1500, GPU: 3, GPUMemory: 3},
+		},
+		{
+			name:     "near max addition saturated",
+			r:        &Resource{MilliCPU: math.MaxInt64 - 1, Memory: math.MaxInt64 - 1, GPU: math.MaxInt64 - 1, GPUMemory: math.MaxInt64 - 1},
+			rr:       &Resource{MilliCPU: math.MaxInt64 - 1, Memory: math.MaxInt64 - 1, GPU: math.MaxInt64 - 1, GPUMemory: math.MaxInt64 - 1},
+			expected: &Resource{MilliCPU: math.MaxInt64, Memory: math.MaxInt64, GPU: math.MaxInt64, GPUMemory: math.MaxInt64},
+		},
+		{
+			name:     "addition to max",
+			r:        &Resource{GPU: math.MaxInt64 - 1},
+			rr:       &Resource{GPU: 1},
+			expected: &Resource{GPU: math.MaxInt64},
+		},
+		{
+			name: "scalar addition saturated",
+			r:    &Resource{ScalarResources: map[v1.ResourceName]float64{"example.com/dev": math.MaxInt64 - 1}},
+			rr: &Resource{ScalarResources: map[v1.ResourceName]float64{"example.com/dev": math.MaxInt64 - 1,
+				"example.com/other": 2}},
+			expected: &Resource{ScalarResources: map[v1.ResourceName]float64{"example.com/dev": math.MaxInt64,
+				"example.com/other": 2}},
+		},
+	}
+
+	for i, test := range tests {
+		if sum := test.r.Clone().Add(test.rr); !reflect.DeepEqual(sum, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, sum)
+		}
+	}
+}
+
+func TestResource_String(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        *Resource
+		expected string
+	}{
+		{
+			name:     "empty resource",
+			r:        EmptyResource(),
+			expected: "cpu 0, memory 0, GPU 0",
+		},
+		{
+			name:     "whole cores and gibibytes",
+			r:        &Resource{MilliCPU: 2000, Memory: 2 * 1024 * 1024 * 1024, GPU: 1},
+			expected: "cpu 2, memory 2Gi, GPU 1",
+		},
+		{
+			name:     "millicores and fractional gibibytes",
+			r:        &Resource{MilliCPU: 2500, Memory: 1.5 * 1024 * 1024 * 1024},
+			expected: "cpu 2500m, memory 1.5Gi, GPU 0",
+		},
+		{
+			name:     "millicores and mebibytes",
+			r:        &Resource{MilliCPU: 100, Memory: 512 * 1024 * 1024},
+			expected: "cpu 100m, memory 512Mi, GPU 0",
+		},
+		{
+			name:     "memory below a kibibyte",
+			r:        &Resource{Memory: 1000, GPU: 4, GPUMemory: 8},
+			expected: "cpu 0, memory 1000, GPU 4, GPU memory 8",
+		},
+		{
+			name:     "decimal memory",
+			r:        &Resource{MilliCPU: 1000, Memory: 1000000000},
+			expected: "cpu 1, memory 953.67Mi, GPU 0",
+		},
+	}
+
+	for i, test := range tests {
+		if got := test.r.String(); got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+
+	r := &Resource{MilliCPU: 2500, Memory: 1024}
+	r.SetScalar("example.com/dev", 2)
+	if got, expected := r.RawString(), "cpu 2500.00, memory 1024.00, GPU 0, example.com/dev 2.00"; got != expected {
+		t.Errorf("raw string: \n expected %v, \n got %v \n", expected, got)
+	}
+}
+
+func TestResource_JSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        *Resource
+		expected string
+	}{
+		{
+			name:     "empty resource",
+			r:        EmptyResource(),
+			expected: `{"millicpu":0,"memory":0,"gpu":0,"gpuMemory":0,"scalars":null}`,
+		},
+		{
+			name: "scalar resources",
+			r: &Resource{MilliCPU: 2500, Memory: 1.5 * 1024 * 1024 * 1024, GPU: 2, GPUMemory: 8,
+				ScalarResources: map[v1.ResourceName]float64{"nvidia.com/gpu-shared": 0.5, "hugepages-2Mi": 4194304}},
+			expected: `{"millicpu":2500,"memory":1610612736,"gpu":2,"gpuMemory":8,` +
+				`"scalars":{"hugepages-2Mi":4194304,"nvidia.com/gpu-shared":0.5}}`,
+		},
+		{
+			name:     "no scalar resources",
+			r:        &Resource{MilliCPU: 0.5, ScalarResources: map[v1.ResourceName]float64{}},
+			expected: `{"millicpu":0.5,"memory":0,"gpu":0,"gpuMemory":0,"scalars":{}}`,
+		},
+	}
+
+	for i, test := range tests {
+		data, err := json.Marshal(test.r)
+		if err != nil {
+			t.Fatalf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if string(data) != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, string(data))
+		}
+
+		decoded := &Resource{}
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if !reflect.DeepEqual(decoded, test.r) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.r, decoded)
+		}
+	}
+}
@@ -73,6 +73,8 @@ type ClusterStateCache struct {
 	availableResources *api.Resource
 	availableHistogram *api.ResourceHistogram
 	resourceCapacities *api.Resource
+	// Smallest GPU sharing factor of the schedulable nodes with GPUs
+	gpuSharingFactor int
 	deletedJobs        *cache.FIFO
 
 	errTasks *cache.FIFO
@@ -172,6 +174,7 @@ func newClusterStateCache(config *rest.Config) *ClusterStateCache {
 	sc.availableResources = api.EmptyResource()
 	sc.availableHistogram = api.NewResourceHistogram(api.EmptyResource(), api.EmptyResource())
 	sc.resourceCapacities = api.EmptyResource()
+	sc.gpuSharingFactor = 1
 
 	return sc
 }
@@ -206,8 +209,7 @@ func (sc *ClusterStateCache) GetUnallocatedResources() *api.Resource {
 	sc.Mutex.Lock()
 	defer sc.Mutex.Unlock()
 
-	r := api.EmptyResource()
-	return r.Add(sc.availableResources)
+	return sc.availableResources.Clone()
 }
 
 
@@ -232,13 +234,21 @@ func (sc *ClusterStateCache) GetResourceCapacities() *api.Resource {
 	sc.Mutex.Lock()
 	defer sc.Mutex.Unlock()
 
-	r := api.EmptyResource()
-	return r.Add(sc.resourceCapacities)
+	return sc.resourceCapacities.Clone()
+}
+
+// GetGPUSharingFactor returns the smallest number of GPU replicas advertised per physical GPU of the
+// schedulable nodes with GPUs, 1 without time-sliced GPUs.
+func (sc *ClusterStateCache) GetGPUSharingFactor() int {
+	sc.Mutex.Lock()
+	defer sc.Mutex.Unlock()
+
+	return sc.gpuSharingFactor
 }
 
 // Save the cluster state.
 func (sc *ClusterStateCache) saveState(available *api.Resource, capacity *api.Resource,
-								availableHistogram *api.ResourceHistogram) error {
+	availableHistogram *api.ResourceHistogram, gpuSharingFactor int) error {
 	klog.V(12).Infof("Saving Cluster State")
 
 	sc.Mutex.Lock()
@@ -246,6 +256,7 @@ func (sc *ClusterStateCache) saveState(available *api.Resource, capacity *api.Re
 	sc.availableResources.Replace(available)
 	sc.resourceCapacities.Replace(capacity)
 	sc.availableHistogram = availableHistogram
+	sc.gpuSharingFactor = gpuSharingFactor
 	klog.V(12).Infof("Updated Cluster State completed.")
 	return nil
 }
@@ -260,6 +271,7 @@ func (sc *ClusterStateCache) updateState() error {
 	idle := api.EmptyResource()
 	idleMin := api.EmptyResource()
 	idleMax := api.EmptyResource()
+	gpuSharingFactor := 0
 
 	firstNode :=  true
 	for _, value := range cluster.Nodes {
@@ -274,6 +286,14 @@ func (sc *ClusterStateCache) updateState() error {
 		used = used.Add(value.Used)
 		idle = idle.Add(value.Idle)
 
+		// Deliberately a single cluster-wide factor: the quota is evaluated before the pods are placed on
+		// nodes, and the smallest sharing factor never under-counts the physical GPUs of a demand
+		if value.Allocatable.GPU > 0 {
+			if factor := value.GPUSharingFactor(); gpuSharingFactor == 0 || factor < gpuSharingFactor {
+				gpuSharingFactor = factor
+			}
+		}
+
 		// Collect Min and Max for histogram
 		if firstNode {
 			idleMin.MilliCPU = idle.MilliCPU
@@ -329,7 +349,11 @@ func (sc *ClusterStateCache) updateState() error {
 		klog.V(12).Infof("[updateState] GPU histogram:\n%s", proto.MarshalTextString(metricGPU))
 	}
 
-	err := sc.saveState(idle, total, newIdleHistogram)
+	if gpuSharingFactor == 0 {
+		gpuSharingFactor = 1
+	}
+
+	err := sc.saveState(idle, total, newIdleHistogram, gpuSharingFactor)
 	return err
 }
 
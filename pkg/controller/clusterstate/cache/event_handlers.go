@@ -70,7 +70,7 @@ func (sc *ClusterStateCache) addTask(pi *arbapi.TaskInfo) error {
 		node := sc.Nodes[pi.NodeName]
 		if !isTerminated(pi.Status) {
 			klog.V(10).Infof("Adding Task: %s to node: %s.", pi.Name, pi.NodeName)
-			return node.AddTask(pi)
+			return node.AddBoundTask(pi)
 		} else {
 			klog.V(10).Infof("Task: %s is terminated.  Did not added not node: %s.", pi.Name, pi.NodeName)
 		}
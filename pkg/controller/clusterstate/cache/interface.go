@@ -55,4 +55,10 @@ type Cache interface {
 
 	// Obtains current cluster unallocated histogram of resources
 	GetUnallocatedHistograms() map[string]*dto.Metric
+
+	// Obtains current cluster capacity, the allocatable resources of the schedulable nodes
+	GetResourceCapacities() *api.Resource
+
+	// Obtains the smallest number of GPU replicas advertised per physical GPU of the time-sliced nodes
+	GetGPUSharingFactor() int
 }
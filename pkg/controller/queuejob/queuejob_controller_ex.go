@@ -31,6 +31,8 @@ limitations under the License.
 package queuejob
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -55,8 +57,10 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 
 	v1 "k8s.io/api/core/v1"
@@ -81,6 +85,7 @@ import (
 
 	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
 	clientset "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/clientset/controller-versioned"
+	clientsetscheme "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/clientset/controller-versioned/scheme"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/clientset/controller-versioned/clients"
 	arbinformers "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/informers/controller-externalversion"
 	informersv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/informers/controller-externalversion/v1"
@@ -163,6 +168,8 @@ type XController struct {
 
 	// Quota Manager
 	quotaManager quota.QuotaManagerInterface
+	// Canceled when the controller is stopped, aborting the quota evaluations in progress
+	quotaContext context.Context
 
 	// Active Scheduling AppWrapper
 	schedulingAW *arbv1.AppWrapper
@@ -384,8 +391,21 @@ func NewJobController(config *rest.Config, serverOption *options.ServerOption) *
 	// Setup Quota
 	if serverOption.QuotaEnabled {
 		dispatchedAWDemands, dispatchedAWs := cc.getDispatchedAppWrappers()
-		cc.quotaManager, _ = quotamanager.NewQuotaManager(dispatchedAWDemands, dispatchedAWs, cc.queueJobLister,
-			config, serverOption)
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cc.clients.CoreV1().Events("")})
+		quotaEventRecorder := eventBroadcaster.NewRecorder(clientsetscheme.Scheme, v1.EventSource{Component: "mcad-quota-manager"})
+		quotaManager, quotaErr := quotamanager.NewQuotaManager(dispatchedAWDemands, dispatchedAWs, cc.queueJobLister,
+			cc.queueJobInformer.Informer(), config, serverOption, quotaEventRecorder)
+		// Only store a quota manager that was created, a nil *QuotaManager would be a non-nil interface
+		if quotaManager != nil {
+			cc.quotaManager = quotaManager
+		}
+		var forestErr *quota.ForestConsistencyError
+		if errors.As(quotaErr, &forestErr) {
+			klog.Errorf("[Controller] Quota manager started degraded, err=%v", forestErr)
+		} else if quotaErr != nil {
+			klog.Errorf("[Controller] Quota manager initialization failure, err=%v", quotaErr)
+		}
 	} else {
 		cc.quotaManager = nil
 	}
@@ -917,12 +937,13 @@ func (qjm *XController) chooseAgent(qj *arbv1.AppWrapper) string {
 		//Now evaluate quota
 		if qjm.serverOption.QuotaEnabled {
 			if qjm.quotaManager != nil {
-				if fits, preemptAWs, _ := qjm.quotaManager.Fits(qj, qjAggrResources, proposedPreemptions); fits {
+				fitResult, fitErr := qjm.quotaManager.Fits(qjm.getQuotaContext(), qj, qjAggrResources, proposedPreemptions)
+				if fitResult = getQuotaFitResult(fitResult, fitErr); fitResult.Fits {
 					klog.V(2).Infof("[chooseAgent] AppWrapper %s has enough quota.\n", qj.Name)
-					qjm.preemptAWJobs(preemptAWs)
+					qjm.preemptAWJobs(fitResult.PreemptionTargets)
 					return agentId
 				} else {
-					klog.V(2).Infof("[chooseAgent] AppWrapper %s  does not have enough quota\n", qj.Name)
+					klog.V(2).Infof("[chooseAgent] AppWrapper %s  does not have enough quota, reason: %s\n", qj.Name, fitResult.Reason)
 				}
 			} else {
 				klog.Errorf("[chooseAgent] Quota evaluation is enable but not initialize.  AppWrapper %s/%s does not have enough quota\n", qj.Name, qj.Namespace)
@@ -937,6 +958,43 @@ func (qjm *XController) chooseAgent(qj *arbv1.AppWrapper) string {
 	return ""
 }
 
+// getQuotaContext returns the context of the quota evaluations, canceled when the controller is stopped.
+func (qjm *XController) getQuotaContext() context.Context {
+	if qjm.quotaContext == nil {
+		return context.Background()
+	}
+	return qjm.quotaContext
+}
+
+// updateQuotaClusterState sets the GPU sharing factor and the cluster capacity of the last snapshot of the
+// cluster state in the quota manager.  The quota manager only invalidates its quota decisions when the
+// sharing factor changed, and recomputes the percentage quotas when the capacity changed.
+func (qjm *XController) updateQuotaClusterState() {
+	if !qjm.serverOption.QuotaEnabled || qjm.quotaManager == nil {
+		return
+	}
+	qjm.quotaManager.SetGPUSharingFactor(qjm.cache.GetGPUSharingFactor())
+	qjm.quotaManager.SetClusterCapacity(qjm.cache.GetResourceCapacities())
+}
+
+// getQuotaFitResult returns the result of a quota evaluation.  An evaluation failing or returning no
+// result does not fit.
+func getQuotaFitResult(fitResult *quota.FitResult, err error) *quota.FitResult {
+	if err == nil && fitResult != nil {
+		return fitResult
+	}
+
+	klog.Errorf("[getQuotaFitResult] Quota evaluation failed, err=%v", err)
+	notFit := &quota.FitResult{Reason: quota.QuotaExceeded}
+	if fitResult != nil {
+		notFit.Reason, notFit.Message = fitResult.Reason, fitResult.Message
+	}
+	if len(notFit.Message) <= 0 && err != nil {
+		notFit.Message = err.Error()
+	}
+	return notFit
+}
+
 func (qjm *XController) nodeChecks(histograms map[string]*dto.Metric, aw *arbv1.AppWrapper) bool {
 	ok := true
 	allPods := qjm.GetAggregatedResourcesPerGenericItem(aw)
@@ -1149,10 +1207,16 @@ func (qjm *XController) ScheduleNext() {
 				klog.V(10).Infof("[ScheduleNext] HOL available resourse successful check for %s at %s activeQ=%t Unsched=%t &qj=%p Version=%s Status=%+v due to quota limits", qj.Name, time.Now().Sub(HOLStartTime), qjm.qjqueue.IfExistActiveQ(qj), qjm.qjqueue.IfExistUnschedulableQ(qj), qj, qj.ResourceVersion, qj.Status)
 				if qjm.serverOption.QuotaEnabled {
 					if qjm.quotaManager != nil {
-						quotaFits, preemptAWs, msg := qjm.quotaManager.Fits(qj, aggqj, proposedPreemptions)
+						fitResult, fitErr := qjm.quotaManager.Fits(qjm.getQuotaContext(), qj, aggqj, proposedPreemptions)
+						fitResult = getQuotaFitResult(fitResult, fitErr)
+						quotaFits, preemptAWs, msg := fitResult.Fits, fitResult.PreemptionTargets, fitResult.Message
 						if quotaFits {
 							klog.V(4).Infof("[ScheduleNext] HOL quota evaluation successful %s for %s activeQ=%t Unsched=%t &qj=%p Version=%s Status=%+v due to quota limits", qj.Name, time.Now().Sub(HOLStartTime), qjm.qjqueue.IfExistActiveQ(qj), qjm.qjqueue.IfExistUnschedulableQ(qj), qj, qj.ResourceVersion, qj.Status)
 							// Set any jobs that are marked for preemption
+							if cost := fitResult.PreemptionCost; cost != nil {
+								klog.V(4).Infof("[ScheduleNext] %s preempting %d AppWrappers, preemption cost %.1f, value %.3f, runtime %v",
+									qj.Name, len(preemptAWs), cost.Cost, cost.Value, cost.Runtime)
+							}
 							qjm.preemptAWJobs(preemptAWs)
 						} else { // Not enough free quota to dispatch appwrapper
 							dispatchFailedMessage = "Insufficient quota to dispatch AppWrapper."
@@ -1418,9 +1482,20 @@ func (cc *XController) Run(stopCh chan struct{}) {
 
 	cache.WaitForCacheSync(stopCh, cc.queueJobSynced)
 
+	// Abort the quota evaluations in progress when the controller is stopped
+	quotaContext, cancelQuota := context.WithCancel(context.Background())
+	cc.quotaContext = quotaContext
+	go func() {
+		<-stopCh
+		cancelQuota()
+	}()
+
 	// update snapshot of ClientStateCache every second
 	cc.cache.Run(stopCh)
 
+	// Physical GPU quotas follow the time-slicing of the nodes, percentage quotas the cluster capacity
+	go wait.Until(cc.updateQuotaClusterState, time.Second, stopCh)
+
 	// go wait.Until(cc.ScheduleNext, 2*time.Second, stopCh)
 	go wait.Until(cc.ScheduleNext, 0, stopCh)
 	// start preempt thread based on preemption of pods
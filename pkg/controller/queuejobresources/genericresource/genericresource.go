@@ -379,13 +379,13 @@ func addLabelsToPodTemplateField(unstruct *unstructured.Unstructured, labels map
 }
 
 //checks if object has replicas and containers field
-func hasFields(obj runtime.RawExtension) (hasFields bool, replica float64, containers []v1.Container) {
+func hasFields(obj runtime.RawExtension) (hasFields bool, replica float64, podSpec v1.PodSpec) {
 	var unstruct unstructured.Unstructured
 	unstruct.Object = make(map[string]interface{})
 	var blob interface{}
 	if err := json.Unmarshal(obj.Raw, &blob); err != nil {
 		klog.Errorf("Error unmarshalling, err=%#v", err)
-		return false, 0, nil
+		return false, 0, podSpec
 	}
 	unstruct.Object = blob.(map[string]interface{})
 	spec, isFound, _ := unstructured.NestedMap(unstruct.UnstructuredContent(), "spec")
@@ -412,16 +412,31 @@ func hasFields(obj runtime.RawExtension) (hasFields bool, replica float64, conta
 	containerList, isFound, _ := unstructured.NestedSlice(subspec, "containers")
 	if !isFound {
 		klog.Warningf("[hasFields] No containers field found in raw object: %#v", subspec)
-		return false, 0, nil
+		return false, 0, podSpec
 	}
-	objContainers := make([]v1.Container, len(containerList))
+	podSpec.Containers = getContainers(containerList)
+
+	// Init containers and the pod overhead contribute to the effective request of the pods
+	if initContainerList, found, _ := unstructured.NestedSlice(subspec, "initContainers"); found {
+		podSpec.InitContainers = getContainers(initContainerList)
+	}
+	if overhead, found, _ := unstructured.NestedMap(subspec, "overhead"); found {
+		marshal, _ := json.Marshal(overhead)
+		_ = json.Unmarshal(marshal, &podSpec.Overhead)
+	}
+	return isFound, replicas, podSpec
+}
+
+// getContainers converts the unstructured containers of a pod spec.
+func getContainers(containerList []interface{}) []v1.Container {
+	objContainers := make([]v1.Container, 0, len(containerList))
 	for _, container := range containerList {
 		marshal, _ := json.Marshal(container)
 		unmarshal := v1.Container{}
 		_ = json.Unmarshal(marshal, &unmarshal)
 		objContainers = append(objContainers, unmarshal)
 	}
-	return isFound, replicas, objContainers
+	return objContainers
 }
 
 func createObject(namespaced bool, namespace string, name string, rsrc schema.GroupVersionResource, unstruct unstructured.Unstructured, dclient dynamic.Interface) (erro error) {
@@ -488,13 +503,9 @@ func GetListOfPodResourcesFromOneGenericItem(awr *arbv1.AppWrapperGenericResourc
 	var err error
 	err = nil
 	if awr.GenericTemplate.Raw != nil {
-		hasContainer, replicas, containers := hasFields(awr.GenericTemplate)
+		hasContainer, replicas, podSpec := hasFields(awr.GenericTemplate)
 		if hasContainer {
-			// Add up all the containers in a pod
-			for _, container := range containers {
-				res := getContainerResources(container, 1)
-				podTotalresource = podTotalresource.Add(res)
-			}
+			podTotalresource = getPodSpecResources(podSpec)
 			klog.V(8).Infof("[GetListOfPodResourcesFromOneGenericItem] Requested total pod allocation resource from containers `%v`.\n", podTotalresource)
 		} else {
 			podresources := awr.CustomPodResources
@@ -530,12 +541,9 @@ func GetResources(awr *arbv1.AppWrapperGenericResource) (resource *clusterstatea
 			klog.V(4).Infof("[GetResources] Requested total allocation resource from custompodresources `%v`.\n", totalresource)
 			return totalresource, err
 		}
-		hasContainer, replicas, containers := hasFields(awr.GenericTemplate)
+		hasContainer, replicas, podSpec := hasFields(awr.GenericTemplate)
 		if hasContainer {
-			for _, item := range containers {
-				res := getContainerResources(item, replicas)
-				totalresource = totalresource.Add(res)
-			}
+			totalresource = getPodSpecResources(podSpec).Scale(replicas)
 			klog.V(4).Infof("[GetResources] Requested total allocation resource from containers `%v`.\n", totalresource)
 			return totalresource, err
 		}
@@ -565,9 +573,39 @@ func getPodResources(pod arbv1.CustomPodResourceTemplate) (resource *clusterstat
 	if req.GPU <= 0 {
 		req.GPU = limit.GPU
 	}
+
+	if req.GPUMemory <= 0 {
+		req.GPUMemory = limit.GPUMemory
+	}
+	for rName, rQuant := range limit.ScalarResources {
+		if req.ScalarResources[rName] <= 0 {
+			req.SetScalar(rName, rQuant)
+		}
+	}
 	req.MilliCPU = req.MilliCPU * float64(replicas)
 	req.Memory = req.Memory * float64(replicas)
 	req.GPU = req.GPU * int64(replicas)
+	req.GPUMemory = req.GPUMemory * int64(replicas)
+	for rName, rQuant := range req.ScalarResources {
+		req.SetScalar(rName, rQuant*float64(replicas))
+	}
+	return req
+}
+
+// getPodSpecResources returns the effective resource request of a pod, matching the Kubernetes scheduler:
+// the larger of the sum of the regular containers and of the largest init container, plus the pod overhead.
+func getPodSpecResources(podSpec v1.PodSpec) *clusterstateapi.Resource {
+	req := clusterstateapi.EmptyResource()
+	for _, container := range podSpec.Containers {
+		req.Add(getContainerResources(container, 1))
+	}
+	// Init containers run one at a time before the regular containers
+	for _, container := range podSpec.InitContainers {
+		req = req.Max(getContainerResources(container, 1))
+	}
+	if podSpec.Overhead != nil {
+		req.Add(clusterstateapi.NewResource(podSpec.Overhead))
+	}
 	return req
 }
 
@@ -590,9 +628,22 @@ func getContainerResources(container v1.Container, replicas float64) *clustersta
 		req.GPU = limit.GPU
 	}
 
+	if req.GPUMemory <= 0 {
+		req.GPUMemory = limit.GPUMemory
+	}
+	for rName, rQuant := range limit.ScalarResources {
+		if req.ScalarResources[rName] <= 0 {
+			req.SetScalar(rName, rQuant)
+		}
+	}
+
 	req.MilliCPU = req.MilliCPU * float64(replicas)
 	req.Memory = req.Memory * float64(replicas)
 	req.GPU = req.GPU * int64(replicas)
+	req.GPUMemory = req.GPUMemory * int64(replicas)
+	for rName, rQuant := range req.ScalarResources {
+		req.SetScalar(rName, rQuant*float64(replicas))
+	}
 	return req
 }
 
@@ -0,0 +1,86 @@
+/*
+Copyright 2019, 2021 The Multi-Cluster App Dispatcher Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package genericresource
+
+import (
+	"reflect"
+	"testing"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestGetResources_InitContainers(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected *clusterstateapi.Resource
+	}{
+		{
+			name: "regular containers",
+			template: `{"kind": "Deployment", "spec": {"replicas": 2, "template": {"spec": {
+				"containers": [
+					{"resources": {"requests": {"cpu": "1", "memory": "1Gi"}}},
+					{"resources": {"requests": {"cpu": "1", "memory": "1Gi"}}}]}}}}`,
+			expected: &clusterstateapi.Resource{MilliCPU: 4000, Memory: 4 * 1024 * 1024 * 1024},
+		},
+		{
+			name: "init container larger than the regular containers",
+			template: `{"kind": "Deployment", "spec": {"replicas": 2, "template": {"spec": {
+				"initContainers": [
+					{"resources": {"requests": {"cpu": "3", "memory": "512Mi"}}}],
+				"containers": [
+					{"resources": {"requests": {"cpu": "1", "memory": "1Gi"}}},
+					{"resources": {"requests": {"cpu": "1", "memory": "1Gi"}}}]}}}}`,
+			expected: &clusterstateapi.Resource{MilliCPU: 6000, Memory: 4 * 1024 * 1024 * 1024},
+		},
+		{
+			name: "pod overhead",
+			template: `{"kind": "Pod", "spec": {
+				"initContainers": [
+					{"resources": {"requests": {"cpu": "3", "memory": "512Mi"}}}],
+				"containers": [
+					{"resources": {"requests": {"cpu": "1", "memory": "1Gi"}}}],
+				"overhead": {"cpu": "250m", "memory": "128Mi"}}}`,
+			expected: &clusterstateapi.Resource{MilliCPU: 3250, Memory: 1152 * 1024 * 1024},
+		},
+	}
+
+	for i, test := range tests {
+		awr := &arbv1.AppWrapperGenericResource{
+			GenericTemplate: runtime.RawExtension{Raw: []byte(test.template)},
+		}
+		result, err := GetResources(awr)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, result)
+		}
+
+		podResources, err := GetListOfPodResourcesFromOneGenericItem(awr)
+		if err != nil || len(podResources) == 0 {
+			t.Errorf("case %d (%s): expected pod resources, got %v, err=%v", i, test.name, podResources, err)
+			continue
+		}
+		perPod := podResources[0].Scale(float64(len(podResources)))
+		if !reflect.DeepEqual(perPod, test.expected) {
+			t.Errorf("case %d (%s): \n expected pods total %v, \n got %v \n", i, test.name, test.expected, perPod)
+		}
+	}
+}
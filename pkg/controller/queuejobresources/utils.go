@@ -85,6 +85,9 @@ func GetPodResourcesByPhase(phase v1.PodPhase, pods []*v1.Pod) *clusterstateapi.
     return req
 }
 
+// GetPodResources returns the effective resource request of the pods of a template, matching the
+// Kubernetes scheduler: the larger of the sum of the regular containers and of the largest init
+// container, plus the pod overhead.  The request of a container is raised to its limit.
 func GetPodResources(template *v1.PodTemplateSpec) *clusterstateapi.Resource {
         total := clusterstateapi.EmptyResource()
         req := clusterstateapi.EmptyResource()
@@ -96,19 +99,31 @@ func GetPodResources(template *v1.PodTemplateSpec) *clusterstateapi.Resource {
             return total
         }
 
-        for _, c := range template.Spec.Containers {
-            req.Add(clusterstateapi.NewResource(c.Resources.Requests))
-            limit.Add(clusterstateapi.NewResource(c.Resources.Limits))
-        }
-        if req.MilliCPU < limit.MilliCPU {
-                                req.MilliCPU = limit.MilliCPU
-        }
-        if req.Memory < limit.Memory {
-                                req.Memory = limit.Memory
-        }
-        if req.GPU < limit.GPU {
-                                req.GPU = limit.GPU
-        }
-        total = total.Add(req)
-        return total
+	for _, c := range template.Spec.Containers {
+		req.Add(clusterstateapi.NewResource(c.Resources.Requests))
+		limit.Add(clusterstateapi.NewResource(c.Resources.Limits))
+	}
+	if req.MilliCPU < limit.MilliCPU {
+		req.MilliCPU = limit.MilliCPU
+	}
+	if req.Memory < limit.Memory {
+		req.Memory = limit.Memory
+	}
+	if req.GPU < limit.GPU {
+		req.GPU = limit.GPU
+	}
+	if req.GPUMemory < limit.GPUMemory {
+		req.GPUMemory = limit.GPUMemory
+	}
+
+	// Init containers run one at a time before the regular containers
+	for _, c := range template.Spec.InitContainers {
+		initReq := clusterstateapi.NewResource(c.Resources.Requests)
+		req = req.Max(initReq.Max(clusterstateapi.NewResource(c.Resources.Limits)))
+	}
+	if template.Spec.Overhead != nil {
+		req.Add(clusterstateapi.NewResource(template.Spec.Overhead))
+	}
+	total = total.Add(req)
+	return total
 }
@@ -0,0 +1,87 @@
+/*
+Copyright 2019, 2021 The Multi-Cluster App Dispatcher Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package queuejobresources
+
+import (
+	"reflect"
+	"testing"
+
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func buildContainer(cpu, memory string) v1.Container {
+	return v1.Container{
+		Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+func TestGetPodResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     v1.PodSpec
+		expected *clusterstateapi.Resource
+	}{
+		{
+			name: "regular containers",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{buildContainer("1", "1Gi"), buildContainer("2", "1Gi")},
+			},
+			expected: &clusterstateapi.Resource{MilliCPU: 3000, Memory: 2 * 1024 * 1024 * 1024},
+		},
+		{
+			name: "init container larger than the regular containers",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{buildContainer("4", "512Mi")},
+				Containers:     []v1.Container{buildContainer("1", "1Gi"), buildContainer("2", "1Gi")},
+			},
+			expected: &clusterstateapi.Resource{MilliCPU: 4000, Memory: 2 * 1024 * 1024 * 1024},
+		},
+		{
+			name: "init containers run one at a time",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{buildContainer("2", "512Mi"), buildContainer("2", "512Mi")},
+				Containers:     []v1.Container{buildContainer("3", "1Gi")},
+			},
+			expected: &clusterstateapi.Resource{MilliCPU: 3000, Memory: 1024 * 1024 * 1024},
+		},
+		{
+			name: "pod overhead",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{buildContainer("4", "512Mi")},
+				Containers:     []v1.Container{buildContainer("1", "1Gi")},
+				Overhead: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("250m"),
+					v1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+			},
+			expected: &clusterstateapi.Resource{MilliCPU: 4250, Memory: 1152 * 1024 * 1024},
+		},
+	}
+
+	for i, test := range tests {
+		result := GetPodResources(&v1.PodTemplateSpec{Spec: test.spec})
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"strings"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+)
+
+// Label of the AppWrappers that bypass quota, set to "true"
+const BestEffortLabel = "mcad.io/besteffort"
+
+// IsBestEffort returns true if an AppWrapper is best-effort.  Best-effort AppWrappers always fit in quota
+// without being allocated in any quota tree, i.e. they are preemptable and hold no quota.  They dispatch
+// into spare cluster capacity and are preempted when the capacity is proposed to be reclaimed for another
+// AppWrapper.  As the controller only proposes preemptions when preemption is enabled, best-effort
+// AppWrappers are never reclaimed when preemption is disabled.
+func IsBestEffort(aw *arbv1.AppWrapper) bool {
+	if aw == nil {
+		return false
+	}
+	return strings.EqualFold(aw.GetLabels()[BestEffortLabel], "true")
+}
+
+// AddBestEffortTargets adds the best-effort AppWrappers of the proposed preemptions to the preemption
+// targets, skipping the AppWrappers already targeted.
+func AddBestEffortTargets(targets []*arbv1.AppWrapper, proposedPreemptions []*arbv1.AppWrapper) []*arbv1.AppWrapper {
+	for _, proposed := range proposedPreemptions {
+		if !IsBestEffort(proposed) {
+			continue
+		}
+		targeted := false
+		for _, target := range targets {
+			if target.Namespace == proposed.Namespace && target.Name == proposed.Name {
+				targeted = true
+				break
+			}
+		}
+		if !targeted {
+			targets = append(targets, proposed)
+		}
+	}
+	return targets
+}
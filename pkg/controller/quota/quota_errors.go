@@ -0,0 +1,194 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAppWrapper is returned for AppWrappers that cannot be evaluated against quota, e.g. with an
+// empty namespace or name.
+var ErrInvalidAppWrapper = errors.New("invalid AppWrapper")
+
+// ErrUnsupportedTreeUnit is returned for quota trees declaring resource units other than the units AppWrapper
+// demands are converted to.
+var ErrUnsupportedTreeUnit = errors.New("unsupported quota tree unit")
+
+// ForestConsistencyError reports a quota forest that is structurally broken after a refresh: tree nodes
+// that could not be linked to their parent, hard quota tree nodes whose children hard quotas exceed their
+// own quota and consumers that could not be allocated again.
+type ForestConsistencyError struct {
+	// Dangling tree node names, formatted as <tree name>/<node name>
+	DanglingNodeNames []string
+	// Hard quota tree node names whose children hard quotas exceed the node quota, formatted as
+	// <tree name>/<node name>
+	OverCommittedNodeNames []string
+	// IDs of the consumers not allocated after the refresh
+	UnallocatedConsumers []string
+	// Error returned by the quota manager backend, if any
+	Err error
+}
+
+func (e *ForestConsistencyError) Error() string {
+	var msgs []string
+	if len(e.DanglingNodeNames) > 0 {
+		msgs = append(msgs, fmt.Sprintf("dangling tree nodes: %s", strings.Join(e.DanglingNodeNames, ", ")))
+	}
+	if len(e.OverCommittedNodeNames) > 0 {
+		msgs = append(msgs, fmt.Sprintf("hard quota exceeded by children: %s", strings.Join(e.OverCommittedNodeNames, ", ")))
+	}
+	if len(e.UnallocatedConsumers) > 0 {
+		msgs = append(msgs, fmt.Sprintf("unallocated consumers: %s", strings.Join(e.UnallocatedConsumers, ", ")))
+	}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+	return "quota forest is inconsistent: " + strings.Join(msgs, "; ")
+}
+
+func (e *ForestConsistencyError) Unwrap() error {
+	return e.Err
+}
+
+// PartialLoadError reports dispatched AppWrappers not replayed into the quota manager at startup before
+// the load timeout expired, or whose replay failed to allocate their quota.
+type PartialLoadError struct {
+	// AppWrappers not replayed before the timeout, formatted as <namespace>/<name>
+	UnreplayedAppWrappers []string
+	// AppWrappers replayed without allocating their quota, formatted as <namespace>/<name>
+	FailedAppWrappers []string
+	// Timeout of the replay
+	Timeout time.Duration
+	// Errors of the AppWrappers replayed, if any
+	Err error
+}
+
+func (e *PartialLoadError) Error() string {
+	var msgs []string
+	if len(e.UnreplayedAppWrappers) > 0 {
+		msgs = append(msgs, fmt.Sprintf("loading of dispatched AppWrappers timed out after %v, AppWrappers not loaded: %s",
+			e.Timeout, strings.Join(e.UnreplayedAppWrappers, ", ")))
+	}
+	if len(e.FailedAppWrappers) > 0 {
+		msgs = append(msgs, "quota allocation of dispatched AppWrappers failed, AppWrappers not loaded: "+
+			strings.Join(e.FailedAppWrappers, ", "))
+	}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *PartialLoadError) Unwrap() error {
+	return e.Err
+}
+
+// DemandConversionReason is the reason an AppWrapper demand could not be converted to a quota demand.
+type DemandConversionReason string
+
+const (
+	// The demand is larger than the quota manager backend supports and was clamped
+	DemandOverflow DemandConversionReason = "Overflow"
+	// The resource type of the quota tree is not requested by the AppWrapper, the demand is zero
+	DemandMissing DemandConversionReason = "Missing"
+)
+
+// DemandConversionFailure reports the conversion of the demand of a quota tree resource type.
+type DemandConversionFailure struct {
+	// Resource type of the quota tree
+	ResourceName string
+	Reason       DemandConversionReason
+	// Demand used for the resource type, e.g. the clamped demand
+	Value int
+	// Description of the failure
+	Message string
+	// Error of the conversion, e.g. a *DemandOverflowError, nil for missing demands
+	Err error
+}
+
+// DemandOverflowError reports a demand larger than the quota manager backend supports.  The demand is
+// clamped to the maximum for the conversion failure to be reported, but the clamped demand must not be
+// allocated.
+type DemandOverflowError struct {
+	// Resource type of the quota tree, empty when not known
+	ResourceName string
+	// Demand of the AppWrapper in the units of the quota tree
+	Demand float64
+	// Maximum demand of the quota manager backend
+	Max int
+}
+
+func (e *DemandOverflowError) Error() string {
+	msg := fmt.Sprintf("demand %0.0f is larger than Max Quota Management Backend size %d", e.Demand, e.Max)
+	if len(e.ResourceName) > 0 {
+		msg = fmt.Sprintf("resource type: %s %s", e.ResourceName, msg)
+	}
+	return msg
+}
+
+// DemandConversionError reports the quota tree resource types whose AppWrapper demand could not be
+// converted.  It is only returned when a conversion failed; the resource types not requested by the
+// AppWrapper are then reported as well.
+type DemandConversionError struct {
+	Failures []DemandConversionFailure
+}
+
+func (e *DemandConversionError) Error() string {
+	var msgs []string
+	for _, failure := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("resource type: %s %s", failure.ResourceName, failure.Message))
+	}
+	return strings.Join(msgs, "; next error ")
+}
+
+// FailuresWithReason returns the failures with the given reason.
+func (e *DemandConversionError) FailuresWithReason(reason DemandConversionReason) []DemandConversionFailure {
+	var failures []DemandConversionFailure
+	for _, failure := range e.Failures {
+		if failure.Reason == reason {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+// Overflow returns the error of the first failure with an overflowing demand, nil when no demand overflowed.
+func (e *DemandConversionError) Overflow() *DemandOverflowError {
+	for _, failure := range e.FailuresWithReason(DemandOverflow) {
+		var overflowErr *DemandOverflowError
+		if errors.As(failure.Err, &overflowErr) {
+			return overflowErr
+		}
+	}
+	return nil
+}
+
+// TreeValidationError reports every problem found validating the nodes of the quota trees, e.g. parent
+// references that do not resolve, duplicate node names, quotas that are not numbers and cycles.
+type TreeValidationError struct {
+	Errs []error
+}
+
+func (e *TreeValidationError) Error() string {
+	var msgs []string
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid quota tree nodes: " + strings.Join(msgs, "; ")
+}
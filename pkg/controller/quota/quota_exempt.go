@@ -0,0 +1,47 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"strings"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+)
+
+// Annotation of the AppWrappers exempt from quota, set to "true"
+const ExemptAnnotation = "quota.mcad.io/exempt"
+
+// IsExempt returns true if an AppWrapper is annotated as exempt from quota.  When quota exemption is
+// allowed, see the allowQuotaExemption option, exempt AppWrappers, e.g. critical system AppWrappers, always
+// fit without being allocated in any quota tree.  Unlike best-effort AppWrappers they are never preempted
+// to free quota.
+func IsExempt(aw *arbv1.AppWrapper) bool {
+	if aw == nil {
+		return false
+	}
+	return strings.EqualFold(aw.GetAnnotations()[ExemptAnnotation], "true")
+}
+
+// RemoveExemptTargets removes the exempt AppWrappers from the preemption targets.
+func RemoveExemptTargets(targets []*arbv1.AppWrapper) []*arbv1.AppWrapper {
+	var kept []*arbv1.AppWrapper
+	for _, target := range targets {
+		if !IsExempt(target) {
+			kept = append(kept, target)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,33 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+)
+
+// Label of the AppWrappers designating the quota forest they are evaluated in
+const ForestLabel = "quota.mcad.io/forest"
+
+// GetForest returns the quota forest designated by the labels of an AppWrapper, empty for the default
+// forest.  The AppWrapper is only allocated in the quota trees of its forest, so it neither preempts nor is
+// preempted by the AppWrappers of other forests.
+func GetForest(aw *arbv1.AppWrapper) string {
+	if aw == nil {
+		return ""
+	}
+	return aw.GetLabels()[ForestLabel]
+}
@@ -16,11 +16,196 @@
 package quota
 
 import (
+	"context"
+
 	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
 	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
 )
 
+// FitReason describes why a quota evaluation produced its result.
+type FitReason int
+
+const (
+	// Allocated means quota was allocated to the request
+	Allocated FitReason = iota
+	// NoBackend means no quota manager backend exists to evaluate the request
+	NoBackend
+	// Maintenance means the quota manager backend is in maintenance mode
+	Maintenance
+	// InvalidRequest means the quota request could not be built from the AppWrapper
+	InvalidRequest
+	// QuotaExceeded means the request does not fit in the available quota
+	QuotaExceeded
+	// BestEffort means the request bypasses quota, see IsBestEffort
+	BestEffort
+	// NotSelected means the request does not match the quota AppWrapper selector, no quota is applied
+	NotSelected
+	// Exempt means the request is exempt from quota, see IsExempt
+	Exempt
+	// NoQuotaTrees means no quota trees are defined, no quota is applied
+	NoQuotaTrees
+	// Canceled means the evaluation was canceled, e.g. on controller shutdown, no quota is allocated
+	Canceled
+)
+
+func (fr FitReason) String() string {
+	switch fr {
+	case Allocated:
+		return "Allocated"
+	case NoBackend:
+		return "NoBackend"
+	case Maintenance:
+		return "Maintenance"
+	case InvalidRequest:
+		return "InvalidRequest"
+	case QuotaExceeded:
+		return "QuotaExceeded"
+	case BestEffort:
+		return "BestEffort"
+	case NotSelected:
+		return "NotSelected"
+	case Exempt:
+		return "Exempt"
+	case NoQuotaTrees:
+		return "NoQuotaTrees"
+	case Canceled:
+		return "Canceled"
+	}
+
+	return "Unknown"
+}
+
+// ReleaseResult is the outcome of releasing the quota of a consumer.
+type ReleaseResult int
+
+const (
+	// Released means the quota allocated to the consumer was released
+	Released ReleaseResult = iota
+	// NotFound means no quota was allocated to the consumer, e.g. already released, the release is a no-op
+	NotFound
+	// ReleaseError means the quota allocated to the consumer could not be released
+	ReleaseError
+)
+
+func (rr ReleaseResult) String() string {
+	switch rr {
+	case Released:
+		return "Released"
+	case NotFound:
+		return "NotFound"
+	case ReleaseError:
+		return "ReleaseError"
+	}
+
+	return "Unknown"
+}
+
+// FitResult is the outcome of evaluating an AppWrapper against quota.
+type FitResult struct {
+	Fits              bool
+	PreemptionTargets []*arbv1.AppWrapper
+	// Estimated cost of preempting the targets, nil without targets or when not estimated
+	PreemptionCost *PreemptionCost
+	Reason         FitReason
+	Message        string
+}
+
+// FitsRequest is the quota evaluation request of an AppWrapper of a group.
+type FitsRequest struct {
+	AppWrapper          *arbv1.AppWrapper
+	Resources           *clusterstateapi.Resource
+	ProposedPreemptions []*arbv1.AppWrapper
+}
+
+// GroupFitResult is the outcome of evaluating a group of AppWrappers against quota.  Either all the
+// AppWrappers of the group are allocated or none is.
+type GroupFitResult struct {
+	Fits bool
+	// Results of the AppWrappers evaluated, in request order
+	Results           []*FitResult
+	PreemptionTargets []*arbv1.AppWrapper
+	Message           string
+}
+
+// DriftEntry identifies a quota consumer reported by a consistency check.
+type DriftEntry struct {
+	ID        string
+	Namespace string
+	Name      string
+}
+
+// DriftReport lists the differences between the consumers allocated in the quota forest and the
+// dispatched AppWrappers.
+type DriftReport struct {
+	// Consumers allocated in the forest without a dispatched AppWrapper, e.g. due to a missed release
+	Leaked []DriftEntry
+	// Dispatched AppWrappers without a consumer allocated in the forest
+	UnderCounted []DriftEntry
+}
+
+// IsConsistent returns true if the report lists no differences.
+func (dr *DriftReport) IsConsistent() bool {
+	return len(dr.Leaked) == 0 && len(dr.UnderCounted) == 0
+}
+
 type QuotaManagerInterface interface {
+	Fits(ctx context.Context, aw *arbv1.AppWrapper, resources *clusterstateapi.Resource, proposedPremptions []*arbv1.AppWrapper) (*FitResult, error)
+	FitsGroup(requests []FitsRequest) (*GroupFitResult, error)
+	Release(aw *arbv1.AppWrapper) bool
+	ReleaseByID(awId string) bool
+	ReleaseWithResult(aw *arbv1.AppWrapper) ReleaseResult
+	ReleaseByIDWithResult(awId string) ReleaseResult
+	Preempt(targets []*arbv1.AppWrapper) ([]*arbv1.AppWrapper, error)
+	ListConsumers() ([]string, error)
+	FlushConsumer(awId string) (bool, error)
+	SetUnpreemptable(awId string, unpreemptable bool) error
+	SetClusterCapacity(capacity *clusterstateapi.Resource)
+	SetGPUSharingFactor(factor int)
+	Healthy() (bool, string)
+	RegisterObserver(observer QuotaEventObserver)
+	VerifyConsistency(dispatchedAWs map[string]*arbv1.AppWrapper) (*DriftReport, error)
+}
+
+// LegacyQuotaManagerInterface is the quota manager method set before quota evaluations returned a
+// FitResult.
+//
+// Deprecated: use QuotaManagerInterface, this interface will be removed in the next release.
+type LegacyQuotaManagerInterface interface {
 	Fits(aw *arbv1.AppWrapper, resources *clusterstateapi.Resource, proposedPremptions []*arbv1.AppWrapper) (bool, []*arbv1.AppWrapper, string)
 	Release(aw *arbv1.AppWrapper) bool
 }
+
+// LegacyQuotaManager adapts a QuotaManagerInterface to the LegacyQuotaManagerInterface, so existing callers
+// of Fits(aw, resources, proposedPremptions) compile unchanged.
+//
+// Deprecated: use QuotaManagerInterface, this adapter will be removed in the next release.
+type LegacyQuotaManager struct {
+	qm QuotaManagerInterface
+}
+
+var _ LegacyQuotaManagerInterface = &LegacyQuotaManager{}
+
+// NewLegacyQuotaManager returns the LegacyQuotaManagerInterface of a quota manager.
+//
+// Deprecated: use QuotaManagerInterface, this adapter will be removed in the next release.
+func NewLegacyQuotaManager(qm QuotaManagerInterface) *LegacyQuotaManager {
+	return &LegacyQuotaManager{qm: qm}
+}
+
+// Fits evaluates quota and returns the result in the (fits, preemptions, message) form.
+func (lqm *LegacyQuotaManager) Fits(aw *arbv1.AppWrapper, resources *clusterstateapi.Resource,
+	proposedPremptions []*arbv1.AppWrapper) (bool, []*arbv1.AppWrapper, string) {
+	result, err := lqm.qm.Fits(context.Background(), aw, resources, proposedPremptions)
+	if result == nil {
+		if err != nil {
+			return false, nil, err.Error()
+		}
+		return false, nil, ""
+	}
+	return result.Fits, result.PreemptionTargets, result.Message
+}
+
+// Release releases the quota of an AppWrapper and returns whether quota was released.
+func (lqm *LegacyQuotaManager) Release(aw *arbv1.AppWrapper) bool {
+	return lqm.qm.Release(aw)
+}
@@ -0,0 +1,77 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+)
+
+// fitsQuotaManager is a quota manager returning a fixed quota evaluation.
+type fitsQuotaManager struct {
+	QuotaManagerInterface
+	result *FitResult
+	err    error
+}
+
+func (qm *fitsQuotaManager) Fits(ctx context.Context, aw *arbv1.AppWrapper, resources *clusterstateapi.Resource,
+	proposedPremptions []*arbv1.AppWrapper) (*FitResult, error) {
+	return qm.result, qm.err
+}
+
+func TestLegacyQuotaManager_Fits(t *testing.T) {
+	target := buildPreemptionTarget("target", 1, time.Time{}, "1")
+	tests := []struct {
+		name        string
+		result      *FitResult
+		err         error
+		fits        bool
+		preemptions []*arbv1.AppWrapper
+		message     string
+	}{
+		{
+			name:        "fits with preemptions",
+			result:      &FitResult{Fits: true, PreemptionTargets: []*arbv1.AppWrapper{target}, Reason: Allocated},
+			fits:        true,
+			preemptions: []*arbv1.AppWrapper{target},
+		},
+		{
+			name:    "does not fit",
+			result:  &FitResult{Fits: false, Reason: QuotaExceeded, Message: "quota exceeded"},
+			message: "quota exceeded",
+		},
+		{
+			name:    "failed without result",
+			err:     errors.New("no backend"),
+			message: "no backend",
+		},
+	}
+
+	for i, test := range tests {
+		var legacy LegacyQuotaManagerInterface = NewLegacyQuotaManager(&fitsQuotaManager{result: test.result, err: test.err})
+		fits, preemptions, message := legacy.Fits(&arbv1.AppWrapper{}, clusterstateapi.EmptyResource(), nil)
+		if fits != test.fits || !reflect.DeepEqual(preemptions, test.preemptions) || message != test.message {
+			t.Errorf("case %d (%s): \n expected %v %v %q, \n got %v %v %q \n", i, test.name,
+				test.fits, test.preemptions, test.message, fits, preemptions, message)
+		}
+	}
+}
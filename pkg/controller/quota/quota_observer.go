@@ -0,0 +1,76 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// QuotaEventObserver is notified of the quota allocations and releases of AppWrappers.
+type QuotaEventObserver interface {
+	// OnAllocate is called with the result of each quota evaluation of an AppWrapper
+	OnAllocate(awId string, result *FitResult)
+	// OnRelease is called after each release of the quota of an AppWrapper
+	OnRelease(awId string, success bool)
+}
+
+// QuotaEventObservers is a list of registered quota event observers.  Observers are notified
+// synchronously, in registration order, and failing observers do not affect the other observers.
+type QuotaEventObservers struct {
+	mutex     sync.RWMutex
+	observers []QuotaEventObserver
+}
+
+// Register adds an observer to the list.
+func (o *QuotaEventObservers) Register(observer QuotaEventObserver) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.observers = append(o.observers, observer)
+}
+
+// NotifyAllocate calls OnAllocate of the registered observers.
+func (o *QuotaEventObservers) NotifyAllocate(awId string, result *FitResult) {
+	for _, observer := range o.list() {
+		notify(func() { observer.OnAllocate(awId, result) })
+	}
+}
+
+// NotifyRelease calls OnRelease of the registered observers.
+func (o *QuotaEventObservers) NotifyRelease(awId string, success bool) {
+	for _, observer := range o.list() {
+		notify(func() { observer.OnRelease(awId, success) })
+	}
+}
+
+func (o *QuotaEventObservers) list() []QuotaEventObserver {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.observers
+}
+
+// notify calls an observer, recovering from its panics.
+func notify(call func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("[notify] Quota event observer failed, err=%v.", r)
+		}
+	}()
+	call()
+}
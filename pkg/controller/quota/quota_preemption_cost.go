@@ -0,0 +1,50 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"time"
+)
+
+// PreemptionCost estimates the work lost by preempting the targets of a quota evaluation.
+//
+// The share of a consumer is the largest fraction of the quota of a tree demanded by the consumer, over
+// the resources and trees of its consumer demand.  The cost of a preemption is the sum over the targets
+// of their share times the number of seconds since they were dispatched:
+//
+//	Cost = sum(share(target) * seconds since dispatch(target))
+//
+// e.g. a target demanding half of the cpu quota of its tree and dispatched an hour ago costs 1800.  The
+// value of the evaluated AppWrapper is its own share.  Best-effort targets hold no quota and cost nothing.
+type PreemptionCost struct {
+	// Sum of the demands of the targets by resource name, in the units of the quota trees
+	Demand map[string]int
+	// Sum of the time since the targets were dispatched
+	Runtime time.Duration
+	// Cost of the preemption and value of the evaluated AppWrapper, see above
+	Cost  float64
+	Value float64
+}
+
+// Exceeds returns true if the cost of the preemption exceeds the value of the evaluated AppWrapper times
+// the threshold in seconds, i.e. the preemption throws away more work than the evaluated AppWrapper
+// does in the threshold.  A threshold of zero disables the check.
+func (pc *PreemptionCost) Exceeds(threshold time.Duration) bool {
+	if pc == nil || threshold <= 0 {
+		return false
+	}
+	return pc.Cost > pc.Value*threshold.Seconds()
+}
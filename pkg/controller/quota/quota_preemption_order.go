@@ -0,0 +1,122 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"sort"
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/queuejobresources/genericresource"
+)
+
+// PreemptionOrder is the order of the preemption targets of equal priority.
+type PreemptionOrder string
+
+const (
+	// Most recently dispatched AppWrappers are preempted first
+	YoungestFirst PreemptionOrder = "YoungestFirst"
+	// Least recently dispatched AppWrappers are preempted first
+	OldestFirst PreemptionOrder = "OldestFirst"
+	// AppWrappers requesting the most resources are preempted first
+	LargestFirst PreemptionOrder = "LargestFirst"
+)
+
+// GetDispatchTime returns the time an AppWrapper was last dispatched, zero when it was never dispatched.
+func GetDispatchTime(aw *arbv1.AppWrapper) time.Time {
+	var dispatchTime time.Time
+	for _, condition := range aw.Status.Conditions {
+		if condition.Type == arbv1.AppWrapperCondDispatched && condition.LastUpdateMicroTime.Time.After(dispatchTime) {
+			dispatchTime = condition.LastUpdateMicroTime.Time
+		}
+	}
+	return dispatchTime
+}
+
+// getRequestedResources returns the resources requested by the generic items of an AppWrapper.
+func getRequestedResources(aw *arbv1.AppWrapper) *clusterstateapi.Resource {
+	requested := clusterstateapi.EmptyResource()
+	for i := range aw.Spec.AggrResources.GenericItems {
+		resources, err := genericresource.GetResources(&aw.Spec.AggrResources.GenericItems[i])
+		if err == nil && resources != nil {
+			requested = requested.Add(resources)
+		}
+	}
+	return requested
+}
+
+// isLarger compares resources by GPU, then CPU, then memory.
+func isLarger(l, r *clusterstateapi.Resource) (larger bool, equal bool) {
+	if l.GPU != r.GPU {
+		return l.GPU > r.GPU, false
+	}
+	if l.MilliCPU != r.MilliCPU {
+		return l.MilliCPU > r.MilliCPU, false
+	}
+	if l.Memory != r.Memory {
+		return l.Memory > r.Memory, false
+	}
+	return false, true
+}
+
+// SortPreemptionTargets sorts preemption targets so the targets preempted first come first: bursting
+// targets, allocated above a soft quota limit, first when isBursting is not nil, then lower priority
+// targets, then targets of equal priority in the given order.  Remaining ties are broken by the most
+// recent dispatch time, then by namespace and name, so the order does not depend on the order the targets
+// were returned by the quota manager.
+func SortPreemptionTargets(targets []*arbv1.AppWrapper, order PreemptionOrder, priority func(*arbv1.AppWrapper) int,
+	isBursting func(*arbv1.AppWrapper) bool) {
+	bursting := make(map[*arbv1.AppWrapper]bool, len(targets))
+	priorities := make(map[*arbv1.AppWrapper]int, len(targets))
+	dispatchTimes := make(map[*arbv1.AppWrapper]time.Time, len(targets))
+	requested := make(map[*arbv1.AppWrapper]*clusterstateapi.Resource)
+	for _, target := range targets {
+		if isBursting != nil {
+			bursting[target] = isBursting(target)
+		}
+		priorities[target] = priority(target)
+		dispatchTimes[target] = GetDispatchTime(target)
+		if order == LargestFirst {
+			requested[target] = getRequestedResources(target)
+		}
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool {
+		l, r := targets[i], targets[j]
+		if bursting[l] != bursting[r] {
+			return bursting[l]
+		}
+		if priorities[l] != priorities[r] {
+			return priorities[l] < priorities[r]
+		}
+		if order == LargestFirst {
+			if larger, equal := isLarger(requested[l], requested[r]); !equal {
+				return larger
+			}
+		}
+		if !dispatchTimes[l].Equal(dispatchTimes[r]) {
+			if order == OldestFirst {
+				return dispatchTimes[l].Before(dispatchTimes[r])
+			}
+			return dispatchTimes[l].After(dispatchTimes[r])
+		}
+		if l.Namespace != r.Namespace {
+			return l.Namespace < r.Namespace
+		}
+		return l.Name < r.Name
+	})
+}
@@ -0,0 +1,132 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+// 
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// 
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+package quota
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func buildPreemptionTarget(name string, priority int32, dispatchTime time.Time, cpu string) *arbv1.AppWrapper {
+	return &arbv1.AppWrapper{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: arbv1.AppWrapperSpec{
+			Priority: priority,
+			AggrResources: arbv1.AppWrapperResourceList{
+				GenericItems: []arbv1.AppWrapperGenericResource{{
+					GenericTemplate: runtime.RawExtension{Raw: []byte("{}")},
+					CustomPodResources: []arbv1.CustomPodResourceTemplate{{
+						Replicas: 1,
+						Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+					}},
+				}},
+			},
+		},
+		Status: arbv1.AppWrapperStatus{
+			Conditions: []arbv1.AppWrapperCondition{{
+				Type:                arbv1.AppWrapperCondDispatched,
+				LastUpdateMicroTime: metav1.NewMicroTime(dispatchTime),
+			}},
+		},
+	}
+}
+
+func TestSortPreemptionTargets(t *testing.T) {
+	now := time.Now()
+	old := buildPreemptionTarget("old", 1, now.Add(-time.Hour), "1")
+	young := buildPreemptionTarget("young", 1, now, "1")
+	large := buildPreemptionTarget("large", 1, now.Add(-time.Minute), "4")
+	twinA := buildPreemptionTarget("twin-a", 1, now.Add(-time.Minute), "1")
+	twinB := buildPreemptionTarget("twin-b", 1, now.Add(-time.Minute), "1")
+	low := buildPreemptionTarget("low", 0, now.Add(-2*time.Hour), "1")
+
+	priority := func(aw *arbv1.AppWrapper) int {
+		return int(aw.Spec.Priority)
+	}
+
+	tests := []struct {
+		name     string
+		order    PreemptionOrder
+		expected []*arbv1.AppWrapper
+	}{
+		{
+			name:     "youngest first",
+			order:    YoungestFirst,
+			expected: []*arbv1.AppWrapper{low, young, large, twinA, twinB, old},
+		},
+		{
+			name:     "oldest first",
+			order:    OldestFirst,
+			expected: []*arbv1.AppWrapper{low, old, large, twinA, twinB, young},
+		},
+		{
+			name:     "largest first",
+			order:    LargestFirst,
+			expected: []*arbv1.AppWrapper{low, large, young, twinA, twinB, old},
+		},
+	}
+
+	inputs := [][]*arbv1.AppWrapper{
+		{old, young, large, twinA, twinB, low},
+		{twinB, low, twinA, large, young, old},
+		{young, twinA, old, low, twinB, large},
+	}
+
+	for i, test := range tests {
+		for _, input := range inputs {
+			targets := append([]*arbv1.AppWrapper{}, input...)
+			SortPreemptionTargets(targets, test.order, priority, nil)
+			if !reflect.DeepEqual(targets, test.expected) {
+				t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, names(test.expected), names(targets))
+			}
+		}
+	}
+}
+
+func TestSortPreemptionTargetsBursting(t *testing.T) {
+	now := time.Now()
+	young := buildPreemptionTarget("young", 1, now, "1")
+	old := buildPreemptionTarget("old", 1, now.Add(-time.Hour), "1")
+	low := buildPreemptionTarget("low", 0, now.Add(-2*time.Hour), "1")
+	bursting := buildPreemptionTarget("bursting", 2, now.Add(-3*time.Hour), "1")
+
+	targets := []*arbv1.AppWrapper{young, old, low, bursting}
+	SortPreemptionTargets(targets, YoungestFirst, func(aw *arbv1.AppWrapper) int {
+		return int(aw.Spec.Priority)
+	}, func(aw *arbv1.AppWrapper) bool {
+		return aw.Name == "bursting"
+	})
+
+	expected := []*arbv1.AppWrapper{bursting, low, young, old}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("bursting targets: \n expected %v, \n got %v \n", names(expected), names(targets))
+	}
+}
+
+func names(aws []*arbv1.AppWrapper) []string {
+	var names []string
+	for _, aw := range aws {
+		names = append(names, aw.Name)
+	}
+	return names
+}
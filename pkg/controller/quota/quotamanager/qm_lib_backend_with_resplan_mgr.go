@@ -19,6 +19,8 @@ package quotamanager
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/cmd/kar-controllers/app/options"
 	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
@@ -26,20 +28,35 @@ import (
 	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
 	rpmanager "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr"
+	rputil "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr/util"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
 	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
 	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"github.ibm.com/ai-foundation/quota-manager/quota/core"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	schedulinglisters "k8s.io/client-go/listers/scheduling/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"strings"
 
 	"k8s.io/klog/v2"
 	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 const (
-	// Quota Manager Forest Name
-	QuotaManagerForestName string = "MCAD-CONTROLLER-FOREST"
+	// Quota Manager Forest Name, the forest of the trees not assigned to a named forest
+	QuotaManagerForestName string = rputil.DefaultForestName
 
 	// Default Tree Name
 	DefaultQuotaTreeName = "UNKNOWNTREENAME"
@@ -49,16 +66,259 @@ const (
 
 	MaxInt = int(^uint(0) >> 1)
 
+	// Ratio of a memory demand above which rounding the demand up to the quota memory unit is logged
+	MemoryRoundingWarningRatio = 0.01
+
+	// Separator of the group IDs of a quota label designating fallback groups, e.g. "team-a,team-b"
+	QuotaGroupIdSeparator = ","
+
+	// Reason of the event emitted when an AppWrapper is missing quota tree designations
+	MissingQuotaDesignationReason = "MissingQuotaDesignation"
+
+	// Time quota tree refreshes keep failing on ResourcePlan changes before a warning is logged
+	RefreshStalledWarningThreshold = 10 * time.Minute
+
+	// Interval of the progress logs of the replay of the dispatched AppWrappers at startup
+	LoadProgressLogInterval = 10 * time.Second
+
+	// Default number of dispatched AppWrappers replayed in a batch at startup
+	LoadBatchSize = 100
 )
 
 // QuotaManager implements a QuotaManagerInterface.
+//
+// The exported methods changing the backend or the consumers, e.g. Fits, Release and the refresh of the
+// quota trees, hold mutex for writing, the queries, e.g. ListConsumers and DumpForest, hold it for
+// reading.  Exported methods do not call each other while holding mutex, they share unexported
+// implementations instead.  Locks are acquired in the order maintenanceMutex, mutex, then the locks of the
+// ResourcePlanManager.  The ResourcePlan informer handlers only acquire the locks of the
+// ResourcePlanManager, so they never wait for a quota evaluation.  Observers are notified with mutex held
+// and must not call back into the quota manager.
 type QuotaManager struct {
 	url                 string
 	appwrapperLister    listersv1.AppWrapperLister
 	preemptionEnabled   bool
 	quotaManagerBackend *qmbackend.Manager
-	resourcePlanManager *rpmanager.ResourcePlanManager
+	resourcePlanManager ResourcePlanProvider
 	initializationDone  bool
+	// Consumer specs registered with the quota manager backend, keyed by consumer ID
+	consumerSpecs map[string]*qmbackendutils.JConsumerSpec
+	// Registration order of the consumer specs, keyed by consumer ID, so clones of the backend replay the
+	// consumers in allocation order
+	consumerOrder map[string]uint64
+	consumerSeq   uint64
+	// Consumers of canceled allocations still running in the backend, removed once the allocations complete
+	abandonedAllocations map[string]bool
+	eventRecorder        record.EventRecorder
+	// AppWrapper generation of the last missing quota designation event, keyed by consumer ID, guarded by
+	// missingDesignationMutex as events are recorded under the read lock
+	missingDesignationGenerations map[string]int64
+	missingDesignationMutex       sync.Mutex
+	// Cached tree names of the quota manager backend, nil when invalidated, guarded by treeNamesMutex as
+	// queries fill the cache
+	treeNames      []string
+	treeNamesMutex sync.Mutex
+	// Forest names of the quota trees, keyed by tree name, refreshed with the forest
+	treeForests map[string]string
+	// Resource types reported in the quota metrics, keyed by tree name
+	reportedMetrics     map[string]map[string]bool
+	priorityClassLister schedulinglisters.PriorityClassLister
+	// Units of the memory quota defined in quota trees and the number of bytes in a unit
+	memoryUnit      string
+	memoryUnitBytes float64
+	// Canonical resource types of the quota tree resource types, keyed by lower case resource type
+	resourceAliases map[string]string
+	// GPU resource names consumed by the quota tree resource types, keyed by lower case resource type
+	gpuVendorResources map[string][]string
+	// Consecutive failed refreshes of the quota trees and time before which no refresh is retried
+	refreshFailures  int
+	refreshRetryTime time.Time
+	// Error of the last refresh of the quota trees, nil when it succeeded
+	lastRefreshErr error
+	// Start of the consecutive failed refreshes of the quota trees
+	refreshFailingSince time.Time
+	// Time of the last successful update of the forest in Unix nanoseconds, updated atomically
+	lastRefreshTime int64
+	// Minimum time since dispatch before an AppWrapper can be preempted
+	minPreemptionAge time.Duration
+	// Work of the preempting AppWrapper its preemptions may throw away, zero when unlimited
+	preemptionCostThreshold time.Duration
+	// Tree names of legacy quota label keys, keyed by label key
+	treeRemap map[string]string
+	// Observers notified of the quota allocations and releases
+	observers quota.QuotaEventObservers
+	// Fractional millicore CPU demands are truncated instead of rounded up
+	truncateCPUDemand bool
+	// Order of the preemption targets of equal priority
+	preemptionOrder quota.PreemptionOrder
+	// Prefix of the annotation keys designating quota groups, followed by the tree name
+	annotationPrefix string
+	// Number of workers and timeout of the replay of the dispatched AppWrappers at startup
+	loadWorkers int
+	loadTimeout time.Duration
+	// Number of dispatched AppWrappers replayed in a batch at startup, 1 to replay them one at a time
+	loadBatchSize int
+	// Dispatched AppWrappers replayed and to replay at startup, updated atomically
+	loadDone  int64
+	loadTotal int64
+	// Last quota decisions of AppWrappers that did not fit, keyed by consumer ID, and generation of the
+	// forest incremented on every change of the forest
+	fitsCache        map[string]*fitsCacheEntry
+	forestGeneration uint64
+	// Consumers allocated above the soft limit of a quota node, keyed by consumer ID
+	burstingConsumers map[string]bool
+	// Consumers allocated with quota borrowed from sibling quota nodes, keyed by consumer ID, mapped to the
+	// lender nodes formatted as <tree name>/<node name>
+	borrowingConsumers map[string][]string
+	// Consumers made unpreemptable after their allocation, keyed by consumer ID
+	unpreemptableConsumers map[string]bool
+	// Quota reserved ahead of the dispatch of AppWrappers, keyed by consumer ID
+	reservations map[string]*quotaReservation
+	// Demands of the consumers above the quota of the trees after the last refresh, keyed by tree name and
+	// resource name
+	overSubscription map[string]map[string]int
+	// GPU replicas advertised per physical GPU of time-sliced nodes, 1 or less without time-slicing
+	gpuSharingFactor int
+	// Cluster capacity last set by resource name, in the units of the quota trees
+	clusterCapacity map[string]int
+	// Label selector of the AppWrappers subject to quota, nil for all AppWrappers
+	appwrapperSelector labels.Selector
+	// Quota group designated to the AppWrappers designating no quota group, none when the tree is empty
+	defaultQuotaTree  string
+	defaultQuotaGroup string
+	// AppWrappers annotated as exempt from quota always fit without allocating quota
+	allowQuotaExemption bool
+	// Held for reading by in-flight quota evaluations and for writing to quiesce them when entering or
+	// exiting maintenance mode
+	maintenanceMutex sync.RWMutex
+	// Held for writing by the operations changing the backend, the consumers and the caches of the quota
+	// manager and for reading by the queries
+	mutex sync.RWMutex
+	// Wait for quota after which the priority of an AppWrapper in a tree is boosted, zero when disabled,
+	// and the deadlines of specific trees keyed by tree name
+	allocationDeadline      time.Duration
+	treeAllocationDeadlines map[string]time.Duration
+	// Quota manager of a Simulator, reporting no metrics
+	simulation bool
+}
+
+// ResourcePlanProvider provides the quota trees defined by the ResourcePlans to a QuotaManager, e.g. a
+// ResourcePlanManager watching the ResourcePlans of the cluster or loading them from a file.  Providers
+// may implement TreeSpecProvider, TreeSettingsProvider, TreeNodeLister, PercentageQuotaProvider and
+// ResourcePlanLoader as well, the features relying on them are disabled otherwise.
+type ResourcePlanProvider interface {
+	// IsResplanChanged returns whether the ResourcePlans changed since they were loaded into the backend
+	IsResplanChanged() bool
+	// LoadResourcePlansIntoBackend loads the ResourcePlans into the backend of the provider
+	LoadResourcePlansIntoBackend() error
+}
+
+// TreeSpecProvider provides the node specs and the quotas of each quota tree.
+type TreeSpecProvider interface {
+	GetTreeNodeSpecs() map[string]map[string]*qmbackendutils.JNodeSpec
+	GetTreeQuotas() map[string]map[string]int
+}
+
+// TreeSettingsProvider provides the forests, memory units, burst limits and borrowable nodes of each quota
+// tree.
+type TreeSettingsProvider interface {
+	GetTreeForests() map[string]string
+	GetTreeMemoryUnits() map[string][]string
+	GetTreeBurstLimits() map[string]map[string]map[string]int
+	GetTreeBorrowableNodes() map[string]map[string]bool
+}
+
+// TreeNodeLister lists every node spec defined by the ResourcePlans of each quota tree, to validate them.
+type TreeNodeLister interface {
+	GetTreeNodeLists() map[string][]rpmanager.NamedNodeSpec
+}
+
+// PercentageQuotaProvider resolves the percentage quotas of the quota trees.  SetClusterCapacity and
+// SetQuotaFloors set the inputs of the percentage quotas and return whether a percentage quota changed,
+// IsQuotaClamped whether a percentage quota is kept above its percentage.
+type PercentageQuotaProvider interface {
+	SetClusterCapacity(capacity map[string]int) bool
+	SetQuotaFloors(floors map[string]map[string]map[string]int) bool
+	IsQuotaClamped() bool
+}
+
+// ResourcePlanLoader loads the ResourcePlans into a given backend, e.g. a scratch backend.
+type ResourcePlanLoader interface {
+	LoadResourcePlansInto(quotaManagerBackend *qmbackend.Manager)
+}
+
+// Making sure that ResourcePlanManager implements all the provider interfaces.
+var _ = ResourcePlanProvider(&rpmanager.ResourcePlanManager{})
+var _ = TreeSpecProvider(&rpmanager.ResourcePlanManager{})
+var _ = TreeSettingsProvider(&rpmanager.ResourcePlanManager{})
+var _ = TreeNodeLister(&rpmanager.ResourcePlanManager{})
+var _ = PercentageQuotaProvider(&rpmanager.ResourcePlanManager{})
+var _ = ResourcePlanLoader(&rpmanager.ResourcePlanManager{})
+
+// getTreeNodeSpecs returns the node specs of each quota tree, keyed by tree name and node name.
+func (qm *QuotaManager) getTreeNodeSpecs() map[string]map[string]*qmbackendutils.JNodeSpec {
+	if provider, ok := qm.resourcePlanManager.(TreeSpecProvider); ok {
+		return provider.GetTreeNodeSpecs()
+	}
+	return nil
+}
+
+// getTreeQuotas returns the quota of each quota tree, keyed by tree name and resource name.
+func (qm *QuotaManager) getTreeQuotas() map[string]map[string]int {
+	if provider, ok := qm.resourcePlanManager.(TreeSpecProvider); ok {
+		return provider.GetTreeQuotas()
+	}
+	return nil
+}
+
+// getTreeForests returns the forest name of each quota tree.
+func (qm *QuotaManager) getTreeForests() map[string]string {
+	if provider, ok := qm.resourcePlanManager.(TreeSettingsProvider); ok {
+		return provider.GetTreeForests()
+	}
+	return nil
+}
+
+// getTreeMemoryUnits returns the memory units declared by each quota tree.
+func (qm *QuotaManager) getTreeMemoryUnits() map[string][]string {
+	if provider, ok := qm.resourcePlanManager.(TreeSettingsProvider); ok {
+		return provider.GetTreeMemoryUnits()
+	}
+	return nil
+}
+
+// getTreeBurstLimits returns the burst limits of the nodes of each quota tree.
+func (qm *QuotaManager) getTreeBurstLimits() map[string]map[string]map[string]int {
+	if provider, ok := qm.resourcePlanManager.(TreeSettingsProvider); ok {
+		return provider.GetTreeBurstLimits()
+	}
+	return nil
+}
+
+// getTreeBorrowableNodes returns the nodes of each quota tree lending their idle quota.
+func (qm *QuotaManager) getTreeBorrowableNodes() map[string]map[string]bool {
+	if provider, ok := qm.resourcePlanManager.(TreeSettingsProvider); ok {
+		return provider.GetTreeBorrowableNodes()
+	}
+	return nil
+}
+
+// getTreeNodeLists returns every node spec defined by the ResourcePlans of each quota tree.
+func (qm *QuotaManager) getTreeNodeLists() map[string][]rpmanager.NamedNodeSpec {
+	if provider, ok := qm.resourcePlanManager.(TreeNodeLister); ok {
+		return provider.GetTreeNodeLists()
+	}
+	return nil
+}
+
+// loadResourcePlansInto loads the ResourcePlans into the given backend.
+func (qm *QuotaManager) loadResourcePlansInto(backend *qmbackend.Manager) error {
+	provider, ok := qm.resourcePlanManager.(ResourcePlanLoader)
+	if !ok {
+		return fmt.Errorf("ResourcePlan provider does not load ResourcePlans into other backends")
+	}
+	provider.LoadResourcePlansInto(backend)
+	return nil
 }
 
 type QuotaGroup struct {
@@ -106,7 +366,8 @@ func getDispatchedAppWrapper(dispatchedAWs map[string]*arbv1.AppWrapper, awId st
 }
 
 func NewQuotaManager(dispatchedAWDemands map[string]*clusterstateapi.Resource, dispatchedAWs map[string]*arbv1.AppWrapper,
-			awJobLister listersv1.AppWrapperLister, config *rest.Config, serverOptions *options.ServerOption) (*QuotaManager, error) {
+	awJobLister listersv1.AppWrapperLister, awInformer cache.SharedIndexInformer, config *rest.Config,
+	serverOptions *options.ServerOption, recorder record.EventRecorder) (*QuotaManager, error) {
 
 	if serverOptions.QuotaEnabled == false {
 		klog.
@@ -114,32 +375,48 @@ func NewQuotaManager(dispatchedAWDemands map[string]*clusterstateapi.Resource, d
 		return nil, nil
 	}
 
-	qm := &QuotaManager{
-		url:                 serverOptions.QuotaRestURL,
-		appwrapperLister:    awJobLister,
-		preemptionEnabled:   serverOptions.Preemption,
-		quotaManagerBackend: qmbackend.NewManager(),
-		initializationDone:  false,
-	}
-
 	// Set the name of the forest in the backend
-	qm.quotaManagerBackend.AddForest(QuotaManagerForestName)
-	klog.V(10).Infof("[NewQuotaManager] Before initialization ResourcePlan informer - %s", qm.quotaManagerBackend.String())
+	quotaManagerBackend := qmbackend.NewManager()
+	quotaManagerBackend.AddForest(QuotaManagerForestName)
+	klog.V(10).Infof("[NewQuotaManager] Before initialization ResourcePlan informer - %s", quotaManagerBackend.String())
 
-	// Create a resource plan manager
-	qm.resourcePlanManager, _ = rpmanager.NewResourcePlanManager(config, qm.quotaManagerBackend)
+	// Create a resource plan manager, loading static quota trees from a file when configured
+	var resourcePlanManager *rpmanager.ResourcePlanManager
+	var err error
+	if len(serverOptions.QuotaTreeFile) > 0 {
+		resourcePlanManager, err = rpmanager.NewStaticResourcePlanManager(serverOptions.QuotaTreeFile, quotaManagerBackend)
+		if err != nil {
+			klog.Errorf("[NewQuotaManager] Failure loading quota trees, err=%v", err)
+			return nil, err
+		}
+	} else {
+		resourcePlanManager, err = rpmanager.NewResourcePlanManager(config, quotaManagerBackend)
+		if err != nil {
+			klog.Errorf("[NewQuotaManager] Failure creating the ResourcePlan manager, err=%v", err)
+			return nil, err
+		}
+	}
 
-	// Initialize Forest/Trees if new resource plan manager added to the cache
-	err := qm.updateForestFromCache()
-	if err != nil {
-		klog.Errorf("[dispatchedAWDemands] Failure during Quota Manager Backend Cache refresh, err=%#v", err)
+	qm, err := NewQuotaManagerWithBackend(quotaManagerBackend, resourcePlanManager, awJobLister, serverOptions)
+	if errors.Is(err, quota.ErrUnsupportedTreeUnit) {
+		klog.Fatalf("[NewQuotaManager] Quota trees use unsupported resource units, err=%v", err)
+	}
+	if qm == nil {
+		return nil, err
 	}
+	qm.eventRecorder = recorder
+
+	// Create a priority class lister to resolve AppWrapper priority class names
+	qm.priorityClassLister = newPriorityClassLister(config)
 
-	// Add AppWrappers that have been evaluated as runnable to QuotaManager
+	// Add AppWrappers that have been evaluated as runnable to QuotaManager, the backend in maintenance mode
+	// allocating them as dispatched
+	qm.initializationDone = false
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
 	err2 := qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs)
 	if err2 != nil {
 		klog.Errorf("[dispatchedAWDemands] Failure during Quota Manager Backend Cache refresh, err=%#v",
-														err2)
+			err2)
 		// Combine errors for function return
 		if err != nil {
 			err = fmt.Errorf("%w; Next error %s", err, err2.Error())
@@ -147,19 +424,164 @@ func NewQuotaManager(dispatchedAWDemands map[string]*clusterstateapi.Resource, d
 			err = err2
 		}
 	}
+	// Set mode of quota manager
+	qm.quotaManagerBackend.SetMode(qmbackend.Normal)
+	qm.initializationDone = true
+
+	// Release the quota of deleted AppWrappers, closing the leak of AppWrappers deleted without a release
+	if serverOptions.AutoReleaseOnDelete && awInformer != nil {
+		awInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			DeleteFunc: qm.releaseDeletedAppWrapper,
+		})
+	}
+	return qm, err
+}
+
+// NewQuotaManagerWithBackend creates a quota manager of the quota trees provided by a ResourcePlanProvider,
+// loaded into the given backend, e.g. to inject an in-memory backend and static ResourcePlans in tests.
+// The forest of the quota manager is added to the backend when missing.  No AppWrappers are replayed, no
+// events are emitted and priority class names are not resolved.  Failures refreshing or validating the
+// quota trees are returned with the quota manager.
+func NewQuotaManagerWithBackend(quotaManagerBackend *qmbackend.Manager, resourcePlanProvider ResourcePlanProvider,
+	awJobLister listersv1.AppWrapperLister, serverOptions *options.ServerOption) (*QuotaManager, error) {
+
+	if quotaManagerBackend == nil || resourcePlanProvider == nil {
+		return nil, fmt.Errorf("quota manager requires a backend and a ResourcePlan provider")
+	}
+
+	memoryUnitBytes, err := serverOptions.QuotaMemoryUnitBytes()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota memory unit, err=%v", err)
+		return nil, err
+	}
+
+	resourceAliases, err := serverOptions.QuotaResourceAliasTable()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota resource aliases, err=%v", err)
+		return nil, err
+	}
+
+	gpuVendorResources, err := serverOptions.QuotaGPUVendorTable()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota GPU vendor resources, err=%v", err)
+		return nil, err
+	}
+
+	allocationDeadline, treeAllocationDeadlines, err := serverOptions.QuotaAllocationDeadlineTable()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota allocation deadline, err=%v", err)
+		return nil, err
+	}
+
+	treeRemap, err := serverOptions.QuotaTreeRemapTable()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota tree remap, err=%v", err)
+		return nil, err
+	}
+
+	appwrapperSelector, err := serverOptions.QuotaAppWrapperLabelSelector()
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota AppWrapper selector, err=%v", err)
+		return nil, err
+	}
+
+	qm := &QuotaManager{
+		url:                           serverOptions.QuotaRestURL,
+		appwrapperLister:              awJobLister,
+		preemptionEnabled:             serverOptions.Preemption,
+		quotaManagerBackend:           quotaManagerBackend,
+		resourcePlanManager:           resourcePlanProvider,
+		initializationDone:            false,
+		consumerSpecs:                 make(map[string]*qmbackendutils.JConsumerSpec),
+		missingDesignationGenerations: make(map[string]int64),
+		memoryUnit:                    serverOptions.QuotaMemoryUnit,
+		memoryUnitBytes:               memoryUnitBytes,
+		resourceAliases:               resourceAliases,
+		gpuVendorResources:            gpuVendorResources,
+		minPreemptionAge:              time.Duration(serverOptions.MinPreemptionAge) * time.Second,
+		preemptionCostThreshold:       time.Duration(serverOptions.PreemptionCostThreshold) * time.Second,
+		allocationDeadline:            allocationDeadline,
+		treeAllocationDeadlines:       treeAllocationDeadlines,
+		treeRemap:                     treeRemap,
+		annotationPrefix:              serverOptions.QuotaAnnotationPrefix,
+		allowQuotaExemption:           serverOptions.AllowQuotaExemption,
+		truncateCPUDemand:             serverOptions.QuotaCPURounding == options.QuotaCPURoundingTrunc,
+		preemptionOrder:               quota.PreemptionOrder(serverOptions.PreemptionOrder),
+		loadWorkers:                   serverOptions.QuotaLoadWorkers,
+		loadTimeout:                   time.Duration(serverOptions.QuotaLoadTimeout) * time.Second,
+		loadBatchSize:                 LoadBatchSize,
+		appwrapperSelector:            appwrapperSelector,
+		defaultQuotaTree:              serverOptions.DefaultQuotaTree,
+		defaultQuotaGroup:             serverOptions.DefaultQuotaGroup,
+	}
+
+	registerQuotaMetrics()
+	registerRefreshAgeMetric(qm)
+
+	// Set the name of the forest in the backend when missing
+	forestFound := false
+	for _, forestName := range qm.quotaManagerBackend.GetForestNames() {
+		if forestName == QuotaManagerForestName {
+			forestFound = true
+		}
+	}
+	if !forestFound {
+		qm.quotaManagerBackend.AddForest(QuotaManagerForestName)
+	}
+
+	// Report invalid tree nodes, e.g. duplicate names or cyclic parent references, before loading the trees
+	validationErr := qm.validateTreeNodes()
+	if validationErr != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid quota tree nodes, err=%v", validationErr)
+	}
+
+	// Initialize Forest/Trees if new resource plan manager added to the cache
+	err = qm.updateForestFromCache()
+	qm.lastRefreshErr = err
+	if err != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Failure during Quota Manager Backend Cache refresh, err=%#v", err)
+	}
+	if validationErr != nil {
+		if err != nil {
+			err = fmt.Errorf("%w; Next error %s", err, validationErr.Error())
+		} else {
+			err = validationErr
+		}
+	}
+
+	// The default quota group must exist for AppWrappers designating no quota group to be evaluated
+	if defaultErr := qm.validateDefaultQuotaGroup(); defaultErr != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Invalid default quota group, err=%v", defaultErr)
+		if err != nil {
+			err = fmt.Errorf("%w; Next error %s", err, defaultErr.Error())
+		} else {
+			err = defaultErr
+		}
+	}
+
+	// Quota trees must be defined in the units used to convert AppWrapper demands
+	if unitErr := qm.validateTreeUnits(); unitErr != nil {
+		klog.Errorf("[NewQuotaManagerWithBackend] Quota trees use unsupported resource units, err=%v", unitErr)
+		return nil, fmt.Errorf("%w: %s", quota.ErrUnsupportedTreeUnit, unitErr.Error())
+	}
+
 	// Set mode of quota manager
 	qm.quotaManagerBackend.SetMode(qmbackend.Normal)
 
-	treeNames := qm.quotaManagerBackend.GetTreeNames()
+	treeNames := qm.getTreeNames()
 
 	for _, treeName := range treeNames {
-		klog.V(4).Infof("[NewQuotaManager] Quota Manager Backend tree %s processing completed.", treeName)
+		klog.V(4).Infof("[NewQuotaManagerWithBackend] Quota Manager Backend tree %s processing completed.", treeName)
 	}
 
 	qm.initializationDone = true
+
 	return qm, err
 }
 
+// loadDispatchedAWs replays the dispatched AppWrappers into the quota manager using loadWorkers workers.
+// The backend calls of the workers are serialized.  AppWrappers not replayed before the loadTimeout
+// expires and AppWrappers whose replay failed to allocate quota are returned as a *quota.PartialLoadError.
 func (qm *QuotaManager) loadDispatchedAWs(dispatchedAWDemands map[string]*clusterstateapi.Resource,
 						dispatchedAWs map[string]*arbv1.AppWrapper) error {
 
@@ -169,485 +591,2719 @@ func (qm *QuotaManager) loadDispatchedAWs(dispatchedAWDemands map[string]*cluste
 		return nil
 	}
 
+	atomic.StoreInt64(&qm.loadDone, 0)
+	atomic.StoreInt64(&qm.loadTotal, int64(len(dispatchedAWDemands)))
+	quotaLoadReplayed.Set(0)
+	quotaLoadTotal.Set(float64(len(dispatchedAWDemands)))
+
+	ctx := context.Background()
+	if qm.loadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, qm.loadTimeout)
+		defer cancel()
+	}
+	workers := qm.loadWorkers
+	if workers < 1 {
+		workers = 1
+	}
 
 	// Process list of AppWrappers that are already dispatched
 	var err error
 	err = nil
 
-	for k, v := range dispatchedAWDemands{
-		aw := getDispatchedAppWrapper(dispatchedAWs, k)
-		if aw != nil {
-
-			doesFit, preemptionIds, err2:= qm.Fits(aw, v, nil)
-			if doesFit == false {
-				klog.Errorf("[loadDispatchedAWs] Loading of AppWrapper %s/%s failed.",
-										aw.Namespace, aw.Name)
-				err = fmt.Errorf("Loading of AppWrapper %s/%s failed, %#v \n",
-										aw.Namespace, aw.Name, err2)
-			}
-
-			if preemptionIds != nil && len(preemptionIds) > 0 {
-				klog.Errorf("[loadDispatchedAWs] Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state.",
-					aw.Namespace, aw.Name, preemptionIds)
-				if err == nil {
-					err = fmt.Errorf("Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state. \n",
-						aw.Namespace, aw.Name, preemptionIds)
+	startTime := time.Now()
+	var mutex sync.Mutex
+	// Replay the AppWrappers allocated as requested in batches, then the others one at a time
+	replayed := qm.loadDispatchedAWsBatch(ctx, dispatchedAWDemands, dispatchedAWs)
+	var pending []string
+	for k := range dispatchedAWDemands {
+		if !replayed[k] {
+			pending = append(pending, k)
+		}
+	}
+	failed := make(map[string]bool)
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				// Skip the remaining AppWrappers once the load timed out
+				if ctx.Err() != nil {
+					continue
+				}
+				// The demands are converted concurrently, the allocations are serialized by the quota manager lock
+				allocated, err2 := qm.loadDispatchedAW(ctx, k, dispatchedAWDemands[k], dispatchedAWs)
+				mutex.Lock()
+				if err2 != nil {
+					if err == nil {
+						err = err2
+					} else {
+						err = fmt.Errorf("%w; Next error %s", err, err2.Error())
+					}
+				}
+				if allocated {
+					replayed[k] = true
 				} else {
-					err = fmt.Errorf("%w; Next error %s Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state. \n",
-						err, aw.Namespace, aw.Name, preemptionIds)
+					failed[k] = true
 				}
+				mutex.Unlock()
+				quotaLoadReplayed.Set(float64(atomic.AddInt64(&qm.loadDone, 1)))
 			}
-			klog.V(4).Infof("[loadDispatchedAWs] Dispatched AppWrappers %s/%s found to preload.", aw.Namespace, aw.Name)
-		} else {
-			klog.Warningf("[loadDispatchedAWs] Unable to obtain AppWrapper from key: %s.  Loading of AppWrapper will be skipped.",
-				k)
-		}
+		}()
 	}
 
-	return err
-}
-
-func (qm *QuotaManager) updateForestFromCache() error {
-	unallocatedConsumers, treeCacheCreateResponse, err := qm.quotaManagerBackend.UpdateForest(QuotaManagerForestName)
-
-	if treeCacheCreateResponse != nil {
-		for k, v := range treeCacheCreateResponse {
-			danglingNodeNames := v.DanglingNodeNames
-			if danglingNodeNames != nil {
-				for _, danglingNodeName := range danglingNodeNames {
-					klog.Errorf("[updateForestFromCache] Failure to link node %s to tree %s after Quota Manager Backend Cache refresh.", danglingNodeName, k)
-				}
+	// Log the progress periodically until the replay completes
+	progressDone := make(chan struct{})
+	defer close(progressDone)
+	go func() {
+		ticker := time.NewTicker(LoadProgressLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				done, total := qm.LoadProgress()
+				klog.Infof("[loadDispatchedAWs] Loaded %d of %d dispatched AppWrappers.", done, total)
+			case <-progressDone:
+				return
 			}
-			klog.V(10).Infof("[updateForestFromCache] %s", qm.quotaManagerBackend.String())
+		}
+	}()
+
+feedLoop:
+	for _, k := range pending {
+		select {
+		case keys <- k:
+		case <-ctx.Done():
+			break feedLoop
 		}
 	}
+	close(keys)
+	wg.Wait()
 
-	if unallocatedConsumers != nil && len(unallocatedConsumers) > 0 {
-		for _, unallocatedConsumer := range unallocatedConsumers {
-			klog.Errorf("[updateForestFromCache] Failure to allocate %s after Quota Manager Backend Cache refresh.", unallocatedConsumer)
+	var unreplayed, unallocated []string
+	for k := range dispatchedAWDemands {
+		if replayed[k] {
+			continue
+		}
+		name := k
+		if aw := getDispatchedAppWrapper(dispatchedAWs, k); aw != nil {
+			name = aw.Namespace + "/" + aw.Name
+		}
+		if failed[k] {
+			unallocated = append(unallocated, name)
+		} else {
+			unreplayed = append(unreplayed, name)
+		}
+	}
+	sort.Strings(unreplayed)
+	sort.Strings(unallocated)
+
+	duration := time.Since(startTime)
+	quotaLoadDuration.Set(duration.Seconds())
+	quotaLoadUnreplayed.Set(float64(len(unreplayed)))
+	klog.Infof("[loadDispatchedAWs] Loaded %d of %d dispatched AppWrappers with %d workers in %v.",
+		len(replayed), len(dispatchedAWDemands), workers, duration)
+
+	if len(unreplayed) > 0 {
+		klog.Errorf("[loadDispatchedAWs] Loading of dispatched AppWrappers timed out after %v, AppWrappers not loaded: %v.",
+			qm.loadTimeout, unreplayed)
+	}
+	if len(unallocated) > 0 {
+		klog.Errorf("[loadDispatchedAWs] Quota allocation of dispatched AppWrappers failed, AppWrappers not loaded: %v.",
+			unallocated)
+	}
+	if len(unreplayed) > 0 || len(unallocated) > 0 {
+		return &quota.PartialLoadError{
+			UnreplayedAppWrappers: unreplayed,
+			FailedAppWrappers:     unallocated,
+			Timeout:               qm.loadTimeout,
+			Err:                   err,
 		}
 	}
 
 	return err
 }
 
-// Recrusive call to add names of Tree
-func (qm *QuotaManager) addChildrenNodes(parentNode TreeNode, treeIDs []string) ([]string) {
-	if len(parentNode.Children) > 0 {
-		for _, childNode := range parentNode.Children {
-			klog.V(10).Infof("[getQuotaTreeIDs] Quota tree response child node from quota mananger: %s", childNode.Name)
-			treeIDs = qm.addChildrenNodes(childNode, treeIDs)
-		}
-	}
-	treeIDs = append(treeIDs, parentNode.Name)
-	return treeIDs
+// LoadProgress returns the number of dispatched AppWrappers replayed into the quota manager at startup and
+// the total number of dispatched AppWrappers to replay.
+func (qm *QuotaManager) LoadProgress() (done, total int) {
+	return int(atomic.LoadInt64(&qm.loadDone)), int(atomic.LoadInt64(&qm.loadTotal))
 }
 
-func isValidQuota(quotaGroup QuotaGroup, qmTreeIDs []string) bool {
-	for _, treeNodeID := range qmTreeIDs {
-		if strings.Compare(treeNodeID, quotaGroup.GroupContext) == 0 {
-			return true
-		}
+// loadDispatchedAW replays a dispatched AppWrapper into the quota manager and returns whether it was
+// allocated its quota.  An AppWrapper no longer found has nothing to replay.
+func (qm *QuotaManager) loadDispatchedAW(ctx context.Context, k string, demand *clusterstateapi.Resource,
+	dispatchedAWs map[string]*arbv1.AppWrapper) (bool, error) {
+	aw := getDispatchedAppWrapper(dispatchedAWs, k)
+	if aw == nil {
+		klog.Warningf("[loadDispatchedAWs] Unable to obtain AppWrapper from key: %s.  Loading of AppWrapper will be skipped.",
+			k)
+		return true, nil
 	}
-	return false
-}
 
-func (qm *QuotaManager) getQuotaDesignation(aw *arbv1.AppWrapper) ([]QuotaGroup, map[string][]string, error) {
-	var groups []QuotaGroup
-	treeNameToResourceTypes := make(map[string][]string)
+	var err error
+	err = nil
 
-	// Get list of quota management tree IDs
-	qmTreeIDs := qm.quotaManagerBackend.GetTreeNames()
-	if len(qmTreeIDs) <= 0 {
-		klog.Warningf("[getQuotaDesignation] No quota management IDs defined for quota evalution of for AppWrapper Job: %s/%s",
+	// AppWrappers whose demands are not converted are evaluated by Fits, reporting the failure
+	var fitResult *quota.FitResult
+	var err2 error
+	if perTreeDemands := qm.getLoadDemands(ctx, aw, demand); perTreeDemands != nil {
+		qm.maintenanceMutex.RLock()
+		qm.mutex.Lock()
+		fitResult, err2 = qm.fitsWithDemandAndNotify(ctx, aw, perTreeDemands, nil)
+		qm.mutex.Unlock()
+		qm.maintenanceMutex.RUnlock()
+	} else {
+		fitResult, err2 = qm.Fits(ctx, aw, demand, nil)
+	}
+	if fitResult == nil {
+		klog.Errorf("[loadDispatchedAWs] Loading of AppWrapper %s/%s failed.",
 			aw.Namespace, aw.Name)
-		return groups, treeNameToResourceTypes, nil
+		return false, fmt.Errorf("Loading of AppWrapper %s/%s failed, err: %#v \n", aw.Namespace, aw.Name, err2)
+	}
+	if err2 != nil || !fitResult.Fits {
+		klog.Errorf("[loadDispatchedAWs] Loading of AppWrapper %s/%s failed.",
+			aw.Namespace, aw.Name)
+		err = fmt.Errorf("Loading of AppWrapper %s/%s failed, reason: %s, msg: %s, err: %#v \n",
+			aw.Namespace, aw.Name, fitResult.Reason, fitResult.Message, err2)
 	}
 
-	labels := aw.GetLabels()
-	if ( labels != nil) {
-		keys := reflect.ValueOf(labels).MapKeys()
-		for _,  key := range keys {
-			strkey := key.String()
-			quotaGroup := QuotaGroup{
-				GroupContext: strkey,
-				GroupId: labels[strkey],
-			}
-			if isValidQuota(quotaGroup, qmTreeIDs) {
-				// Save the quota designation ain return var
-				groups = append(groups, quotaGroup)
-				klog.V(8).Infof("[getQuotaDesignation] AppWrapper: %s/%s quota label: %v found.",
-					aw.Namespace, aw.Name, quotaGroup)
-				// Save the related resource types in return var
-				treeNameToResourceTypes[quotaGroup.GroupContext] = qm.quotaManagerBackend.GetTreeCache(quotaGroup.GroupContext).GetResourceNames()
+	preemptionIds := fitResult.PreemptionTargets
+	if preemptionIds != nil && len(preemptionIds) > 0 {
+		klog.Errorf("[loadDispatchedAWs] Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state.",
+			aw.Namespace, aw.Name, preemptionIds)
+		if err == nil {
+			err = fmt.Errorf("Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state. \n",
+				aw.Namespace, aw.Name, preemptionIds)
+		} else {
+			err = fmt.Errorf("%w; Next error %s Loading of AppWrapper %s/%s caused invalid preemptions: %v.  Quota Manager is in inconsistent state. \n",
+				err, aw.Namespace, aw.Name, preemptionIds)
+		}
+	}
+	klog.V(4).Infof("[loadDispatchedAWs] Dispatched AppWrappers %s/%s found to preload.", aw.Namespace, aw.Name)
+
+	return err2 == nil && fitResult.Fits, err
+}
+
+// getLoadDemands converts the resource demands of a dispatched AppWrapper replayed at startup into the
+// demands of each designated quota tree, keyed by tree name.  The demands are converted under the read
+// lock, concurrently with the other load workers.  Returns nil when the demands are not converted, e.g. of
+// an AppWrapper missing a quota designation.
+func (qm *QuotaManager) getLoadDemands(ctx context.Context, aw *arbv1.AppWrapper,
+	demand *clusterstateapi.Resource) map[string]map[string]int {
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	if ctx.Err() != nil || qm.quotaManagerBackend == nil {
+		return nil
+	}
+	quotaTreeDesignations, treeNameToResourceTypes, err := qm.resolveQuotaDesignation(aw)
+	if err != nil {
+		return nil
+	}
+	perTreeDemands, err := qm.convertPerTreeDemands(aw, demand, quotaTreeDesignations, treeNameToResourceTypes)
+	if err != nil {
+		return nil
+	}
+	return perTreeDemands
+}
+
+// validateTreeUnits verifies the memory units declared by each quota tree match the units memory demands
+// are converted to.  Trees not declaring memory units are assumed to use the configured memory unit.
+func (qm *QuotaManager) validateTreeUnits() error {
+	var err error
+	err = nil
 
+	treeMemoryUnits := qm.getTreeMemoryUnits()
+	for _, treeName := range qm.getTreeNames() {
+		for _, memoryUnit := range treeMemoryUnits[treeName] {
+			if len(memoryUnit) <= 0 || strings.Compare(memoryUnit, qm.memoryUnit) == 0 {
+				continue
+			}
+			if err == nil {
+				err = fmt.Errorf("tree: %s memory unit %s is not supported, expected %s",
+					treeName, memoryUnit, qm.memoryUnit)
 			} else {
-				klog.V(10).Infof("[getQuotaDesignation] AppWrapper: %s/%s label: %v ignored.  Not a valid quota ID from Quota Tree list: %v.",
-					aw.Namespace, aw.Name, quotaGroup, qmTreeIDs)
+				err = fmt.Errorf("%w; next error tree: %s memory unit %s is not supported, expected %s",
+					err, treeName, memoryUnit, qm.memoryUnit)
 			}
 		}
-	} else {
-		klog.V(4).Infof("[getQuotaDesignation] AppWrapper: %s/%s does not any context quota labels.",
-										aw.Namespace, aw.Name)
 	}
 
-	// Figure out which quota tree allocation is missing and produce an error
-	if len(groups) < len(qmTreeIDs) {
-		var allocationMessage bytes.Buffer
-		fmt.Fprintf(&allocationMessage, "Missing required quota designation: ")
+	return err
+}
 
-		numMissingTreesCt := 0
-		for _, treeName := range qmTreeIDs {
-			treeFound := false
-			for _, quotaGroup := range groups {
-				if strings.Compare(treeName, quotaGroup.GroupContext) == 0 {
-					treeFound = true
-					break
-				}
+// validateTreeNodes validates the nodes defined by the ResourcePlans of each quota tree, see
+// ValidateTreeNodes.  The problems found in all trees are returned as a *quota.TreeValidationError.
+func (qm *QuotaManager) validateTreeNodes() error {
+	treeNodeLists := qm.getTreeNodeLists()
+	var treeNames []string
+	for treeName := range treeNodeLists {
+		treeNames = append(treeNames, treeName)
+	}
+	sort.Strings(treeNames)
+
+	var errs []error
+	for _, treeName := range treeNames {
+		var treeNodes []TreeNode
+		for _, namedNodeSpec := range treeNodeLists[treeName] {
+			var resourceNames []string
+			for resourceName := range namedNodeSpec.Spec.Quota {
+				resourceNames = append(resourceNames, resourceName)
 			}
-			if treeFound {
-				continue
-			} else {
-				if numMissingTreesCt < 1 {
-					fmt.Fprintf(&allocationMessage, "%s", treeName)
-				} else {
-					fmt.Fprintf(&allocationMessage, ", %s", treeName)
-				}
-				numMissingTreesCt++
+			sort.Strings(resourceNames)
+			amounts := make([]string, len(resourceNames))
+			for i, resourceName := range resourceNames {
+				amounts[i] = namedNodeSpec.Spec.Quota[resourceName]
 			}
+			hard, _ := strconv.ParseBool(namedNodeSpec.Spec.Hard)
+			treeNodes = append(treeNodes, TreeNode{
+				Name:   namedNodeSpec.Name,
+				Parent: namedNodeSpec.Spec.Parent,
+				Quota:  "[" + strings.Join(amounts, " ") + "]",
+				Hard:   hard,
+			})
 		}
 
-		// Produce an error
-		var err error
-		err = nil
-		if len(allocationMessage.String()) > 0 {
-			fmt.Fprintf(&allocationMessage, ".")
-			err = fmt.Errorf(allocationMessage.String())
+		var validationErr *quota.TreeValidationError
+		if err := ValidateTreeNodes(treeNodes); errors.As(err, &validationErr) {
+			for _, nodeErr := range validationErr.Errs {
+				errs = append(errs, fmt.Errorf("tree: %s %w", treeName, nodeErr))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &quota.TreeValidationError{Errs: errs}
+	}
+	return nil
+}
+
+// validateDefaultQuotaGroup returns an error when a default quota group is configured but its tree or
+// its group does not exist.
+func (qm *QuotaManager) validateDefaultQuotaGroup() error {
+	if len(qm.defaultQuotaTree) <= 0 {
+		return nil
+	}
+
+	if _, loaded := qm.getTreeResourceNames(qm.defaultQuotaTree); !loaded {
+		return fmt.Errorf("default quota tree %s does not exist", qm.defaultQuotaTree)
+	}
+	if _, found := qm.getTreeNodeSpecs()[qm.defaultQuotaTree][qm.defaultQuotaGroup]; !found {
+		return fmt.Errorf("default quota group %s does not exist in quota tree %s", qm.defaultQuotaGroup, qm.defaultQuotaTree)
+	}
+	return nil
+}
+
+// getTreeNames returns the quota tree names, fetching them from the backend only when the cached
+// names have been invalidated by a forest refresh.
+func (qm *QuotaManager) getTreeNames() []string {
+	qm.treeNamesMutex.Lock()
+	defer qm.treeNamesMutex.Unlock()
+
+	if qm.treeNames == nil {
+		treeNames := qm.quotaManagerBackend.GetTreeNames()
+		if treeNames == nil {
+			treeNames = []string{}
+		}
+		qm.treeNames = treeNames
+	}
+	return qm.treeNames
+}
+
+// getTreeResourceNames returns the resource names of a quota tree and whether the tree cache is loaded.
+// A tree may be listed by the backend before its cache is loaded, e.g. during concurrent ResourcePlan
+// updates.
+func (qm *QuotaManager) getTreeResourceNames(treeName string) ([]string, bool) {
+	treeCache := qm.quotaManagerBackend.GetTreeCache(treeName)
+	if treeCache == nil {
+		return nil, false
+	}
+	return treeCache.GetResourceNames(), true
+}
+
+func (qm *QuotaManager) invalidateTreeNames() {
+	qm.treeNamesMutex.Lock()
+	defer qm.treeNamesMutex.Unlock()
+
+	qm.treeNames = nil
+}
+
+// updateForestFromCache realizes the quota forest from the backend cache.  Dangling tree nodes, hard
+// quota nodes over committed by their children and consumers not allocated after the refresh are
+// returned as a *quota.ForestConsistencyError.
+func (qm *QuotaManager) updateForestFromCache() error {
+	qm.invalidateTreeNames()
+	qm.invalidateFitsCache()
+	qm.treeForests = qm.getTreeForests()
+
+	// Realize each forest, the consistency errors of all the forests are reported
+	var unallocatedConsumers []string
+	var danglingNodes []string
+	var err error
+	for _, forestName := range qm.getForestNames() {
+		forestUnallocatedConsumers, treeCacheCreateResponse, forestErr := qm.quotaManagerBackend.UpdateForest(forestName)
+		if forestErr != nil {
+			if err == nil {
+				err = fmt.Errorf("forest: %s %w", forestName, forestErr)
+			} else {
+				err = fmt.Errorf("%w; Next error forest: %s %s", err, forestName, forestErr.Error())
+			}
+		}
+		unallocatedConsumers = append(unallocatedConsumers, forestUnallocatedConsumers...)
+		danglingNodes = append(danglingNodes, qm.getDanglingNodes(treeCacheCreateResponse)...)
+	}
+	if err == nil {
+		atomic.StoreInt64(&qm.lastRefreshTime, time.Now().UnixNano())
+	}
+
+	if unallocatedConsumers != nil && len(unallocatedConsumers) > 0 {
+		for _, unallocatedConsumer := range unallocatedConsumers {
+			klog.Errorf("[updateForestFromCache] Failure to allocate %s after Quota Manager Backend Cache refresh.", unallocatedConsumer)
+		}
+	}
+
+	overCommittedNodes := validateHardQuotaRollup(getHardQuotaNodes(qm.getTreeNodeSpecs()))
+
+	qm.updateQuotaMetrics()
+
+	if len(danglingNodes) > 0 || len(unallocatedConsumers) > 0 || len(overCommittedNodes) > 0 {
+		sort.Strings(danglingNodes)
+		return &quota.ForestConsistencyError{
+			DanglingNodeNames:      danglingNodes,
+			OverCommittedNodeNames: overCommittedNodes,
+			UnallocatedConsumers:   unallocatedConsumers,
+			Err:                    err,
+		}
+	}
+
+	return err
+}
+
+// getDanglingNodes returns the dangling nodes of the tree cache create responses of a forest update,
+// formatted as <tree name>/<node name>.
+func (qm *QuotaManager) getDanglingNodes(treeCacheCreateResponse map[string]*core.TreeCacheCreateResponse) []string {
+	var danglingNodes []string
+	if treeCacheCreateResponse != nil {
+		for k, v := range treeCacheCreateResponse {
+			danglingNodeNames := v.DanglingNodeNames
+			if danglingNodeNames != nil {
+				for _, danglingNodeName := range danglingNodeNames {
+					klog.Errorf("[updateForestFromCache] Failure to link node %s to tree %s after Quota Manager Backend Cache refresh.", danglingNodeName, k)
+					danglingNodes = append(danglingNodes, k+"/"+danglingNodeName)
+				}
+			}
+			klog.V(10).Infof("[updateForestFromCache] %s", qm.quotaManagerBackend.String())
+		}
+	}
+	return danglingNodes
+}
+
+// Recrusive call to add names of Tree
+func (qm *QuotaManager) addChildrenNodes(parentNode TreeNode, treeIDs []string) []string {
+	if len(parentNode.Children) > 0 {
+		for _, childNode := range parentNode.Children {
+			klog.V(10).Infof("[getQuotaTreeIDs] Quota tree response child node from quota mananger: %s", childNode.Name)
+			treeIDs = qm.addChildrenNodes(childNode, treeIDs)
+		}
+	}
+	treeIDs = append(treeIDs, parentNode.Name)
+	return treeIDs
+}
+
+// getHardQuotaNodes returns the quota of the nodes of each quota tree checked by the hard quota rollup
+// validation, keyed by tree name and node name.
+func getHardQuotaNodes(treeNodeSpecs map[string]map[string]*qmbackendutils.JNodeSpec) map[string]map[string]*hardQuotaNode {
+	treeNodes := make(map[string]map[string]*hardQuotaNode)
+	for treeName, nodeSpecs := range treeNodeSpecs {
+		treeNodes[treeName] = make(map[string]*hardQuotaNode)
+		for nodeName, nodeSpec := range nodeSpecs {
+			hard, _ := strconv.ParseBool(nodeSpec.Hard)
+			treeNodes[treeName][nodeName] = &hardQuotaNode{parent: nodeSpec.Parent, hard: hard, quota: nodeSpec.Quota}
+		}
+	}
+	return treeNodes
+}
+
+func isValidQuota(quotaGroup QuotaGroup, qmTreeIDs []string) bool {
+	for _, treeNodeID := range qmTreeIDs {
+		if strings.Compare(treeNodeID, quotaGroup.GroupContext) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitQuotaGroupIds splits the group ID of a quota designation, a comma separated list of group IDs
+// designating fallback groups in order of preference.
+func splitQuotaGroupIds(quotaGroup QuotaGroup) []QuotaGroup {
+	var groups []QuotaGroup
+	for _, groupId := range strings.Split(quotaGroup.GroupId, QuotaGroupIdSeparator) {
+		groupId = strings.TrimSpace(groupId)
+		if len(groupId) <= 0 {
+			continue
+		}
+		groups = append(groups, QuotaGroup{
+			GroupContext: quotaGroup.GroupContext,
+			GroupId:      groupId,
+		})
+	}
+	return groups
+}
+
+// remapTreeName returns the tree name a legacy quota label key of an AppWrapper is remapped to, or the
+// label key when it is not remapped.
+func (qm *QuotaManager) remapTreeName(aw *arbv1.AppWrapper, labelKey string) string {
+	treeName, found := qm.treeRemap[labelKey]
+	if !found {
+		return labelKey
+	}
+
+	klog.V(4).Infof("[remapTreeName] AppWrapper: %s/%s quota label %s remapped to quota tree %s.",
+		aw.Namespace, aw.Name, labelKey, treeName)
+	return treeName
+}
+
+// getQuotaDesignation returns the quota groups designated by the labels of an AppWrapper and the resource
+// types of the designated trees, keyed by tree name.  A warning event is recorded on the AppWrapper when a
+// quota designation is missing.
+func (qm *QuotaManager) getQuotaDesignation(aw *arbv1.AppWrapper) ([]QuotaGroup, map[string][]string, error) {
+	groups, treeNameToResourceTypes, err := qm.resolveQuotaDesignation(aw)
+	if err != nil {
+		qm.recordMissingDesignation(aw, err.Error())
+	}
+	return groups, treeNameToResourceTypes, err
+}
+
+// ResourceTreeMapping returns the resource types of the quota trees designated by the labels of an
+// AppWrapper, keyed by tree name, i.e. which tree governs each resource type of the AppWrapper.  An
+// error is returned when a quota designation is missing, along with the mapping of the designated trees.
+func (qm *QuotaManager) ResourceTreeMapping(aw *arbv1.AppWrapper) (map[string][]string, error) {
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	_, treeNameToResourceTypes, err := qm.resolveQuotaDesignation(aw)
+	return treeNameToResourceTypes, err
+}
+
+// resolveQuotaDesignation resolves the quota designation of an AppWrapper, see getQuotaDesignation.
+func (qm *QuotaManager) resolveQuotaDesignation(aw *arbv1.AppWrapper) ([]QuotaGroup, map[string][]string, error) {
+	var groups []QuotaGroup
+	treeNameToResourceTypes := make(map[string][]string)
+
+	// Get list of quota management tree IDs of the forest of the AppWrapper
+	qmTreeIDs := qm.getForestTreeNames(qm.getAppWrapperForest(aw))
+	if len(qmTreeIDs) <= 0 {
+		klog.Warningf("[getQuotaDesignation] No quota management IDs defined for quota evalution of for AppWrapper Job: %s/%s",
+			aw.Namespace, aw.Name)
+		return groups, treeNameToResourceTypes, nil
+	}
+
+	labels := aw.GetLabels()
+	if labels != nil {
+		keys := reflect.ValueOf(labels).MapKeys()
+		for _, key := range keys {
+			strkey := key.String()
+			quotaGroup := QuotaGroup{
+				GroupContext: qm.remapTreeName(aw, strkey),
+				GroupId:      labels[strkey],
+			}
+			// Labels of the renamed tree take precedence over legacy labels
+			if _, found := labels[quotaGroup.GroupContext]; found && strings.Compare(quotaGroup.GroupContext, strkey) != 0 {
+				continue
+			}
+			if isValidQuota(quotaGroup, qmTreeIDs) {
+				// Trees not fully loaded, e.g. during a refresh, are skipped
+				resourceTypes, loaded := qm.getTreeResourceNames(quotaGroup.GroupContext)
+				if !loaded {
+					klog.Warningf("[getQuotaDesignation] AppWrapper: %s/%s quota label: %v ignored.  Quota tree %s is not loaded.",
+						aw.Namespace, aw.Name, quotaGroup, quotaGroup.GroupContext)
+					continue
+				}
+				// Save the quota designation(s) in return var
+				groups = append(groups, splitQuotaGroupIds(quotaGroup)...)
+				klog.V(8).Infof("[getQuotaDesignation] AppWrapper: %s/%s quota label: %v found.",
+					aw.Namespace, aw.Name, quotaGroup)
+				// Save the related resource types in return var
+				treeNameToResourceTypes[quotaGroup.GroupContext] = resourceTypes
+
+			} else {
+				klog.V(10).Infof("[getQuotaDesignation] AppWrapper: %s/%s label: %v ignored.  Not a valid quota ID from Quota Tree list: %v.",
+					aw.Namespace, aw.Name, quotaGroup, qmTreeIDs)
+			}
+		}
+	} else {
+		klog.V(4).Infof("[getQuotaDesignation] AppWrapper: %s/%s does not any context quota labels.",
+			aw.Namespace, aw.Name)
+	}
+
+	// Annotations designate quota groups of the trees not designated by labels, allowing group IDs that
+	// are not valid label values
+	if annotations := aw.GetAnnotations(); len(qm.annotationPrefix) > 0 && annotations != nil {
+		var keys []string
+		for key := range annotations {
+			if strings.HasPrefix(key, qm.annotationPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			quotaGroup := QuotaGroup{
+				GroupContext: qm.remapTreeName(aw, strings.TrimPrefix(key, qm.annotationPrefix)),
+				GroupId:      annotations[key],
+			}
+			if _, found := treeNameToResourceTypes[quotaGroup.GroupContext]; found {
+				klog.V(10).Infof("[getQuotaDesignation] AppWrapper: %s/%s annotation: %v ignored.  Quota tree already designated.",
+					aw.Namespace, aw.Name, quotaGroup)
+				continue
+			}
+			if !isValidQuota(quotaGroup, qmTreeIDs) {
+				klog.V(10).Infof("[getQuotaDesignation] AppWrapper: %s/%s annotation: %v ignored.  Not a valid quota ID from Quota Tree list: %v.",
+					aw.Namespace, aw.Name, quotaGroup, qmTreeIDs)
+				continue
+			}
+			resourceTypes, loaded := qm.getTreeResourceNames(quotaGroup.GroupContext)
+			if !loaded {
+				klog.Warningf("[getQuotaDesignation] AppWrapper: %s/%s annotation: %v ignored.  Quota tree %s is not loaded.",
+					aw.Namespace, aw.Name, quotaGroup, quotaGroup.GroupContext)
+				continue
+			}
+			groups = append(groups, splitQuotaGroupIds(quotaGroup)...)
+			klog.V(8).Infof("[getQuotaDesignation] AppWrapper: %s/%s quota annotation: %v found.",
+				aw.Namespace, aw.Name, quotaGroup)
+			treeNameToResourceTypes[quotaGroup.GroupContext] = resourceTypes
+		}
+	}
+
+	// AppWrappers designating no quota group are designated the default quota group, if any
+	if len(groups) == 0 && len(qm.defaultQuotaTree) > 0 {
+		defaultGroup := QuotaGroup{
+			GroupContext: qm.defaultQuotaTree,
+			GroupId:      qm.defaultQuotaGroup,
+		}
+		if resourceTypes, loaded := qm.getTreeResourceNames(defaultGroup.GroupContext); loaded && isValidQuota(defaultGroup, qmTreeIDs) {
+			groups = append(groups, defaultGroup)
+			treeNameToResourceTypes[defaultGroup.GroupContext] = resourceTypes
+			klog.V(6).Infof("[getQuotaDesignation] AppWrapper: %s/%s designates no quota group, default quota group: %v used.",
+				aw.Namespace, aw.Name, defaultGroup)
+		} else {
+			klog.V(4).Infof("[getQuotaDesignation] AppWrapper: %s/%s default quota group: %v ignored.  Quota tree %s is not loaded or not in the forest of the AppWrapper.",
+				aw.Namespace, aw.Name, defaultGroup, defaultGroup.GroupContext)
+		}
+	}
+
+	// Figure out which quota tree allocation is missing and produce an error, best-effort AppWrappers
+	// need no quota designation
+	if len(groups) < len(qmTreeIDs) && !quota.IsBestEffort(aw) {
+		var allocationMessage bytes.Buffer
+		fmt.Fprintf(&allocationMessage, "Missing required quota designation: ")
+
+		numMissingTreesCt := 0
+		for _, treeName := range qmTreeIDs {
+			treeFound := false
+			for _, quotaGroup := range groups {
+				if strings.Compare(treeName, quotaGroup.GroupContext) == 0 {
+					treeFound = true
+					break
+				}
+			}
+			if treeFound {
+				continue
+			} else {
+				if numMissingTreesCt < 1 {
+					fmt.Fprintf(&allocationMessage, "%s", treeName)
+				} else {
+					fmt.Fprintf(&allocationMessage, ", %s", treeName)
+				}
+				numMissingTreesCt++
+			}
+		}
+
+		// Produce an error
+		var err error
+		err = nil
+		if len(allocationMessage.String()) > 0 {
+			fmt.Fprintf(&allocationMessage, ".")
+			err = fmt.Errorf(allocationMessage.String())
+		} else {
+			err = fmt.Errorf("Unknown error verifying quota designations.")
+		}
+		klog.V(6).Infof("[getQuotaDesignation] No valid quota management IDs found for AppWrapper Job: %s/%s, err=%#v",
+			aw.Namespace, aw.Name, err)
+		return groups, treeNameToResourceTypes, err
+	}
+
+	if len(groups) > 0 {
+		klog.V(6).Infof("[getQuotaDesignation] AppWrapper: %s/%s quota labels: %v.", aw.Namespace,
+			aw.Name, groups)
+	} else {
+		klog.Warningf("[getQuotaDesignation] AppWrapper: %s/%s does not have any quota labels.",
+			aw.Namespace, aw.Name)
+	}
+
+	return groups, treeNameToResourceTypes, nil
+}
+
+// recordMissingDesignation emits a warning event on the AppWrapper listing the missing quota tree
+// designations.  The event is emitted once per AppWrapper generation.
+func (qm *QuotaManager) recordMissingDesignation(aw *arbv1.AppWrapper, message string) {
+	if qm.eventRecorder == nil {
+		return
+	}
+
+	qm.missingDesignationMutex.Lock()
+	defer qm.missingDesignationMutex.Unlock()
+
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	if generation, found := qm.missingDesignationGenerations[awId]; found && generation == aw.Generation {
+		return
+	}
+	qm.missingDesignationGenerations[awId] = aw.Generation
+
+	qm.eventRecorder.Event(aw, v1.EventTypeWarning, MissingQuotaDesignationReason, message)
+}
+
+// convertInt64Demand converts a demand to the quota manager backend size.  A demand larger than MaxInt is
+// clamped to MaxInt and reported as a *quota.DemandOverflowError.
+func (qm *QuotaManager) convertInt64Demand(int64Demand int64) (int, error) {
+	if int64Demand > int64(MaxInt) {
+		return MaxInt, &quota.DemandOverflowError{Demand: float64(int64Demand), Max: MaxInt}
+	}
+	return int(int64Demand), nil
+}
+
+// convertFloat64Demand converts a demand to the quota manager backend size, truncating fractions.  A
+// demand not below float64(MaxInt), which rounds MaxInt up, is clamped to MaxInt and reported as a
+// *quota.DemandOverflowError.
+func (qm *QuotaManager) convertFloat64Demand(floatDemand float64) (int, error) {
+	if floatDemand >= float64(MaxInt) {
+		return MaxInt, &quota.DemandOverflowError{Demand: floatDemand, Max: MaxInt}
+	}
+	return int(math.Trunc(floatDemand)), nil
+}
+
+// convertCPUDemand converts a CPU demand in millicores, rounding fractional millicores up unless CPU
+// demands are configured to be truncated.
+func (qm *QuotaManager) convertCPUDemand(milliCPUDemand float64) (int, error) {
+	if !qm.truncateCPUDemand {
+		milliCPUDemand = math.Ceil(milliCPUDemand)
+	}
+	return qm.convertFloat64Demand(milliCPUDemand)
+}
+
+// convertMemoryDemand converts a memory demand in bytes to the configured memory unit, rounding up so
+// the memory demand is never under-counted.  Logs a warning when rounding down would have lost more than
+// MemoryRoundingWarningRatio of the demand.
+func (qm *QuotaManager) convertMemoryDemand(bytesDemand float64) (int, error) {
+	unitDemand := bytesDemand / qm.memoryUnitBytes
+	roundedDemand := math.Ceil(unitDemand)
+
+	if remainder := bytesDemand - math.Trunc(unitDemand)*qm.memoryUnitBytes; bytesDemand > 0 &&
+		remainder/bytesDemand > MemoryRoundingWarningRatio {
+		klog.Warningf("[convertMemoryDemand] Memory demand of %0.0f bytes is not a multiple of %s, rounding up to %0.0f%s.",
+			bytesDemand, qm.memoryUnit, roundedDemand, qm.memoryUnit)
+	}
+
+	return qm.convertFloat64Demand(roundedDemand)
+}
+
+// getQuotaTreeResourceTypesDemands converts the AppWrapper demands to the demands of the resource types of
+// a quota tree.  Failed conversions are returned as a *quota.DemandConversionError.
+func (qm *QuotaManager) getQuotaTreeResourceTypesDemands(awResDemands *clusterstateapi.Resource, treeToResourceTypes []string) (map[string]int, error) {
+	demands := map[string]int{}
+	var failures []quota.DemandConversionFailure
+	converted := true
+
+	for _, treeResourceType := range treeToResourceTypes {
+		var demand int
+		var converErr error
+		canonicalResourceType := qm.resourceAliases[strings.ToLower(treeResourceType)]
+
+		if vendorResourceNames, found := qm.gpuVendorResources[strings.ToLower(treeResourceType)]; found {
+			// GPU Demands of specific vendors, e.g. only nvidia.com/gpu and not amd.com/gpu
+			demand, converErr = qm.convertFloat64Demand(getVendorGPUDemand(awResDemands, vendorResourceNames))
+		} else if clusterstateapi.IsSharedGPUResource(v1.ResourceName(treeResourceType)) {
+			// Shared GPU Demands in milli-units, the quota tree is expected to be defined in milli-units.
+			// Round up so fractional demands never convert to zero.
+			quantity := awResDemands.ScalarResources[v1.ResourceName(treeResourceType)]
+			demand, converErr = qm.convertFloat64Demand(math.Ceil(quantity * 1000))
+		} else if canonicalResourceType == options.QuotaResourceCPU {
+			// CPU Demands
+			demand, converErr = qm.convertCPUDemand(awResDemands.MilliCPU)
+		} else if canonicalResourceType == options.QuotaResourceMemory {
+			// Memory Demands
+			demand, converErr = qm.convertMemoryDemand(awResDemands.Memory)
+		} else if canonicalResourceType == options.QuotaResourceGPU {
+			// GPU Demands
+			demand, converErr = qm.convertInt64Demand(awResDemands.GPU)
+		} else if canonicalResourceType == options.QuotaResourceGPUMemory {
+			// GPU Memory Demands
+			demand, converErr = qm.convertInt64Demand(awResDemands.GPUMemory)
+		} else if canonicalResourceType == options.QuotaResourcePhysicalGPU {
+			// Physical GPU Demands of time-sliced GPU replica demands
+			demand, converErr = qm.convertFloat64Demand(qm.getPhysicalGPUDemand(awResDemands))
+		} else if quantity, found := awResDemands.ScalarResources[v1.ResourceName(treeResourceType)]; found {
+			// Extended resource demands (e.g. hugepages, MIG devices)
+			demand, converErr = qm.convertFloat64Demand(quantity)
+		} else {
+			// Resource type not requested by the AppWrapper
+			klog.V(8).Infof("[getQuotaTreeResourceTypesDemands] Resource type: %s not found in demands, using zero demand.",
+				treeResourceType)
+			failures = append(failures, quota.DemandConversionFailure{
+				ResourceName: treeResourceType,
+				Reason:       quota.DemandMissing,
+				Value:        0,
+				Message:      "not found in demands, using zero demand",
+			})
+		}
+
+		// Handle type conversions
+		if converErr != nil {
+			converted = false
+			failures = append(failures, quota.DemandConversionFailure{
+				ResourceName: treeResourceType,
+				Reason:       quota.DemandOverflow,
+				Value:        demand,
+				Message:      fmt.Sprintf("%s, resetting demand to %d", converErr.Error(), demand),
+				Err:          converErr,
+			})
+			var overflowErr *quota.DemandOverflowError
+			if errors.As(converErr, &overflowErr) {
+				overflowErr.ResourceName = treeResourceType
+			}
+		}
+		demands[treeResourceType] = demand
+	}
+
+	klog.V(10).Infof("[getQuotaTreeResourceTypesDemands] Quota resource demands: %#v.", demands)
+	if converted {
+		return demands, nil
+	}
+	return demands, &quota.DemandConversionError{Failures: failures}
+}
+
+// getVendorGPUDemand returns the sum of the demands of the named GPU resources.
+func getVendorGPUDemand(awResDemands *clusterstateapi.Resource, resourceNames []string) float64 {
+	var demand float64
+	for _, resourceName := range resourceNames {
+		if resourceName == clusterstateapi.GPUResourceName {
+			demand += float64(awResDemands.GPU)
+		} else {
+			demand += awResDemands.ScalarResources[v1.ResourceName(resourceName)]
+		}
+	}
+	return demand
+}
+
+// newPriorityClassLister creates a PriorityClass lister with a synchronized cache, returns nil when no
+// client can be created.
+func newPriorityClassLister(config *rest.Config) schedulinglisters.PriorityClassLister {
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Errorf("[newPriorityClassLister] Failure creating client, AppWrapper priority class names will be ignored, err=%#v.", err)
+		return nil
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	priorityClassInformer := informerFactory.Scheduling().V1().PriorityClasses()
+	priorityClassLister := priorityClassInformer.Lister()
+
+	neverStop := make(chan struct{})
+	go priorityClassInformer.Informer().Run(neverStop)
+	cache.WaitForCacheSync(neverStop, priorityClassInformer.Informer().HasSynced)
+
+	return priorityClassLister
+}
+
+// getPriority returns the effective priority of an AppWrapper: the value of its PriorityClass when a
+// priority class name is set, otherwise Spec.Priority.
+func (qm *QuotaManager) getPriority(aw *arbv1.AppWrapper) int {
+	priorityClassName := aw.Spec.PriorityClassName
+	if len(priorityClassName) <= 0 {
+		return int(aw.Spec.Priority)
+	}
+
+	if qm.priorityClassLister == nil {
+		klog.Warningf("[getPriority] No priority class lister exists, using priority %d of AppWrapper %s/%s.",
+			aw.Spec.Priority, aw.Namespace, aw.Name)
+		return int(aw.Spec.Priority)
+	}
+
+	priorityClass, err := qm.priorityClassLister.Get(priorityClassName)
+	if err != nil {
+		klog.Warningf("[getPriority] Priority class %s of AppWrapper %s/%s not found, using priority %d, err=%v.",
+			priorityClassName, aw.Namespace, aw.Name, aw.Spec.Priority, err)
+		return int(aw.Spec.Priority)
+	}
+
+	return int(priorityClass.Value)
+}
+
+// buildRequest creates the consumer spec of an AppWrapper with one tree spec per designated quota group.
+// A tree with fallback groups has several tree specs, in order of preference.  Trees for which the
+// AppWrapper demands none of the tree resource types are skipped, unless the AppWrapper demands no
+// resources at all, in which case it is kept present in all its designated trees.
+func (qm *QuotaManager) buildRequest(ctx context.Context, aw *arbv1.AppWrapper,
+	awResDemands *clusterstateapi.Resource) (*qmbackendutils.JConsumerSpec, error) {
+	perTreeDemands, err := qm.getPerTreeDemands(ctx, aw, awResDemands)
+	if err != nil {
+		return nil, err
+	}
+	return qm.buildRequestWithDemands(ctx, aw, perTreeDemands)
+}
+
+// getPerTreeDemands converts the resource demands of an AppWrapper into the demands of the resource types
+// of each designated quota tree, keyed by tree name.  Trees with no demand for their resource types are
+// omitted unless the AppWrapper demands no resources at all.  Demands larger than the quota manager backend
+// supports are rejected with a *quota.DemandOverflowError.
+func (qm *QuotaManager) getPerTreeDemands(ctx context.Context, aw *arbv1.AppWrapper,
+	awResDemands *clusterstateapi.Resource) (map[string]map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if aw == nil {
+		return nil, fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+	}
+	if len(util.CreateId(aw.Namespace, aw.Name)) <= 0 {
+		err := fmt.Errorf("%w: empty namespace: %s or name: %s", quota.ErrInvalidAppWrapper, aw.Namespace, aw.Name)
+		return nil, err
+	}
+
+	// Get quota tree designations and associated resource demands from AW labels
+	quotaTreeDesignations, treeNameToResourceTypes, err := qm.getQuotaDesignation(aw)
+	if err != nil {
+		return nil, err
+	}
+	return qm.convertPerTreeDemands(aw, awResDemands, quotaTreeDesignations, treeNameToResourceTypes)
+}
+
+// convertPerTreeDemands converts the resource demands of an AppWrapper into the demands of the resource
+// types of each of its designated quota trees, keyed by tree name, see getPerTreeDemands.
+func (qm *QuotaManager) convertPerTreeDemands(aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	quotaTreeDesignations []QuotaGroup, treeNameToResourceTypes map[string][]string) (map[string]map[string]int, error) {
+	perTreeDemands := make(map[string]map[string]int)
+	for _, quotaTreeDesignation := range quotaTreeDesignations {
+		quotaTreeName := quotaTreeDesignation.GroupContext
+		if _, found := perTreeDemands[quotaTreeName]; found {
+			continue
+		}
+
+		demands, err := qm.getQuotaTreeResourceTypesDemands(awResDemands, treeNameToResourceTypes[quotaTreeName])
+		if err != nil {
+			klog.Errorf("[getPerTreeDemands] Failure building quota resource demands for AppWrapper %s/%s, err=%#v",
+				aw.Namespace, aw.Name, err)
+
+			// Reject demands clamped to the backend size rather than allocating the clamped demand
+			var conversionErr *quota.DemandConversionError
+			if errors.As(err, &conversionErr) {
+				if overflowErr := conversionErr.Overflow(); overflowErr != nil {
+					return nil, fmt.Errorf("quota tree %s demands of AppWrapper %s/%s: %w",
+						quotaTreeName, aw.Namespace, aw.Name, overflowErr)
+				}
+			}
+		}
+
+		if isZeroDemand(demands) && !awResDemands.IsEmpty() {
+			klog.V(8).Infof("[getPerTreeDemands] Skipping quota tree %s of AppWrapper %s/%s with no demand for the tree resource types: %v.",
+				quotaTreeName, aw.Namespace, aw.Name, treeNameToResourceTypes[quotaTreeName])
+			continue
+		}
+		perTreeDemands[quotaTreeName] = demands
+	}
+
+	return perTreeDemands, nil
+}
+
+// buildRequestWithDemands creates the consumer spec of an AppWrapper from the demands of each quota tree,
+// keyed by tree name.  Designated trees without demands are skipped, demands of trees missing from the
+// forest are rejected.
+func (qm *QuotaManager) buildRequestWithDemands(ctx context.Context, aw *arbv1.AppWrapper,
+	perTreeDemands map[string]map[string]int) (*qmbackendutils.JConsumerSpec, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if aw == nil {
+		return nil, fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+	}
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	if len(awId) <= 0 {
+		err := fmt.Errorf("%w: empty namespace: %s or name: %s", quota.ErrInvalidAppWrapper, aw.Namespace, aw.Name)
+		return nil, err
+	}
+
+	forestTrees := make(map[string]bool)
+	for _, treeName := range qm.getTreeNames() {
+		forestTrees[treeName] = true
+	}
+	for treeName := range perTreeDemands {
+		if !forestTrees[treeName] {
+			return nil, fmt.Errorf("quota tree %s of the demands of AppWrapper %s/%s does not exist",
+				treeName, aw.Namespace, aw.Name)
+		}
+	}
+
+	var consumerTrees []qmbackendutils.JConsumerTreeSpec
+
+	// Get quota tree designations from AW labels
+	quotaTreeDesignations, _, err := qm.getQuotaDesignation(aw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, quotaTreeDesignation := range quotaTreeDesignations {
+		unPreemptable := !qm.preemptionEnabled || qm.unpreemptableConsumers[awId]
+
+		demands, found := perTreeDemands[quotaTreeDesignation.GroupContext]
+		if !found {
+			continue
+		}
+
+		priority := qm.getTreePriority(aw, awId, quotaTreeDesignation.GroupContext, qm.getPriority(aw), now)
+
+		consumerTreeSpec := &qmbackendutils.JConsumerTreeSpec{
+			ID:            awId,
+			TreeName:      quotaTreeDesignation.GroupContext,
+			GroupID:       quotaTreeDesignation.GroupId,
+			Request:       demands,
+			Priority:      priority,
+			CType:         0,
+			UnPreemptable: unPreemptable,
+		}
+
+		consumerTrees = append(consumerTrees, *consumerTreeSpec)
+
+	}
+
+	// Add quota demands per tree to quota allocation request
+	consumerSpec := &qmbackendutils.JConsumerSpec{
+		ID:    awId,
+		Trees: consumerTrees,
+	}
+
+	return consumerSpec, nil
+}
+
+// isZeroDemand returns true if the demand of every resource type of a quota tree is zero.
+func isZeroDemand(demands map[string]int) bool {
+	for _, demand := range demands {
+		if demand != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// getConsumerAlternatives expands a consumer spec with fallback groups into the consumer specs to try,
+// each with a single tree spec per tree, in order of preference, see getAlternativeTreeSpecs.
+func getConsumerAlternatives(consumerSpec *qmbackendutils.JConsumerSpec) []*qmbackendutils.JConsumerSpec {
+	treeNames := make([]string, len(consumerSpec.Trees))
+	for i, treeSpec := range consumerSpec.Trees {
+		treeNames[i] = treeSpec.TreeName
+	}
+
+	var alternatives []*qmbackendutils.JConsumerSpec
+	for _, treeSpecIndexes := range getAlternativeTreeSpecs(treeNames) {
+		alternative := &qmbackendutils.JConsumerSpec{ID: consumerSpec.ID}
+		for _, treeSpecIndex := range treeSpecIndexes {
+			alternative.Trees = append(alternative.Trees, consumerSpec.Trees[treeSpecIndex])
+		}
+		alternatives = append(alternatives, alternative)
+	}
+	return alternatives
+}
+
+// Messages of the backend allocation errors caused by a tree cache not yet realized in the forest, e.g.
+// right after a ResourcePlan change
+var staleTreeErrorMessages = []string{"unknown tree", "tree not found", "tree does not exist"}
+
+// isStaleTreeError returns true if an allocation error is caused by a stale tree cache.
+func isStaleTreeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := strings.ToLower(err.Error())
+	for _, staleTreeErrorMessage := range staleTreeErrorMessages {
+		if strings.Contains(message, staleTreeErrorMessage) {
+			return true
+		}
+	}
+	return false
+}
+
+// allocateForest allocates a consumer in a forest of the given backend, replaced in tests.
+var allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+	return backend.AllocateForest(forestName, consumerID)
+}
+
+// allocationResult is the result of a backend allocation.
+type allocationResult struct {
+	response *core.AllocationResponse
+	err      error
+}
+
+// allocateForestWithContext allocates a consumer in a forest of the given backend, returning ctx.Err()
+// as soon as the context is canceled, e.g. on controller shutdown, without waiting for the backend call.
+// The consumer of a canceled allocation is removed from the backend: right away when canceled before the
+// backend call, otherwise once the abandoned backend call completes, de-allocating its late result under
+// the quota manager lock unless the consumer was registered again in between.  Called with mutex held.
+func (qm *QuotaManager) allocateForestWithContext(ctx context.Context, backend *qmbackend.Manager, forestName string,
+	consumerID string) (*core.AllocationResponse, error) {
+	if err := ctx.Err(); err != nil {
+		backend.RemoveConsumer(consumerID)
+		return nil, err
+	}
+
+	done := make(chan allocationResult, 1)
+	go func() {
+		response, err := allocateForest(backend, forestName, consumerID)
+		done <- allocationResult{response: response, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.response, result.err
+	case <-ctx.Done():
+		klog.Warningf("[allocateForestWithContext] Allocation of consumer %s canceled, err=%v.", consumerID, ctx.Err())
+		if backend == qm.quotaManagerBackend {
+			if qm.abandonedAllocations == nil {
+				qm.abandonedAllocations = make(map[string]bool)
+			}
+			qm.abandonedAllocations[consumerID] = true
+		}
+		go qm.releaseAbandonedAllocation(done, backend, forestName, consumerID)
+		return nil, ctx.Err()
+	}
+}
+
+// releaseAbandonedAllocation waits for the backend allocation of a canceled consumer to complete and
+// removes the consumer, de-allocating it when the late allocation succeeded.
+func (qm *QuotaManager) releaseAbandonedAllocation(done <-chan allocationResult, backend *qmbackend.Manager,
+	forestName string, consumerID string) {
+	result := <-done
+
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+	if backend == qm.quotaManagerBackend {
+		delete(qm.abandonedAllocations, consumerID)
+	}
+	if _, found := qm.consumerSpecs[consumerID]; found && backend == qm.quotaManagerBackend {
+		klog.V(4).Infof("[releaseAbandonedAllocation] Consumer %s registered again, keeping its allocation.", consumerID)
+		return
+	}
+	if result.err == nil && result.response != nil && result.response.IsAllocated() {
+		klog.V(4).Infof("[releaseAbandonedAllocation] Releasing late allocation of canceled consumer %s.", consumerID)
+		backend.DeAllocateForest(forestName, consumerID)
+	}
+	backend.RemoveConsumer(consumerID)
+	if backend == qm.quotaManagerBackend {
+		qm.invalidateFitsCache()
+	}
+}
+
+// cancelConsumer removes the consumer of a canceled quota evaluation.  The backend consumer of an allocation
+// still running is left to releaseAbandonedAllocation.
+func (qm *QuotaManager) cancelConsumer(consumerID string) {
+	if !qm.abandonedAllocations[consumerID] {
+		qm.removeConsumer(consumerID)
+		return
+	}
+	qm.deleteConsumerSpec(consumerID)
+	delete(qm.burstingConsumers, consumerID)
+	delete(qm.borrowingConsumers, consumerID)
+}
+
+// allocateConsumer allocates a consumer in the forest of the given backend, trying the fallback groups of
+// the consumer spec in order of preference.  The first alternative allocated wins, when none is allocated
+// the last alternative stays registered in the backend.  Every alternative is allocated with the
+// AppWrapper priority, so an alternative fitting by preempting lower priority consumers is preferred
+// over later alternatives fitting without preemption.  Returns the response and consumer spec of the
+// last alternative tried.
+func (qm *QuotaManager) allocateConsumer(ctx context.Context, backend *qmbackend.Manager,
+	consumerSpec *qmbackendutils.JConsumerSpec) (*core.AllocationResponse, *qmbackendutils.JConsumerSpec, error) {
+	alternatives := getConsumerAlternatives(consumerSpec)
+
+	var allocResponse *core.AllocationResponse
+	var err error
+	for i, alternative := range alternatives {
+		// JConsumer : JSON consumer
+		consumer := qmbackendutils.JConsumer{
+			Kind: qmbackendutils.DefaultConsumerKind,
+			Spec: *alternative,
+		}
+		consumerInfo, infoErr := qmbackend.NewConsumerInfo(consumer)
+		if infoErr != nil {
+			return nil, alternative, infoErr
+		}
+
+		backend.AddConsumer(consumerInfo)
+		klog.V(4).Infof("[allocateConsumer] Sending quota allocation request for consumer %s.", alternative.ID)
+		logAllocationRequest(alternative)
+		allocResponse, err = qm.allocateForestWithContext(ctx, backend, qm.getSpecForest(alternative), alternative.ID)
+		logAllocationResponse(alternative.ID, allocResponse, err)
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			// Canceled allocations are removed from the backend by allocateForestWithContext
+			return nil, alternative, ctxErr
+		}
+		if err == nil && allocResponse.IsAllocated() {
+			return allocResponse, alternative, nil
+		}
+
+		if i < len(alternatives)-1 {
+			klog.V(4).Infof("[allocateConsumer] Consumer %s alternative %d of %d not allocated, trying next alternative.",
+				alternative.ID, i+1, len(alternatives))
+			backend.RemoveConsumer(alternative.ID)
+		}
+	}
+
+	return allocResponse, alternatives[len(alternatives)-1], err
+}
+
+// Log level of the structured quota allocation request and response logs
+const allocationLogLevel = klog.Level(6)
+
+// logAllocationRequest logs the per tree demands of a quota allocation request as key-value pairs.
+func logAllocationRequest(consumerSpec *qmbackendutils.JConsumerSpec) {
+	logger := klog.V(allocationLogLevel)
+	if !logger.Enabled() {
+		return
+	}
+
+	for _, treeSpec := range consumerSpec.Trees {
+		var resourceNames []string
+		for resourceName := range treeSpec.Request {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		sort.Strings(resourceNames)
+		for _, resourceName := range resourceNames {
+			logger.InfoS("[logAllocationRequest] Quota allocation request", "consumer", consumerSpec.ID,
+				"tree", treeSpec.TreeName, "group", treeSpec.GroupID, "resource", resourceName,
+				"demand", treeSpec.Request[resourceName], "priority", treeSpec.Priority)
+		}
+	}
+}
+
+// logAllocationResponse logs the allocation and preemption decision of a quota allocation response as
+// key-value pairs.
+func logAllocationResponse(consumerID string, allocResponse *core.AllocationResponse, err error) {
+	logger := klog.V(allocationLogLevel)
+	if !logger.Enabled() {
+		return
+	}
+
+	if err != nil || allocResponse == nil {
+		logger.InfoS("[logAllocationResponse] Quota allocation response", "consumer", consumerID,
+			"allocated", false, "error", err)
+		return
+	}
+	logger.InfoS("[logAllocationResponse] Quota allocation response", "consumer", consumerID,
+		"allocated", allocResponse.IsAllocated(), "preemptedIds", allocResponse.GetPreemptedIds(),
+		"message", allocResponse.GetMessage())
+}
+
+// LastRefresh returns the time of the last successful update of the forest, the zero time if the forest
+// was never updated.
+func (qm *QuotaManager) LastRefresh() time.Time {
+	lastRefreshTime := atomic.LoadInt64(&qm.lastRefreshTime)
+	if lastRefreshTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastRefreshTime)
+}
+
+// isRefreshDue returns false while the refresh of the quota trees is backing off after failures.
+func (qm *QuotaManager) isRefreshDue() bool {
+	return qm.refreshFailures == 0 || !time.Now().Before(qm.refreshRetryTime)
+}
+
+// refreshQuotaDefiniions loads the ResourcePlans into the quota manager backend and realizes the quota
+// trees.  Failures back off the next refresh, forest consistency errors are reported but not retried.
+func (qm *QuotaManager) refreshQuotaDefiniions() error {
+	qm.invalidateTreeNames()
+	allocatedIDs := qm.getAllocatedConsumerIDs()
+	// Keep the percentage quotas above the allocated quota
+	if percentageQuotas, ok := qm.resourcePlanManager.(PercentageQuotaProvider); ok {
+		percentageQuotas.SetQuotaFloors(qm.getQuotaFloors())
+	}
+	// Validate the tree nodes, then load ResourcePlan Cache into Quoto Management Backend Cache
+	err := qm.validateTreeNodes()
+	if err == nil {
+		err = qm.resourcePlanManager.LoadResourcePlansIntoBackend()
+	}
+	if err == nil {
+		// Realize new Quoto Management tree(s) from Backend Cache
+		err = qm.updateForestFromCache()
+	}
+	qm.lastRefreshErr = err
+
+	var consistencyErr *quota.ForestConsistencyError
+	if err == nil || errors.As(err, &consistencyErr) {
+		qm.updateOverSubscription(allocatedIDs)
+	}
+	if err != nil && !errors.As(err, &consistencyErr) {
+		qm.refreshFailures++
+		delay := refreshBackoff(qm.refreshFailures)
+		qm.refreshRetryTime = time.Now().Add(delay)
+		if qm.refreshFailures == 1 {
+			klog.Warningf("[refreshQuotaDefiniions] Entering quota tree refresh backoff.")
+			qm.refreshFailingSince = time.Now()
+		} else if failingFor := time.Since(qm.refreshFailingSince); failingFor > RefreshStalledWarningThreshold {
+			klog.Warningf("[refreshQuotaDefiniions] ResourcePlan changes not applied, quota tree refreshes failing for %v.",
+				failingFor.Round(time.Second))
+		}
+		klog.Errorf("[refreshQuotaDefiniions] Quota tree refresh failed %d times, retrying in %v, err=%v.",
+			qm.refreshFailures, delay, err)
+		return err
+	}
+
+	if qm.refreshFailures > 0 {
+		klog.Infof("[refreshQuotaDefiniions] Leaving quota tree refresh backoff after %d failures.", qm.refreshFailures)
+		qm.refreshFailures = 0
+	}
+	return err
+}
+
+// ForceRefresh reloads the ResourcePlans into the quota trees whether or not a change was detected, and
+// regardless of the refresh backoff, e.g. on SIGHUP or from an admin endpoint.  Dangling tree nodes and
+// consumers not allocated after the reload are returned as a *quota.ForestConsistencyError.
+func (qm *QuotaManager) ForceRefresh() error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	klog.Infof("[ForceRefresh] Reloading the quota trees from the ResourcePlans.")
+	err := qm.refreshQuotaDefiniions()
+	if err != nil {
+		klog.Warningf("[ForceRefresh] Reload of the quota trees completed with errors, err=%v.", err)
+	}
+	return err
+}
+
+// Fits evaluates an AppWrapper against quota and notifies the registered observers of the result.  The
+// decision for an AppWrapper that did not fit is cached until its demand or the forest changes.
+func (qm *QuotaManager) Fits(ctx context.Context, aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	if aw == nil {
+		err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+		return &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}, err
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	return qm.fitsAndNotify(ctx, aw, awResDemands, proposedPreemptions)
+}
+
+// fitsAndNotify evaluates an AppWrapper against quota as Fits, with mutex held.
+func (qm *QuotaManager) fitsAndNotify(ctx context.Context, aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	qm.expireReservations(time.Now())
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	demandHash := getDemandHash(aw, awResDemands, proposedPreemptions, qm.annotationPrefix, qm.allowQuotaExemption)
+	if result := qm.getCachedFitResult(awId, demandHash); result != nil {
+		qm.observers.NotifyAllocate(awId, result)
+		return result, nil
+	}
+
+	result, err := qm.fitsWithReservation(awId, func() (*quota.FitResult, error) {
+		return qm.fits(ctx, aw, awResDemands, nil, proposedPreemptions)
+	})
+	if result != nil && result.Fits && result.Reason == quota.Allocated {
+		qm.invalidateFitsCache()
+	}
+	if err == nil {
+		qm.cacheFitResult(awId, demandHash, result)
+	}
+	qm.observers.NotifyAllocate(awId, result)
+	return result, err
+}
+
+// FitsWithDemand evaluates an AppWrapper against quota like Fits, using the demands of each quota tree
+// supplied by the caller, keyed by tree name, instead of converting the resource demands of the AppWrapper,
+// e.g. when the caller caches the demands.  Designated trees without supplied demands are not allocated,
+// demands of trees missing from the forest are rejected.
+func (qm *QuotaManager) FitsWithDemand(aw *arbv1.AppWrapper, perTreeDemands map[string]map[string]int,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	if aw == nil {
+		err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+		return &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}, err
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	return qm.fitsWithDemandAndNotify(context.Background(), aw, perTreeDemands, proposedPreemptions)
+}
+
+// fitsWithDemandAndNotify evaluates an AppWrapper against quota as FitsWithDemand, with mutex held.
+func (qm *QuotaManager) fitsWithDemandAndNotify(ctx context.Context, aw *arbv1.AppWrapper, perTreeDemands map[string]map[string]int,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	if perTreeDemands == nil {
+		perTreeDemands = make(map[string]map[string]int)
+	}
+	qm.expireReservations(time.Now())
+	awId := util.CreateId(aw.Namespace, aw.Name)
+
+	result, err := qm.fitsWithReservation(awId, func() (*quota.FitResult, error) {
+		return qm.fits(ctx, aw, nil, perTreeDemands, proposedPreemptions)
+	})
+	if result != nil && result.Fits && result.Reason == quota.Allocated {
+		qm.invalidateFitsCache()
+	}
+	qm.observers.NotifyAllocate(awId, result)
+	return result, err
+}
+
+// isQuotaSelected returns whether the AppWrapper matches the quota AppWrapper selector, i.e. is subject to
+// quota.
+func (qm *QuotaManager) isQuotaSelected(aw *arbv1.AppWrapper) bool {
+	return qm.appwrapperSelector == nil || qm.appwrapperSelector.Matches(labels.Set(aw.Labels))
+}
+
+// isQuotaExempt returns whether the AppWrapper is exempt from quota, see quota.IsExempt.
+func (qm *QuotaManager) isQuotaExempt(aw *arbv1.AppWrapper) bool {
+	return qm.allowQuotaExemption && quota.IsExempt(aw)
+}
+
+// hasNoQuotaTrees returns whether no quota trees apply to the AppWrapper, i.e. no quota trees are defined
+// or the default forest of the AppWrapper has none.
+func (qm *QuotaManager) hasNoQuotaTrees(aw *arbv1.AppWrapper) bool {
+	forestName := qm.getAppWrapperForest(aw)
+	return len(qm.getTreeNames()) == 0 ||
+		(len(qm.getForestTreeNames(forestName)) == 0 && forestName == QuotaManagerForestName)
+}
+
+// bypassesQuota returns whether the AppWrapper always fits without allocating quota, matching the early
+// exits of fits: exempt, unselected and best-effort AppWrappers, and AppWrappers without quota trees.
+func (qm *QuotaManager) bypassesQuota(aw *arbv1.AppWrapper) bool {
+	return qm.isQuotaExempt(aw) || !qm.isQuotaSelected(aw) || quota.IsBestEffort(aw) || qm.hasNoQuotaTrees(aw)
+}
+
+// fits evaluates an AppWrapper against quota.  The demands of each quota tree are converted from the
+// resource demands of the AppWrapper unless perTreeDemands are supplied.
+func (qm *QuotaManager) fits(ctx context.Context, aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	perTreeDemands map[string]map[string]int, proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+
+	result := &quota.FitResult{
+		Fits: false,
+	}
+
+	// If a Quota Manager Backend instance does not exists then assume quota failed
+	if qm.quotaManagerBackend == nil {
+		klog.V(4).Infof("[Fits] No quota manager backend exists, %#v fails quota by default.",
+			awResDemands)
+		result.Reason = quota.NoBackend
+		result.Message = "No quota manager backend exists"
+		return result, errors.New(result.Message)
+	}
+
+	// Exempt AppWrappers always fit without allocating quota
+	if qm.isQuotaExempt(aw) {
+		consumerID := util.CreateId(aw.Namespace, aw.Name)
+		if _, found := qm.consumerSpecs[consumerID]; found {
+			klog.V(4).Infof("[Fits] Removing registered consumer of exempt AppWrapper %s/%s.", aw.Namespace, aw.Name)
+			qm.removeConsumer(consumerID)
+		}
+		klog.Infof("[Fits] Quota exemption applied to AppWrapper %s/%s.", aw.Namespace, aw.Name)
+		result.Fits = true
+		result.Reason = quota.Exempt
+		result.Message = "AppWrapper is exempt from quota"
+		return result, nil
+	}
+
+	// If Quota Manager initialization is complete but quota manager backend is in maintenance mode assume quota
+	// Processing quota requests is allow during initialization and backend is in maitenace mode for recovery purposes
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance && qm.initializationDone {
+		klog.Warningf("[Fits] Quota Manager backend in maintenance mode.  Unable to process request for AppWrapper: %s/%s",
+			aw.Namespace, aw.Name)
+		result.Reason = quota.Maintenance
+		result.Message = "Quota Manager backend in maintenance mode"
+		return result, errors.New(result.Message)
+	}
+
+	// AppWrappers not matching the quota AppWrapper selector always fit without allocating quota
+	if !qm.isQuotaSelected(aw) {
+		consumerID := util.CreateId(aw.Namespace, aw.Name)
+		if _, found := qm.consumerSpecs[consumerID]; found {
+			klog.V(4).Infof("[Fits] Removing registered consumer of unselected AppWrapper %s/%s.", aw.Namespace, aw.Name)
+			qm.removeConsumer(consumerID)
+		}
+		klog.V(4).Infof("[Fits] AppWrapper %s/%s does not match the quota AppWrapper selector, no quota applied.",
+			aw.Namespace, aw.Name)
+		result.Fits = true
+		result.Reason = quota.NotSelected
+		result.Message = "AppWrapper does not match the quota AppWrapper selector, no quota applied"
+		return result, nil
+	}
+
+	// Best-effort AppWrappers always fit without allocating quota
+	if quota.IsBestEffort(aw) {
+		consumerID := util.CreateId(aw.Namespace, aw.Name)
+		if _, found := qm.consumerSpecs[consumerID]; found {
+			klog.V(4).Infof("[Fits] Removing registered consumer of best-effort AppWrapper %s/%s.", aw.Namespace, aw.Name)
+			qm.removeConsumer(consumerID)
+		}
+		klog.V(4).Infof("[Fits] Best-effort AppWrapper %s/%s bypasses quota.", aw.Namespace, aw.Name)
+		result.Fits = true
+		result.Reason = quota.BestEffort
+		result.Message = "Best-effort AppWrapper bypasses quota"
+		return result, nil
+	}
+
+	// Refresh Quota Manager Backend Cache and Tree(s) if detected change in ResourcePlans, unless
+	// backing off after failed refreshes
+	if qm.resourcePlanManager.IsResplanChanged() && qm.isRefreshDue() {
+		err := qm.refreshQuotaDefiniions()
+		if err != nil {
+			klog.Errorf("[Fits] Failure during refresh of quota tree(s), err=%#v.", err)
+		}
+	}
+
+	// Without quota trees AppWrappers always fit, skip building and allocating a consumer
+	forestName := qm.getAppWrapperForest(aw)
+	forestTreeNames := qm.getForestTreeNames(forestName)
+	if qm.hasNoQuotaTrees(aw) {
+		consumerID := util.CreateId(aw.Namespace, aw.Name)
+		if _, found := qm.consumerSpecs[consumerID]; found {
+			klog.V(4).Infof("[Fits] Removing registered consumer of AppWrapper %s/%s, no quota trees defined.", aw.Namespace, aw.Name)
+			qm.removeConsumer(consumerID)
+		}
+		klog.V(8).Infof("[Fits] No quota trees defined, no quota applied to AppWrapper %s/%s.", aw.Namespace, aw.Name)
+		result.Fits = true
+		result.Reason = quota.NoQuotaTrees
+		result.Message = "no quota trees defined"
+		return result, nil
+	}
+
+	// AppWrappers designating a named forest without quota trees, e.g. misspelled, do not fit
+	if len(forestTreeNames) == 0 {
+		err := fmt.Errorf("%w: no quota trees defined in forest %s", quota.ErrInvalidAppWrapper, forestName)
+		klog.Errorf("[Fits] Creation of quota request failed: %s/%s, err=%v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.InvalidRequest
+		result.Message = err.Error()
+		return result, err
+	}
+
+	// Create a consumer
+	buildConsumerSpec := func() (*qmbackendutils.JConsumerSpec, error) {
+		if perTreeDemands != nil {
+			return qm.buildRequestWithDemands(ctx, aw, perTreeDemands)
+		}
+		return qm.buildRequest(ctx, aw, awResDemands)
+	}
+	consumerSpec, err := buildConsumerSpec()
+	if err != nil {
+		klog.Errorf("[Fits] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.InvalidRequest
+		if err == ctx.Err() {
+			result.Reason = quota.Canceled
+		}
+		result.Message = err.Error()
+		return result, err
+	}
+
+	// Handle a consumer already registered, e.g. Fits called again for a pending AppWrapper
+	if existingSpec, found := qm.consumerSpecs[consumerSpec.ID]; found {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerSpec.ID), consumerSpec.ID) {
+			for _, alternative := range getConsumerAlternatives(consumerSpec) {
+				if reflect.DeepEqual(existingSpec, alternative) {
+					klog.V(4).Infof("[Fits] Consumer %s/%s already allocated with the same request.", aw.Namespace, aw.Name)
+					result.Fits = true
+					result.Reason = quota.Allocated
+					result.Message = "Consumer already allocated"
+					return result, nil
+				}
+			}
+		}
+
+		// Replace the registered consumer by the new request
+		klog.V(4).Infof("[Fits] Replacing registered consumer %s/%s.", aw.Namespace, aw.Name)
+		qm.removeConsumer(consumerSpec.ID)
+	}
+
+	allocResponse, allocatedSpec, err := qm.allocateConsumer(ctx, qm.quotaManagerBackend, consumerSpec)
+	if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+		klog.Warningf("[Fits] Allocation of consumer %s/%s canceled, err=%v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.Canceled
+		result.Message = err.Error()
+		return result, err
+	}
+
+	// Refresh the forest and retry once when the tree cache was stale, e.g. right after a ResourcePlan change
+	if isStaleTreeError(err) {
+		klog.Warningf("[Fits] Allocation of consumer %s/%s failed on a stale quota tree, refreshing the forest and retrying, err=%v.",
+			aw.Namespace, aw.Name, err)
+		qm.removeConsumer(consumerSpec.ID)
+		if refreshErr := qm.updateForestFromCache(); refreshErr != nil {
+			klog.Errorf("[Fits] Failure refreshing the forest for consumer %s/%s, err=%v.", aw.Namespace, aw.Name, refreshErr)
+		}
+
+		consumerSpec, err = buildConsumerSpec()
+		if err != nil {
+			klog.Errorf("[Fits] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+			result.Reason = quota.InvalidRequest
+			if err == ctx.Err() {
+				result.Reason = quota.Canceled
+			}
+			result.Message = err.Error()
+			return result, err
+		}
+		allocResponse, allocatedSpec, err = qm.allocateConsumer(ctx, qm.quotaManagerBackend, consumerSpec)
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			klog.Warningf("[Fits] Retry allocating consumer %s/%s canceled, err=%v.", aw.Namespace, aw.Name, err)
+			result.Reason = quota.Canceled
+			result.Message = err.Error()
+			return result, err
+		}
+		if err != nil {
+			klog.Errorf("[Fits] Retry allocating consumer %s/%s after forest refresh failed, err=%v.", aw.Namespace, aw.Name, err)
+			qm.removeConsumer(consumerSpec.ID)
+			result.Reason = quota.QuotaExceeded
+			result.Message = err.Error()
+			return result, err
+		}
+	}
+
+	qm.setConsumerSpec(consumerSpec.ID, allocatedSpec)
+
+	if err != nil {
+		result.Reason = quota.QuotaExceeded
+		if allocResponse != nil && len(allocResponse.GetMessage()) > 0 {
+			klog.Errorf("[Fits] Error allocating consumer: %s/%s, msg=%s, err=%#v.",
+				aw.Namespace, aw.Name, allocResponse.GetMessage(), err)
+			result.Message = allocResponse.GetMessage()
+		} else {
+			klog.Errorf("[Fits] Error allocating consumer: %s/%s, err=%#v.",
+				aw.Namespace, aw.Name, err)
+			result.Message = err.Error()
+		}
+		return result, err
+	}
+
+	// Reclaim the quota lent by the groups of the consumer, or else borrow the idle quota of the siblings of
+	// its exhausted hard quota groups
+	var reclaimedBorrowers []*reclaimedBorrower
+	if !allocResponse.IsAllocated() {
+		if reclaimResponse, reclaimSpec, reclaimed := qm.reclaimLentQuota(ctx, allocatedSpec); reclaimResponse != nil {
+			allocResponse, allocatedSpec, reclaimedBorrowers = reclaimResponse, reclaimSpec, reclaimed
+		} else if borrowResponse, borrowSpec := qm.borrowIdleQuota(ctx, allocatedSpec); borrowResponse != nil {
+			allocResponse, allocatedSpec = borrowResponse, borrowSpec
+		}
+		qm.setConsumerSpec(consumerSpec.ID, allocatedSpec)
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			klog.Warningf("[Fits] Allocation of consumer %s/%s canceled, err=%v.", aw.Namespace, aw.Name, ctxErr)
+			qm.cancelConsumer(consumerSpec.ID)
+			result.Reason = quota.Canceled
+			result.Message = ctxErr.Error()
+			return result, ctxErr
+		}
+	}
+
+	result.PreemptionTargets = qm.getAppWrappers(allocResponse.GetPreemptedIds())
+	for _, reclaimed := range reclaimedBorrowers {
+		result.PreemptionTargets = append(result.PreemptionTargets, reclaimed.appWrapper)
+	}
+
+	// AppWrappers dispatched less than the minimum preemption age ago can not be preempted
+	if youngTargets := qm.getYoungPreemptionTargets(result.PreemptionTargets, time.Now()); len(youngTargets) > 0 {
+		klog.V(4).Infof("[Fits] Allocation of %s/%s requires preempting %d AppWrappers dispatched less than %v ago, rolling back.",
+			aw.Namespace, aw.Name, len(youngTargets), qm.minPreemptionAge)
+		qm.rollbackPreemption(consumerSpec.ID, allocResponse.GetPreemptedIds())
+		qm.restoreBorrowers(reclaimedBorrowers)
+		result.PreemptionTargets = nil
+		result.Reason = quota.QuotaExceeded
+		result.Message = fmt.Sprintf("preemption of %d AppWrappers dispatched less than %v ago is not allowed",
+			len(youngTargets), qm.minPreemptionAge)
+		return result, nil
+	}
+
+	// Consumers made unpreemptable after their allocation can not be preempted
+	if pinnedIDs := qm.getUnpreemptableIDs(allocResponse.GetPreemptedIds()); len(pinnedIDs) > 0 {
+		klog.V(4).Infof("[Fits] Allocation of %s/%s requires preempting unpreemptable consumers %v, rolling back.",
+			aw.Namespace, aw.Name, pinnedIDs)
+		qm.rollbackPreemption(consumerSpec.ID, allocResponse.GetPreemptedIds())
+		qm.restoreBorrowers(reclaimedBorrowers)
+		result.PreemptionTargets = nil
+		result.Reason = quota.QuotaExceeded
+		result.Message = fmt.Sprintf("preemption of unpreemptable consumers %v is not allowed", pinnedIDs)
+		return result, nil
+	}
+
+	// Preemptions throwing away more work than the preemption cost threshold allows are not allowed
+	preemptionCost := qm.getPreemptionCost(allocatedSpec, result.PreemptionTargets, reclaimedBorrowers, time.Now())
+	if preemptionCost.Exceeds(qm.preemptionCostThreshold) {
+		klog.V(4).Infof("[Fits] Allocation of %s/%s requires preemptions of cost %.1f exceeding value %.3f times %v, rolling back.",
+			aw.Namespace, aw.Name, preemptionCost.Cost, preemptionCost.Value, qm.preemptionCostThreshold)
+		qm.rollbackPreemption(consumerSpec.ID, allocResponse.GetPreemptedIds())
+		qm.restoreBorrowers(reclaimedBorrowers)
+		result.PreemptionTargets = nil
+		result.Reason = quota.QuotaExceeded
+		result.Message = fmt.Sprintf("preemption cost %.1f exceeds the preemption cost threshold of %v", preemptionCost.Cost,
+			qm.preemptionCostThreshold)
+		return result, nil
+	}
+
+	// Soft quota nodes can not burst above their burst limits
+	if allocResponse.IsAllocated() {
+		exceededNodes, bursting := qm.checkBurstLimits(allocatedSpec)
+		if len(exceededNodes) > 0 {
+			klog.V(4).Infof("[Fits] Allocation of %s/%s exceeds the burst limits of quota nodes %v, rolling back.",
+				aw.Namespace, aw.Name, exceededNodes)
+			qm.rollbackPreemption(consumerSpec.ID, allocResponse.GetPreemptedIds())
+			qm.restoreBorrowers(reclaimedBorrowers)
+			result.PreemptionTargets = nil
+			result.Reason = quota.QuotaExceeded
+			result.Message = fmt.Sprintf("burst limits of quota nodes %v exceeded", exceededNodes)
+			return result, nil
+		}
+		qm.setBursting(consumerSpec.ID, bursting)
+	}
+
+	result.Fits = allocResponse.IsAllocated()
+	result.Message = allocResponse.GetMessage()
+	if result.Fits {
+		result.Reason = quota.Allocated
+		result.PreemptionCost = preemptionCost
+		// Best-effort AppWrappers hold no quota, they are preempted when their capacity is reclaimed
+		result.PreemptionTargets = quota.AddBestEffortTargets(result.PreemptionTargets, proposedPreemptions)
+		if qm.allowQuotaExemption {
+			result.PreemptionTargets = quota.RemoveExemptTargets(result.PreemptionTargets)
+		}
+		qm.updateQuotaMetrics()
+	} else {
+		result.Reason = quota.QuotaExceeded
+		if blockingMessage := qm.getBlockingMessage(consumerSpec); len(blockingMessage) > 0 {
+			klog.V(4).Infof("[Fits] AppWrapper %s/%s does not fit, %s.", aw.Namespace, aw.Name, blockingMessage)
+			if len(result.Message) > 0 {
+				result.Message = result.Message + "; " + blockingMessage
+			} else {
+				result.Message = blockingMessage
+			}
+		}
+	}
+	if len(allocResponse.GetMessage()) > 0 {
+		klog.Warningf("[Fits] Response from Quota Management backend: %s",
+			allocResponse.GetMessage())
+	}
+
+	return result, nil
+}
+
+// getYoungPreemptionTargets returns the preemption targets dispatched less than the minimum preemption
+// age before now.
+func (qm *QuotaManager) getYoungPreemptionTargets(targets []*arbv1.AppWrapper, now time.Time) []*arbv1.AppWrapper {
+	if qm.minPreemptionAge <= 0 {
+		return nil
+	}
+
+	var youngTargets []*arbv1.AppWrapper
+	for _, target := range targets {
+		dispatchTime := quota.GetDispatchTime(target)
+		if !dispatchTime.IsZero() && now.Sub(dispatchTime) < qm.minPreemptionAge {
+			youngTargets = append(youngTargets, target)
+		}
+	}
+	return youngTargets
+}
+
+// restorePreempted allocates again the consumers preempted by allocations that were rolled back, e.g. of a
+// group that does not fit.  Consumers removed by their preemption, e.g. reclaimed borrowers, are registered
+// again from their specs and lenders before the preemption.  The preempted consumers are allocated in
+// maintenance mode so they do not preempt each other.
+func (qm *QuotaManager) restorePreempted(preemptedIDs []string, specs map[string]*qmbackendutils.JConsumerSpec,
+	lenders map[string][]string) {
+	mode := qm.quotaManagerBackend.GetMode()
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	defer qm.quotaManagerBackend.SetMode(mode)
+
+	for _, preemptedID := range preemptedIDs {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(preemptedID), preemptedID) {
+			continue
+		}
+		if _, found := qm.consumerSpecs[preemptedID]; found {
+			allocResponse, err := qm.quotaManagerBackend.AllocateForest(qm.getConsumerForest(preemptedID), preemptedID)
+			if err != nil || !allocResponse.IsAllocated() {
+				klog.Errorf("[restorePreempted] Failure allocating preempted consumer %s again, err=%v.", preemptedID, err)
+			}
+			continue
+		}
+		// Preempted AppWrappers without a consumer, e.g. best-effort AppWrappers, hold no quota
+		consumerSpec, found := specs[preemptedID]
+		if !found {
+			continue
+		}
+		if err := qm.reallocateConsumer(consumerSpec); err != nil {
+			klog.Errorf("[restorePreempted] Failure allocating removed consumer %s again, err=%v.", preemptedID, err)
+			continue
+		}
+		qm.setBorrowing(preemptedID, lenders[preemptedID])
+	}
+}
+
+// rollbackPreemption removes a consumer allocated by preempting other consumers and allocates the
+// preempted consumers again.  The preempted consumers are allocated in maintenance mode so they do not
+// preempt each other.
+func (qm *QuotaManager) rollbackPreemption(consumerID string, preemptedIDs []string) {
+	qm.removeConsumer(consumerID)
+
+	mode := qm.quotaManagerBackend.GetMode()
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	defer qm.quotaManagerBackend.SetMode(mode)
+
+	for _, preemptedID := range preemptedIDs {
+		allocResponse, err := qm.quotaManagerBackend.AllocateForest(qm.getConsumerForest(preemptedID), preemptedID)
+		if err != nil || !allocResponse.IsAllocated() {
+			klog.Errorf("[rollbackPreemption] Failure allocating preempted consumer %s again, err=%v.", preemptedID, err)
+		}
+	}
+}
+
+// DryRunFits evaluates whether an AppWrapper would fit in quota, and which AppWrappers would be
+// preempted, without changing the quota forest.  The request is evaluated against a clone of the
+// backend so no consumer is left registered in the quota manager backend.
+func (qm *QuotaManager) DryRunFits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource) (*quota.FitResult, error) {
+	if aw == nil {
+		err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+		return &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}, err
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	result := &quota.FitResult{
+		Fits: false,
+	}
+
+	// If a Quota Manager Backend instance does not exists then assume quota failed
+	if qm.quotaManagerBackend == nil {
+		result.Reason = quota.NoBackend
+		result.Message = "No quota manager backend exists"
+		return result, errors.New(result.Message)
+	}
+
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance && qm.initializationDone {
+		result.Reason = quota.Maintenance
+		result.Message = "Quota Manager backend in maintenance mode"
+		return result, errors.New(result.Message)
+	}
+
+	if qm.isQuotaExempt(aw) {
+		result.Fits = true
+		result.Reason = quota.Exempt
+		result.Message = "AppWrapper is exempt from quota"
+		return result, nil
+	}
+
+	if !qm.isQuotaSelected(aw) {
+		result.Fits = true
+		result.Reason = quota.NotSelected
+		result.Message = "AppWrapper does not match the quota AppWrapper selector, no quota applied"
+		return result, nil
+	}
+
+	if quota.IsBestEffort(aw) {
+		result.Fits = true
+		result.Reason = quota.BestEffort
+		result.Message = "Best-effort AppWrapper bypasses quota"
+		return result, nil
+	}
+
+	// Create a consumer
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, awResDemands)
+	if err != nil {
+		klog.Errorf("[DryRunFits] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.InvalidRequest
+		result.Message = err.Error()
+		return result, err
+	}
+
+	backend, err := qm.cloneBackend()
+	if err != nil {
+		klog.Errorf("[DryRunFits] Failure cloning quota manager backend for %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.NoBackend
+		result.Message = err.Error()
+		return result, err
+	}
+
+	allocResponse, _, err := qm.allocateConsumer(context.Background(), backend, consumerSpec)
+	if err != nil {
+		result.Reason = quota.QuotaExceeded
+		result.Message = err.Error()
+		if allocResponse != nil && len(allocResponse.GetMessage()) > 0 {
+			result.Message = allocResponse.GetMessage()
+		}
+		return result, err
+	}
+
+	result.Fits = allocResponse.IsAllocated()
+	result.Message = allocResponse.GetMessage()
+	result.Reason = quota.QuotaExceeded
+	if result.Fits {
+		result.Reason = quota.Allocated
+	}
+	result.PreemptionTargets = qm.getAppWrappers(allocResponse.GetPreemptedIds())
+	result.PreemptionCost = qm.getPreemptionCost(consumerSpec, result.PreemptionTargets, nil, time.Now())
+	klog.V(4).Infof("[DryRunFits] Dry run for %s/%s fits: %t, preemptions: %d, msg: %s.",
+		aw.Namespace, aw.Name, result.Fits, len(result.PreemptionTargets), result.Message)
+
+	return result, nil
+}
+
+// FitsGroup evaluates a group of AppWrappers against quota, allocating either all of them or none.  The
+// group is first evaluated against a clone of the backend so a group that does not fit leaves the forest
+// unchanged.  The AppWrappers are then allocated in request order and the tentative allocations are
+// released when any of them does not fit.
+func (qm *QuotaManager) FitsGroup(requests []quota.FitsRequest) (*quota.GroupFitResult, error) {
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	result := &quota.GroupFitResult{
+		Fits: false,
+	}
+
+	// If a Quota Manager Backend instance does not exists then assume quota failed
+	if qm.quotaManagerBackend == nil {
+		result.Message = "No quota manager backend exists"
+		return result, errors.New(result.Message)
+	}
+
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance && qm.initializationDone {
+		result.Message = "Quota Manager backend in maintenance mode"
+		return result, errors.New(result.Message)
+	}
+
+	// Evaluate the group against a scratch backend
+	backend, err := qm.cloneBackend()
+	if err != nil {
+		klog.Errorf("[FitsGroup] Failure cloning quota manager backend, err=%#v.", err)
+		result.Message = err.Error()
+		return result, err
+	}
+	for _, request := range requests {
+		aw := request.AppWrapper
+		// AppWrappers bypassing quota always fit
+		if qm.bypassesQuota(aw) {
+			continue
+		}
+		consumerSpec, err := qm.buildRequest(context.Background(), aw, request.Resources)
+		if err != nil {
+			klog.Errorf("[FitsGroup] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+			result.Message = err.Error()
+			return result, err
+		}
+
+		// Consumers already allocated are evaluated with their new request
+		if backend.IsAllocatedForest(qm.getSpecForest(consumerSpec), consumerSpec.ID) {
+			backend.DeAllocateForest(qm.getSpecForest(consumerSpec), consumerSpec.ID)
+			backend.RemoveConsumer(consumerSpec.ID)
+		}
+
+		allocResponse, _, err := qm.allocateConsumer(context.Background(), backend, consumerSpec)
+		if err != nil || !allocResponse.IsAllocated() {
+			klog.V(4).Infof("[FitsGroup] AppWrapper %s/%s of the group does not fit, err=%v.", aw.Namespace, aw.Name, err)
+			result.Message = fmt.Sprintf("AppWrapper %s/%s of the group does not fit", aw.Namespace, aw.Name)
+			return result, nil
+		}
+	}
+
+	// Allocate the group, keeping the consumers before the allocations to restore the preempted ones
+	specs := make(map[string]*qmbackendutils.JConsumerSpec, len(qm.consumerSpecs))
+	for consumerID, consumerSpec := range qm.consumerSpecs {
+		specs[consumerID] = consumerSpec
+	}
+	lenders := make(map[string][]string, len(qm.borrowingConsumers))
+	for consumerID, consumerLenders := range qm.borrowingConsumers {
+		lenders[consumerID] = consumerLenders
+	}
+	var allocatedIDs []string
+	var preemptedIDs []string
+	for _, request := range requests {
+		aw := request.AppWrapper
+		consumerID := util.CreateId(aw.Namespace, aw.Name)
+		wasAllocated := qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID)
+
+		fitResult, err := qm.fitsAndNotify(context.Background(), aw, request.Resources, request.ProposedPreemptions)
+		result.Results = append(result.Results, fitResult)
+		if err != nil || fitResult == nil || !fitResult.Fits {
+			klog.Warningf("[FitsGroup] AppWrapper %s/%s of the group does not fit, releasing %d tentative allocations and restoring %d preempted consumers.",
+				aw.Namespace, aw.Name, len(allocatedIDs), len(preemptedIDs))
+			for _, allocatedID := range allocatedIDs {
+				qm.release(allocatedID)
+			}
+			qm.restorePreempted(preemptedIDs, specs, lenders)
+			result.PreemptionTargets = nil
+			result.Message = fmt.Sprintf("AppWrapper %s/%s of the group does not fit", aw.Namespace, aw.Name)
+			return result, err
+		}
+
+		// Allocations made before the group evaluation are not rolled back
+		if !wasAllocated {
+			allocatedIDs = append(allocatedIDs, consumerID)
+		}
+		for _, target := range fitResult.PreemptionTargets {
+			preemptedIDs = append(preemptedIDs, util.CreateId(target.Namespace, target.Name))
+		}
+		result.PreemptionTargets = append(result.PreemptionTargets, fitResult.PreemptionTargets...)
+	}
+
+	result.Fits = true
+	return result, nil
+}
+
+// cloneBackend creates a scratch quota manager backend holding the current quota trees and the
+// consumers currently allocated in the forest.  Only reads the quota manager, callers hold the read lock.
+func (qm *QuotaManager) cloneBackend() (*qmbackend.Manager, error) {
+	backend := qmbackend.NewManager()
+	err := backend.AddForest(QuotaManagerForestName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := qm.loadResourcePlansInto(backend); err != nil {
+		return nil, err
+	}
+	for _, forestName := range backend.GetForestNames() {
+		_, _, err = backend.UpdateForest(forestName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Replay allocated consumers in allocation order, so the clone allocates as the forest did
+	var consumerIDs []string
+	for consumerID := range qm.consumerSpecs {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			consumerIDs = append(consumerIDs, consumerID)
+		}
+	}
+	sortByConsumerOrder(consumerIDs, qm.consumerOrder)
+
+	for _, consumerID := range consumerIDs {
+		consumer := qmbackendutils.JConsumer{
+			Kind: qmbackendutils.DefaultConsumerKind,
+			Spec: *qm.consumerSpecs[consumerID],
+		}
+		consumerInfo, err := qmbackend.NewConsumerInfo(consumer)
+		if err != nil {
+			return nil, err
+		}
+		backend.AddConsumer(consumerInfo)
+		allocResponse, err := backend.AllocateForest(qm.getConsumerForest(consumerID), consumerID)
+		if err != nil || !allocResponse.IsAllocated() {
+			klog.Warningf("[cloneBackend] Consumer %s could not be replayed in cloned quota manager backend, err=%v.",
+				consumerID, err)
+		}
+	}
+	backend.SetMode(qmbackend.Normal)
+
+	return backend, nil
+}
+
+func (qm *QuotaManager) getAppWrappers(preemptIds []string) []*arbv1.AppWrapper {
+	var aws []*arbv1.AppWrapper
+	if len(preemptIds) <= 0 {
+		return nil
+	}
+
+	for _, preemptId := range preemptIds {
+		awNamespace, awName := util.ParseId(preemptId)
+		if len(awNamespace) <= 0 || len(awName) <= 0 {
+			klog.Errorf("[getAppWrappers] Failed to parse AppWrapper id from quota manager, parse string: %s.  Preemption of this Id will be ignored.", preemptId)
+			continue
+		}
+		aw, e := qm.appwrapperLister.AppWrappers(awNamespace).Get(awName)
+		if e != nil {
+			klog.Errorf("[getAppWrappers] Failed to get AppWrapper from API Cache %s/%s, err=%v.  Preemption of this Id will be ignored.",
+				awNamespace, awName, e)
+			continue
+		}
+		aws = append(aws, aw)
+	}
+
+	// Final validation check
+	if len(preemptIds) != len(aws) {
+		klog.Warningf("[getAppWrappers] Preemption list size of %d from quota manager does not match size of generated list of AppWrapper: %d", len(preemptIds), len(aws))
+	}
+	quota.SortPreemptionTargets(aws, qm.preemptionOrder, qm.getPriority, qm.isReclaimable)
+	return aws
+}
+
+// UpdateConsumer changes the demand of the allocated consumer of an AppWrapper, e.g. when the AppWrapper
+// is scaled in place, without releasing its quota in between.  The new demand must fit without preempting
+// other consumers, otherwise the original allocation is restored unchanged and the result does not fit.
+func (qm *QuotaManager) UpdateConsumer(aw *arbv1.AppWrapper, newDemands *clusterstateapi.Resource) (*quota.FitResult, error) {
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	result := &quota.FitResult{
+		Fits: false,
+	}
+
+	if qm.quotaManagerBackend == nil {
+		result.Reason = quota.NoBackend
+		result.Message = "No quota manager backend exists"
+		return result, errors.New(result.Message)
+	}
+	if aw == nil {
+		err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+		result.Reason = quota.InvalidRequest
+		result.Message = err.Error()
+		return result, err
+	}
+
+	consumerID := util.CreateId(aw.Namespace, aw.Name)
+	existingSpec, found := qm.consumerSpecs[consumerID]
+	if !found || !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+		err := fmt.Errorf("no allocated consumer exists for AppWrapper %s/%s", aw.Namespace, aw.Name)
+		result.Reason = quota.InvalidRequest
+		result.Message = err.Error()
+		return result, err
+	}
+
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, newDemands)
+	if err != nil {
+		klog.Errorf("[UpdateConsumer] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+		result.Reason = quota.InvalidRequest
+		result.Message = err.Error()
+		return result, err
+	}
+
+	// Swap the consumer, nothing else is allocated in between
+	wasBursting := qm.burstingConsumers[consumerID]
+	qm.removeConsumer(consumerID)
+	allocResponse, allocatedSpec, err := qm.allocateConsumer(context.Background(), qm.quotaManagerBackend, consumerSpec)
+	var exceededNodes []string
+	if err == nil && allocResponse.IsAllocated() && len(allocResponse.GetPreemptedIds()) == 0 {
+		qm.setConsumerSpec(consumerID, allocatedSpec)
+		var bursting bool
+		exceededNodes, bursting = qm.checkBurstLimits(allocatedSpec)
+		qm.setBursting(consumerID, bursting)
+	}
+	if err == nil && allocResponse.IsAllocated() && len(allocResponse.GetPreemptedIds()) == 0 && len(exceededNodes) == 0 {
+		qm.invalidateFitsCache()
+		delete(qm.fitsCache, consumerID)
+		qm.updateQuotaMetrics()
+		klog.V(4).Infof("[UpdateConsumer] Demand of consumer %s/%s updated.", aw.Namespace, aw.Name)
+		result.Fits = true
+		result.Reason = quota.Allocated
+		result.Message = allocResponse.GetMessage()
+		return result, nil
+	}
+
+	// Restore the original allocation and the consumers preempted by the new demand
+	result.Reason = quota.QuotaExceeded
+	var preemptedIDs []string
+	if err != nil {
+		result.Message = err.Error()
+	} else if !allocResponse.IsAllocated() {
+		result.Message = allocResponse.GetMessage()
+	} else if len(exceededNodes) > 0 {
+		result.Message = fmt.Sprintf("burst limits of quota nodes %v exceeded", exceededNodes)
+	} else {
+		preemptedIDs = allocResponse.GetPreemptedIds()
+		result.Message = fmt.Sprintf("updated demand requires preempting %d consumers", len(preemptedIDs))
+	}
+	qm.rollbackPreemption(consumerID, preemptedIDs)
+	restoreErr := qm.reallocateConsumer(existingSpec)
+	qm.setBursting(consumerID, wasBursting)
+	if restoreErr != nil {
+		klog.Errorf("[UpdateConsumer] Failure restoring consumer %s/%s, quota manager is in inconsistent state, err=%v.",
+			aw.Namespace, aw.Name, restoreErr)
+		if err == nil {
+			err = restoreErr
 		} else {
-			err = fmt.Errorf("Unknown error verifying quota designations.")
+			err = fmt.Errorf("%w; next error %s", err, restoreErr.Error())
 		}
-		klog.V(6).Infof("[getQuotaDesignation] No valid quota management IDs found for AppWrapper Job: %s/%s, err=%#v",
-			aw.Namespace, aw.Name, err)
-		return groups, treeNameToResourceTypes, err
 	}
+	klog.V(4).Infof("[UpdateConsumer] Demand of consumer %s/%s not updated: %s", aw.Namespace, aw.Name, result.Message)
+	return result, err
+}
 
-	if len(groups) > 0 {
-		klog.V(6).Infof("[getQuotaDesignation] AppWrapper: %s/%s quota labels: %v.", aw.Namespace,
-			aw.Name, groups)
-	} else {
-		klog.Warningf("[getQuotaDesignation] AppWrapper: %s/%s does not have any quota labels.",
-			aw.Namespace, aw.Name)
+// reallocateConsumer registers and allocates a consumer spec in maintenance mode, so the allocation does
+// not preempt other consumers, e.g. to restore a consumer previously allocated.
+func (qm *QuotaManager) reallocateConsumer(consumerSpec *qmbackendutils.JConsumerSpec) error {
+	mode := qm.quotaManagerBackend.GetMode()
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	defer qm.quotaManagerBackend.SetMode(mode)
+
+	consumerInfo, err := qmbackend.NewConsumerInfo(qmbackendutils.JConsumer{
+		Kind: qmbackendutils.DefaultConsumerKind,
+		Spec: *consumerSpec,
+	})
+	if err != nil {
+		return err
+	}
+	qm.quotaManagerBackend.AddConsumer(consumerInfo)
+	qm.setConsumerSpec(consumerSpec.ID, consumerSpec)
+
+	allocResponse, err := qm.quotaManagerBackend.AllocateForest(qm.getConsumerForest(consumerSpec.ID), consumerSpec.ID)
+	if err != nil {
+		return err
+	}
+	if !allocResponse.IsAllocated() {
+		return fmt.Errorf("not allocated: %s", allocResponse.GetMessage())
 	}
+	return nil
+}
 
-	return groups, treeNameToResourceTypes, nil
+// Release releases the quota of an AppWrapper and returns whether quota was released, see
+// ReleaseWithResult.
+func (qm *QuotaManager) Release(aw *arbv1.AppWrapper) bool {
+	return qm.ReleaseWithResult(aw) == quota.Released
 }
 
-func (qm *QuotaManager) convertInt64Demand (int64Demand int64) (int, error) {
-	var err error
-	err = nil
-	if int64Demand > int64(MaxInt) {
-		err = fmt.Errorf("demand %d is larger than Max Quota Management Backend size, resetting demand to %d",
-			int64Demand, MaxInt)
-		return MaxInt, err
-	} else {
-		return int(int64Demand), err
+// ReleaseWithResult releases the quota of an AppWrapper and returns whether quota was released, no quota
+// was allocated to the AppWrapper, or the release failed.  Releasing an AppWrapper again is a no-op.
+func (qm *QuotaManager) ReleaseWithResult(aw *arbv1.AppWrapper) quota.ReleaseResult {
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	return qm.releaseAppWrapper(aw)
+}
+
+// releaseAppWrapper releases the quota of an AppWrapper as ReleaseWithResult, with mutex held.
+func (qm *QuotaManager) releaseAppWrapper(aw *arbv1.AppWrapper) quota.ReleaseResult {
+
+	// Handle uninitialized quota manager
+	if qm.quotaManagerBackend == nil {
+		klog.Errorf("[Release] No quota manager backend exists, Quota release %s/%s fails quota by default.",
+			aw.Name, aw.Namespace)
+		return quota.ReleaseError
+	}
+
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	if len(awId) <= 0 {
+		klog.Errorf("[Release] Request failed due to invalid AppWrapper due to empty namespace: %s or name:%s.", aw.Namespace, aw.Name)
+		return quota.ReleaseError
 	}
+
+	return qm.release(awId)
 }
 
-func (qm *QuotaManager) convertFloat64Demand (floatDemand float64) (int, error) {
-	var err error
-	err = nil
-	if floatDemand > float64(MaxInt) {
-		err = fmt.Errorf("demand %f is larger than Max Quota Management Backend size, resetting demand to %d",
-			floatDemand, MaxInt)
-		return MaxInt, err
-	} else {
-		return int(math.Trunc(floatDemand)), err
+// removeConsumer deallocates and removes a registered consumer from the quota manager backend.
+// setConsumerSpec registers the consumer spec of a consumer allocated, or evaluated, in the quota manager
+// backend, recording its registration order.
+func (qm *QuotaManager) setConsumerSpec(consumerID string, consumerSpec *qmbackendutils.JConsumerSpec) {
+	if qm.consumerOrder == nil {
+		qm.consumerOrder = make(map[string]uint64)
 	}
+	qm.consumerSeq++
+	qm.consumerOrder[consumerID] = qm.consumerSeq
+	qm.consumerSpecs[consumerID] = consumerSpec
 }
 
-func (qm *QuotaManager) getQuotaTreeResourceTypesDemands(awResDemands *clusterstateapi.Resource, treeToResourceTypes []string)  (map[string]int, error) {
-	demands := map[string]int{}
-	var err error
-	err = nil
-	var processedResourceTypes []string
+// deleteConsumerSpec unregisters the consumer spec of a consumer.
+func (qm *QuotaManager) deleteConsumerSpec(consumerID string) {
+	delete(qm.consumerSpecs, consumerID)
+	delete(qm.consumerOrder, consumerID)
+}
 
-	for _, treeResourceType := range treeToResourceTypes {
+func (qm *QuotaManager) removeConsumer(consumerID string) {
+	if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+		qm.quotaManagerBackend.DeAllocateForest(qm.getConsumerForest(consumerID), consumerID)
+	}
+	if _, err := qm.quotaManagerBackend.RemoveConsumer(consumerID); err != nil {
+		klog.Errorf("[removeConsumer] Error removing Quota request definition id: %s, err=%#v.", consumerID, err)
+	}
+	qm.deleteConsumerSpec(consumerID)
+	delete(qm.burstingConsumers, consumerID)
+	delete(qm.borrowingConsumers, consumerID)
+}
 
-		// CPU Demands
-		if strings.Contains(strings.ToLower(treeResourceType), "cpu") {
-			// Handle type conversions
-			demand, converErr := qm.convertFloat64Demand(awResDemands.MilliCPU)
-			if converErr != nil {
-				if err == nil {
-					err = fmt.Errorf("resource type: %s %s",
-						treeResourceType, converErr.Error())
-				} else {
-					err = fmt.Errorf("%w; next error resource type: %s %s",
-						err, treeResourceType, converErr.Error())
-				}
-			}
-			demands[treeResourceType] = demand
-			processedResourceTypes = append(processedResourceTypes, treeResourceType)
-		}
-
-		// Memory Demands
-		if strings.Contains(strings.ToLower(treeResourceType), "memory") {
-			// Handle type conversions
-			demand, converErr := qm.convertFloat64Demand(awResDemands.Memory/1000000)
-			if converErr != nil {
-				if err == nil {
-					err = fmt.Errorf("resource type: %s %s",
-						treeResourceType, converErr.Error())
-				} else {
-					err = fmt.Errorf("%w; next error resource type: %s %s",
-						err, treeResourceType, converErr.Error())
-				}
-			}
-			demands[treeResourceType] = demand
-			processedResourceTypes = append(processedResourceTypes, treeResourceType)
+// ReleaseByID releases the quota of the consumer with the given ID, as produced by util.CreateId, e.g. to
+// clean up consumers of AppWrappers deleted while the controller was down.  The registered observers
+// are notified of the release.  Returns whether quota was released, see ReleaseByIDWithResult.
+func (qm *QuotaManager) ReleaseByID(awId string) bool {
+	return qm.ReleaseByIDWithResult(awId) == quota.Released
+}
 
-		}
+// ReleaseByIDWithResult releases the quota of the consumer with the given ID as ReleaseByID, and returns
+// whether quota was released, no quota was allocated to the consumer, e.g. when a leaked consumer was
+// already released, or the release failed.
+func (qm *QuotaManager) ReleaseByIDWithResult(awId string) quota.ReleaseResult {
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
-		// GPU Demands
-		if strings.Contains(strings.ToLower(treeResourceType), "gpu") {
-			// Handle type conversions
-			demand, converErr := qm.convertInt64Demand(awResDemands.GPU)
+	return qm.release(awId)
+}
 
-			if converErr != nil {
-				if err == nil {
-					err = fmt.Errorf("resource type: %s %s",
-						treeResourceType, converErr.Error())
-				} else {
-					err = fmt.Errorf("%w; next error resource type: %s %s",
-						err, treeResourceType, converErr.Error())
-				}
-			}
-			demands[treeResourceType] = demand
-			processedResourceTypes = append(processedResourceTypes, treeResourceType)
-		}
+// release releases the quota of a consumer as ReleaseByIDWithResult, with mutex held.
+func (qm *QuotaManager) release(awId string) quota.ReleaseResult {
+	result := qm.releaseByID(awId)
+	if result == quota.Released {
+		qm.updateQuotaFloors()
 	}
+	qm.invalidateFitsCache()
+	delete(qm.fitsCache, awId)
+	qm.observers.NotifyRelease(awId, result == quota.Released)
+	return result
+}
 
-	if len(processedResourceTypes) < len(treeToResourceTypes) {
-		if err == nil {
-			err = fmt.Errorf("resource type processed %v less than expected %v",
-				processedResourceTypes, treeToResourceTypes)
-		} else {
-			err = fmt.Errorf("%w; next error resource type processed %v less than expected %v",
-				err, processedResourceTypes, treeToResourceTypes)
+// releaseDeletedAppWrapper is the AppWrapper delete event handler releasing the consumer of the deleted
+// AppWrapper.  Consumers already released, e.g. by the controller cleanup, are ignored.
+func (qm *QuotaManager) releaseDeletedAppWrapper(obj interface{}) {
+	aw, ok := obj.(*arbv1.AppWrapper)
+	if !ok {
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if !isTombstone {
+			klog.Errorf("[releaseDeletedAppWrapper] obj is not AppWrapper. obj=%+v", obj)
+			return
+		}
+		aw, ok = tombstone.Obj.(*arbv1.AppWrapper)
+		if !ok {
+			klog.Errorf("[releaseDeletedAppWrapper] Tombstone obj is not AppWrapper. obj=%+v", tombstone.Obj)
+			return
 		}
 	}
 
-	klog.V(10).Infof("[getQuotaTreeResourceTypesDemands] Quota resource demands: %#v.", demands)
-	return demands, err
-}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
-func (qm *QuotaManager) buildRequest(aw *arbv1.AppWrapper,
-			awResDemands *clusterstateapi.Resource) (*qmbackend.ConsumerInfo, error) {
 	awId := util.CreateId(aw.Namespace, aw.Name)
+	if _, found := qm.consumerSpecs[awId]; !found {
+		klog.V(8).Infof("[releaseDeletedAppWrapper] No consumer of deleted AppWrapper %s/%s to release.",
+			aw.Namespace, aw.Name)
+		return
+	}
+	klog.V(4).Infof("[releaseDeletedAppWrapper] Releasing consumer of deleted AppWrapper %s/%s.", aw.Namespace, aw.Name)
+	qm.release(awId)
+}
+
+func (qm *QuotaManager) releaseByID(awId string) quota.ReleaseResult {
+
+	// Handle uninitialized quota manager
+	if qm.quotaManagerBackend == nil {
+		klog.Errorf("[ReleaseByID] No quota manager backend exists, Quota release %s fails quota by default.",
+			awId)
+		return quota.ReleaseError
+	}
+
 	if len(awId) <= 0 {
-		err := fmt.Errorf("[buildRequest] Request failed due to invalid AppWrapper due to empty namespace: %s or name:%s.", aw.Namespace, aw.Name)
-		return nil, err
+		klog.Errorf("[ReleaseByID] Request failed due to empty consumer id.")
+		return quota.ReleaseError
 	}
 
-	var consumerTrees []qmbackendutils.JConsumerTreeSpec
+	_, registered := qm.consumerSpecs[awId]
+	allocated := qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId)
+	released := allocated && qm.quotaManagerBackend.DeAllocateForest(qm.getConsumerForest(awId), awId)
 
-	// Get quota tree designations and associated resource demands from AW labels
-	quotaTreeDesignations, treeNameToResourceTypes, err := qm.getQuotaDesignation(aw)
+	if released {
+		klog.V(8).Infof("[ReleaseByID] Quota release for %s successful.", awId)
+	} else if allocated {
+		klog.Errorf("[ReleaseByID] Quota release for %s failed.", awId)
+	} else {
+		klog.V(4).Infof("[ReleaseByID] No quota allocated to %s to release.", awId)
+	}
 
+	// Remove Consumer Request
+	success, err := qm.quotaManagerBackend.RemoveConsumer(awId)
 	if err != nil {
-		return nil, err
+		klog.Errorf("[ReleaseByID] Error removing Quota request definition id: %s, err=%#v.",
+			awId, err)
 	}
 
-	for _, quotaTreeDesignation := range quotaTreeDesignations {
-		unPreemptable := !qm.preemptionEnabled
+	qm.missingDesignationMutex.Lock()
+	delete(qm.missingDesignationGenerations, awId)
+	qm.missingDesignationMutex.Unlock()
 
-		quotaTreeName := quotaTreeDesignation.GroupContext
-		demands, err := qm.getQuotaTreeResourceTypesDemands(awResDemands, treeNameToResourceTypes[quotaTreeName])
-		if err != nil {
-			klog.Errorf("[buildRequest] Failure building quota resource demands for AppWrapper %s/%s, err=%#v",
-				aw.Namespace, aw.Name, err)
-		}
+	if success {
+		qm.deleteConsumerSpec(awId)
+		delete(qm.burstingConsumers, awId)
+		delete(qm.borrowingConsumers, awId)
+		delete(qm.unpreemptableConsumers, awId)
+		delete(qm.reservations, awId)
+		qm.updateQuotaMetrics()
+		klog.V(8).Infof("[ReleaseByID] Quota request definition for %s successful.", awId)
+
+	} else if registered {
+		klog.Warningf("[ReleaseByID] Removing Quota request definition for %s unsuccessful.", awId)
+	}
 
-		priority := int(aw.Spec.Priority)
+	switch {
+	case released:
+		return quota.Released
+	case allocated || (registered && err != nil):
+		return quota.ReleaseError
+	}
+	return quota.NotFound
+}
 
-		consumerTreeSpec := &qmbackendutils.JConsumerTreeSpec {
-			ID:            awId,
-			TreeName:      quotaTreeDesignation.GroupContext,
-			GroupID:       quotaTreeDesignation.GroupId,
-			Request:       demands,
-			Priority:      priority,
-			CType:         0,
-			UnPreemptable: unPreemptable,
+// Drain releases the quota of all the consumers of the forest, e.g. for a controlled maintenance.  Unlike
+// the maintenance mode of the backend, which only refuses new requests, the forest is left without any
+// allocation.  Consumers that could not be released are returned as an aggregated error.
+func (qm *QuotaManager) Drain() error {
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	consumerIDs, err := qm.listConsumers()
+	if err != nil {
+		return err
+	}
+
+	for _, consumerID := range consumerIDs {
+		qm.release(consumerID)
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			klog.Errorf("[Drain] Failure releasing quota of consumer %s.", consumerID)
+			if err == nil {
+				err = fmt.Errorf("consumer: %s not released", consumerID)
+			} else {
+				err = fmt.Errorf("%w; next error consumer: %s not released", err, consumerID)
+			}
 		}
+	}
 
-		consumerTrees = append(consumerTrees, *consumerTreeSpec)
+	// Forget the consumers not registered in the backend
+	for consumerID := range qm.consumerSpecs {
+		if !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			qm.deleteConsumerSpec(consumerID)
+		}
+	}
+	qm.updateQuotaMetrics()
+
+	klog.V(4).Infof("[Drain] Quota of %d consumers released.", len(consumerIDs))
+	return err
+}
+
+// GetAllocation returns the quota allocated to an AppWrapper as a map of tree name to
+// resource type to allocated amount.
+func (qm *QuotaManager) GetAllocation(aw *arbv1.AppWrapper) (map[string]map[string]int, error) {
+	// Handle uninitialized quota manager
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
 
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance {
+		return nil, fmt.Errorf("quota manager backend in maintenance mode")
 	}
 
-	// Add quota demands per tree to quota allocation request
-	consumerSpec := &qmbackendutils.JConsumerSpec  {
-		ID:	awId,
-		Trees:	consumerTrees,
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	if len(awId) <= 0 {
+		return nil, fmt.Errorf("invalid AppWrapper due to empty namespace: %s or name: %s", aw.Namespace, aw.Name)
 	}
 
-	// JConsumer : JSON consumer
-	consumer := &qmbackendutils.JConsumer  {
-		Kind: qmbackendutils.DefaultConsumerKind,
-		Spec: *consumerSpec,
+	consumerSpec, found := qm.consumerSpecs[awId]
+	if !found || !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+		return nil, fmt.Errorf("quota consumer %s for AppWrapper %s/%s is unknown", awId, aw.Namespace, aw.Name)
 	}
 
-	consumerInfo, err := qmbackend.NewConsumerInfo(*consumer)
+	allocation := make(map[string]map[string]int)
+	for _, consumerTree := range consumerSpec.Trees {
+		treeAllocation := make(map[string]int)
+		for resourceType, amount := range consumerTree.Request {
+			treeAllocation[resourceType] = amount
+		}
+		allocation[consumerTree.TreeName] = treeAllocation
+	}
 
-	return consumerInfo, err
+	klog.V(8).Infof("[GetAllocation] Quota allocation for %s/%s: %v.", aw.Namespace, aw.Name, allocation)
+	return allocation, nil
 }
 
-func (qm *QuotaManager) refreshQuotaDefiniions() error {
-	// Initialize Forest/Trees if new resource plan manager added to the cache
-	err := qm.updateForestFromCache()
+// Preempt releases the quota of each target AppWrapper and returns the targets successfully released.
+// Targets holding no quota, e.g. already released or registered but not allocated, are treated as released
+// so calling Preempt again for the same targets is harmless.  Release failures do not stop the remaining targets from being released and are returned
+// aggregated.
+func (qm *QuotaManager) Preempt(targets []*arbv1.AppWrapper) ([]*arbv1.AppWrapper, error) {
+	var err error
+	err = nil
+	released := []*arbv1.AppWrapper{}
 
-	return err
-}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
-func (qm *QuotaManager) Fits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
-					proposedPreemptions []*arbv1.AppWrapper) (bool, []*arbv1.AppWrapper, string) {
+	for _, target := range targets {
+		if target == nil {
+			continue
+		}
 
-	doesFit := false
+		// Targets holding no quota, whether registered or not, have nothing to release
+		if qm.quotaManagerBackend != nil {
+			awId := util.CreateId(target.Namespace, target.Name)
+			if len(awId) > 0 && !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+				klog.V(4).Infof("[Preempt] No quota allocated to %s/%s, already released.", target.Namespace, target.Name)
+				released = append(released, target)
+				continue
+			}
+		}
 
-	// If a Quota Manager Backend instance does not exists then assume quota failed
+		if qm.releaseAppWrapper(target) == quota.Released {
+			released = append(released, target)
+		} else if err == nil {
+			err = fmt.Errorf("quota release failed for AppWrapper %s/%s", target.Namespace, target.Name)
+		} else {
+			err = fmt.Errorf("%w; next error quota release failed for AppWrapper %s/%s",
+				err, target.Namespace, target.Name)
+		}
+	}
+
+	return released, err
+}
+
+// Healthy returns false and the reason when the quota manager backend does not exist, is in maintenance
+// mode or the last refresh of the quota trees failed.  No request is sent to the backend.
+func (qm *QuotaManager) Healthy() (bool, string) {
 	if qm.quotaManagerBackend == nil {
-		klog.V(4).Infof("[Fits] No quota manager backend exists, %#v fails quota by default.",
-													awResDemands)
-		return doesFit, nil, "No quota manager backend exists"
+		return false, "no quota manager backend exists"
 	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
 
-	// If Quota Manager initialization is complete but quota manager backend is in maintenance mode assume quota
-	// Processing quota requests is allow during initialization and backend is in maitenace mode for recovery purposes
-	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance && qm.initializationDone {
-		klog.Warningf("[Fits] Quota Manager backend in maintenance mode.  Unable to process request for AppWrapper: %s/%s",
-			aw.Namespace, aw.Name)
-		return doesFit, nil, "Quota Manager backend in maintenance mode"
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance {
+		return false, "quota manager backend in maintenance mode"
 	}
+	if qm.lastRefreshErr != nil {
+		return false, fmt.Sprintf("last quota tree refresh failed: %v", qm.lastRefreshErr)
+	}
+	return true, ""
+}
 
-	// Refresh Quota Manager Backend Cache and Tree(s) if detected change in ResourcePlans
-	if qm.resourcePlanManager.IsResplanChanged() {
-		// Load ResourcePlan Cache into Quoto Management Backend Cache
-		qm.resourcePlanManager.LoadResourcePlansIntoBackend()
-		// Realize new Quoto Management tree(s) from Backend Cache
-		err := qm.updateForestFromCache()
-		if err != nil {
-			klog.Errorf("[Fits] Failure during refresh of quota tree(s), err=%#v.", err)
-		}
+// EnterMaintenance puts the quota manager backend in maintenance mode, e.g. for an operator to repair the
+// backend, failing quota evaluations until ExitMaintenance is called.  When quiesce is set, quota
+// evaluations in flight finish before maintenance mode is entered while new ones wait and then fail.
+func (qm *QuotaManager) EnterMaintenance(quiesce bool) error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
 	}
 
-	// Create a consumer
-	consumerInfo, err := qm.buildRequest(aw, awResDemands)
-	if err != nil {
-		klog.Errorf("[Fits] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
-		return doesFit, nil, err.Error()
+	if quiesce {
+		qm.maintenanceMutex.Lock()
+		defer qm.maintenanceMutex.Unlock()
 	}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
-	var preemptIds []*arbv1.AppWrapper
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	klog.Infof("[EnterMaintenance] Quota manager backend entered maintenance mode.")
+	return nil
+}
 
-	qm.quotaManagerBackend.AddConsumer(consumerInfo)
+// ExitMaintenance returns the quota manager backend to normal mode and refreshes the forest from the
+// ResourcePlans, applying the changes made during maintenance.  The error of the refresh is returned.
+func (qm *QuotaManager) ExitMaintenance() error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
+	}
 
-	consumerID := consumerInfo.GetID()
-	klog.V(4).Infof("[Fits] Sending quota allocation request: %#v ", consumerInfo)
-	allocResponse, err := qm.quotaManagerBackend.AllocateForest(QuotaManagerForestName, consumerID)
+	qm.maintenanceMutex.Lock()
+	defer qm.maintenanceMutex.Unlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
+	qm.quotaManagerBackend.SetMode(qmbackend.Normal)
+	klog.Infof("[ExitMaintenance] Quota manager backend left maintenance mode.")
+	err := qm.refreshQuotaDefiniions()
 	if err != nil {
-		if allocResponse != nil && len(allocResponse.GetMessage()) > 0 {
-			klog.Errorf("[Fits] Error allocating consumer: %s/%s, msg=%s, err=%#v.",
-				aw.Namespace, aw.Name, allocResponse.GetMessage(), err)
-			return 	doesFit, nil, allocResponse.GetMessage()
-		} else {
-			klog.Errorf("[Fits] Error allocating consumer: %s/%s, err=%#v.",
-				aw.Namespace, aw.Name, err)
-			return 	doesFit, nil, err.Error()
+		klog.Errorf("[ExitMaintenance] Failure during refresh of quota tree(s), err=%v.", err)
+	}
+	return err
+}
 
-		}
+// Mode returns the mode of the quota manager backend: Normal or Maintenance, None when no backend exists.
+func (qm *QuotaManager) Mode() string {
+	if qm.quotaManagerBackend == nil {
+		return "None"
 	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
 
-	doesFit = allocResponse.IsAllocated()
-	if len(allocResponse.GetMessage()) > 0 {
-		klog.Warningf("[Fits] Response from Quota Management backend: %s",
-			allocResponse.GetMessage())
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance {
+		return "Maintenance"
 	}
-	preemptIds = qm.getAppWrappers(allocResponse.GetPreemptedIds())
+	return "Normal"
+}
 
-	return doesFit, preemptIds, allocResponse.GetMessage()
+// RegisterObserver registers an observer notified of the quota allocations and releases.
+func (qm *QuotaManager) RegisterObserver(observer quota.QuotaEventObserver) {
+	qm.observers.Register(observer)
 }
 
+// ListConsumers returns the sorted IDs of the consumers registered with the quota manager backend.
+func (qm *QuotaManager) ListConsumers() ([]string, error) {
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
 
-func  (qm *QuotaManager) getAppWrappers(preemptIds []string) []*arbv1.AppWrapper{
-	var aws []*arbv1.AppWrapper
-	if len(preemptIds) <= 0 {
-		return nil
+	return qm.listConsumers()
+}
+
+// listConsumers returns the consumer IDs as ListConsumers, with mutex held.
+func (qm *QuotaManager) listConsumers() ([]string, error) {
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
 	}
 
-	for _, preemptId := range preemptIds {
-		awNamespace, awName := util.ParseId(preemptId)
-		if len(awNamespace) <= 0 || len(awName) <= 0 {
-			klog.Errorf("[getAppWrappers] Failed to parse AppWrapper id from quota manager, parse string: %s.  Preemption of this Id will be ignored.", preemptId)
-			continue
-		}
-		aw, e := qm.appwrapperLister.AppWrappers(awNamespace).Get(awName)
-		if e != nil {
-			klog.Errorf("[getAppWrappers] Failed to get AppWrapper from API Cache %s/%s, err=%v.  Preemption of this Id will be ignored.",
-				awNamespace, awName, e)
-			continue
+	consumerIDs := append([]string{}, qm.quotaManagerBackend.GetAllConsumerIDs()...)
+	sort.Strings(consumerIDs)
+	return consumerIDs, nil
+}
+
+// FlushConsumer removes the definition of a consumer from the quota manager backend without releasing
+// it, e.g. for the consistency checker to clean up orphaned consumer definitions holding no allocation
+// after a partial failure.  Returns whether the consumer definition existed, flushing a consumer that does
+// not exist is not an error.  Consumers holding an allocation must be released with ReleaseByID.
+func (qm *QuotaManager) FlushConsumer(awId string) (bool, error) {
+	if qm.quotaManagerBackend == nil {
+		return false, fmt.Errorf("no quota manager backend exists")
+	}
+	if len(awId) <= 0 {
+		return false, fmt.Errorf("empty consumer id")
+	}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+		return false, fmt.Errorf("consumer %s holds an allocation and must be released", awId)
+	}
+
+	existed := false
+	for _, consumerID := range qm.quotaManagerBackend.GetAllConsumerIDs() {
+		if consumerID == awId {
+			existed = true
+			break
 		}
-		aws = append(aws, aw)
+	}
+	qm.deleteConsumerSpec(awId)
+	delete(qm.burstingConsumers, awId)
+	delete(qm.borrowingConsumers, awId)
+	delete(qm.unpreemptableConsumers, awId)
+	delete(qm.reservations, awId)
+	delete(qm.fitsCache, awId)
+	if !existed {
+		klog.V(8).Infof("[FlushConsumer] No consumer definition %s to flush.", awId)
+		return false, nil
 	}
 
-	// Final validation check
-	if len(preemptIds) != len(aws) {
-		klog.Warningf("[getAppWrappers] Preemption list size of %d from quota manager does not match size of generated list of AppWrapper: %d", len(preemptIds), len(aws))
+	if _, err := qm.quotaManagerBackend.RemoveConsumer(awId); err != nil {
+		klog.Errorf("[FlushConsumer] Error removing consumer definition %s, err=%#v.", awId, err)
+		return true, fmt.Errorf("failure removing consumer definition %s: %w", awId, err)
 	}
-	return aws
+	klog.V(4).Infof("[FlushConsumer] Consumer definition %s flushed.", awId)
+	return true, nil
 }
-func (qm *QuotaManager) Release(aw *arbv1.AppWrapper) bool {
 
-	released := false
-
-	// Handle uninitialized quota manager
+// SetUnpreemptable makes the consumer of a dispatched AppWrapper unpreemptable, or preemptable again, e.g.
+// while a checkpoint of the AppWrapper is in progress, without allocating it again.  The flag of the
+// registered consumer is updated for the evaluations against a clone of the backend, and allocations
+// preempting an unpreemptable consumer are rolled back, so the consumer is no longer preempted from the
+// next quota evaluation on.  The flag is kept until the consumer is released.
+//
+// A quota evaluation in flight when the flag is set may already have preempted the consumer in the
+// backend: the preemption is not undone and the consumer is a preemption target of that evaluation.
+func (qm *QuotaManager) SetUnpreemptable(awId string, unpreemptable bool) error {
 	if qm.quotaManagerBackend == nil {
-		klog.Errorf("[Release] No quota manager backend exists, Quota release %s/%s fails quota by default.",
-								aw.Name, aw.Namespace)
-		return released
+		return fmt.Errorf("no quota manager backend exists")
 	}
-
-	awId := util.CreateId(aw.Namespace, aw.Name)
 	if len(awId) <= 0 {
-		klog.Errorf("[Release] Request failed due to invalid AppWrapper due to empty namespace: %s or name:%s.", aw.Namespace, aw.Name)
-		return released
+		return fmt.Errorf("empty consumer id")
 	}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
 
-	released = qm.quotaManagerBackend.DeAllocateForest(QuotaManagerForestName, awId)
+	consumerSpec, found := qm.consumerSpecs[awId]
+	if !found {
+		return fmt.Errorf("consumer %s not found", awId)
+	}
 
-	if !released {
-		klog.Errorf("[Release] Quota release for %s/%s failed.",
-			aw.Namespace, aw.Name)
+	if unpreemptable {
+		if qm.unpreemptableConsumers == nil {
+			qm.unpreemptableConsumers = make(map[string]bool)
+		}
+		qm.unpreemptableConsumers[awId] = true
 	} else {
-		klog.V(8).Infof("[Release] Quota release for %s/%s successful.",
-			aw.Namespace, aw.Name)
+		delete(qm.unpreemptableConsumers, awId)
+	}
+	for i := range consumerSpec.Trees {
+		consumerSpec.Trees[i].UnPreemptable = unpreemptable || !qm.preemptionEnabled
 	}
+	qm.invalidateFitsCache()
+	klog.V(4).Infof("[SetUnpreemptable] Consumer %s unpreemptable: %t.", awId, unpreemptable)
+	return nil
+}
 
-	// Remove Consumer Request
-	success, err := qm.quotaManagerBackend.RemoveConsumer(awId)
-	if err != nil {
-		klog.Errorf("[Release] Error removing Quota request definition id: %s for AppWrapper %s/%s, err=%#v.",
-			awId, aw.Namespace, aw.Name, err)
+// getUnpreemptableIDs returns the consumer IDs made unpreemptable after their allocation, sorted.
+func (qm *QuotaManager) getUnpreemptableIDs(consumerIDs []string) []string {
+	var unpreemptableIDs []string
+	for _, consumerID := range consumerIDs {
+		if qm.unpreemptableConsumers[consumerID] {
+			unpreemptableIDs = append(unpreemptableIDs, consumerID)
+		}
 	}
+	sort.Strings(unpreemptableIDs)
+	return unpreemptableIDs
+}
 
-	if success {
-		klog.V(8).Infof("[Release] Quota request definition for %s/%s successful.",
-			aw.Namespace, aw.Name)
+// VerifyConsistency compares the consumers allocated in the forest with the dispatched AppWrappers and
+// reports the consumers allocated without a dispatched AppWrapper (leaks) and the dispatched AppWrappers
+// without an allocated consumer (under-counts).  The forest is not modified.
+func (qm *QuotaManager) VerifyConsistency(dispatchedAWs map[string]*arbv1.AppWrapper) (*quota.DriftReport, error) {
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
 
-	} else {
-		klog.Warningf("[Release] Removing Quota request definition for %s/%s unsuccessful.",
-			aw.Namespace, aw.Name)
+	dispatchedIDs := make(map[string]bool)
+	for _, aw := range dispatchedAWs {
+		if aw == nil {
+			continue
+		}
+		dispatchedIDs[util.CreateId(aw.Namespace, aw.Name)] = true
+	}
+
+	allocatedIDs := make(map[string]bool)
+	for _, consumerID := range qm.quotaManagerBackend.GetAllConsumerIDs() {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			allocatedIDs[consumerID] = true
+		}
 	}
 
-	return released
+	report := &quota.DriftReport{}
+	for consumerID := range allocatedIDs {
+		if !dispatchedIDs[consumerID] {
+			report.Leaked = append(report.Leaked, newDriftEntry(consumerID))
+		}
+	}
+	for consumerID := range dispatchedIDs {
+		if !allocatedIDs[consumerID] {
+			report.UnderCounted = append(report.UnderCounted, newDriftEntry(consumerID))
+		}
+	}
+	sort.Slice(report.Leaked, func(i, j int) bool { return report.Leaked[i].ID < report.Leaked[j].ID })
+	sort.Slice(report.UnderCounted, func(i, j int) bool { return report.UnderCounted[i].ID < report.UnderCounted[j].ID })
+
+	if !report.IsConsistent() {
+		klog.Warningf("[VerifyConsistency] Quota forest drift detected, leaked consumers: %d, under-counted AppWrappers: %d.",
+			len(report.Leaked), len(report.UnderCounted))
+	}
+	return report, nil
+}
+
+func newDriftEntry(consumerID string) quota.DriftEntry {
+	namespace, name := util.ParseId(consumerID)
+	return quota.DriftEntry{ID: consumerID, Namespace: namespace, Name: name}
 }
@@ -0,0 +1,159 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package resplanmgr
+
+import (
+	"reflect"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr/util"
+	"k8s.io/klog/v2"
+	rpv1 "sigs.k8s.io/scheduler-plugins/pkg/apis/resourceplan/v1"
+)
+
+// Percentage quotas
+//
+// The quota of the children of a ResourcePlan annotated with the percentage quota mode, see
+// util.URMQuotaModeAnnotation, is expressed in percent of the cluster capacity, e.g. "30" or "12.5".  The
+// absolute quota of a child is the capacity of the resource multiplied by the percentage, rounded down,
+// so the quota of children adding up to 100% never exceeds the capacity.  The quota is zero until the
+// capacity of the resource is known.
+//
+// The percentage quotas are computed again when the capacity changes, on the next refresh of the quota
+// trees.  The quota of a child is never reduced below the quota allocated in its subtree: the allocated
+// consumers are neither preempted nor left unallocated when the capacity shrinks below the usage, new
+// consumers no longer fit, and the quota drops to its percentage of the capacity as the consumers are
+// released.
+
+// isPercentageResourcePlan returns whether the quota of the children of a ResourcePlan is expressed in
+// percent of the cluster capacity.
+func isPercentageResourcePlan(rp *rpv1.ResourcePlan) bool {
+	return rp.Annotations[util.URMQuotaModeAnnotation] == util.URMQuotaModePercentage
+}
+
+// percentOf returns a percentage, given in thousandths of percent, of a capacity, rounded down.
+func percentOf(capacity int, milliPercentage int64) int {
+	// Split the capacity so the product does not overflow for large capacities
+	amount := int64(capacity)/100000*milliPercentage + int64(capacity)%100000*milliPercentage/100000
+	if amount < 0 {
+		return 0
+	}
+	return int(amount)
+}
+
+// getPercentageQuota returns the absolute quota of a resource of a child of a percentage ResourcePlan,
+// the percentage given in thousandths of percent, not below the quota allocated in the subtree of the
+// child.
+func (rpm *ResourcePlanManager) getPercentageQuota(rp *rpv1.ResourcePlan, childName string, resourceName string,
+	milliPercentage int64) int {
+	quota := percentOf(rpm.clusterCapacity[resourceName], milliPercentage)
+	if floor := rpm.quotaFloors[rp.Labels[util.URMTreeLabel]][childName][resourceName]; floor > quota {
+		klog.V(4).Infof("[getPercentageQuota] Quota %d of resource %s of node %s below its allocated quota, keeping %d.",
+			quota, resourceName, childName, floor)
+		quota = floor
+	}
+	return quota
+}
+
+// hasPercentageResourcePlans returns whether any ResourcePlan is a percentage ResourcePlan.
+func (rpm *ResourcePlanManager) hasPercentageResourcePlans() bool {
+	for _, rp := range rpm.rpMap {
+		if isPercentageResourcePlan(rp) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPercentageQuotas returns the absolute quotas of the children of the percentage ResourcePlans, keyed
+// by tree name, node name and resource name.
+func (rpm *ResourcePlanManager) getPercentageQuotas() map[string]map[string]map[string]string {
+	quotas := make(map[string]map[string]map[string]string)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 || !isPercentageResourcePlan(rp) {
+			continue
+		}
+		if quotas[rpTreeName] == nil {
+			quotas[rpTreeName] = make(map[string]map[string]string)
+		}
+		nodeSpecs, _ := rpm.createTreeNodesFromRP(rp)
+		for childKey, nodeSpec := range nodeSpecs {
+			quotas[rpTreeName][childKey] = nodeSpec.Quota
+		}
+	}
+	return quotas
+}
+
+// updatePercentageQuotas applies a change of the inputs of the percentage quotas, and marks the
+// ResourcePlans as changed when a percentage quota changed.  Returns whether a percentage quota changed.
+func (rpm *ResourcePlanManager) updatePercentageQuotas(update func()) bool {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	if !rpm.hasPercentageResourcePlans() {
+		update()
+		return false
+	}
+	quotas := rpm.getPercentageQuotas()
+	update()
+	if reflect.DeepEqual(quotas, rpm.getPercentageQuotas()) {
+		return false
+	}
+	rpm.percentageQuotasChanged = true
+	return true
+}
+
+// SetClusterCapacity sets the cluster capacity by resource name, in the units of the quota trees, and
+// returns whether a percentage quota changed.  The changed quotas are loaded on the next refresh.
+func (rpm *ResourcePlanManager) SetClusterCapacity(capacity map[string]int) bool {
+	return rpm.updatePercentageQuotas(func() {
+		rpm.clusterCapacity = capacity
+	})
+}
+
+// SetQuotaFloors sets the quota allocated in the subtree of each node, keyed by tree name, node name and
+// resource name, below which percentage quotas are not reduced, and returns whether a percentage quota
+// changed.  The changed quotas are loaded on the next refresh.
+func (rpm *ResourcePlanManager) SetQuotaFloors(floors map[string]map[string]map[string]int) bool {
+	return rpm.updatePercentageQuotas(func() {
+		rpm.quotaFloors = floors
+	})
+}
+
+// IsQuotaClamped returns whether the quota of a child of a percentage ResourcePlan is kept above its
+// percentage of the cluster capacity by the quota allocated in its subtree.
+func (rpm *ResourcePlanManager) IsQuotaClamped() bool {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	for _, rp := range rpm.rpMap {
+		if !isPercentageResourcePlan(rp) {
+			continue
+		}
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		for _, rpChild := range rp.Spec.Children {
+			for resourceName, v := range rpChild.RunPodQuotas.Requests {
+				quota := percentOf(rpm.clusterCapacity[string(resourceName)], v.MilliValue())
+				if rpm.quotaFloors[rpTreeName][rpChild.Name][string(resourceName)] > quota {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package resplanmgr
+
+import (
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr/util"
+	"k8s.io/klog/v2"
+)
+
+// Quota forests
+//
+// The tree of a ResourcePlan labeled with util.URMForestLabel is in the named forest of the label, the
+// other trees are in util.DefaultForestName.  The named forests are added to the quota manager backend
+// as their trees are loaded.  A consumer is allocated in the forest of its trees, so consumers of
+// different forests never preempt each other.  When the ResourcePlans of a tree are labeled with
+// different forests the tree is in the first forest name in lexical order.
+
+// getTreeForests returns the forest name of each quota tree, keyed by tree name.  rpMutex must be held.
+func (rpm *ResourcePlanManager) getTreeForests() map[string]string {
+	treeForests := make(map[string]string)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+
+		forestName := rp.Labels[util.URMForestLabel]
+		if len(forestName) <= 0 {
+			forestName = util.DefaultForestName
+		}
+		if treeForest, found := treeForests[rpTreeName]; found && treeForest != forestName {
+			klog.Errorf("[getTreeForests] ResourcePlan %s of tree %s labeled with forest %s, tree also labeled with forest %s.",
+				rp.Name, rpTreeName, forestName, treeForest)
+			if treeForest < forestName {
+				continue
+			}
+		}
+		treeForests[rpTreeName] = forestName
+	}
+	return treeForests
+}
+
+// GetTreeForests returns the forest name of each quota tree, keyed by tree name.
+func (rpm *ResourcePlanManager) GetTreeForests() map[string]string {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	return rpm.getTreeForests()
+}
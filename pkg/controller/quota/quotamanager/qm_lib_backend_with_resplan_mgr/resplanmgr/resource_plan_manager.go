@@ -18,8 +18,11 @@
 package resplanmgr
 
 import (
+	"fmt"
 	"github.ibm.com/ai-foundation/quota-manager/quota/core"
 	"k8s.io/klog/v2"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -37,6 +40,7 @@ import (
 
 	rpinformer "sigs.k8s.io/scheduler-plugins/pkg/client/resourceplan/informers/externalversions"
 	rpinformers "sigs.k8s.io/scheduler-plugins/pkg/client/resourceplan/informers/externalversions/resourceplan/v1"
+	"sigs.k8s.io/yaml"
 )
 
 
@@ -45,6 +49,41 @@ func NewResourcePlanManager(config *rest.Config, quotaManagerBackend *qmlib.Mana
 	return newResourcePlanManager(config, quotaManagerBackend)
 }
 
+// NewStaticResourcePlanManager returns an implementation loading the ResourcePlans once from a JSON or
+// YAML ResourcePlanList file.  No ResourcePlan informer is started and the ResourcePlans never change.
+func NewStaticResourcePlanManager(quotaTreeFile string, quotaManagerBackend *qmlib.Manager) (*ResourcePlanManager, error) {
+	f, err := os.ReadFile(quotaTreeFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the quota trees from file %s: %v", quotaTreeFile, err)
+	}
+
+	rpList := &rpv1.ResourcePlanList{}
+	err = yaml.Unmarshal(f, rpList)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal the quota trees from file %s: %v", quotaTreeFile, err)
+	}
+
+	rpm := &ResourcePlanManager{
+		quotaManagerBackend: quotaManagerBackend,
+		rpMap:               make(map[string]*rpv1.ResourcePlan),
+		static:              true,
+	}
+	for i := range rpList.Items {
+		rp := &rpList.Items[i]
+		rpm.rpMap[rp.Namespace+"/"+rp.Name] = rp
+	}
+	klog.V(4).Infof("[NewStaticResourcePlanManager] Loaded %d ResourcePlans from file %s.", len(rpList.Items), quotaTreeFile)
+
+	// Initialize Quota Trees
+	if rpm.quotaManagerBackend.GetMode() != qmlib.Maintenance {
+		klog.Warningf("[NewStaticResourcePlanManager] Forcing Quota Manager into maintenance mode.")
+		rpm.quotaManagerBackend.SetMode(qmlib.Maintenance)
+	}
+	rpm.LoadResourcePlansIntoBackend()
+	klog.V(10).Infof("[NewStaticResourcePlanManager] ResourcePlan Manager initialization complete.")
+	return rpm, nil
+}
+
 type ResourcePlanManager struct {
 	mutex sync.Mutex
 
@@ -61,6 +100,16 @@ type ResourcePlanManager struct {
 
 	rpChanged bool
 	rpSynced func() bool
+
+	// ResourcePlans loaded once from a file, without a ResourcePlan informer
+	static bool
+
+	// Cluster capacity by resource name and allocated quota of the subtree of each node, keyed by tree
+	// name, node name and resource name, the inputs of the percentage quotas
+	clusterCapacity map[string]int
+	quotaFloors     map[string]map[string]map[string]int
+	// Percentage quotas changed since the ResourcePlans were loaded into the backend
+	percentageQuotasChanged bool
 }
 
 func newResourcePlanManager(config *rest.Config, quotaManagerBackend *qmlib.Manager) (*ResourcePlanManager, error) {
@@ -126,10 +175,14 @@ func (rpm *ResourcePlanManager) setResplanChanged() {
 
 func (rpm *ResourcePlanManager) clearResplanChanged() {
 	rpm.rpChanged = false
+	rpm.percentageQuotasChanged = false
 }
 
 func (rpm *ResourcePlanManager) IsResplanChanged() bool {
-	return rpm.rpChanged
+	if rpm.static {
+		return rpm.percentageQuotasChanged
+	}
+	return rpm.rpChanged || rpm.percentageQuotasChanged
 }
 
 
@@ -153,6 +206,10 @@ func (rpm *ResourcePlanManager) createTreeNodesFromRP(rp *rpv1.ResourcePlan) (ma
 				continue
 			}
 			resourceTypes = appendIfNotPresent(resourceName, resourceTypes)
+			if isPercentageResourcePlan(rp) {
+				quota[resourceName] = strconv.Itoa(rpm.getPercentageQuota(rp, child_key, resourceName, v.MilliValue()))
+				continue
+			}
 			amount, success := v.AsInt64()
 			if !success {
 				klog.Errorf("[createTreeNodesFromRP] Failure converting ResourcePlan request demand quota to int64, ResourcePlan %s request quota: %v will be ignored.",
@@ -194,10 +251,10 @@ func (rpm *ResourcePlanManager) addResourcePlansIntoBackend(rp *rpv1.ResourcePla
 	}
 }
 
-func (rpm *ResourcePlanManager) createTreeCache(forestName string, rp *rpv1.ResourcePlan) *core.TreeCache {
+func (rpm *ResourcePlanManager) createTreeCache(quotaManagerBackend *qmlib.Manager, forestName string, rp *rpv1.ResourcePlan) *core.TreeCache {
 	// Create new tree in backend
 	rpTreeName := rp.Labels[util.URMTreeLabel]
-	_, err := rpm.quotaManagerBackend.AddTreeByName(rpTreeName)
+	_, err := quotaManagerBackend.AddTreeByName(rpTreeName)
 	if err != nil {
 		klog.Errorf("[LoadResourcePlansIntoBackend] Failure adding tree name %s to quota tree backend err=%#v. ResourcePlan %s will be ignored.",
 			rpTreeName, err, rp.Name)
@@ -205,7 +262,7 @@ func (rpm *ResourcePlanManager) createTreeCache(forestName string, rp *rpv1.Reso
 	}
 
 	// Add new tree to forest in backend
-	err = rpm.quotaManagerBackend.AddTreeToForest(forestName, rpTreeName)
+	err = quotaManagerBackend.AddTreeToForest(forestName, rpTreeName)
 
 	if err != nil {
 		klog.Errorf("[LoadResourcePlansIntoBackend] Failure adding tree name %s to forest %s in quota tree backend failed err=%#v, ResourcePlan %s will be ignored.",
@@ -214,30 +271,53 @@ func (rpm *ResourcePlanManager) createTreeCache(forestName string, rp *rpv1.Reso
 	}
 
 	// Add new tree to local cache
-	return rpm.quotaManagerBackend.GetTreeCache(rpTreeName)
+	return quotaManagerBackend.GetTreeCache(rpTreeName)
 }
 
-func (rpm *ResourcePlanManager) LoadResourcePlansIntoBackend() {
+// LoadResourcePlansIntoBackend loads the cached ResourcePlans into the quota manager backend.  The
+// ResourcePlans remain marked as changed when loading fails.
+func (rpm *ResourcePlanManager) LoadResourcePlansIntoBackend() error {
 
 	rpm.rpMutex.Lock()
 	defer rpm.rpMutex.Unlock()
 
-	// Get the list of forests names (should be only one for MCAD
-	forestNames := rpm.quotaManagerBackend.GetForestNames()
-	if len(forestNames) != 1 {
-		klog.Errorf("[LoadResourcePlansIntoBackend] ResourcePlan initialization requires only one forest to be defined in quota tree backend, found %v defined.", len(forestNames))
-		return
+	if !rpm.loadResourcePlans(rpm.quotaManagerBackend) {
+		return fmt.Errorf("failure loading ResourcePlans into quota manager backend")
 	}
+	rpm.clearResplanChanged()
+	return nil
+}
 
-	forestName := forestNames[0]
+// LoadResourcePlansInto loads the cached ResourcePlans into the given quota manager backend,
+// e.g. a scratch backend used to evaluate requests without changing the live forest.
+func (rpm *ResourcePlanManager) LoadResourcePlansInto(quotaManagerBackend *qmlib.Manager) {
+
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	rpm.loadResourcePlans(quotaManagerBackend)
+}
+
+func (rpm *ResourcePlanManager) loadResourcePlans(quotaManagerBackend *qmlib.Manager) bool {
+	// Get the list of forests names, the default forest must be defined
+	forestFound := make(map[string]bool)
+	for _, forestName := range quotaManagerBackend.GetForestNames() {
+		forestFound[forestName] = true
+	}
+	if !forestFound[util.DefaultForestName] {
+		klog.Errorf("[LoadResourcePlansIntoBackend] ResourcePlan initialization requires forest %s to be defined in quota tree backend, found %v defined.",
+			util.DefaultForestName, len(forestFound))
+		return false
+	}
+	treeForests := rpm.getTreeForests()
 
 	// Get the list of trees names in the forest
-	treeNames := rpm.quotaManagerBackend.GetTreeNames()
+	treeNames := quotaManagerBackend.GetTreeNames()
 
 	// Function cache map of Tree Name to Tree Cache
 	treeNameToTreeCache := make(map[string]*core.TreeCache)
 	for _, treeName := range treeNames {
-		treeNameToTreeCache[treeName] = rpm.quotaManagerBackend.GetTreeCache(treeName)
+		treeNameToTreeCache[treeName] = quotaManagerBackend.GetTreeCache(treeName)
 	}
 
 	// Process all resourceplans to the tree caches
@@ -255,8 +335,19 @@ func (rpm *ResourcePlanManager) LoadResourcePlansIntoBackend() {
 
 		// Handle new tree
 		if treeCache == nil {
+			// Add the named forest of the tree when missing
+			forestName := treeForests[rpTreeName]
+			if !forestFound[forestName] {
+				if err := quotaManagerBackend.AddForest(forestName); err != nil {
+					klog.Errorf("[LoadResourcePlansIntoBackend] Failure adding forest %s to quota tree backend err=%#v. ResourcePlan %s will be ignored.",
+						forestName, err, rp.Name)
+					continue
+				}
+				forestFound[forestName] = true
+			}
+
 			// Add new tree to function cache
-			treeNameToTreeCache[rpTreeName] = rpm.createTreeCache(forestName, rp)
+			treeNameToTreeCache[rpTreeName] = rpm.createTreeCache(quotaManagerBackend, forestName, rp)
 
 			// Validate cache exists in backend
 			treeCache = treeNameToTreeCache[rpTreeName]
@@ -276,8 +367,220 @@ func (rpm *ResourcePlanManager) LoadResourcePlansIntoBackend() {
 		klog.V(10).Infof("[LoadResourcePlansIntoBackend] Processing Quota Manager Backend tree %s completed.", treeName)
 	}
 
-	rpm.clearResplanChanged()
+	return true
+}
+
+// GetTreeNodeSpecs returns the node specs defined by the ResourcePlans of each quota tree, keyed by
+// tree name and node name.
+func (rpm *ResourcePlanManager) GetTreeNodeSpecs() map[string]map[string]*qmlibutils.JNodeSpec {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	treeNodes := make(map[string]map[string]*qmlibutils.JNodeSpec)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+		if treeNodes[rpTreeName] == nil {
+			treeNodes[rpTreeName] = make(map[string]*qmlibutils.JNodeSpec)
+		}
+		nodeSpecs, _ := rpm.createTreeNodesFromRP(rp)
+		for childKey, nodeSpec := range nodeSpecs {
+			treeNodes[rpTreeName][childKey] = nodeSpec
+		}
+	}
+
+	return treeNodes
+}
+
+// NamedNodeSpec is a tree node spec with the name of the node.
+type NamedNodeSpec struct {
+	Name string
+	Spec qmlibutils.JNodeSpec
+}
+
+// GetTreeNodeLists returns every node spec defined by the ResourcePlans of each quota tree, keyed by tree
+// name, in ResourcePlan key order.  Unlike GetTreeNodeSpecs, nodes defined more than once are all listed.
+func (rpm *ResourcePlanManager) GetTreeNodeLists() map[string][]NamedNodeSpec {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	var rpKeys []string
+	for rpKey := range rpm.rpMap {
+		rpKeys = append(rpKeys, rpKey)
+	}
+	sort.Strings(rpKeys)
+
+	// ResourcePlans are cached by UID and by namespace and name
+	listed := make(map[*rpv1.ResourcePlan]bool)
+	treeNodes := make(map[string][]NamedNodeSpec)
+	for _, rpKey := range rpKeys {
+		rp := rpm.rpMap[rpKey]
+		if listed[rp] {
+			continue
+		}
+		listed[rp] = true
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+		nodeSpecs, _ := rpm.createTreeNodesFromRP(rp)
+		for _, rpChild := range rp.Spec.Children {
+			if nodeSpec, found := nodeSpecs[rpChild.Name]; found {
+				treeNodes[rpTreeName] = append(treeNodes[rpTreeName], NamedNodeSpec{Name: rpChild.Name, Spec: *nodeSpec})
+			}
+		}
+	}
+
+	return treeNodes
+}
+
+// GetTreeQuotas returns the total quota of each quota tree by resource name, i.e. the quota of the
+// tree root node(s) defined by the ResourcePlans.
+func (rpm *ResourcePlanManager) GetTreeQuotas() map[string]map[string]int {
+	treeNodes := rpm.GetTreeNodeSpecs()
+
+	// Sum the quota of the nodes without a parent in the tree
+	treeQuotas := make(map[string]map[string]int)
+	for treeName, nodes := range treeNodes {
+		treeQuotas[treeName] = make(map[string]int)
+		for _, nodeSpec := range nodes {
+			if _, found := nodes[nodeSpec.Parent]; found {
+				continue
+			}
+			for resourceName, quota := range nodeSpec.Quota {
+				amount, err := strconv.Atoi(quota)
+				if err != nil {
+					klog.Errorf("[GetTreeQuotas] Failure converting quota %s of resource %s in tree %s, err=%#v.",
+						quota, resourceName, treeName, err)
+					continue
+				}
+				treeQuotas[treeName][resourceName] += amount
+			}
+		}
+	}
+
+	return treeQuotas
+}
+
+// GetTreeBurstLimits returns the burst limits declared by the ResourcePlan annotations for the soft quota
+// children of each quota tree, keyed by tree name, node name and resource name.  Invalid limits are
+// ignored.
+func (rpm *ResourcePlanManager) GetTreeBurstLimits() map[string]map[string]map[string]int {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	treeBurstLimits := make(map[string]map[string]map[string]int)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+
+		for _, rpChild := range rp.Spec.Children {
+			limitsString, found := rp.Annotations[util.URMBurstLimitAnnotationPrefix+rpChild.Name]
+			if !found {
+				continue
+			}
+			if rpChild.RunPodQuotas.HardLimit {
+				klog.Warningf("[GetTreeBurstLimits] Burst limits of hard quota child %s of ResourcePlan %s will be ignored.",
+					rpChild.Name, rp.Name)
+				continue
+			}
+
+			limits := make(map[string]int)
+			for _, limitString := range strings.Split(limitsString, ",") {
+				pair := strings.SplitN(strings.TrimSpace(limitString), "=", 2)
+				if len(pair) != 2 {
+					klog.Errorf("[GetTreeBurstLimits] Invalid burst limit %q of child %s of ResourcePlan %s will be ignored.",
+						limitString, rpChild.Name, rp.Name)
+					continue
+				}
+				amount, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+				if err != nil || amount < 0 {
+					klog.Errorf("[GetTreeBurstLimits] Invalid burst limit %q of child %s of ResourcePlan %s will be ignored.",
+						limitString, rpChild.Name, rp.Name)
+					continue
+				}
+				limits[strings.TrimSpace(pair[0])] = amount
+			}
+
+			if treeBurstLimits[rpTreeName] == nil {
+				treeBurstLimits[rpTreeName] = make(map[string]map[string]int)
+			}
+			treeBurstLimits[rpTreeName][rpChild.Name] = limits
+		}
+	}
+
+	return treeBurstLimits
+}
+
+// GetTreeBorrowableNodes returns the children declared borrowable by the ResourcePlan annotations of each
+// quota tree, keyed by tree name and node name.  Invalid annotation values are ignored.
+func (rpm *ResourcePlanManager) GetTreeBorrowableNodes() map[string]map[string]bool {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	treeBorrowableNodes := make(map[string]map[string]bool)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+
+		for _, rpChild := range rp.Spec.Children {
+			borrowableString, found := rp.Annotations[util.URMBorrowableAnnotationPrefix+rpChild.Name]
+			if !found {
+				continue
+			}
+			borrowable, err := strconv.ParseBool(strings.TrimSpace(borrowableString))
+			if err != nil {
+				klog.Errorf("[GetTreeBorrowableNodes] Invalid borrowable value %q of child %s of ResourcePlan %s will be ignored.",
+					borrowableString, rpChild.Name, rp.Name)
+				continue
+			}
+			if !borrowable {
+				continue
+			}
+
+			if treeBorrowableNodes[rpTreeName] == nil {
+				treeBorrowableNodes[rpTreeName] = make(map[string]bool)
+			}
+			treeBorrowableNodes[rpTreeName][rpChild.Name] = true
+		}
+	}
+
+	return treeBorrowableNodes
+}
+
+// GetTreeMemoryUnits returns the distinct memory units declared by the ResourcePlans of each quota tree.
+// Trees with ResourcePlans not declaring memory units are mapped to an empty unit.
+func (rpm *ResourcePlanManager) GetTreeMemoryUnits() map[string][]string {
+	rpm.rpMutex.Lock()
+	defer rpm.rpMutex.Unlock()
+
+	treeMemoryUnits := make(map[string][]string)
+	for _, rp := range rpm.rpMap {
+		rpTreeName := rp.Labels[util.URMTreeLabel]
+		if len(rpTreeName) <= 0 {
+			continue
+		}
+
+		memoryUnit := rp.Labels[util.URMMemoryUnitLabel]
+		found := false
+		for _, unit := range treeMemoryUnits[rpTreeName] {
+			if strings.Compare(unit, memoryUnit) == 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			treeMemoryUnits[rpTreeName] = append(treeMemoryUnits[rpTreeName], memoryUnit)
+		}
+	}
 
+	return treeMemoryUnits
 }
 
 func (rpm *ResourcePlanManager) initializeQuotaTreeBackend() {
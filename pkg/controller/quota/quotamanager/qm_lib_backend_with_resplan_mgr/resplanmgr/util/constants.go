@@ -18,4 +18,27 @@ package util
 const (
 	// PodGroupLabel is the default label of coscheduling
 	URMTreeLabel = "tree"
+
+	// URMMemoryUnitLabel declares the units of the memory quota defined in a ResourcePlan
+	URMMemoryUnitLabel = "memory-unit"
+
+	// URMBurstLimitAnnotationPrefix is followed by the name of a soft quota child of a ResourcePlan, the
+	// annotation declares the burst limits of the child, e.g. "cpu=8000,memory=64"
+	URMBurstLimitAnnotationPrefix = "burst-limit.quota.mcad.io/"
+
+	// URMBorrowableAnnotationPrefix is followed by the name of a child of a ResourcePlan, the annotation
+	// value "true" lets the siblings of the child borrow its idle quota
+	URMBorrowableAnnotationPrefix = "borrowable.quota.mcad.io/"
+
+	// URMQuotaModeAnnotation declares how the quota of the children of a ResourcePlan is expressed, the
+	// value URMQuotaModePercentage declares the quota in percent of the cluster capacity, e.g. "30"
+	URMQuotaModeAnnotation = "quota.mcad.io/quota-mode"
+	URMQuotaModePercentage = "percentage"
+
+	// URMForestLabel assigns the tree of a ResourcePlan to a named quota forest, the trees without the
+	// label are in DefaultForestName
+	URMForestLabel = "quota.mcad.io/forest"
+
+	// DefaultForestName is the quota forest of the trees not assigned to a named forest
+	DefaultForestName = "MCAD-CONTROLLER-FOREST"
 )
@@ -0,0 +1,217 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	"github.ibm.com/ai-foundation/quota-manager/quota/core"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"k8s.io/klog/v2"
+)
+
+// Batched replay
+//
+// At startup the dispatched AppWrappers are replayed into the quota manager backend in batches of
+// loadBatchSize consumers: the consumers of a batch are added, then allocated, in one backend interaction
+// each when the backend supports it.  Only the AppWrappers allocated as requested, i.e. with a single
+// alternative and without preemptions, are replayed by a batch.  The other AppWrappers, e.g. those
+// borrowing quota or preempting consumers, are removed from the backend and replayed one at a time by Fits.
+// The AppWrappers of the named forests are replayed one at a time as well.
+
+// batchBackend is implemented by quota manager backends submitting multiple consumers in one interaction.
+type batchBackend interface {
+	AddConsumers(consumerInfos []*qmbackend.ConsumerInfo) error
+	AllocateForestBatch(forestName string, consumerIDs []string) ([]*core.AllocationResponse, error)
+}
+
+// AddConsumers adds consumers to a quota manager backend, in one interaction when the backend supports
+// it, one consumer at a time otherwise.
+func AddConsumers(backend *qmbackend.Manager, consumerInfos []*qmbackend.ConsumerInfo) error {
+	if batch, ok := interface{}(backend).(batchBackend); ok {
+		return batch.AddConsumers(consumerInfos)
+	}
+
+	var err error
+	for _, consumerInfo := range consumerInfos {
+		if _, addErr := backend.AddConsumer(consumerInfo); addErr != nil {
+			if err == nil {
+				err = addErr
+			} else {
+				err = fmt.Errorf("%w; Next error %s", err, addErr.Error())
+			}
+		}
+	}
+	return err
+}
+
+// AllocateForestBatch allocates consumers in the forest of a quota manager backend, in one interaction
+// when the backend supports it, one consumer at a time otherwise.  The responses are in the order of the
+// consumer IDs, nil for the consumers whose allocation failed.
+func AllocateForestBatch(backend *qmbackend.Manager, forestName string,
+	consumerIDs []string) ([]*core.AllocationResponse, error) {
+	if batch, ok := interface{}(backend).(batchBackend); ok {
+		return batch.AllocateForestBatch(forestName, consumerIDs)
+	}
+
+	var err error
+	responses := make([]*core.AllocationResponse, len(consumerIDs))
+	for i, consumerID := range consumerIDs {
+		response, allocErr := allocateForest(backend, forestName, consumerID)
+		if allocErr != nil {
+			if err == nil {
+				err = allocErr
+			} else {
+				err = fmt.Errorf("%w; Next error %s", err, allocErr.Error())
+			}
+			continue
+		}
+		responses[i] = response
+	}
+	return responses, err
+}
+
+// loadDispatchedAWsBatch replays the dispatched AppWrappers allocated as requested in batches, and returns
+// the keys of the replayed AppWrappers.  The other AppWrappers are left to be replayed one at a time.
+func (qm *QuotaManager) loadDispatchedAWsBatch(ctx context.Context, dispatchedAWDemands map[string]*clusterstateapi.Resource,
+	dispatchedAWs map[string]*arbv1.AppWrapper) map[string]bool {
+	replayed := make(map[string]bool)
+	if qm.loadBatchSize <= 1 {
+		return replayed
+	}
+
+	var keys []string
+	for k := range dispatchedAWDemands {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for start := 0; start < len(keys); start += qm.loadBatchSize {
+		if ctx.Err() != nil {
+			break
+		}
+		end := start + qm.loadBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		for _, k := range qm.loadBatch(ctx, keys[start:end], dispatchedAWDemands, dispatchedAWs) {
+			replayed[k] = true
+		}
+	}
+
+	if len(replayed) > 0 {
+		qm.invalidateFitsCache()
+		qm.updateQuotaMetrics()
+	}
+	return replayed
+}
+
+// loadBatch replays a batch of dispatched AppWrappers and returns the keys of the replayed AppWrappers.
+func (qm *QuotaManager) loadBatch(ctx context.Context, keys []string, dispatchedAWDemands map[string]*clusterstateapi.Resource,
+	dispatchedAWs map[string]*arbv1.AppWrapper) []string {
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	var batchKeys []string
+	var consumerSpecs []*qmbackendutils.JConsumerSpec
+	var consumerInfos []*qmbackend.ConsumerInfo
+	for _, k := range keys {
+		aw := getDispatchedAppWrapper(dispatchedAWs, k)
+		if aw == nil || !qm.isQuotaSelected(aw) || quota.IsBestEffort(aw) || qm.isQuotaExempt(aw) {
+			continue
+		}
+		consumerSpec, err := qm.buildRequest(ctx, aw, dispatchedAWDemands[k])
+		if err != nil || len(getConsumerAlternatives(consumerSpec)) > 1 ||
+			qm.getSpecForest(consumerSpec) != QuotaManagerForestName {
+			continue
+		}
+		if _, found := qm.consumerSpecs[consumerSpec.ID]; found {
+			continue
+		}
+		consumerInfo, err := qmbackend.NewConsumerInfo(qmbackendutils.JConsumer{
+			Kind: qmbackendutils.DefaultConsumerKind,
+			Spec: *consumerSpec,
+		})
+		if err != nil {
+			continue
+		}
+		batchKeys = append(batchKeys, k)
+		consumerSpecs = append(consumerSpecs, consumerSpec)
+		consumerInfos = append(consumerInfos, consumerInfo)
+	}
+	if len(batchKeys) == 0 {
+		return nil
+	}
+
+	consumerIDs := make([]string, len(consumerSpecs))
+	for i, consumerSpec := range consumerSpecs {
+		consumerIDs[i] = consumerSpec.ID
+	}
+	if err := AddConsumers(qm.quotaManagerBackend, consumerInfos); err != nil {
+		klog.Warningf("[loadBatch] Failure adding %d consumers, replaying them one at a time, err=%v.", len(consumerIDs), err)
+		for _, consumerID := range consumerIDs {
+			qm.quotaManagerBackend.RemoveConsumer(consumerID)
+		}
+		return nil
+	}
+	responses, err := AllocateForestBatch(qm.quotaManagerBackend, QuotaManagerForestName, consumerIDs)
+	if err != nil {
+		klog.Warningf("[loadBatch] Failure allocating consumers, replaying them one at a time, err=%v.", err)
+	}
+
+	var replayed []string
+	for i, consumerSpec := range consumerSpecs {
+		var response *core.AllocationResponse
+		if i < len(responses) {
+			response = responses[i]
+		}
+		if response == nil || !response.IsAllocated() {
+			qm.quotaManagerBackend.RemoveConsumer(consumerSpec.ID)
+			continue
+		}
+		if preemptedIDs := response.GetPreemptedIds(); len(preemptedIDs) > 0 {
+			qm.setConsumerSpec(consumerSpec.ID, consumerSpec)
+			qm.rollbackPreemption(consumerSpec.ID, preemptedIDs)
+			continue
+		}
+
+		qm.setConsumerSpec(consumerSpec.ID, consumerSpec)
+		exceededNodes, bursting := qm.checkBurstLimits(consumerSpec)
+		if len(exceededNodes) > 0 {
+			qm.removeConsumer(consumerSpec.ID)
+			continue
+		}
+		qm.setBursting(consumerSpec.ID, bursting)
+		qm.observers.NotifyAllocate(consumerSpec.ID, &quota.FitResult{Fits: true, Reason: quota.Allocated})
+
+		replayed = append(replayed, batchKeys[i])
+		quotaLoadReplayed.Set(float64(atomic.AddInt64(&qm.loadDone, 1)))
+	}
+	klog.V(4).Infof("[loadBatch] Replayed %d of %d dispatched AppWrappers in a batch.", len(replayed), len(keys))
+
+	return replayed
+}
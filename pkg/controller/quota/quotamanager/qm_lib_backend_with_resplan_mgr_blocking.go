@@ -0,0 +1,134 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+)
+
+// Blocking resources
+//
+// When a consumer is not allocated, the quota evaluation reports the resource type of each tree that
+// blocked the allocation, from the allocation of the trees after the failed attempt.  Walking up from the
+// preferred group of the consumer, the blocking node is the first hard quota node or root whose available
+// quota, its quota minus the allocation of its subtree, is below the demand.  Soft quota nodes may borrow
+// from their parent, so they only block when no hard quota node or root does.
+
+// blockingResource is a resource type of a quota tree node whose available quota is below a demand.
+type blockingResource struct {
+	treeName     string
+	nodeName     string
+	resourceName string
+	requested    int
+	available    int
+}
+
+func (br blockingResource) String() string {
+	return fmt.Sprintf("blocked on tree %s resource %s: requested %d, available %d",
+		br.treeName, br.resourceName, br.requested, br.available)
+}
+
+// getBlockingMessage returns the blocking resources of a consumer not allocated, empty when no resource
+// type is exhausted, e.g. when the allocation failed on priorities.
+func (qm *QuotaManager) getBlockingMessage(consumerSpec *qmbackendutils.JConsumerSpec) string {
+	blockingResources := getBlockingResources(consumerSpec, qm.getTreeNodeSpecs(),
+		qm.getGroupAllocations())
+
+	var msgs []string
+	for _, blocking := range blockingResources {
+		msgs = append(msgs, blocking.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// getBlockingResources returns the blocking resource of each tree of the preferred alternative of a
+// consumer, given the group allocations by tree name, group and resource name.
+func getBlockingResources(consumerSpec *qmbackendutils.JConsumerSpec,
+	treeNodeSpecs map[string]map[string]*qmbackendutils.JNodeSpec,
+	allocated map[string]map[string]map[string]int) []blockingResource {
+	alternatives := getConsumerAlternatives(consumerSpec)
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	var blockingResources []blockingResource
+	for _, treeSpec := range alternatives[0].Trees {
+		nodeSpecs := treeNodeSpecs[treeSpec.TreeName]
+		var resourceNames []string
+		for resourceName, demand := range treeSpec.Request {
+			if demand > 0 {
+				resourceNames = append(resourceNames, resourceName)
+			}
+		}
+		sort.Strings(resourceNames)
+
+		var treeBlocking *blockingResource
+		nodeName := treeSpec.GroupID
+		// Walk up the ancestors of the group, bounded by the number of nodes in case of cycles
+		for i := 0; i < len(nodeSpecs); i++ {
+			nodeSpec, found := nodeSpecs[nodeName]
+			if !found {
+				break
+			}
+			_, hasParent := nodeSpecs[nodeSpec.Parent]
+			hard, _ := strconv.ParseBool(nodeSpec.Hard)
+			allocation := getSubtreeAllocation(nodeName, nodeSpecs, allocated[treeSpec.TreeName])
+
+			var blocking *blockingResource
+			for _, resourceName := range resourceNames {
+				nodeQuota, _ := strconv.Atoi(nodeSpec.Quota[resourceName])
+				available := nodeQuota - allocation[resourceName]
+				if available < 0 {
+					available = 0
+				}
+				if treeSpec.Request[resourceName] > available {
+					blocking = &blockingResource{
+						treeName:     treeSpec.TreeName,
+						nodeName:     nodeName,
+						resourceName: resourceName,
+						requested:    treeSpec.Request[resourceName],
+						available:    available,
+					}
+					break
+				}
+			}
+
+			if blocking != nil && (hard || !hasParent) {
+				treeBlocking = blocking
+				break
+			}
+			if blocking != nil && treeBlocking == nil {
+				treeBlocking = blocking
+			}
+			if !hasParent {
+				break
+			}
+			nodeName = nodeSpec.Parent
+		}
+
+		if treeBlocking != nil {
+			blockingResources = append(blockingResources, *treeBlocking)
+		}
+	}
+	return blockingResources
+}
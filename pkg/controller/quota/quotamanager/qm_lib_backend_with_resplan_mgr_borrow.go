@@ -0,0 +1,299 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	"github.ibm.com/ai-foundation/quota-manager/quota/core"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"k8s.io/klog/v2"
+)
+
+// Borrowing between sibling quota nodes
+//
+// A quota node is borrowable when its parent ResourcePlan has the annotation
+// borrowable.quota.mcad.io/<node name> set to "true".  When a consumer does not fit because the quota of a
+// hard quota node of its groups is exhausted, the consumer borrows the idle quota, i.e. the quota minus the
+// allocation of its subtree, of a borrowable sibling of the node under the same parent.  Siblings are
+// considered in name order and the first sibling whose idle quota covers the whole demand of the consumer
+// in the tree lends it.  The consumer is then allocated in the group of the lender, without preempting any
+// other consumer.
+//
+// Borrowed quota is reclaimed in priority: when a consumer of a lender group does not fit, the consumers
+// borrowing from the group are released one at a time, in the preemption order of the quota manager,
+// until the consumer fits.  The released borrowers become preemption targets of the consumer.  When the
+// consumer does not fit after releasing all the borrowers, the borrowers are allocated again.  Borrowers
+// are also the first preemption targets of the backend preemptions, along with bursting consumers.  As the
+// backend consumer spec has no borrowing flag, borrowers are tracked by the quota manager when they are
+// allocated.
+
+// reclaimedBorrower is a borrowing consumer released to reclaim the quota it borrowed.
+type reclaimedBorrower struct {
+	appWrapper   *arbv1.AppWrapper
+	consumerSpec *qmbackendutils.JConsumerSpec
+	lenders      []string
+}
+
+// hasIdleQuota returns true if the quota of a node minus the allocation of its subtree covers a demand.
+func hasIdleQuota(nodeName string, demand map[string]int, nodeSpecs map[string]*qmbackendutils.JNodeSpec,
+	allocated map[string]map[string]int) bool {
+	nodeSpec, found := nodeSpecs[nodeName]
+	if !found {
+		return false
+	}
+	allocation := getSubtreeAllocation(nodeName, nodeSpecs, allocated)
+	for resourceName, amount := range demand {
+		if amount <= 0 {
+			continue
+		}
+		quota, err := strconv.Atoi(nodeSpec.Quota[resourceName])
+		if err != nil || allocation[resourceName]+amount > quota {
+			return false
+		}
+	}
+	return true
+}
+
+// findLender returns the first borrowable sibling of a node, in name order, whose idle quota covers a
+// demand.  Returns an empty name when no sibling can lend.
+func findLender(nodeName string, demand map[string]int, nodeSpecs map[string]*qmbackendutils.JNodeSpec,
+	borrowable map[string]bool, allocated map[string]map[string]int) string {
+	nodeSpec, found := nodeSpecs[nodeName]
+	if !found || len(nodeSpec.Parent) <= 0 {
+		return ""
+	}
+
+	var siblings []string
+	for siblingName, siblingSpec := range nodeSpecs {
+		if siblingName != nodeName && siblingSpec.Parent == nodeSpec.Parent && borrowable[siblingName] {
+			siblings = append(siblings, siblingName)
+		}
+	}
+	sort.Strings(siblings)
+	for _, siblingName := range siblings {
+		if hasIdleQuota(siblingName, demand, nodeSpecs, allocated) {
+			return siblingName
+		}
+	}
+	return ""
+}
+
+// getBorrowSpec returns a copy of a consumer spec whose groups with exhausted hard quota are replaced by
+// borrowable siblings able to lend their idle quota, and the lenders formatted as <tree name>/<node name>.
+// Returns nil when no group needs to borrow or a group can not borrow.
+func getBorrowSpec(consumerSpec *qmbackendutils.JConsumerSpec,
+	treeNodeSpecs map[string]map[string]*qmbackendutils.JNodeSpec, treeBorrowable map[string]map[string]bool,
+	allocated map[string]map[string]map[string]int) (*qmbackendutils.JConsumerSpec, []string) {
+	borrowSpec := &qmbackendutils.JConsumerSpec{
+		ID:    consumerSpec.ID,
+		Trees: make([]qmbackendutils.JConsumerTreeSpec, len(consumerSpec.Trees)),
+	}
+	copy(borrowSpec.Trees, consumerSpec.Trees)
+
+	var lenders []string
+	for i, treeSpec := range borrowSpec.Trees {
+		nodeSpecs := treeNodeSpecs[treeSpec.TreeName]
+		nodeSpec, found := nodeSpecs[treeSpec.GroupID]
+		if !found {
+			continue
+		}
+		if hard, _ := strconv.ParseBool(nodeSpec.Hard); !hard {
+			continue
+		}
+		if hasIdleQuota(treeSpec.GroupID, treeSpec.Request, nodeSpecs, allocated[treeSpec.TreeName]) {
+			continue
+		}
+
+		lender := findLender(treeSpec.GroupID, treeSpec.Request, nodeSpecs, treeBorrowable[treeSpec.TreeName],
+			allocated[treeSpec.TreeName])
+		if len(lender) <= 0 {
+			return nil, nil
+		}
+		borrowSpec.Trees[i].GroupID = lender
+		lenders = append(lenders, treeSpec.TreeName+"/"+lender)
+	}
+	if len(lenders) <= 0 {
+		return nil, nil
+	}
+	return borrowSpec, lenders
+}
+
+// borrowIdleQuota allocates a consumer not allocated in its groups by borrowing the idle quota of the
+// borrowable siblings of its exhausted hard quota groups.  Returns the response and the consumer spec of
+// the allocation, or nil when the consumer could not borrow, in which case the consumer stays registered
+// unallocated.
+func (qm *QuotaManager) borrowIdleQuota(ctx context.Context,
+	consumerSpec *qmbackendutils.JConsumerSpec) (*core.AllocationResponse, *qmbackendutils.JConsumerSpec) {
+	if qm.resourcePlanManager == nil || consumerSpec == nil {
+		return nil, nil
+	}
+	treeBorrowable := qm.getTreeBorrowableNodes()
+	if len(treeBorrowable) <= 0 {
+		return nil, nil
+	}
+
+	borrowSpec, lenders := getBorrowSpec(consumerSpec, qm.getTreeNodeSpecs(), treeBorrowable,
+		qm.getGroupAllocations())
+	if borrowSpec == nil {
+		return nil, nil
+	}
+
+	klog.V(4).Infof("[borrowIdleQuota] Consumer %s borrowing the idle quota of %v.", consumerSpec.ID, lenders)
+	qm.removeConsumer(consumerSpec.ID)
+	allocResponse, allocatedSpec, err := qm.allocateConsumer(ctx, qm.quotaManagerBackend, borrowSpec)
+	if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+		// Canceled allocations are removed from the backend by allocateForestWithContext
+		return nil, nil
+	}
+	if err == nil && allocResponse.IsAllocated() && len(allocResponse.GetPreemptedIds()) <= 0 {
+		qm.setBorrowing(consumerSpec.ID, lenders)
+		return allocResponse, allocatedSpec
+	}
+
+	// Borrowing never preempts, register the consumer again unallocated in its own groups
+	klog.V(4).Infof("[borrowIdleQuota] Consumer %s could not borrow the idle quota of %v, err=%v.",
+		consumerSpec.ID, lenders, err)
+	if err == nil && allocResponse.IsAllocated() {
+		qm.rollbackPreemption(consumerSpec.ID, allocResponse.GetPreemptedIds())
+	} else {
+		qm.removeConsumer(consumerSpec.ID)
+	}
+	consumerInfo, err := qmbackend.NewConsumerInfo(qmbackendutils.JConsumer{
+		Kind: qmbackendutils.DefaultConsumerKind,
+		Spec: *consumerSpec,
+	})
+	if err != nil {
+		klog.Errorf("[borrowIdleQuota] Failure registering consumer %s again, err=%v.", consumerSpec.ID, err)
+		return nil, nil
+	}
+	qm.quotaManagerBackend.AddConsumer(consumerInfo)
+	return nil, nil
+}
+
+// getBorrowers returns the IDs of the consumers borrowing from the groups of a consumer, sorted.  Unpreemptable
+// borrowers are not returned, their borrowed quota is not reclaimed.
+func (qm *QuotaManager) getBorrowers(consumerSpec *qmbackendutils.JConsumerSpec) []string {
+	groups := make(map[string]bool)
+	for _, treeSpec := range consumerSpec.Trees {
+		groups[treeSpec.TreeName+"/"+treeSpec.GroupID] = true
+	}
+
+	var borrowerIDs []string
+	for borrowerID, lenders := range qm.borrowingConsumers {
+		if borrowerID == consumerSpec.ID || qm.unpreemptableConsumers[borrowerID] {
+			continue
+		}
+		for _, lender := range lenders {
+			if groups[lender] {
+				borrowerIDs = append(borrowerIDs, borrowerID)
+				break
+			}
+		}
+	}
+	sort.Strings(borrowerIDs)
+	return borrowerIDs
+}
+
+// reclaimLentQuota allocates a consumer not allocated in its groups by releasing the consumers borrowing
+// from its groups, in preemption order, until the consumer fits.  Returns the response and the consumer
+// spec of the allocation and the released borrowers, or nil when the consumer does not fit after releasing
+// all the borrowers, in which case the borrowers are allocated again.
+func (qm *QuotaManager) reclaimLentQuota(ctx context.Context, consumerSpec *qmbackendutils.JConsumerSpec) (*core.AllocationResponse,
+	*qmbackendutils.JConsumerSpec, []*reclaimedBorrower) {
+	if consumerSpec == nil {
+		return nil, nil, nil
+	}
+	borrowerIDs := qm.getBorrowers(consumerSpec)
+	if len(borrowerIDs) <= 0 {
+		return nil, nil, nil
+	}
+
+	var reclaimed []*reclaimedBorrower
+	for _, borrower := range qm.getAppWrappers(borrowerIDs) {
+		borrowerID := util.CreateId(borrower.Namespace, borrower.Name)
+		reclaimed = append(reclaimed, &reclaimedBorrower{
+			appWrapper:   borrower,
+			consumerSpec: qm.consumerSpecs[borrowerID],
+			lenders:      qm.borrowingConsumers[borrowerID],
+		})
+		klog.V(4).Infof("[reclaimLentQuota] Releasing borrower %s to reclaim the quota lent to it for consumer %s.",
+			borrowerID, consumerSpec.ID)
+		qm.removeConsumer(borrowerID)
+
+		qm.removeConsumer(consumerSpec.ID)
+		allocResponse, allocatedSpec, err := qm.allocateConsumer(ctx, qm.quotaManagerBackend, consumerSpec)
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			klog.V(4).Infof("[reclaimLentQuota] Reclaim for consumer %s canceled, allocating %d borrowers again.",
+				consumerSpec.ID, len(reclaimed))
+			qm.restoreBorrowers(reclaimed)
+			return nil, nil, nil
+		}
+		if err == nil && allocResponse.IsAllocated() {
+			return allocResponse, allocatedSpec, reclaimed
+		}
+	}
+
+	klog.V(4).Infof("[reclaimLentQuota] Consumer %s does not fit after releasing %d borrowers, allocating them again.",
+		consumerSpec.ID, len(reclaimed))
+	qm.restoreBorrowers(reclaimed)
+	return nil, nil, nil
+}
+
+// restoreBorrowers allocates again borrowers released to reclaim the quota they borrowed.
+func (qm *QuotaManager) restoreBorrowers(reclaimed []*reclaimedBorrower) {
+	for _, borrower := range reclaimed {
+		if borrower.consumerSpec == nil {
+			continue
+		}
+		if err := qm.reallocateConsumer(borrower.consumerSpec); err != nil {
+			klog.Errorf("[restoreBorrowers] Failure allocating borrower %s again, err=%v.", borrower.consumerSpec.ID, err)
+			continue
+		}
+		qm.setBorrowing(borrower.consumerSpec.ID, borrower.lenders)
+	}
+}
+
+// setBorrowing records the lenders, formatted as <tree name>/<node name>, a consumer borrows from.
+func (qm *QuotaManager) setBorrowing(consumerID string, lenders []string) {
+	if len(lenders) <= 0 {
+		delete(qm.borrowingConsumers, consumerID)
+		return
+	}
+	if qm.borrowingConsumers == nil {
+		qm.borrowingConsumers = make(map[string][]string)
+	}
+	qm.borrowingConsumers[consumerID] = lenders
+}
+
+// isBorrowing returns true if the consumer of an AppWrapper is allocated with borrowed quota.
+func (qm *QuotaManager) isBorrowing(aw *arbv1.AppWrapper) bool {
+	_, found := qm.borrowingConsumers[util.CreateId(aw.Namespace, aw.Name)]
+	return found
+}
+
+// isReclaimable returns true if the consumer of an AppWrapper is bursting or borrowing, i.e. its quota is
+// reclaimed first.
+func (qm *QuotaManager) isReclaimable(aw *arbv1.AppWrapper) bool {
+	return qm.isBursting(aw) || qm.isBorrowing(aw)
+}
@@ -0,0 +1,100 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"sort"
+	"strconv"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+)
+
+// Soft quota and bursting
+//
+// A hard quota node (Hard=true) never allocates more than its quota.  A soft quota node (Hard=false)
+// allocates above its quota, its soft limit, by borrowing the spare quota of its parent.  A soft node
+// may declare burst limits with the ResourcePlan annotation burst-limit.quota.mcad.io/<node name>, e.g.
+// "cpu=8000,memory=64", bounding the allocation of the node subtree while bursting.  Without burst limits
+// a soft node bursts up to the spare quota of its ancestors.
+//
+// Consumers allocated while a soft node of their groups is above its soft limit are bursting.  Bursting
+// consumers are the first preemption targets.  As the backend consumer spec has no burst flag, bursting
+// consumers are tracked by the quota manager when they are allocated.
+
+// checkBurstLimits evaluates the allocation of the nodes of the groups of an allocated consumer and its
+// ancestors.  Returns the nodes, formatted as <tree name>/<node name>, whose allocation exceeds their
+// burst limits, and whether a node is above its soft limit, i.e. the consumer is bursting.
+func (qm *QuotaManager) checkBurstLimits(consumerSpec *qmbackendutils.JConsumerSpec) ([]string, bool) {
+	if qm.resourcePlanManager == nil || consumerSpec == nil {
+		return nil, false
+	}
+
+	treeNodeSpecs := qm.getTreeNodeSpecs()
+	treeBurstLimits := qm.getTreeBurstLimits()
+	allocated := qm.getGroupAllocations()
+
+	exceededNodes := make(map[string]bool)
+	bursting := false
+	for _, treeSpec := range consumerSpec.Trees {
+		nodeSpecs := treeNodeSpecs[treeSpec.TreeName]
+		nodeName := treeSpec.GroupID
+		for i := 0; i <= len(nodeSpecs); i++ {
+			nodeSpec, found := nodeSpecs[nodeName]
+			if !found {
+				break
+			}
+			if hard, _ := strconv.ParseBool(nodeSpec.Hard); !hard {
+				allocation := getSubtreeAllocation(nodeName, nodeSpecs, allocated[treeSpec.TreeName])
+				for resourceName, amount := range allocation {
+					if quota, err := strconv.Atoi(nodeSpec.Quota[resourceName]); err == nil && amount > quota {
+						bursting = true
+					}
+					if limit, found := treeBurstLimits[treeSpec.TreeName][nodeName][resourceName]; found && amount > limit {
+						exceededNodes[treeSpec.TreeName+"/"+nodeName] = true
+					}
+				}
+			}
+			nodeName = nodeSpec.Parent
+		}
+	}
+	var exceeded []string
+	for exceededNode := range exceededNodes {
+		exceeded = append(exceeded, exceededNode)
+	}
+	sort.Strings(exceeded)
+	return exceeded, bursting
+}
+
+// setBursting records whether a consumer is bursting.
+func (qm *QuotaManager) setBursting(consumerID string, bursting bool) {
+	if !bursting {
+		delete(qm.burstingConsumers, consumerID)
+		return
+	}
+	if qm.burstingConsumers == nil {
+		qm.burstingConsumers = make(map[string]bool)
+	}
+	qm.burstingConsumers[consumerID] = true
+}
+
+// isBursting returns true if the consumer of an AppWrapper was bursting when it was allocated.
+func (qm *QuotaManager) isBursting(aw *arbv1.AppWrapper) bool {
+	return qm.burstingConsumers[util.CreateId(aw.Namespace, aw.Name)]
+}
@@ -0,0 +1,65 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"k8s.io/klog/v2"
+)
+
+// invalidateFitsCache invalidates the cached quota decisions after a change of the forest.
+func (qm *QuotaManager) invalidateFitsCache() {
+	qm.forestGeneration++
+}
+
+// getCachedFitResult returns a copy of the cached quota decision of an AppWrapper, or nil when its demand
+// or the forest changed since the decision was made.
+func (qm *QuotaManager) getCachedFitResult(awId string, demandHash string) *quota.FitResult {
+	entry, found := qm.fitsCache[awId]
+	if !found || !entry.matches(demandHash, qm.forestGeneration) || qm.resourcePlanManager.IsResplanChanged() {
+		quotaFitsCacheRequests.WithLabelValues("miss").Inc()
+		return nil
+	}
+
+	quotaFitsCacheRequests.WithLabelValues("hit").Inc()
+	klog.V(6).Infof("[getCachedFitResult] Cached quota decision of consumer %s in forest generation %d returned.",
+		awId, entry.generation)
+	result := *entry.result
+	return &result
+}
+
+// cacheFitResult caches the quota decision of an AppWrapper that did not fit.  Decisions are not cached
+// when preemption is restricted by the minimum preemption age or the preemption cost threshold, or when
+// allocation deadlines boost the priority of waiting AppWrappers, as they change with time alone.
+func (qm *QuotaManager) cacheFitResult(awId string, demandHash string, result *quota.FitResult) {
+	if result == nil || result.Fits || qm.minPreemptionAge > 0 || qm.preemptionCostThreshold > 0 ||
+		qm.allocationDeadline > 0 || len(qm.treeAllocationDeadlines) > 0 {
+		delete(qm.fitsCache, awId)
+		return
+	}
+
+	if qm.fitsCache == nil || len(qm.fitsCache) >= FitsCacheMaxSize {
+		qm.fitsCache = make(map[string]*fitsCacheEntry)
+	}
+	cached := *result
+	qm.fitsCache[awId] = &fitsCacheEntry{
+		demandHash: demandHash,
+		generation: qm.forestGeneration,
+		result:     &cached,
+	}
+}
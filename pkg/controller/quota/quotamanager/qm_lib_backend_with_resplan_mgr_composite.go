@@ -0,0 +1,177 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"k8s.io/klog/v2"
+)
+
+// Composite demands
+//
+// The demand of an AppWrapper with heterogeneous pod templates, e.g. a driver and workers, is evaluated
+// from the demand of each of its pods.  The consumer requests the total demand of the pods, as for an
+// aggregated demand, and records the largest demand of a single pod of each tree, i.e. the element-wise
+// maximum of the pod demands.  A pod is indivisible: the AppWrapper is rejected when its largest pod
+// exceeds the own quota of every designated group of a tree, even if the total fits by borrowing spare
+// quota from the ancestors of the group.
+//
+// Gang semantics are unchanged: the AppWrapper is allocated the total demand of all its pods or nothing,
+// independently of its minimum number of available pods.  The largest pod demand is only an admission
+// check before the allocation, it does not change the allocated quota.  As the backend consumer spec
+// has no such field, the largest pod demand is kept alongside the consumer spec.
+
+// compositeConsumerSpec is a consumer spec extended with the largest single pod demand of each tree.
+type compositeConsumerSpec struct {
+	*qmbackendutils.JConsumerSpec
+	// Demand of the largest single pod by resource type, keyed by tree name
+	MaxSinglePod map[string]map[string]int
+}
+
+// buildCompositeRequest creates the consumer spec of an AppWrapper from the demands of each of its pods.
+func (qm *QuotaManager) buildCompositeRequest(ctx context.Context, aw *arbv1.AppWrapper,
+	podDemands []*clusterstateapi.Resource) (*compositeConsumerSpec, error) {
+	total := clusterstateapi.EmptyResource()
+	maxSinglePod := clusterstateapi.EmptyResource()
+	for _, podDemand := range podDemands {
+		total.Add(podDemand)
+		maxSinglePod = maxSinglePod.Max(podDemand)
+	}
+
+	perTreeDemands, err := qm.getPerTreeDemands(ctx, aw, total)
+	if err != nil {
+		return nil, err
+	}
+	consumerSpec, err := qm.buildRequestWithDemands(ctx, aw, perTreeDemands)
+	if err != nil {
+		return nil, err
+	}
+
+	_, treeNameToResourceTypes, err := qm.getQuotaDesignation(aw)
+	if err != nil {
+		return nil, err
+	}
+	maxSinglePodDemands := make(map[string]map[string]int)
+	for treeName := range perTreeDemands {
+		demands, err := qm.getQuotaTreeResourceTypesDemands(maxSinglePod, treeNameToResourceTypes[treeName])
+		if err != nil {
+			klog.Errorf("[buildCompositeRequest] Failure building largest pod demands for AppWrapper %s/%s, err=%#v",
+				aw.Namespace, aw.Name, err)
+		}
+		maxSinglePodDemands[treeName] = demands
+	}
+
+	return &compositeConsumerSpec{
+		JConsumerSpec: consumerSpec,
+		MaxSinglePod:  maxSinglePodDemands,
+	}, nil
+}
+
+// getOversizedPodTrees returns the trees of a composite consumer spec whose largest single pod demand
+// exceeds the own quota of every designated group of the tree for some resource type.
+func getOversizedPodTrees(consumerSpec *compositeConsumerSpec,
+	treeNodeSpecs map[string]map[string]*qmbackendutils.JNodeSpec) []string {
+	fittingTrees := make(map[string]bool)
+	checkedTrees := make(map[string]bool)
+	for _, treeSpec := range consumerSpec.Trees {
+		checkedTrees[treeSpec.TreeName] = true
+		nodeSpec, found := treeNodeSpecs[treeSpec.TreeName][treeSpec.GroupID]
+		if !found {
+			continue
+		}
+		fits := true
+		for resourceName, demand := range consumerSpec.MaxSinglePod[treeSpec.TreeName] {
+			if quota, err := strconv.Atoi(nodeSpec.Quota[resourceName]); err == nil && demand > quota {
+				fits = false
+			}
+		}
+		if fits {
+			fittingTrees[treeSpec.TreeName] = true
+		}
+	}
+
+	var oversizedTrees []string
+	for treeName := range checkedTrees {
+		if !fittingTrees[treeName] {
+			oversizedTrees = append(oversizedTrees, treeName)
+		}
+	}
+	sort.Strings(oversizedTrees)
+	return oversizedTrees
+}
+
+// FitsComposite evaluates an AppWrapper against quota from the demand of each of its pods, allocating
+// their total demand.  The AppWrapper does not fit when its largest pod exceeds the own quota of the
+// designated groups of a tree.
+func (qm *QuotaManager) FitsComposite(aw *arbv1.AppWrapper, podDemands []*clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	if aw == nil {
+		return qm.Fits(context.Background(), aw, nil, proposedPreemptions)
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	if qm.quotaManagerBackend == nil || !qm.isQuotaSelected(aw) || quota.IsBestEffort(aw) ||
+		qm.isQuotaExempt(aw) {
+		total := clusterstateapi.EmptyResource()
+		for _, podDemand := range podDemands {
+			total.Add(podDemand)
+		}
+		return qm.fitsAndNotify(context.Background(), aw, total, proposedPreemptions)
+	}
+
+	consumerSpec, err := qm.buildCompositeRequest(context.Background(), aw, podDemands)
+	if err != nil {
+		klog.Errorf("[FitsComposite] Creation of quota request failed: %s/%s, err=%#v.", aw.Namespace, aw.Name, err)
+		result := &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}
+		qm.observers.NotifyAllocate(util.CreateId(aw.Namespace, aw.Name), result)
+		return result, err
+	}
+
+	if qm.resourcePlanManager != nil {
+		oversizedTrees := getOversizedPodTrees(consumerSpec, qm.getTreeNodeSpecs())
+		if len(oversizedTrees) > 0 {
+			klog.V(4).Infof("[FitsComposite] Largest pod of %s/%s exceeds the quota of its groups in trees %v.",
+				aw.Namespace, aw.Name, oversizedTrees)
+			result := &quota.FitResult{
+				Fits:    false,
+				Reason:  quota.QuotaExceeded,
+				Message: fmt.Sprintf("largest pod exceeds the quota of its groups in quota trees %v", oversizedTrees),
+			}
+			qm.observers.NotifyAllocate(consumerSpec.ID, result)
+			return result, nil
+		}
+	}
+
+	perTreeDemands := make(map[string]map[string]int)
+	for _, treeSpec := range consumerSpec.Trees {
+		perTreeDemands[treeSpec.TreeName] = treeSpec.Request
+	}
+	return qm.fitsWithDemandAndNotify(context.Background(), aw, perTreeDemands, proposedPreemptions)
+}
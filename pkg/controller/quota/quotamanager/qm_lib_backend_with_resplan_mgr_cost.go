@@ -0,0 +1,111 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+)
+
+// Preemption cost
+//
+// The preemption targets of a quota evaluation are charged their share of the quota trees times the time
+// since they were dispatched, see quota.PreemptionCost.  The shares are computed from the consumer
+// demands in the units of the quota trees against the quota of the tree roots.  A quota evaluation whose
+// preemptions cost more than the share of the evaluated AppWrapper times the preemptionCostThreshold
+// option is rolled back and does not fit, as when a target is younger than the minimum preemption age.
+
+// getConsumerShare returns the largest fraction of the quota of a tree demanded by a consumer, over the
+// resources and trees of the consumer.  Resources without quota in a tree are ignored.
+func getConsumerShare(consumerSpec *qmbackendutils.JConsumerSpec, treeQuotas map[string]map[string]int) float64 {
+	share := 0.0
+	for _, treeSpec := range consumerSpec.Trees {
+		for resourceName, demand := range treeSpec.Request {
+			treeQuota := treeQuotas[treeSpec.TreeName][resourceName]
+			if treeQuota <= 0 {
+				continue
+			}
+			if resourceShare := float64(demand) / float64(treeQuota); resourceShare > share {
+				share = resourceShare
+			}
+		}
+	}
+	return share
+}
+
+// getConsumerDemand returns the demand of a consumer by resource name, the largest demand over the trees
+// of the consumer.
+func getConsumerDemand(consumerSpec *qmbackendutils.JConsumerSpec) map[string]int {
+	demand := make(map[string]int)
+	for _, treeSpec := range consumerSpec.Trees {
+		for resourceName, amount := range treeSpec.Request {
+			if amount > demand[resourceName] {
+				demand[resourceName] = amount
+			}
+		}
+	}
+	return demand
+}
+
+// getPreemptionCost returns the cost of preempting the targets to allocate a consumer, nil without
+// targets.  The consumers of the targets reclaimed from borrowers are no longer registered and are
+// looked up in the reclaimed borrowers.
+func (qm *QuotaManager) getPreemptionCost(consumerSpec *qmbackendutils.JConsumerSpec, targets []*arbv1.AppWrapper,
+	reclaimedBorrowers []*reclaimedBorrower, now time.Time) *quota.PreemptionCost {
+	if len(targets) == 0 || consumerSpec == nil {
+		return nil
+	}
+
+	reclaimedSpecs := make(map[string]*qmbackendutils.JConsumerSpec)
+	for _, reclaimed := range reclaimedBorrowers {
+		if reclaimed.consumerSpec != nil {
+			reclaimedSpecs[reclaimed.consumerSpec.ID] = reclaimed.consumerSpec
+		}
+	}
+
+	treeQuotas := qm.getTreeQuotas()
+	cost := &quota.PreemptionCost{
+		Demand: make(map[string]int),
+		Value:  getConsumerShare(consumerSpec, treeQuotas),
+	}
+	for _, target := range targets {
+		var runtime time.Duration
+		if dispatchTime := quota.GetDispatchTime(target); !dispatchTime.IsZero() && now.After(dispatchTime) {
+			runtime = now.Sub(dispatchTime)
+		}
+		cost.Runtime += runtime
+
+		targetID := util.CreateId(target.Namespace, target.Name)
+		targetSpec, found := qm.consumerSpecs[targetID]
+		if !found {
+			targetSpec = reclaimedSpecs[targetID]
+		}
+		if targetSpec == nil {
+			continue
+		}
+		for resourceName, amount := range getConsumerDemand(targetSpec) {
+			cost.Demand[resourceName] += amount
+		}
+		cost.Cost += getConsumerShare(targetSpec, treeQuotas) * runtime.Seconds()
+	}
+	return cost
+}
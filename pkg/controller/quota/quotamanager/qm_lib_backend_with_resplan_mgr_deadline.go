@@ -0,0 +1,88 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+// Allocation deadlines
+//
+// An AppWrapper waiting for the quota of a tree longer than the allocation deadline of the tree, see the
+// quotaAllocationDeadline option, has its priority in the tree boosted to the highest priority of the
+// other consumers of the tree.  The wait is measured from the first time the controller saw the
+// AppWrapper, or from its creation.  The boosted priority is the priority of the consumer in the
+// backend: the consumer preempts the consumers of lower priority and, once allocated, is only preempted
+// by consumers of higher priority, so it keeps its allocation against the consumers it waited behind.
+// An allocated consumer keeps the priority it was allocated with when evaluated again.
+//
+// The boost does not change the priority of the AppWrapper.  The preemption targets returned by the
+// backend are ordered by AppWrapper priority, then by the preemption order.
+
+// getAllocationDeadline returns the allocation deadline of a quota tree, zero when disabled.
+func (qm *QuotaManager) getAllocationDeadline(treeName string) time.Duration {
+	if deadline, found := qm.treeAllocationDeadlines[treeName]; found {
+		return deadline
+	}
+	return qm.allocationDeadline
+}
+
+// getTreePriority returns the priority of the consumer of an AppWrapper in a quota tree: the priority of
+// the AppWrapper, boosted to the highest priority of the other consumers of the tree once the AppWrapper
+// waited longer than the allocation deadline of the tree.  An allocated consumer keeps the priority of
+// its allocation.
+func (qm *QuotaManager) getTreePriority(aw *arbv1.AppWrapper, awId string, treeName string, priority int,
+	now time.Time) int {
+	if existingSpec, found := qm.consumerSpecs[awId]; found &&
+		qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+		for _, treeSpec := range existingSpec.Trees {
+			if treeSpec.TreeName == treeName && treeSpec.Priority > priority {
+				return treeSpec.Priority
+			}
+		}
+		return priority
+	}
+
+	deadline := qm.getAllocationDeadline(treeName)
+	pendingSince := getPendingSince(aw)
+	if !isPastAllocationDeadline(deadline, pendingSince, now) {
+		return priority
+	}
+
+	var treePriorities []int
+	for consumerID, consumerSpec := range qm.consumerSpecs {
+		if consumerID == awId {
+			continue
+		}
+		for _, treeSpec := range consumerSpec.Trees {
+			if treeSpec.TreeName == treeName {
+				treePriorities = append(treePriorities, treeSpec.Priority)
+			}
+		}
+	}
+
+	boosted := getBoostedPriority(priority, treePriorities)
+	if boosted > priority {
+		klog.V(4).Infof("[getTreePriority] AppWrapper %s/%s waiting for quota of tree %s since %v, priority boosted from %d to %d.",
+			aw.Namespace, aw.Name, treeName, pendingSince, priority, boosted)
+	}
+	return boosted
+}
@@ -0,0 +1,254 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+)
+
+// Quota forests
+//
+// The quota trees are in the default forest, QuotaManagerForestName, unless their ResourcePlans assign
+// them to a named forest, see rputil.URMForestLabel.  An AppWrapper labeled with quota.ForestLabel is
+// evaluated against the trees of the named forest, the other AppWrappers against the trees of the default
+// forest, and only the trees of its forest need a quota designation.  A consumer is allocated in the
+// forest of its trees, so the consumers of a forest never preempt the consumers of another forest.
+
+// getForestNames returns the sorted forest names of the quota manager backend.
+func (qm *QuotaManager) getForestNames() []string {
+	forestNames := append([]string{}, qm.quotaManagerBackend.GetForestNames()...)
+	sort.Strings(forestNames)
+	return forestNames
+}
+
+// getTreeForest returns the forest name of a quota tree.
+func (qm *QuotaManager) getTreeForest(treeName string) string {
+	if forestName, found := qm.treeForests[treeName]; found {
+		return forestName
+	}
+	return QuotaManagerForestName
+}
+
+// getForestTreeNames returns the names of the quota trees of a forest.
+func (qm *QuotaManager) getForestTreeNames(forestName string) []string {
+	forestTreeNames := []string{}
+	for _, treeName := range qm.getTreeNames() {
+		if qm.getTreeForest(treeName) == forestName {
+			forestTreeNames = append(forestTreeNames, treeName)
+		}
+	}
+	return forestTreeNames
+}
+
+// getAppWrapperForest returns the forest name designated by the labels of an AppWrapper.
+func (qm *QuotaManager) getAppWrapperForest(aw *arbv1.AppWrapper) string {
+	if forestName := quota.GetForest(aw); len(forestName) > 0 {
+		return forestName
+	}
+	return QuotaManagerForestName
+}
+
+// getSpecForest returns the forest name of the trees of a consumer spec.
+func (qm *QuotaManager) getSpecForest(consumerSpec *qmbackendutils.JConsumerSpec) string {
+	if consumerSpec == nil || len(consumerSpec.Trees) == 0 {
+		return QuotaManagerForestName
+	}
+	return qm.getTreeForest(consumerSpec.Trees[0].TreeName)
+}
+
+// getConsumerForest returns the forest name of a registered consumer, the default forest for consumers
+// not registered.
+func (qm *QuotaManager) getConsumerForest(consumerID string) string {
+	return qm.getSpecForest(qm.consumerSpecs[consumerID])
+}
+
+// DumpForest returns the root nodes of the quota trees with the quota and the current allocation of each
+// tree node, e.g. to serialize the forest as JSON.  Quotas and allocations are formatted as lists of
+// amounts ordered by the resource names of the tree.
+func (qm *QuotaManager) DumpForest() ([]TreeNode, error) {
+	if qm.quotaManagerBackend == nil || qm.resourcePlanManager == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	allocated := qm.getGroupAllocations()
+
+	treeNames := append([]string{}, qm.getTreeNames()...)
+	sort.Strings(treeNames)
+
+	treeNodeSpecs := qm.getTreeNodeSpecs()
+	treeNodes := []TreeNode{}
+	for _, treeName := range treeNames {
+		var resourceNames []string
+		if treeCache := qm.quotaManagerBackend.GetTreeCache(treeName); treeCache != nil {
+			resourceNames = treeCache.GetResourceNames()
+		}
+		treeNodes = append(treeNodes, buildTreeNodes(resourceNames, treeNodeSpecs[treeName], allocated[treeName])...)
+	}
+	return treeNodes, nil
+}
+
+// Headroom returns the unused quota of a quota tree node, its quota minus the current allocation of its
+// subtree, for every resource type of the tree.  Unknown trees and tree nodes are rejected.
+func (qm *QuotaManager) Headroom(treeName string, groupId string) (*clusterstateapi.Resource, error) {
+	if qm.quotaManagerBackend == nil || qm.resourcePlanManager == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	resourceNames, found := qm.getTreeResourceNames(treeName)
+	if !found {
+		return nil, fmt.Errorf("quota tree %s does not exist", treeName)
+	}
+	nodeSpecs := qm.getTreeNodeSpecs()[treeName]
+	nodeSpec, found := nodeSpecs[groupId]
+	if !found {
+		return nil, fmt.Errorf("quota group %s does not exist in quota tree %s", groupId, treeName)
+	}
+
+	allocation := getSubtreeAllocation(groupId, nodeSpecs, qm.getGroupAllocations()[treeName])
+	unused := make(map[string]int)
+	for _, resourceName := range resourceNames {
+		nodeQuota, _ := strconv.Atoi(nodeSpec.Quota[resourceName])
+		if available := nodeQuota - allocation[resourceName]; available > 0 {
+			unused[resourceName] = available
+		} else {
+			unused[resourceName] = 0
+		}
+	}
+	return qm.quotaToResource(unused), nil
+}
+
+// getGroupAllocations returns the sum of the requests of the allocated consumers by tree name, group and
+// resource name.
+func (qm *QuotaManager) getGroupAllocations() map[string]map[string]map[string]int {
+	allocated := make(map[string]map[string]map[string]int)
+	for consumerID, consumerSpec := range qm.consumerSpecs {
+		if !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			continue
+		}
+		for _, treeSpec := range consumerSpec.Trees {
+			if allocated[treeSpec.TreeName] == nil {
+				allocated[treeSpec.TreeName] = make(map[string]map[string]int)
+			}
+			if allocated[treeSpec.TreeName][treeSpec.GroupID] == nil {
+				allocated[treeSpec.TreeName][treeSpec.GroupID] = make(map[string]int)
+			}
+			for resourceName, demand := range treeSpec.Request {
+				allocated[treeSpec.TreeName][treeSpec.GroupID][resourceName] += demand
+			}
+		}
+	}
+	return allocated
+}
+
+// getSubtreeAllocation returns the allocation of the subtree of a node by resource name, i.e. the sum of
+// the allocations of the groups the node is an ancestor of, or is.
+func getSubtreeAllocation(nodeName string, nodeSpecs map[string]*qmbackendutils.JNodeSpec,
+	allocated map[string]map[string]int) map[string]int {
+	allocation := make(map[string]int)
+	for groupName, groupAllocation := range allocated {
+		// Walk up the ancestors of the group, bounded by the number of nodes in case of cycles
+		ancestor := groupName
+		for i := 0; i <= len(nodeSpecs) && ancestor != nodeName; i++ {
+			nodeSpec, found := nodeSpecs[ancestor]
+			if !found {
+				break
+			}
+			ancestor = nodeSpec.Parent
+		}
+		if ancestor != nodeName {
+			continue
+		}
+		for resourceName, amount := range groupAllocation {
+			allocation[resourceName] += amount
+		}
+	}
+	return allocation
+}
+
+// buildTreeNodes returns the root nodes of a quota tree built from the node specs of the tree.  The
+// allocation of a node is the sum of the allocations of the groups in its subtree.
+func buildTreeNodes(resourceNames []string, nodeSpecs map[string]*qmbackendutils.JNodeSpec,
+	allocated map[string]map[string]int) []TreeNode {
+	var rootNodes []string
+	childNodes := make(map[string][]string)
+	for nodeName, nodeSpec := range nodeSpecs {
+		if _, found := nodeSpecs[nodeSpec.Parent]; found {
+			childNodes[nodeSpec.Parent] = append(childNodes[nodeSpec.Parent], nodeName)
+		} else {
+			rootNodes = append(rootNodes, nodeName)
+		}
+	}
+	sort.Strings(rootNodes)
+
+	treeNodes := []TreeNode{}
+	for _, rootNode := range rootNodes {
+		treeNode, _ := buildTreeNode(rootNode, resourceNames, nodeSpecs, childNodes, allocated)
+		treeNodes = append(treeNodes, treeNode)
+	}
+	return treeNodes
+}
+
+// buildTreeNode returns the subtree of a node and the allocation of the subtree by resource name.
+func buildTreeNode(nodeName string, resourceNames []string, nodeSpecs map[string]*qmbackendutils.JNodeSpec,
+	childNodes map[string][]string, allocated map[string]map[string]int) (TreeNode, map[string]int) {
+	nodeSpec := nodeSpecs[nodeName]
+	hard, _ := strconv.ParseBool(nodeSpec.Hard)
+	treeNode := TreeNode{
+		Name:     nodeName,
+		Parent:   nodeSpec.Parent,
+		Hard:     hard,
+		Children: []TreeNode{},
+	}
+
+	allocation := make(map[string]int)
+	for resourceName, amount := range allocated[nodeName] {
+		allocation[resourceName] += amount
+	}
+
+	children := append([]string{}, childNodes[nodeName]...)
+	sort.Strings(children)
+	for _, child := range children {
+		childNode, childAllocation := buildTreeNode(child, resourceNames, nodeSpecs, childNodes, allocated)
+		treeNode.Children = append(treeNode.Children, childNode)
+		for resourceName, amount := range childAllocation {
+			allocation[resourceName] += amount
+		}
+	}
+
+	quotas := make([]int, len(resourceNames))
+	allocations := make([]int, len(resourceNames))
+	for i, resourceName := range resourceNames {
+		quotas[i], _ = strconv.Atoi(nodeSpec.Quota[resourceName])
+		allocations[i] = allocation[resourceName]
+	}
+	treeNode.Quota = fmt.Sprint(quotas)
+	treeNode.Allocation = fmt.Sprint(allocations)
+
+	return treeNode, allocation
+}
@@ -0,0 +1,75 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"math"
+
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"k8s.io/klog/v2"
+)
+
+// Time-sliced GPUs
+//
+// A time-sliced node advertises several GPU replicas per physical GPU, the GPU sharing factor of the node
+// label.  Quota trees gating on the physical-gpu resource type count the physical GPUs of the replica
+// demands, the replicas divided by the sharing factor rounded up, so replicas can not inflate the number
+// of physical GPUs allocated.  The smallest sharing factor of the cluster is used, which never under-counts
+// the physical GPUs of a demand whatever the nodes its pods are placed on.  This is a deliberate
+// approximation: the nodes of the pods are not known when the quota is evaluated, so demands placed on
+// nodes sharing their GPUs more widely are over-counted.
+
+// SetGPUSharingFactor sets the number of GPU replicas advertised per physical GPU.  The cached quota
+// decisions are only invalidated when the factor changed.
+func (qm *QuotaManager) SetGPUSharingFactor(factor int) {
+	if factor < 1 {
+		factor = 1
+	}
+
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.RLock()
+	unchanged := factor == qm.getGPUSharingFactor()
+	qm.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	if factor == qm.getGPUSharingFactor() {
+		return
+	}
+	klog.V(4).Infof("[SetGPUSharingFactor] GPU sharing factor changed from %d to %d.", qm.getGPUSharingFactor(), factor)
+	qm.gpuSharingFactor = factor
+	qm.invalidateFitsCache()
+}
+
+// getGPUSharingFactor returns the number of GPU replicas advertised per physical GPU, 1 when not set.
+func (qm *QuotaManager) getGPUSharingFactor() int {
+	if qm.gpuSharingFactor < 1 {
+		return 1
+	}
+	return qm.gpuSharingFactor
+}
+
+// getPhysicalGPUDemand returns the physical GPUs of the GPU replica demands.
+func (qm *QuotaManager) getPhysicalGPUDemand(awResDemands *clusterstateapi.Resource) float64 {
+	return math.Ceil(float64(awResDemands.GPU) / float64(qm.getGPUSharingFactor()))
+}
@@ -0,0 +1,188 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// Quota metrics, labeled by quota tree name and resource type:
+//
+//	mcad_quota_tree_allocated - quota allocated to the consumers of the tree
+//	mcad_quota_tree_quota     - total quota of the tree, i.e. the quota of the tree root node
+//
+// Startup metrics of the replay of the dispatched AppWrappers:
+//
+//	mcad_quota_load_duration_seconds - duration of the replay
+//	mcad_quota_load_unreplayed       - dispatched AppWrappers not replayed before the load timeout
+//	mcad_quota_load_replayed         - dispatched AppWrappers replayed so far
+//	mcad_quota_load_total            - dispatched AppWrappers to replay
+//
+// Quota decision cache metrics, labeled by result, hit or miss:
+//
+//	mcad_quota_fits_cache_requests_total - lookups of cached quota decisions
+//
+// Forest refresh metrics:
+//
+//	mcad_quota_seconds_since_refresh - age of the last successful update of the forest, +Inf if never updated
+var (
+	quotaTreeAllocated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "tree_allocated",
+		Help:      "Quota allocated in the quota tree by resource type.",
+	}, []string{"tree", "resource"})
+
+	quotaTreeQuota = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "tree_quota",
+		Help:      "Total quota of the quota tree by resource type.",
+	}, []string{"tree", "resource"})
+
+	quotaLoadDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "load_duration_seconds",
+		Help:      "Duration of the replay of the dispatched AppWrappers at startup.",
+	})
+
+	quotaLoadUnreplayed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "load_unreplayed",
+		Help:      "Dispatched AppWrappers not replayed at startup before the load timeout.",
+	})
+
+	quotaLoadReplayed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "load_replayed",
+		Help:      "Dispatched AppWrappers replayed so far at startup.",
+	})
+
+	quotaLoadTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "load_total",
+		Help:      "Dispatched AppWrappers to replay at startup.",
+	})
+
+	quotaFitsCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mcad",
+		Subsystem: "quota",
+		Name:      "fits_cache_requests_total",
+		Help:      "Lookups of cached quota decisions by result.",
+	}, []string{"result"})
+
+	registerQuotaMetricsOnce     sync.Once
+	registerRefreshAgeMetricOnce sync.Once
+)
+
+func registerQuotaMetrics() {
+	registerQuotaMetricsOnce.Do(func() {
+		for _, collector := range []prometheus.Collector{quotaTreeAllocated, quotaTreeQuota,
+			quotaLoadDuration, quotaLoadUnreplayed, quotaLoadReplayed, quotaLoadTotal, quotaFitsCacheRequests} {
+			if err := prometheus.Register(collector); err != nil {
+				klog.Errorf("[registerQuotaMetrics] Failure registering quota metrics, err=%#v.", err)
+			}
+		}
+	})
+}
+
+// registerRefreshAgeMetric registers the age of the last successful update of the forest of the quota
+// manager, computed when the metric is collected, e.g. to alert on a stuck ResourcePlan informer.
+func registerRefreshAgeMetric(qm *QuotaManager) {
+	registerRefreshAgeMetricOnce.Do(func() {
+		quotaSecondsSinceRefresh := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "mcad",
+			Subsystem: "quota",
+			Name:      "seconds_since_refresh",
+			Help:      "Seconds since the last successful update of the quota forest.",
+		}, func() float64 {
+			lastRefresh := qm.LastRefresh()
+			if lastRefresh.IsZero() {
+				return math.Inf(1)
+			}
+			return time.Since(lastRefresh).Seconds()
+		})
+		if err := prometheus.Register(quotaSecondsSinceRefresh); err != nil {
+			klog.Errorf("[registerRefreshAgeMetric] Failure registering quota refresh metric, err=%#v.", err)
+		}
+	})
+}
+
+// updateQuotaMetrics reports the allocated and total quota of each tree.  Series of trees and resource
+// types no longer defined are removed.
+func (qm *QuotaManager) updateQuotaMetrics() {
+	if qm.quotaManagerBackend == nil || qm.resourcePlanManager == nil || qm.simulation {
+		return
+	}
+
+	// Sum the requests of the allocated consumers by tree and resource type
+	allocated := make(map[string]map[string]int)
+	for consumerID, consumerSpec := range qm.consumerSpecs {
+		if !qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			continue
+		}
+		for _, treeSpec := range consumerSpec.Trees {
+			if allocated[treeSpec.TreeName] == nil {
+				allocated[treeSpec.TreeName] = make(map[string]int)
+			}
+			for resourceName, demand := range treeSpec.Request {
+				allocated[treeSpec.TreeName][resourceName] += demand
+			}
+		}
+	}
+
+	treeQuotas := qm.getTreeQuotas()
+	reported := make(map[string]map[string]bool)
+	for _, treeName := range qm.getTreeNames() {
+		reported[treeName] = make(map[string]bool)
+		for resourceName, amount := range treeQuotas[treeName] {
+			quotaTreeQuota.WithLabelValues(treeName, resourceName).Set(float64(amount))
+			quotaTreeAllocated.WithLabelValues(treeName, resourceName).Set(float64(allocated[treeName][resourceName]))
+			reported[treeName][resourceName] = true
+		}
+		for resourceName, amount := range allocated[treeName] {
+			if reported[treeName][resourceName] {
+				continue
+			}
+			quotaTreeQuota.WithLabelValues(treeName, resourceName).Set(0)
+			quotaTreeAllocated.WithLabelValues(treeName, resourceName).Set(float64(amount))
+			reported[treeName][resourceName] = true
+		}
+	}
+
+	// Remove stale series
+	for treeName, resourceNames := range qm.reportedMetrics {
+		for resourceName := range resourceNames {
+			if reported[treeName][resourceName] {
+				continue
+			}
+			quotaTreeQuota.DeleteLabelValues(treeName, resourceName)
+			quotaTreeAllocated.DeleteLabelValues(treeName, resourceName)
+		}
+	}
+	qm.reportedMetrics = reported
+}
@@ -0,0 +1,158 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/cmd/kar-controllers/app/options"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Over-subscription
+//
+// A refresh of the quota trees may shrink a tree below the quota allocated in the tree, e.g. when an
+// operator lowers the quota of a ResourcePlan.  The consumers allocated before the refresh keep running
+// whether or not the backend allocates them again, so the over-subscription of a tree is the sum of their
+// demands in the tree minus the quota of the tree, by resource.  It is computed by every refresh, so the
+// controller can preempt consumers down to the new quota instead of discovering it as new consumers do
+// not fit.
+
+// RefreshQuotaTrees refreshes the quota trees when the ResourcePlans changed, as the quota evaluations do,
+// and returns by how much each over-subscribed tree is over its quota, keyed by tree name.
+func (qm *QuotaManager) RefreshQuotaTrees() (map[string]*clusterstateapi.Resource, error) {
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	var err error
+	if qm.resourcePlanManager.IsResplanChanged() {
+		err = qm.refreshQuotaDefiniions()
+	}
+
+	overSubscriptions := make(map[string]*clusterstateapi.Resource)
+	for treeName, amounts := range qm.overSubscription {
+		overSubscriptions[treeName] = qm.quotaToResource(amounts)
+	}
+	return overSubscriptions, err
+}
+
+// OverSubscription returns by how much a quota tree was over its quota after the last refresh, no
+// resources when the tree was within its quota.
+func (qm *QuotaManager) OverSubscription(treeName string) *clusterstateapi.Resource {
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	return qm.quotaToResource(qm.overSubscription[treeName])
+}
+
+// getAllocatedConsumerIDs returns the IDs of the allocated consumers.
+func (qm *QuotaManager) getAllocatedConsumerIDs() []string {
+	var consumerIDs []string
+	for consumerID := range qm.consumerSpecs {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			consumerIDs = append(consumerIDs, consumerID)
+		}
+	}
+	return consumerIDs
+}
+
+// updateOverSubscription computes the over-subscription of the quota trees by the consumers allocated
+// before a refresh.
+func (qm *QuotaManager) updateOverSubscription(consumerIDs []string) {
+	usage := make(map[string]map[string]int)
+	for _, consumerID := range consumerIDs {
+		consumerSpec, found := qm.consumerSpecs[consumerID]
+		if !found {
+			continue
+		}
+		for _, treeSpec := range consumerSpec.Trees {
+			if usage[treeSpec.TreeName] == nil {
+				usage[treeSpec.TreeName] = make(map[string]int)
+			}
+			for resourceName, demand := range treeSpec.Request {
+				usage[treeSpec.TreeName][resourceName] += demand
+			}
+		}
+	}
+
+	overSubscription := make(map[string]map[string]int)
+	treeQuotas := qm.getTreeQuotas()
+	for treeName, amounts := range usage {
+		for resourceName, amount := range amounts {
+			treeQuota, found := treeQuotas[treeName][resourceName]
+			if !found || amount <= treeQuota {
+				continue
+			}
+			if overSubscription[treeName] == nil {
+				overSubscription[treeName] = make(map[string]int)
+			}
+			overSubscription[treeName][resourceName] = amount - treeQuota
+		}
+	}
+
+	var treeNames []string
+	for treeName := range overSubscription {
+		treeNames = append(treeNames, treeName)
+	}
+	sort.Strings(treeNames)
+	for _, treeName := range treeNames {
+		klog.Warningf("[updateOverSubscription] Quota tree %s over-subscribed by %v after refresh.", treeName,
+			overSubscription[treeName])
+	}
+	qm.overSubscription = overSubscription
+}
+
+// quotaToResource converts amounts of the resource types of the quota trees to resources, the inverse of
+// getQuotaTreeResourceTypesDemands.
+func (qm *QuotaManager) quotaToResource(amounts map[string]int) *clusterstateapi.Resource {
+	resource := clusterstateapi.EmptyResource()
+	for treeResourceType, amount := range amounts {
+		canonicalResourceType := qm.resourceAliases[strings.ToLower(treeResourceType)]
+
+		if vendorResourceNames, found := qm.gpuVendorResources[strings.ToLower(treeResourceType)]; found {
+			// GPUs of specific vendors, as the first vendor resource name
+			if len(vendorResourceNames) == 0 || vendorResourceNames[0] == clusterstateapi.GPUResourceName {
+				resource.GPU += int64(amount)
+			} else {
+				resource.AddScalar(v1.ResourceName(vendorResourceNames[0]), float64(amount))
+			}
+		} else if clusterstateapi.IsSharedGPUResource(v1.ResourceName(treeResourceType)) {
+			resource.AddScalar(v1.ResourceName(treeResourceType), float64(amount)/1000)
+		} else if canonicalResourceType == options.QuotaResourceCPU {
+			resource.MilliCPU += float64(amount)
+		} else if canonicalResourceType == options.QuotaResourceMemory {
+			resource.Memory += float64(amount) * qm.memoryUnitBytes
+		} else if canonicalResourceType == options.QuotaResourceGPU {
+			resource.GPU += int64(amount)
+		} else if canonicalResourceType == options.QuotaResourceGPUMemory {
+			resource.GPUMemory += int64(amount)
+		} else {
+			resource.AddScalar(v1.ResourceName(treeResourceType), float64(amount))
+		}
+	}
+	return resource
+}
@@ -0,0 +1,125 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"math"
+	"reflect"
+	"sort"
+
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"k8s.io/klog/v2"
+)
+
+// Percentage quotas
+//
+// The quota of the children of the ResourcePlans annotated with the percentage quota mode is expressed in
+// percent of the cluster capacity, the allocatable resources of the schedulable nodes reported by the
+// controller.  The capacity is converted to the units of the quota trees as the AppWrapper demands are,
+// the memory rounded down to the memory unit.  The ResourcePlan manager computes the absolute quotas,
+// rounded down, and the quota trees are refreshed on the next quota evaluation when a quota changed.
+//
+// Percentage quotas are never reduced below the quota allocated in the subtree of their node.  When the
+// capacity shrinks below the usage, the allocated consumers keep their allocation and new consumers do
+// not fit until the usage drops; the quotas kept above their percentage are lowered as consumers are
+// released.
+
+// SetClusterCapacity sets the cluster capacity the percentage quotas are computed from.  The percentage
+// quotas are only recomputed when the capacity in the units of the quota trees changed.
+func (qm *QuotaManager) SetClusterCapacity(capacity *clusterstateapi.Resource) {
+	// Handle uninitialized quota manager
+	if qm.quotaManagerBackend == nil || capacity == nil {
+		return
+	}
+	percentageQuotas, ok := qm.resourcePlanManager.(PercentageQuotaProvider)
+	if !ok {
+		return
+	}
+
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.RLock()
+	capacities := qm.getClusterCapacities(capacity)
+	unchanged := reflect.DeepEqual(capacities, qm.clusterCapacity)
+	qm.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	// Setting the capacity recomputes the percentage quotas
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+	qm.clusterCapacity = capacities
+	if percentageQuotas.SetClusterCapacity(capacities) {
+		klog.V(4).Infof("[SetClusterCapacity] Percentage quotas changed by cluster capacity %v.", capacities)
+	}
+}
+
+// getClusterCapacities converts the cluster capacity to the resource types of the quota trees, keyed by
+// resource name.
+func (qm *QuotaManager) getClusterCapacities(capacity *clusterstateapi.Resource) map[string]int {
+	// Round the memory down to the memory unit, so the capacity is not rounded up as demands are
+	capacity = capacity.Clone()
+	if qm.memoryUnitBytes > 0 {
+		capacity.Memory = math.Floor(capacity.Memory/qm.memoryUnitBytes) * qm.memoryUnitBytes
+	}
+
+	resourceNameSet := make(map[string]bool)
+	for _, treeName := range qm.getTreeNames() {
+		treeResourceNames, _ := qm.getTreeResourceNames(treeName)
+		for _, resourceName := range treeResourceNames {
+			resourceNameSet[resourceName] = true
+		}
+	}
+	var resourceNames []string
+	for resourceName := range resourceNameSet {
+		resourceNames = append(resourceNames, resourceName)
+	}
+	sort.Strings(resourceNames)
+
+	// Resource types missing from the capacity have no capacity, overflows are saturated
+	capacities, _ := qm.getQuotaTreeResourceTypesDemands(capacity, resourceNames)
+	return capacities
+}
+
+// getQuotaFloors returns the quota allocated in the subtree of each quota tree node, keyed by tree name,
+// node name and resource name.
+func (qm *QuotaManager) getQuotaFloors() map[string]map[string]map[string]int {
+	floors := make(map[string]map[string]map[string]int)
+	treeNodeSpecs := qm.getTreeNodeSpecs()
+	for treeName, groupAllocations := range qm.getGroupAllocations() {
+		nodeSpecs := treeNodeSpecs[treeName]
+		floors[treeName] = make(map[string]map[string]int)
+		for nodeName := range nodeSpecs {
+			floors[treeName][nodeName] = getSubtreeAllocation(nodeName, nodeSpecs, groupAllocations)
+		}
+	}
+	return floors
+}
+
+// updateQuotaFloors lowers the percentage quotas kept above their percentage of the cluster capacity
+// after consumers are released.
+func (qm *QuotaManager) updateQuotaFloors() {
+	percentageQuotas, ok := qm.resourcePlanManager.(PercentageQuotaProvider)
+	if !ok || !percentageQuotas.IsQuotaClamped() {
+		return
+	}
+	if percentageQuotas.SetQuotaFloors(qm.getQuotaFloors()) {
+		klog.V(4).Infof("[updateQuotaFloors] Percentage quotas changed by released consumers.")
+	}
+}
@@ -0,0 +1,217 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	"k8s.io/klog/v2"
+)
+
+// Quota reservations
+//
+// A reservation holds quota for an AppWrapper ahead of its dispatch, e.g. for a later stage of a
+// pipeline.  The reservation is the consumer of the AppWrapper, allocated without a running workload: it
+// counts against the quota trees like any allocation.  Reservations never preempt other consumers.
+//
+// A reservation expires after its TTL unless converted by Fits: the evaluation of the AppWrapper reuses
+// the consumer of the reservation when the demand is unchanged, or else releases the reserved quota
+// before allocating the demand, so the reserved quota is never counted twice.  When the demand does not
+// fit, the reservation is restored until it expires.  Expired reservations are released by the next
+// quota evaluation or reservation.
+//
+// A preemptible reservation is preempted like any consumer of its priority and is then lost.  An
+// unpreemptable reservation is allocated as by SetUnpreemptable and its AppWrapper stays unpreemptable
+// once converted.
+
+// quotaReservation is the reservation of quota by the consumer of an AppWrapper.
+type quotaReservation struct {
+	expiry        time.Time
+	unpreemptable bool
+}
+
+// Reserve allocates quota to the consumer of an AppWrapper, as produced by util.CreateId, until the TTL
+// expires or the reservation is converted by Fits.  The AppWrapper must exist and have no consumer.
+func (qm *QuotaManager) Reserve(awId string, demands *clusterstateapi.Resource, ttl time.Duration, preemptible bool) error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
+	}
+	if len(awId) <= 0 {
+		return fmt.Errorf("empty consumer id")
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("invalid reservation ttl %v of consumer %s", ttl, awId)
+	}
+	qm.maintenanceMutex.RLock()
+	defer qm.maintenanceMutex.RUnlock()
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	if qm.quotaManagerBackend.GetMode() == qmbackend.Maintenance && qm.initializationDone {
+		return fmt.Errorf("quota manager backend in maintenance mode")
+	}
+	now := time.Now()
+	qm.expireReservations(now)
+	if _, found := qm.consumerSpecs[awId]; found {
+		return fmt.Errorf("consumer %s already registered", awId)
+	}
+
+	awNamespace, awName := util.ParseId(awId)
+	aw, err := qm.appwrapperLister.AppWrappers(awNamespace).Get(awName)
+	if err != nil {
+		return fmt.Errorf("AppWrapper of consumer %s not found: %w", awId, err)
+	}
+
+	wasUnpreemptable := qm.unpreemptableConsumers[awId]
+	if !preemptible {
+		if qm.unpreemptableConsumers == nil {
+			qm.unpreemptableConsumers = make(map[string]bool)
+		}
+		qm.unpreemptableConsumers[awId] = true
+	}
+	cleanup := func() {
+		if !wasUnpreemptable {
+			delete(qm.unpreemptableConsumers, awId)
+		}
+	}
+
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, demands)
+	if err != nil {
+		cleanup()
+		return fmt.Errorf("creation of quota request of consumer %s failed: %w", awId, err)
+	}
+	allocResponse, allocatedSpec, err := qm.allocateConsumer(context.Background(), qm.quotaManagerBackend, consumerSpec)
+	if err != nil || !allocResponse.IsAllocated() {
+		qm.removeConsumer(consumerSpec.ID)
+		cleanup()
+		if err != nil {
+			return fmt.Errorf("reservation of consumer %s failed: %w", awId, err)
+		}
+		return fmt.Errorf("reservation of consumer %s does not fit: %s", awId, allocResponse.GetMessage())
+	}
+	if preemptedIDs := allocResponse.GetPreemptedIds(); len(preemptedIDs) > 0 {
+		qm.setConsumerSpec(consumerSpec.ID, allocatedSpec)
+		qm.rollbackPreemption(consumerSpec.ID, preemptedIDs)
+		cleanup()
+		return fmt.Errorf("reservation of consumer %s requires preempting %d consumers", awId, len(preemptedIDs))
+	}
+	qm.setConsumerSpec(consumerSpec.ID, allocatedSpec)
+	exceededNodes, bursting := qm.checkBurstLimits(allocatedSpec)
+	if len(exceededNodes) > 0 {
+		qm.removeConsumer(consumerSpec.ID)
+		cleanup()
+		return fmt.Errorf("reservation of consumer %s exceeds the burst limits of quota nodes %v", awId, exceededNodes)
+	}
+	qm.setBursting(consumerSpec.ID, bursting)
+
+	if qm.reservations == nil {
+		qm.reservations = make(map[string]*quotaReservation)
+	}
+	qm.reservations[awId] = &quotaReservation{
+		expiry:        now.Add(ttl),
+		unpreemptable: !preemptible && !wasUnpreemptable,
+	}
+	qm.invalidateFitsCache()
+	qm.updateQuotaMetrics()
+	klog.V(4).Infof("[Reserve] Quota reserved for consumer %s until %v, preemptible: %t.", awId, now.Add(ttl), preemptible)
+	return nil
+}
+
+// Unreserve releases the quota reserved for the consumer of an AppWrapper.  Consumers converted by Fits
+// are released by Release.
+func (qm *QuotaManager) Unreserve(awId string) error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	if _, found := qm.reservations[awId]; !found {
+		return fmt.Errorf("no reservation of consumer %s", awId)
+	}
+	qm.removeReservation(awId)
+	qm.invalidateFitsCache()
+	qm.updateQuotaMetrics()
+	klog.V(4).Infof("[Unreserve] Quota reservation of consumer %s released.", awId)
+	return nil
+}
+
+// removeReservation releases the consumer of a reservation.
+func (qm *QuotaManager) removeReservation(awId string) {
+	qm.removeConsumer(awId)
+	if qm.reservations[awId].unpreemptable {
+		delete(qm.unpreemptableConsumers, awId)
+	}
+	delete(qm.reservations, awId)
+}
+
+// expireReservations releases the reservations expired at the given time.
+func (qm *QuotaManager) expireReservations(now time.Time) {
+	expired := 0
+	for awId, reservation := range qm.reservations {
+		if now.Before(reservation.expiry) {
+			continue
+		}
+		klog.V(4).Infof("[expireReservations] Quota reservation of consumer %s expired at %v.", awId, reservation.expiry)
+		qm.removeReservation(awId)
+		expired++
+	}
+	if expired > 0 {
+		qm.invalidateFitsCache()
+		qm.updateQuotaMetrics()
+	}
+}
+
+// fitsWithReservation evaluates the AppWrapper of a consumer against quota, converting the reservation of
+// the consumer when the evaluation fits and restoring it otherwise.
+func (qm *QuotaManager) fitsWithReservation(awId string, evaluate func() (*quota.FitResult, error)) (*quota.FitResult, error) {
+	if _, reserved := qm.reservations[awId]; !reserved {
+		return evaluate()
+	}
+	reservedSpec := qm.consumerSpecs[awId]
+	wasBursting := qm.burstingConsumers[awId]
+
+	result, err := evaluate()
+	if result != nil && result.Fits {
+		klog.V(4).Infof("[fitsWithReservation] Quota reservation of consumer %s converted to an allocation.", awId)
+		delete(qm.reservations, awId)
+		return result, err
+	}
+	if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+		return result, err
+	}
+
+	// The evaluation released the reserved quota, allocate it again
+	klog.V(4).Infof("[fitsWithReservation] Restoring quota reservation of consumer %s.", awId)
+	qm.removeConsumer(awId)
+	if restoreErr := qm.reallocateConsumer(reservedSpec); restoreErr != nil {
+		klog.Errorf("[fitsWithReservation] Failure restoring quota reservation of consumer %s, err=%v.", awId, restoreErr)
+		qm.removeReservation(awId)
+		return result, err
+	}
+	qm.setBursting(awId, wasBursting)
+	qm.invalidateFitsCache()
+	qm.updateQuotaMetrics()
+	return result, err
+}
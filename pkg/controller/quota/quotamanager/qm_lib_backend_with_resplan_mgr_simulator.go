@@ -0,0 +1,226 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/cmd/kar-controllers/app/options"
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	listersv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/listers/controller/v1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	rpmanager "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr"
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// SimulationRequest is a timestamped quota request of a recorded workload: the evaluation of the quota of
+// an AppWrapper, as by Fits, or the release of its quota, as by Release.
+type SimulationRequest struct {
+	Time       time.Time
+	AppWrapper *arbv1.AppWrapper
+	// Resource demands of the AppWrapper, ignored by releases
+	Demand *clusterstateapi.Resource
+	// Release the quota of the AppWrapper instead of evaluating it
+	Release bool
+}
+
+// SimulationSample is the utilization of the quota trees after a request of a simulation.
+type SimulationSample struct {
+	Time time.Time
+	// Allocated quota keyed by tree name and resource name
+	Allocated map[string]map[string]int
+	// Ratio of the allocated quota to the quota of the tree roots, keyed by tree name and resource name
+	Utilization map[string]map[string]float64
+}
+
+// SimulationReport summarizes the replay of a recorded workload.
+type SimulationReport struct {
+	// Utilization of the quota trees after each request, in request time order
+	Samples []SimulationSample
+	// Quota evaluations that fit and did not fit
+	Fits     int
+	Rejected int
+	// Quota releases
+	Releases int
+	// AppWrappers preempted by the quota evaluations that fit
+	Preemptions int
+}
+
+// Simulator replays a recorded workload against a candidate quota tree configuration, e.g. to validate
+// a ResourcePlan change before applying it.  Requests are evaluated by a quota manager with its own
+// in-memory backend, no live cluster is accessed and no metrics are reported.
+type Simulator struct {
+	qm                *QuotaManager
+	appwrapperIndexer cache.Indexer
+}
+
+// NewSimulator creates a simulator of the quota trees defined by a JSON or YAML ResourcePlanList file.
+// The quota options, e.g. the memory unit and the resource type aliases, are those of the server options.
+func NewSimulator(quotaTreeFile string, serverOptions *options.ServerOption) (*Simulator, error) {
+	memoryUnitBytes, err := serverOptions.QuotaMemoryUnitBytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota memory unit: %w", err)
+	}
+	resourceAliases, err := serverOptions.QuotaResourceAliasTable()
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota resource aliases: %w", err)
+	}
+	gpuVendorResources, err := serverOptions.QuotaGPUVendorTable()
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota GPU vendor resources: %w", err)
+	}
+	treeRemap, err := serverOptions.QuotaTreeRemapTable()
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota tree remap: %w", err)
+	}
+	allocationDeadline, treeAllocationDeadlines, err := serverOptions.QuotaAllocationDeadlineTable()
+	if err != nil {
+		return nil, fmt.Errorf("invalid quota allocation deadline: %w", err)
+	}
+
+	appwrapperIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	qm := &QuotaManager{
+		appwrapperLister:              listersv1.NewAppWrapperLister(appwrapperIndexer),
+		preemptionEnabled:             true,
+		quotaManagerBackend:           qmbackend.NewManager(),
+		consumerSpecs:                 make(map[string]*qmbackendutils.JConsumerSpec),
+		missingDesignationGenerations: make(map[string]int64),
+		memoryUnit:                    serverOptions.QuotaMemoryUnit,
+		memoryUnitBytes:               memoryUnitBytes,
+		resourceAliases:               resourceAliases,
+		gpuVendorResources:            gpuVendorResources,
+		treeRemap:                     treeRemap,
+		allocationDeadline:            allocationDeadline,
+		treeAllocationDeadlines:       treeAllocationDeadlines,
+		annotationPrefix:              serverOptions.QuotaAnnotationPrefix,
+		allowQuotaExemption:           serverOptions.AllowQuotaExemption,
+		truncateCPUDemand:             serverOptions.QuotaCPURounding == options.QuotaCPURoundingTrunc,
+		preemptionOrder:               quota.PreemptionOrder(serverOptions.PreemptionOrder),
+		simulation:                    true,
+	}
+	qm.quotaManagerBackend.AddForest(QuotaManagerForestName)
+
+	qm.resourcePlanManager, err = rpmanager.NewStaticResourcePlanManager(quotaTreeFile, qm.quotaManagerBackend)
+	if err != nil {
+		return nil, err
+	}
+	if err := qm.updateForestFromCache(); err != nil {
+		return nil, fmt.Errorf("failure creating the quota trees: %w", err)
+	}
+	if err := qm.validateTreeUnits(); err != nil {
+		return nil, err
+	}
+	if err := qm.validateTreeNodes(); err != nil {
+		return nil, err
+	}
+	qm.quotaManagerBackend.SetMode(qmbackend.Normal)
+	qm.initializationDone = true
+
+	return &Simulator{
+		qm:                qm,
+		appwrapperIndexer: appwrapperIndexer,
+	}, nil
+}
+
+// Run replays the requests in time order, requests of the same time in the given order.  The AppWrappers
+// preempted by a quota evaluation are released before the next request, as by the controller.  The
+// allocations of a simulation are kept between runs.
+func (s *Simulator) Run(requests []SimulationRequest) (*SimulationReport, error) {
+	sorted := make([]SimulationRequest, len(requests))
+	copy(sorted, requests)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	report := &SimulationReport{}
+	for i, request := range sorted {
+		if request.AppWrapper == nil {
+			return report, fmt.Errorf("request %d at %v has no AppWrapper", i, request.Time)
+		}
+		aw := request.AppWrapper
+
+		if request.Release {
+			s.qm.Release(aw)
+			s.appwrapperIndexer.Delete(aw)
+			report.Releases++
+		} else {
+			if err := s.appwrapperIndexer.Add(aw); err != nil {
+				return report, fmt.Errorf("request %d at %v: %w", i, request.Time, err)
+			}
+			demand := request.Demand
+			if demand == nil {
+				demand = clusterstateapi.EmptyResource()
+			}
+
+			result, err := s.qm.Fits(context.Background(), aw, demand, nil)
+			if err != nil {
+				klog.V(4).Infof("[Simulator] Quota evaluation of %s/%s at %v failed, err=%v.", aw.Namespace, aw.Name, request.Time, err)
+			}
+			if result != nil && result.Fits {
+				report.Fits++
+				for _, target := range result.PreemptionTargets {
+					s.qm.Release(target)
+					s.appwrapperIndexer.Delete(target)
+					report.Preemptions++
+				}
+			} else {
+				report.Rejected++
+			}
+		}
+
+		report.Samples = append(report.Samples, s.sample(request.Time))
+	}
+
+	return report, nil
+}
+
+// sample returns the utilization of the quota trees at the given time.
+func (s *Simulator) sample(now time.Time) SimulationSample {
+	sample := SimulationSample{
+		Time:        now,
+		Allocated:   make(map[string]map[string]int),
+		Utilization: make(map[string]map[string]float64),
+	}
+
+	for treeName, groupAllocations := range s.qm.getGroupAllocations() {
+		sample.Allocated[treeName] = make(map[string]int)
+		for _, groupAllocation := range groupAllocations {
+			for resourceName, amount := range groupAllocation {
+				sample.Allocated[treeName][resourceName] += amount
+			}
+		}
+	}
+
+	for treeName, treeQuotas := range s.qm.getTreeQuotas() {
+		sample.Utilization[treeName] = make(map[string]float64)
+		for resourceName, treeQuota := range treeQuotas {
+			if treeQuota <= 0 {
+				continue
+			}
+			sample.Utilization[treeName][resourceName] = float64(sample.Allocated[treeName][resourceName]) / float64(treeQuota)
+		}
+	}
+
+	return sample
+}
@@ -0,0 +1,192 @@
+// +build private
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	"github.ibm.com/ai-foundation/quota-manager/quota/core"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	"k8s.io/klog/v2"
+)
+
+// Version of the quota snapshot format
+const QuotaSnapshotVersion = 1
+
+// QuotaSnapshot is the serialized state of the forest allocations.
+type QuotaSnapshot struct {
+	// Version of the snapshot format
+	Version int `json:"version"`
+	// Fingerprint of the tree structure the allocations were made in
+	TreeVersion string `json:"treeVersion"`
+	// Allocated consumers, sorted by consumer ID
+	Consumers []qmbackendutils.JConsumerSpec `json:"consumers"`
+}
+
+// getTreeVersion returns a fingerprint of the forest tree structure: the tree names, their resource
+// names and the node specs defined by the ResourcePlans.
+func (qm *QuotaManager) getTreeVersion() string {
+	treeNames := append([]string{}, qm.getTreeNames()...)
+	sort.Strings(treeNames)
+
+	treeNodeSpecs := qm.getTreeNodeSpecs()
+
+	hash := sha256.New()
+	for _, treeName := range treeNames {
+		fmt.Fprintf(hash, "tree:%s\n", treeName)
+
+		treeResourceNames, _ := qm.getTreeResourceNames(treeName)
+		resourceNames := append([]string{}, treeResourceNames...)
+		sort.Strings(resourceNames)
+		for _, resourceName := range resourceNames {
+			fmt.Fprintf(hash, "resource:%s\n", resourceName)
+		}
+
+		var nodeNames []string
+		for nodeName := range treeNodeSpecs[treeName] {
+			nodeNames = append(nodeNames, nodeName)
+		}
+		sort.Strings(nodeNames)
+		for _, nodeName := range nodeNames {
+			nodeSpec := treeNodeSpecs[treeName][nodeName]
+			fmt.Fprintf(hash, "node:%s parent:%s hard:%s\n", nodeName, nodeSpec.Parent, nodeSpec.Hard)
+
+			var quotaNames []string
+			for quotaName := range nodeSpec.Quota {
+				quotaNames = append(quotaNames, quotaName)
+			}
+			sort.Strings(quotaNames)
+			for _, quotaName := range quotaNames {
+				fmt.Fprintf(hash, "quota:%s=%s\n", quotaName, nodeSpec.Quota[quotaName])
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Snapshot serializes the current forest allocations so they can be restored with Restore, e.g. after a
+// controller restart.
+func (qm *QuotaManager) Snapshot() ([]byte, error) {
+	if qm.quotaManagerBackend == nil {
+		return nil, fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.RLock()
+	defer qm.mutex.RUnlock()
+
+	snapshot := QuotaSnapshot{
+		Version:     QuotaSnapshotVersion,
+		TreeVersion: qm.getTreeVersion(),
+		Consumers:   []qmbackendutils.JConsumerSpec{},
+	}
+
+	var consumerIDs []string
+	for consumerID := range qm.consumerSpecs {
+		if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(consumerID), consumerID) {
+			consumerIDs = append(consumerIDs, consumerID)
+		}
+	}
+	sort.Strings(consumerIDs)
+	for _, consumerID := range consumerIDs {
+		snapshot.Consumers = append(snapshot.Consumers, *qm.consumerSpecs[consumerID])
+	}
+
+	klog.V(4).Infof("[Snapshot] Quota snapshot of %d consumers with tree version %s created.",
+		len(snapshot.Consumers), snapshot.TreeVersion)
+	return json.Marshal(snapshot)
+}
+
+// Restore rebuilds the forest allocations from a snapshot created by Snapshot, replacing the current
+// allocations.  Snapshots of a different format or tree structure are rejected.  Consumers are allocated
+// with the backend in maintenance mode so the restored allocations do not preempt each other.
+func (qm *QuotaManager) Restore(data []byte) error {
+	if qm.quotaManagerBackend == nil {
+		return fmt.Errorf("no quota manager backend exists")
+	}
+	qm.mutex.Lock()
+	defer qm.mutex.Unlock()
+
+	var snapshot QuotaSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("invalid quota snapshot: %w", err)
+	}
+	if snapshot.Version != QuotaSnapshotVersion {
+		return fmt.Errorf("quota snapshot version %d is not supported, expected %d",
+			snapshot.Version, QuotaSnapshotVersion)
+	}
+	treeVersion := qm.getTreeVersion()
+	if snapshot.TreeVersion != treeVersion {
+		return fmt.Errorf("quota snapshot tree version %s does not match current tree version %s",
+			snapshot.TreeVersion, treeVersion)
+	}
+
+	// Release the current allocations
+	var consumerIDs []string
+	for consumerID := range qm.consumerSpecs {
+		consumerIDs = append(consumerIDs, consumerID)
+	}
+	sort.Strings(consumerIDs)
+	for _, consumerID := range consumerIDs {
+		qm.release(consumerID)
+	}
+
+	mode := qm.quotaManagerBackend.GetMode()
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	defer qm.quotaManagerBackend.SetMode(mode)
+
+	var err error
+	err = nil
+	for i := range snapshot.Consumers {
+		consumerSpec := snapshot.Consumers[i]
+		consumer := qmbackendutils.JConsumer{
+			Kind: qmbackendutils.DefaultConsumerKind,
+			Spec: consumerSpec,
+		}
+
+		consumerInfo, allocErr := qmbackend.NewConsumerInfo(consumer)
+		if allocErr == nil {
+			qm.quotaManagerBackend.AddConsumer(consumerInfo)
+			qm.setConsumerSpec(consumerSpec.ID, &consumerSpec)
+			var allocResponse *core.AllocationResponse
+			allocResponse, allocErr = qm.quotaManagerBackend.AllocateForest(qm.getConsumerForest(consumerSpec.ID), consumerSpec.ID)
+			if allocErr == nil && !allocResponse.IsAllocated() {
+				allocErr = fmt.Errorf("not allocated: %s", allocResponse.GetMessage())
+			}
+		}
+
+		if allocErr != nil {
+			klog.Errorf("[Restore] Failure restoring quota consumer %s, err=%v.", consumerSpec.ID, allocErr)
+			if err == nil {
+				err = fmt.Errorf("consumer: %s %s", consumerSpec.ID, allocErr.Error())
+			} else {
+				err = fmt.Errorf("%w; next error consumer: %s %s", err, consumerSpec.ID, allocErr.Error())
+			}
+		}
+	}
+
+	qm.invalidateFitsCache()
+	qm.updateQuotaMetrics()
+	klog.V(4).Infof("[Restore] Quota snapshot of %d consumers with tree version %s restored.",
+		len(snapshot.Consumers), snapshot.TreeVersion)
+	return err
+}
@@ -0,0 +1,3002 @@
+//go:build private
+// +build private
+
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/cmd/kar-controllers/app/options"
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	listersv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/client/listers/controller/v1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	rpmanager "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr"
+	rputil "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/qm_lib_backend_with_resplan_mgr/resplanmgr/util"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	qmbackend "github.ibm.com/ai-foundation/quota-manager/quota"
+	"github.ibm.com/ai-foundation/quota-manager/quota/core"
+	qmbackendutils "github.ibm.com/ai-foundation/quota-manager/quota/utils"
+	v1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulinglisters "k8s.io/client-go/listers/scheduling/v1"
+	"k8s.io/client-go/tools/cache"
+	rpv1 "sigs.k8s.io/scheduler-plugins/pkg/apis/resourceplan/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// buildQuotaManager creates a QuotaManager with an in-memory backend holding a single tree whose root
+// node has the given quota and one child node per group, each with the same quota as the root.
+func buildQuotaManager(t testing.TB, quota map[string]string, groups ...string) *QuotaManager {
+	backend := qmbackend.NewManager()
+	if err := backend.AddForest(QuotaManagerForestName); err != nil {
+		t.Fatalf("failed to add forest: %v", err)
+	}
+	if _, err := backend.AddTreeByName(testTreeName); err != nil {
+		t.Fatalf("failed to add tree: %v", err)
+	}
+	if err := backend.AddTreeToForest(QuotaManagerForestName, testTreeName); err != nil {
+		t.Fatalf("failed to add tree to forest: %v", err)
+	}
+
+	treeCache := backend.GetTreeCache(testTreeName)
+	for resourceName := range quota {
+		treeCache.AddResourceName(resourceName)
+	}
+	treeCache.AddNodeSpec(testRootNode, qmbackendutils.JNodeSpec{Parent: "nil", Quota: quota, Hard: "true"})
+	for _, group := range groups {
+		treeCache.AddNodeSpec(group, qmbackendutils.JNodeSpec{Parent: testRootNode, Quota: quota, Hard: "false"})
+	}
+
+	qm := &QuotaManager{
+		quotaManagerBackend:           backend,
+		resourcePlanManager:           &rpmanager.ResourcePlanManager{},
+		consumerSpecs:                 make(map[string]*qmbackendutils.JConsumerSpec),
+		missingDesignationGenerations: make(map[string]int64),
+		memoryUnit:                    "Mi",
+		memoryUnitBytes:               1024 * 1024,
+		loadBatchSize:                 LoadBatchSize,
+	}
+	qm.resourceAliases, _ = (&options.ServerOption{}).QuotaResourceAliasTable()
+	if err := qm.updateForestFromCache(); err != nil {
+		t.Fatalf("failed to update forest: %v", err)
+	}
+	backend.SetMode(qmbackend.Normal)
+	qm.initializationDone = true
+
+	return qm
+}
+
+// buildConsumerSpec creates the consumer spec of an AppWrapper of the default namespace requesting the
+// given resources from the given groups of the test tree, in order of preference.
+func buildConsumerSpec(name string, request map[string]int, groups ...string) *qmbackendutils.JConsumerSpec {
+	consumerID := util.CreateId("default", name)
+	consumerSpec := &qmbackendutils.JConsumerSpec{ID: consumerID}
+	for _, group := range groups {
+		consumerSpec.Trees = append(consumerSpec.Trees, qmbackendutils.JConsumerTreeSpec{
+			ID: consumerID, TreeName: testTreeName, GroupID: group, Request: request,
+		})
+	}
+	return consumerSpec
+}
+
+// buildDispatchedAppWrapper creates an AppWrapper of group team-a of the test tree dispatched at the given
+// time.
+func buildDispatchedAppWrapper(name string, dispatchedAt time.Time) *arbv1.AppWrapper {
+	aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+	aw.Status.Conditions = []arbv1.AppWrapperCondition{{
+		Type:                arbv1.AppWrapperCondDispatched,
+		LastUpdateMicroTime: metav1.NewMicroTime(dispatchedAt),
+	}}
+	return aw
+}
+
+func BenchmarkQuotaManager_GetQuotaDesignation(b *testing.B) {
+	qm := buildQuotaManager(b, map[string]string{"cpu": "10000"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	b.Run("cached tree names", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			qm.getQuotaDesignation(aw)
+		}
+	})
+
+	b.Run("uncached tree names", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			qm.invalidateTreeNames()
+			qm.getQuotaDesignation(aw)
+		}
+	})
+}
+
+// minimalResourcePlanProvider implements ResourcePlanProvider only, with no quota trees.
+type minimalResourcePlanProvider struct{}
+
+func (p *minimalResourcePlanProvider) IsResplanChanged() bool {
+	return false
+}
+
+func (p *minimalResourcePlanProvider) LoadResourcePlansIntoBackend() error {
+	return nil
+}
+
+// memoryUnitsProvider is a ResourcePlanProvider with fixed tree memory units.
+type memoryUnitsProvider struct {
+	*rpmanager.ResourcePlanManager
+	treeMemoryUnits map[string][]string
+}
+
+func (p *memoryUnitsProvider) GetTreeMemoryUnits() map[string][]string {
+	return p.treeMemoryUnits
+}
+
+func TestNewQuotaManagerWithBackend(t *testing.T) {
+	backend := qmbackend.NewManager()
+	if _, err := backend.AddTreeByName(testTreeName); err != nil {
+		t.Fatalf("failed to add tree: %v", err)
+	}
+	backend.GetTreeCache(testTreeName).AddResourceName("cpu")
+	backend.GetTreeCache(testTreeName).AddNodeSpec(testRootNode,
+		qmbackendutils.JNodeSpec{Parent: "nil", Quota: map[string]string{"cpu": "10"}, Hard: "true"})
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	serverOptions := &options.ServerOption{QuotaMemoryUnit: "Mi"}
+	qm, err := NewQuotaManagerWithBackend(backend, &rpmanager.ResourcePlanManager{},
+		listersv1.NewAppWrapperLister(indexer), serverOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.quotaManagerBackend != backend || !qm.initializationDone {
+		t.Errorf("expected an initialized quota manager of the injected backend, got %+v", qm)
+	}
+	if mode := backend.GetMode(); mode != qmbackend.Normal {
+		t.Errorf("expected backend in normal mode, got %v", mode)
+	}
+
+	// Providers implementing ResourcePlanProvider only are supported
+	qm, err = NewQuotaManagerWithBackend(backend, &minimalResourcePlanProvider{}, listersv1.NewAppWrapperLister(indexer),
+		serverOptions)
+	if err != nil || qm == nil {
+		t.Fatalf("unexpected error with a minimal ResourcePlan provider: %v", err)
+	}
+	if treeNodeSpecs := qm.getTreeNodeSpecs(); treeNodeSpecs != nil {
+		t.Errorf("expected no tree node specs from a minimal ResourcePlan provider, got %v", treeNodeSpecs)
+	}
+
+	// Quota trees in unsupported units are rejected
+	unitsProvider := &memoryUnitsProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		treeMemoryUnits:     map[string][]string{testTreeName: {"Gi"}},
+	}
+	if qm, err := NewQuotaManagerWithBackend(backend, unitsProvider, listersv1.NewAppWrapperLister(indexer),
+		serverOptions); qm != nil || !errors.Is(err, quota.ErrUnsupportedTreeUnit) {
+		t.Errorf("expected an unsupported tree unit error, got %v", err)
+	}
+
+	// Missing dependencies are rejected
+	if qm, err := NewQuotaManagerWithBackend(nil, &rpmanager.ResourcePlanManager{}, nil, serverOptions); qm != nil || err == nil {
+		t.Errorf("expected an error without backend, got %v", err)
+	}
+	if qm, err := NewQuotaManagerWithBackend(backend, nil, nil, serverOptions); qm != nil || err == nil {
+		t.Errorf("expected an error without ResourcePlan provider, got %v", err)
+	}
+}
+
+func TestQuotaManager_GetTreeNamesCached(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	for i := 0; i < 10000; i++ {
+		if _, _, err := qm.getQuotaDesignation(aw); err != nil {
+			t.Fatalf("unexpected quota designation error: %v", err)
+		}
+	}
+
+	// Cached names must only be refreshed from the backend after a forest refresh
+	cached := qm.treeNames
+	if len(cached) != 1 || cached[0] != testTreeName {
+		t.Errorf("expected cached tree names [%s], got %v", testTreeName, cached)
+	}
+	if err := qm.updateForestFromCache(); err != nil {
+		t.Fatalf("failed to update forest: %v", err)
+	}
+	if qm.treeNames != nil {
+		t.Errorf("expected cached tree names to be invalidated after forest refresh, got %v", qm.treeNames)
+	}
+}
+
+func TestQuotaManager_GetQuotaTreeResourceTypesDemands(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	sharedGPU := v1.ResourceName("nvidia.com/gpu-shared")
+
+	tests := []struct {
+		name          string
+		demand        *clusterstateapi.Resource
+		resourceTypes []string
+		expected      map[string]int
+	}{
+		{
+			name:          "half shared gpu",
+			demand:        clusterstateapi.NewResource(v1.ResourceList{sharedGPU: resource.MustParse("0.5")}),
+			resourceTypes: []string{string(sharedGPU)},
+			expected:      map[string]int{string(sharedGPU): 500},
+		},
+		{
+			name:          "smallest shared gpu fraction",
+			demand:        clusterstateapi.NewResource(v1.ResourceList{sharedGPU: resource.MustParse("1m")}),
+			resourceTypes: []string{string(sharedGPU)},
+			expected:      map[string]int{string(sharedGPU): 1},
+		},
+		{
+			name: "cpu and whole gpu",
+			demand: clusterstateapi.NewResource(v1.ResourceList{
+				v1.ResourceCPU:                  resource.MustParse("1500m"),
+				clusterstateapi.GPUResourceName: resource.MustParse("2"),
+			}),
+			resourceTypes: []string{"cpu", "gpu"},
+			expected:      map[string]int{"cpu": 1500, "gpu": 2},
+		},
+		{
+			name: "gpu count and gpu memory",
+			demand: clusterstateapi.NewResource(v1.ResourceList{
+				clusterstateapi.GPUResourceName: resource.MustParse("2"),
+				"nvidia.com/gpu-memory":         resource.MustParse("24"),
+				v1.ResourceMemory:               resource.MustParse("1Gi"),
+			}),
+			resourceTypes: []string{"gpu", "nvidia.com/gpu-memory", "memory"},
+			expected:      map[string]int{"gpu": 2, "nvidia.com/gpu-memory": 24, "memory": 1024},
+		},
+		{
+			name: "gpu does not match gpu memory",
+			demand: clusterstateapi.NewResource(v1.ResourceList{
+				"nvidia.com/gpu-memory": resource.MustParse("24"),
+			}),
+			resourceTypes: []string{"gpu", "gpu-memory"},
+			expected:      map[string]int{"gpu": 0, "gpu-memory": 24},
+		},
+		{
+			name: "gpu memory does not match gpu",
+			demand: clusterstateapi.NewResource(v1.ResourceList{
+				clusterstateapi.GPUResourceName: resource.MustParse("2"),
+			}),
+			resourceTypes: []string{"gpu", "gpu-memory"},
+			expected:      map[string]int{"gpu": 2, "gpu-memory": 0},
+		},
+		{
+			name: "cpu aliases",
+			demand: clusterstateapi.NewResource(v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("2"),
+			}),
+			resourceTypes: []string{"vcpu", "Cores", "nvidia-cpu-shim"},
+			expected:      map[string]int{"vcpu": 2000, "Cores": 2000, "nvidia-cpu-shim": 0},
+		},
+	}
+
+	for i, test := range tests {
+		demands, err := qm.getQuotaTreeResourceTypesDemands(test.demand, test.resourceTypes)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if !reflect.DeepEqual(demands, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, demands)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaTreeResourceTypesDemandsGPUVendors(t *testing.T) {
+	demand := clusterstateapi.NewResource(v1.ResourceList{
+		"nvidia.com/gpu": resource.MustParse("2"),
+		"amd.com/gpu":    resource.MustParse("3"),
+	})
+	resourceTypes := []string{"gpu", "nvidia-gpu", "amd-gpu", "any-gpu"}
+
+	tests := []struct {
+		name            string
+		vendorResources string
+		expected        map[string]int
+	}{
+		{
+			name:            "default gpu matching",
+			vendorResources: "",
+			expected:        map[string]int{"gpu": 2, "nvidia-gpu": 0, "amd-gpu": 0, "any-gpu": 0},
+		},
+		{
+			name:            "vendor specific resource types",
+			vendorResources: "nvidia-gpu=nvidia.com/gpu, AMD-GPU=amd.com/gpu",
+			expected:        map[string]int{"gpu": 2, "nvidia-gpu": 2, "amd-gpu": 3, "any-gpu": 0},
+		},
+		{
+			name:            "resource type of several vendors",
+			vendorResources: "any-gpu=nvidia.com/gpu|amd.com/gpu",
+			expected:        map[string]int{"gpu": 2, "nvidia-gpu": 0, "amd-gpu": 0, "any-gpu": 5},
+		},
+	}
+
+	for i, test := range tests {
+		serverOptions := &options.ServerOption{QuotaGPUVendorResources: test.vendorResources}
+		qm := &QuotaManager{memoryUnit: "bytes", memoryUnitBytes: 1}
+		qm.resourceAliases, _ = serverOptions.QuotaResourceAliasTable()
+		vendorResources, err := serverOptions.QuotaGPUVendorTable()
+		if err != nil {
+			t.Fatalf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		qm.gpuVendorResources = vendorResources
+
+		demands, _ := qm.getQuotaTreeResourceTypesDemands(demand, resourceTypes)
+		if !reflect.DeepEqual(demands, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, demands)
+		}
+	}
+
+	for _, invalid := range []string{"nvidia-gpu", "=nvidia.com/gpu", "nvidia-gpu=", "any-gpu=nvidia.com/gpu||amd.com/gpu"} {
+		if _, err := (&options.ServerOption{QuotaGPUVendorResources: invalid}).QuotaGPUVendorTable(); err == nil {
+			t.Errorf("expected error for GPU vendor resources %q", invalid)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaTreeResourceTypesDemandsPhysicalGPU(t *testing.T) {
+	qm := &QuotaManager{memoryUnit: "bytes", memoryUnitBytes: 1}
+	qm.resourceAliases, _ = (&options.ServerOption{}).QuotaResourceAliasTable()
+	qm.SetGPUSharingFactor(4)
+	resourceTypes := []string{"gpu", "physical-gpu"}
+
+	tests := []struct {
+		name     string
+		replicas string
+		expected map[string]int
+	}{
+		{name: "no replicas", replicas: "0", expected: map[string]int{"gpu": 0, "physical-gpu": 0}},
+		{name: "single replica", replicas: "1", expected: map[string]int{"gpu": 1, "physical-gpu": 1}},
+		{name: "replicas of one physical GPU", replicas: "4", expected: map[string]int{"gpu": 4, "physical-gpu": 1}},
+		{name: "replicas spilling over a physical GPU", replicas: "5", expected: map[string]int{"gpu": 5, "physical-gpu": 2}},
+		{name: "replicas of two physical GPUs", replicas: "8", expected: map[string]int{"gpu": 8, "physical-gpu": 2}},
+	}
+
+	for i, test := range tests {
+		demand := clusterstateapi.NewResource(v1.ResourceList{"nvidia.com/gpu": resource.MustParse(test.replicas)})
+		demands, err := qm.getQuotaTreeResourceTypesDemands(demand, resourceTypes)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if !reflect.DeepEqual(demands, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, demands)
+		}
+	}
+
+	// Without time-slicing a replica is a physical GPU
+	qm.SetGPUSharingFactor(0)
+	demand := clusterstateapi.NewResource(v1.ResourceList{"nvidia.com/gpu": resource.MustParse("5")})
+	if demands, _ := qm.getQuotaTreeResourceTypesDemands(demand, resourceTypes); demands["physical-gpu"] != 5 {
+		t.Errorf("expected 5 physical GPUs without time-slicing, got %v", demands)
+	}
+}
+
+func TestQuotaManager_SetGPUSharingFactor(t *testing.T) {
+	qm := &QuotaManager{}
+
+	tests := []struct {
+		name       string
+		factor     int
+		expected   int
+		generation uint64
+	}{
+		{name: "unset factor", factor: 1, expected: 1, generation: 0},
+		{name: "changed factor", factor: 4, expected: 4, generation: 1},
+		{name: "unchanged factor", factor: 4, expected: 4, generation: 1},
+		{name: "invalid factor", factor: 0, expected: 1, generation: 2},
+	}
+
+	for i, test := range tests {
+		qm.SetGPUSharingFactor(test.factor)
+		if qm.getGPUSharingFactor() != test.expected || qm.forestGeneration != test.generation {
+			t.Errorf("case %d (%s): \n expected factor %d in generation %d, \n got factor %d in generation %d \n",
+				i, test.name, test.expected, test.generation, qm.getGPUSharingFactor(), qm.forestGeneration)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaTreeResourceTypesDemandsConversionError(t *testing.T) {
+	qm := &QuotaManager{memoryUnit: "bytes", memoryUnitBytes: 1}
+	qm.resourceAliases, _ = (&options.ServerOption{}).QuotaResourceAliasTable()
+
+	demand := clusterstateapi.EmptyResource()
+	demand.Memory = 1e30
+	demands, err := qm.getQuotaTreeResourceTypesDemands(demand, []string{"example.com/dev", "memory"})
+
+	var conversionErr *quota.DemandConversionError
+	if !errors.As(err, &conversionErr) {
+		t.Fatalf("expected demand conversion error, got %v", err)
+	}
+	expected := []quota.DemandConversionFailure{
+		{ResourceName: "example.com/dev", Reason: quota.DemandMissing, Value: 0},
+		{ResourceName: "memory", Reason: quota.DemandOverflow, Value: MaxInt},
+	}
+	if len(conversionErr.Failures) != len(expected) {
+		t.Fatalf("failures: \n expected %v, \n got %v \n", expected, conversionErr.Failures)
+	}
+	for i, failure := range conversionErr.Failures {
+		if failure.ResourceName != expected[i].ResourceName || failure.Reason != expected[i].Reason ||
+			failure.Value != expected[i].Value {
+			t.Errorf("failure %d: \n expected %v, \n got %v \n", i, expected[i], failure)
+		}
+	}
+	if overflows := conversionErr.FailuresWithReason(quota.DemandOverflow); len(overflows) != 1 {
+		t.Errorf("expected a single overflow, got %v", overflows)
+	}
+	if demands["memory"] != MaxInt {
+		t.Errorf("expected memory demand to be clamped to %d, got %d", MaxInt, demands["memory"])
+	}
+}
+
+func TestQuotaManager_ConvertDemandOverflow(t *testing.T) {
+	qm := &QuotaManager{}
+	maxFloat := float64(MaxInt)
+	belowMaxFloat := math.Nextafter(maxFloat, 0)
+
+	tests := []struct {
+		name     string
+		convert  func() (int, error)
+		expected int
+		overflow bool
+	}{
+		{
+			name:     "int64 MaxInt",
+			convert:  func() (int, error) { return qm.convertInt64Demand(int64(MaxInt)) },
+			expected: MaxInt,
+		},
+		{
+			name:     "float64 below MaxInt",
+			convert:  func() (int, error) { return qm.convertFloat64Demand(belowMaxFloat) },
+			expected: int(belowMaxFloat),
+		},
+		{
+			name:     "float64 MaxInt",
+			convert:  func() (int, error) { return qm.convertFloat64Demand(maxFloat) },
+			expected: MaxInt,
+			overflow: true,
+		},
+		{
+			name:     "float64 above MaxInt",
+			convert:  func() (int, error) { return qm.convertFloat64Demand(1e30) },
+			expected: MaxInt,
+			overflow: true,
+		},
+		{
+			name:     "float64 fraction",
+			convert:  func() (int, error) { return qm.convertFloat64Demand(2.5) },
+			expected: 2,
+		},
+	}
+
+	for i, test := range tests {
+		demand, err := test.convert()
+		if demand != test.expected {
+			t.Errorf("case %d (%s): \n expected %d, \n got %d \n", i, test.name, test.expected, demand)
+		}
+		var overflowErr *quota.DemandOverflowError
+		if overflow := errors.As(err, &overflowErr); overflow != test.overflow {
+			t.Errorf("case %d (%s): \n expected overflow %v, \n got error %v \n", i, test.name, test.overflow, err)
+		} else if overflow && overflowErr.Max != MaxInt {
+			t.Errorf("case %d (%s): \n expected max %d, \n got %d \n", i, test.name, MaxInt, overflowErr.Max)
+		}
+	}
+}
+
+func TestQuotaManager_BuildRequestDemandOverflow(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	demand := clusterstateapi.EmptyResource()
+	demand.MilliCPU = float64(MaxInt)
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, demand)
+	var overflowErr *quota.DemandOverflowError
+	if !errors.As(err, &overflowErr) {
+		t.Fatalf("expected demand overflow error, got %v and consumer %v", err, consumerSpec)
+	}
+	if overflowErr.ResourceName != "cpu" {
+		t.Errorf("expected overflow of resource type cpu, got %s", overflowErr.ResourceName)
+	}
+
+	result, err := qm.Fits(context.Background(), aw, demand, nil)
+	if !errors.As(err, &overflowErr) || result == nil || result.Fits || result.Reason != quota.InvalidRequest {
+		t.Errorf("expected overflowing demand to be rejected as an invalid request, got %v, err=%v", result, err)
+	}
+	if len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no registered consumers, got %v", qm.consumerSpecs)
+	}
+
+	// The largest demand below the backend size is requested as is
+	demand.MilliCPU = math.Nextafter(float64(MaxInt), 0)
+	if _, err := qm.buildRequest(context.Background(), aw, demand); err != nil {
+		t.Errorf("unexpected error building request: %v", err)
+	}
+}
+
+func TestQuotaManager_Preempt(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	targets := []*arbv1.AppWrapper{
+		buildAppWrapper("aw-1", map[string]string{testTreeName: "team-a"}),
+		buildAppWrapper("aw-2", map[string]string{testTreeName: "team-a"}),
+	}
+	for _, aw := range targets {
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		released, err := qm.Preempt(targets)
+		if err != nil {
+			t.Errorf("attempt %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(released, targets) {
+			t.Errorf("attempt %d: \n expected %v, \n got %v \n", i, targets, released)
+		}
+	}
+	if len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no registered consumers after preemption, got %v", qm.consumerSpecs)
+	}
+
+	// A target registered but not allocated holds no quota to release
+	unallocated := buildAppWrapper("aw-3", map[string]string{testTreeName: "team-a"})
+	unallocatedID := util.CreateId(unallocated.Namespace, unallocated.Name)
+	qm.setConsumerSpec(unallocatedID, buildConsumerSpec("aw-3", map[string]int{"cpu": 1000}, "team-a"))
+	released, err := qm.Preempt([]*arbv1.AppWrapper{unallocated})
+	if err != nil || !reflect.DeepEqual(released, []*arbv1.AppWrapper{unallocated}) {
+		t.Errorf("expected unallocated target to be released, got %v, err=%v", released, err)
+	}
+}
+
+func TestQuotaManager_BuildRequestFallbackGroups(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a", "team-b")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a, team-b"})
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, demand)
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+
+	alternatives := getConsumerAlternatives(consumerSpec)
+	expected := []string{"team-a", "team-b"}
+	if len(alternatives) != len(expected) {
+		t.Fatalf("expected %d alternatives, got %d", len(expected), len(alternatives))
+	}
+	for i, alternative := range alternatives {
+		if len(alternative.Trees) != 1 || alternative.Trees[0].GroupID != expected[i] {
+			t.Errorf("alternative %d: \n expected group %v, \n got %v \n", i, expected[i], alternative.Trees)
+		}
+	}
+}
+
+func TestQuotaManager_BuildRequestSkipsZeroDemandTrees(t *testing.T) {
+	const gpuTreeName = "gpu-context"
+
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	backend := qm.quotaManagerBackend
+	if _, err := backend.AddTreeByName(gpuTreeName); err != nil {
+		t.Fatalf("failed to add tree: %v", err)
+	}
+	if err := backend.AddTreeToForest(QuotaManagerForestName, gpuTreeName); err != nil {
+		t.Fatalf("failed to add tree to forest: %v", err)
+	}
+	treeCache := backend.GetTreeCache(gpuTreeName)
+	treeCache.AddResourceName("nvidia.com/gpu")
+	treeCache.AddNodeSpec(testRootNode, qmbackendutils.JNodeSpec{Parent: "nil", Quota: map[string]string{"nvidia.com/gpu": "8"}, Hard: "true"})
+	treeCache.AddNodeSpec("gpu-team", qmbackendutils.JNodeSpec{Parent: testRootNode, Quota: map[string]string{"nvidia.com/gpu": "8"}, Hard: "false"})
+	if err := qm.updateForestFromCache(); err != nil {
+		t.Fatalf("failed to update forest: %v", err)
+	}
+	backend.SetMode(qmbackend.Normal)
+
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a", gpuTreeName: "gpu-team"})
+
+	// Only the CPU tree is requested by an AppWrapper demanding CPU
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	consumerSpec, err := qm.buildRequest(context.Background(), aw, demand)
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+	if len(consumerSpec.Trees) != 1 || consumerSpec.Trees[0].TreeName != testTreeName {
+		t.Errorf("CPU demand: \n expected only tree %s, \n got %v \n", testTreeName, consumerSpec.Trees)
+	}
+
+	// An AppWrapper demanding nothing stays present in all its designated trees
+	consumerSpec, err = qm.buildRequest(context.Background(), aw, clusterstateapi.EmptyResource())
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+	if len(consumerSpec.Trees) != 2 {
+		t.Errorf("empty demand: \n expected 2 trees, \n got %v \n", consumerSpec.Trees)
+	}
+}
+
+func TestQuotaManager_FitsWithDemand(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	// The supplied demands are requested as is
+	perTreeDemands := map[string]map[string]int{testTreeName: {"cpu": 1234}}
+	consumerSpec, err := qm.buildRequestWithDemands(context.Background(), aw, perTreeDemands)
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+	if len(consumerSpec.Trees) != 1 || !reflect.DeepEqual(consumerSpec.Trees[0].Request, perTreeDemands[testTreeName]) {
+		t.Errorf("supplied demands: \n expected %v, \n got %v \n", perTreeDemands, consumerSpec.Trees)
+	}
+
+	// Demands of trees missing from the forest are rejected
+	result, err := qm.FitsWithDemand(aw, map[string]map[string]int{"unknown-tree": {"cpu": 1000}}, nil)
+	if err == nil || result.Fits || result.Reason != quota.InvalidRequest {
+		t.Errorf("expected demands of an unknown tree to be rejected, got %v, err=%v", result, err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers, got %v", consumers)
+	}
+}
+
+func TestQuotaManager_SnapshotRestore(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+
+	data, err := qm.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected snapshot error: %v", err)
+	}
+
+	restored := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+	if !reflect.DeepEqual(restored.consumerSpecs, qm.consumerSpecs) {
+		t.Errorf("restored consumers: \n expected %v, \n got %v \n", qm.consumerSpecs, restored.consumerSpecs)
+	}
+
+	// Snapshots of a different tree structure are rejected
+	incompatible := buildQuotaManager(t, map[string]string{"cpu": "10000", "memory": "1000"}, "team-a")
+	if err := incompatible.Restore(data); err == nil {
+		t.Errorf("expected error restoring snapshot of a different tree structure")
+	}
+}
+
+func TestQuotaManager_ConvertMemoryDemand(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"memory": "10000"}, "team-a")
+
+	tests := []struct {
+		name     string
+		unit     string
+		bytes    float64
+		expected int
+	}{
+		{
+			name:     "no memory",
+			unit:     "M",
+			bytes:    0,
+			expected: 0,
+		},
+		{
+			name:     "just below one megabyte",
+			unit:     "M",
+			bytes:    999999,
+			expected: 1,
+		},
+		{
+			name:     "one megabyte",
+			unit:     "M",
+			bytes:    1000000,
+			expected: 1,
+		},
+		{
+			name:     "one and a half megabytes",
+			unit:     "M",
+			bytes:    1500000,
+			expected: 2,
+		},
+		{
+			name:     "one and a half mebibytes",
+			unit:     "Mi",
+			bytes:    1.5 * 1024 * 1024,
+			expected: 2,
+		},
+		{
+			name:     "two gibibytes",
+			unit:     "Gi",
+			bytes:    2 * 1024 * 1024 * 1024,
+			expected: 2,
+		},
+		{
+			name:     "bytes",
+			unit:     "bytes",
+			bytes:    1500000,
+			expected: 1500000,
+		},
+	}
+
+	for i, test := range tests {
+		serverOptions := &options.ServerOption{QuotaMemoryUnit: test.unit}
+		qm.memoryUnit = test.unit
+		qm.memoryUnitBytes, _ = serverOptions.QuotaMemoryUnitBytes()
+		demand, err := qm.convertMemoryDemand(test.bytes)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if demand != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, demand)
+		}
+	}
+}
+
+func TestQuotaManager_ConvertCPUDemand(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+
+	tests := []struct {
+		name     string
+		rounding string
+		milliCPU float64
+		expected int
+	}{
+		{name: "0.4 millicores rounded up", rounding: options.QuotaCPURoundingCeil, milliCPU: 0.4, expected: 1},
+		{name: "0.5 millicores rounded up", rounding: options.QuotaCPURoundingCeil, milliCPU: 0.5, expected: 1},
+		{name: "1.6 millicores rounded up", rounding: options.QuotaCPURoundingCeil, milliCPU: 1.6, expected: 2},
+		{name: "0.4 millicores truncated", rounding: options.QuotaCPURoundingTrunc, milliCPU: 0.4, expected: 0},
+		{name: "0.5 millicores truncated", rounding: options.QuotaCPURoundingTrunc, milliCPU: 0.5, expected: 0},
+		{name: "1.6 millicores truncated", rounding: options.QuotaCPURoundingTrunc, milliCPU: 1.6, expected: 1},
+	}
+
+	for i, test := range tests {
+		qm.truncateCPUDemand = test.rounding == options.QuotaCPURoundingTrunc
+		demand, err := qm.convertCPUDemand(test.milliCPU)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if demand != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, demand)
+		}
+	}
+}
+
+func TestQuotaManager_ListConsumers(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	for _, name := range []string{"aw-2", "aw-1"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+		}
+	}
+
+	consumers, err := qm.ListConsumers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{util.CreateId("default", "aw-1"), util.CreateId("default", "aw-2")}
+	if !reflect.DeepEqual(consumers, expected) {
+		t.Errorf("consumers: \n expected %v, \n got %v \n", expected, consumers)
+	}
+}
+
+func TestQuotaManager_LoadDispatchedAWs(t *testing.T) {
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	dispatchedAWDemands := make(map[string]*clusterstateapi.Resource)
+	dispatchedAWs := make(map[string]*arbv1.AppWrapper)
+	var expected []string
+	for i := 0; i < 20; i++ {
+		aw := buildAppWrapper(fmt.Sprintf("aw-%02d", i), map[string]string{testTreeName: "team-a"})
+		aw.Status.CanRun = true
+		awID := util.CreateId(aw.Namespace, aw.Name)
+		dispatchedAWDemands[awID] = demand
+		dispatchedAWs[awID] = aw
+		expected = append(expected, aw.Namespace+"/"+aw.Name)
+	}
+
+	// All AppWrappers are replayed by the workers
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.loadWorkers = 4
+	if err := qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs); err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != len(dispatchedAWs) {
+		t.Errorf("expected %d consumers, got %v", len(dispatchedAWs), consumers)
+	}
+	if done, total := qm.LoadProgress(); done != len(dispatchedAWs) || total != len(dispatchedAWs) {
+		t.Errorf("load progress: \n expected %d of %d, \n got %d of %d \n", len(dispatchedAWs), len(dispatchedAWs), done, total)
+	}
+
+	// No AppWrapper is replayed after the timeout expired
+	qm = buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.loadWorkers = 4
+	qm.loadTimeout = time.Nanosecond
+	err := qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs)
+	var partialLoadErr *quota.PartialLoadError
+	if !errors.As(err, &partialLoadErr) {
+		t.Fatalf("expected partial load error, got %v", err)
+	}
+	if !reflect.DeepEqual(partialLoadErr.UnreplayedAppWrappers, expected) {
+		t.Errorf("unreplayed AppWrappers: \n expected %v, \n got %v \n", expected, partialLoadErr.UnreplayedAppWrappers)
+	}
+	if done, total := qm.LoadProgress(); done != 0 || total != len(dispatchedAWs) {
+		t.Errorf("load progress: \n expected %d of %d, \n got %d of %d \n", 0, len(dispatchedAWs), done, total)
+	}
+
+	// AppWrappers above the quota are not replayed and reported as failed
+	qm = buildQuotaManager(t, map[string]string{"cpu": "5000"}, "team-a")
+	qm.loadWorkers = 4
+	err = qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs)
+	if !errors.As(err, &partialLoadErr) {
+		t.Fatalf("expected partial load error, got %v", err)
+	}
+	if len(partialLoadErr.UnreplayedAppWrappers) != 0 || len(partialLoadErr.FailedAppWrappers) != len(dispatchedAWs)-5 {
+		t.Errorf("expected %d failed AppWrappers and none unreplayed, got %v failed and %v unreplayed",
+			len(dispatchedAWs)-5, partialLoadErr.FailedAppWrappers, partialLoadErr.UnreplayedAppWrappers)
+	}
+
+	// The timeout bounds the allocations in progress, released once they complete
+	defaultAllocateForest := allocateForest
+	defer func() { allocateForest = defaultAllocateForest }()
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		time.Sleep(50 * time.Millisecond)
+		return defaultAllocateForest(backend, forestName, consumerID)
+	}
+	qm = buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.loadWorkers = 4
+	qm.loadBatchSize = 1
+	qm.loadTimeout = 20 * time.Millisecond
+	if err := qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs); !errors.As(err, &partialLoadErr) {
+		t.Fatalf("expected partial load error, got %v", err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 || len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no consumer, got backend consumers %v and specs %v", consumers, qm.consumerSpecs)
+	}
+}
+
+func BenchmarkQuotaManager_LoadDispatchedAWs(b *testing.B) {
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	dispatchedAWDemands := make(map[string]*clusterstateapi.Resource)
+	dispatchedAWs := make(map[string]*arbv1.AppWrapper)
+	for i := 0; i < 1000; i++ {
+		aw := buildAppWrapper(fmt.Sprintf("aw-%04d", i), map[string]string{testTreeName: "team-a"})
+		aw.Status.CanRun = true
+		awID := util.CreateId(aw.Namespace, aw.Name)
+		dispatchedAWDemands[awID] = demand
+		dispatchedAWs[awID] = aw
+	}
+	for _, bm := range []struct {
+		name      string
+		batchSize int
+	}{
+		{"batched", 100},
+		{"per-item", 1},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				qm := buildQuotaManager(b, map[string]string{"cpu": "1000000"}, "team-a")
+				qm.loadBatchSize = bm.batchSize
+				b.StartTimer()
+				if err := qm.loadDispatchedAWs(dispatchedAWDemands, dispatchedAWs); err != nil {
+					b.Fatalf("unexpected load error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaManager_Drain(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	for _, name := range []string{"aw-1", "aw-2", "aw-3"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+		}
+	}
+	if allocated := testutil.ToFloat64(quotaTreeAllocated.WithLabelValues(testTreeName, "cpu")); allocated != 3000 {
+		t.Fatalf("expected 3000 cpu allocated before drain, got %v", allocated)
+	}
+
+	if err := qm.Drain(); err != nil {
+		t.Fatalf("unexpected drain error: %v", err)
+	}
+
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers after drain, got %v", consumers)
+	}
+	if len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no consumer specs after drain, got %v", qm.consumerSpecs)
+	}
+	if allocated := testutil.ToFloat64(quotaTreeAllocated.WithLabelValues(testTreeName, "cpu")); allocated != 0 {
+		t.Errorf("expected no cpu allocated after drain, got %v", allocated)
+	}
+}
+
+// TestQuotaManager_ConcurrentFitsRelease evaluates and releases AppWrappers from concurrent goroutines,
+// to be run with the race detector.
+func TestQuotaManager_ConcurrentFitsRelease(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			aw := buildAppWrapper(fmt.Sprintf("aw-%d", i), map[string]string{testTreeName: "team-a"})
+			for j := 0; j < 20; j++ {
+				if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+					t.Errorf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+					return
+				}
+				qm.ListConsumers()
+				if !qm.Release(aw) {
+					t.Errorf("expected %s to be released", aw.Name)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers after the releases, got %v", consumers)
+	}
+	if len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no consumer specs after the releases, got %v", qm.consumerSpecs)
+	}
+}
+
+func TestQuotaManager_ReleaseWithResult(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+
+	tests := []struct {
+		name     string
+		release  func() quota.ReleaseResult
+		expected quota.ReleaseResult
+	}{
+		{
+			name:     "allocated consumer",
+			release:  func() quota.ReleaseResult { return qm.ReleaseWithResult(aw) },
+			expected: quota.Released,
+		},
+		{
+			name:     "consumer already released",
+			release:  func() quota.ReleaseResult { return qm.ReleaseWithResult(aw) },
+			expected: quota.NotFound,
+		},
+		{
+			name:     "unknown consumer",
+			release:  func() quota.ReleaseResult { return qm.ReleaseByIDWithResult(util.CreateId("default", "unknown")) },
+			expected: quota.NotFound,
+		},
+		{
+			name:     "empty consumer id",
+			release:  func() quota.ReleaseResult { return qm.ReleaseByIDWithResult("") },
+			expected: quota.ReleaseError,
+		},
+	}
+	for i, tc := range tests {
+		if got := tc.release(); got != tc.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestQuotaManager_Reserve(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	qm.appwrapperLister = listersv1.NewAppWrapperLister(indexer)
+	reserved := buildAppWrapper("reserved", map[string]string{testTreeName: "team-a"})
+	other := buildAppWrapper("other", map[string]string{testTreeName: "team-a"})
+	indexer.Add(reserved)
+	indexer.Add(other)
+	reservedID := util.CreateId(reserved.Namespace, reserved.Name)
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("6")})
+	largeDemand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("12")})
+
+	if err := qm.Reserve(reservedID, demand, time.Hour, false); err != nil {
+		t.Fatalf("unexpected error reserving quota: %v", err)
+	}
+	if err := qm.Reserve(reservedID, demand, time.Hour, false); err == nil {
+		t.Errorf("expected error reserving quota of a registered consumer")
+	}
+	if err := qm.Reserve(util.CreateId("default", "unknown"), demand, time.Hour, true); err == nil {
+		t.Errorf("expected error reserving quota of an unknown AppWrapper")
+	}
+	if err := qm.Unreserve(util.CreateId("default", "unknown")); err == nil {
+		t.Errorf("expected error releasing an unknown reservation")
+	}
+
+	// The reservation counts against the tree and is not preempted
+	if result, _ := qm.Fits(context.Background(), other, demand, nil); result.Fits {
+		t.Errorf("expected %s not to fit next to the reservation, got %v", other.Name, result)
+	}
+
+	// A demand not fitting restores the reservation
+	if result, _ := qm.Fits(context.Background(), reserved, largeDemand, nil); result.Fits {
+		t.Errorf("expected %s not to fit with a larger demand, got %v", reserved.Name, result)
+	}
+	if _, found := qm.reservations[reservedID]; !found ||
+		!qm.quotaManagerBackend.IsAllocatedForest(QuotaManagerForestName, reservedID) {
+		t.Errorf("expected the reservation to be restored")
+	}
+
+	// The reservation is converted without counting the reserved quota twice
+	if result, err := qm.Fits(context.Background(), reserved, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit in its reservation, got %v, err=%v", reserved.Name, result, err)
+	}
+	if _, found := qm.reservations[reservedID]; found {
+		t.Errorf("expected the reservation to be converted")
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 1 {
+		t.Errorf("expected a single consumer, got %v", consumers)
+	}
+	if err := qm.Unreserve(reservedID); err == nil {
+		t.Errorf("expected error releasing a converted reservation")
+	}
+	qm.Release(reserved)
+
+	// An expired reservation is released by the next evaluation
+	if err := qm.Reserve(reservedID, demand, time.Hour, true); err != nil {
+		t.Fatalf("unexpected error reserving quota: %v", err)
+	}
+	qm.reservations[reservedID].expiry = time.Now().Add(-time.Second)
+	if result, err := qm.Fits(context.Background(), other, demand, nil); err != nil || !result.Fits {
+		t.Errorf("expected %s to fit after the reservation expired, got %v, err=%v", other.Name, result, err)
+	}
+	if _, found := qm.consumerSpecs[reservedID]; found {
+		t.Errorf("expected the consumer of the expired reservation to be removed")
+	}
+	qm.Release(other)
+
+	if err := qm.Reserve(reservedID, demand, time.Hour, false); err != nil {
+		t.Fatalf("unexpected error reserving quota: %v", err)
+	}
+	if err := qm.Unreserve(reservedID); err != nil {
+		t.Errorf("unexpected error releasing the reservation: %v", err)
+	}
+	if qm.quotaManagerBackend.IsAllocatedForest(QuotaManagerForestName, reservedID) || qm.unpreemptableConsumers[reservedID] {
+		t.Errorf("expected the reservation to be released")
+	}
+}
+
+func TestQuotaManager_GetPriority(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&schedulingv1.PriorityClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "high-priority"},
+		Value:      1000,
+	})
+	qm.priorityClassLister = schedulinglisters.NewPriorityClassLister(indexer)
+
+	tests := []struct {
+		name              string
+		priority          int32
+		priorityClassName string
+		expected          int
+	}{
+		{
+			name:     "no priority class",
+			priority: 5,
+			expected: 5,
+		},
+		{
+			name:              "priority class",
+			priority:          5,
+			priorityClassName: "high-priority",
+			expected:          1000,
+		},
+		{
+			name:              "unknown priority class",
+			priority:          5,
+			priorityClassName: "unknown",
+			expected:          5,
+		},
+	}
+
+	for i, test := range tests {
+		aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+		aw.Spec.Priority = test.priority
+		aw.Spec.PriorityClassName = test.priorityClassName
+		if got := qm.getPriority(aw); got != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, got)
+		}
+	}
+}
+
+func TestQuotaManager_DoubleFits(t *testing.T) {
+	// Quota for a single AppWrapper
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	for i := 0; i < 2; i++ {
+		result, err := qm.Fits(context.Background(), aw, demand, nil)
+		if err != nil || !result.Fits {
+			t.Fatalf("attempt %d: expected %s to fit, got %v, err=%v", i, aw.Name, result, err)
+		}
+	}
+
+	consumers, err := qm.ListConsumers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(consumers) != 1 || len(qm.consumerSpecs) != 1 {
+		t.Errorf("expected a single consumer, got backend consumers %v and specs %v", consumers, qm.consumerSpecs)
+	}
+
+	// Changing the request replaces the registered consumer
+	demand = clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")})
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit with new demand, got %v, err=%v", aw.Name, result, err)
+	}
+	expected := map[string]int{"cpu": 500}
+	if got := qm.consumerSpecs[util.CreateId(aw.Namespace, aw.Name)].Trees[0].Request; !reflect.DeepEqual(got, expected) {
+		t.Errorf("consumer request: \n expected %v, \n got %v \n", expected, got)
+	}
+}
+
+func TestQuotaManager_FitsStaleTreeRetry(t *testing.T) {
+	defaultAllocateForest := allocateForest
+	defer func() { allocateForest = defaultAllocateForest }()
+
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	awId := util.CreateId(aw.Namespace, aw.Name)
+
+	// Stale tree on the first allocation only
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	calls := 0
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("unknown tree %s", testTreeName)
+		}
+		return defaultAllocateForest(backend, forestName, consumerID)
+	}
+	result, err := qm.Fits(context.Background(), aw, demand, nil)
+	if err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit after retry, got %v, err=%v", aw.Name, result, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 allocation attempts, got %d", calls)
+	}
+	if _, found := qm.consumerSpecs[awId]; !found {
+		t.Errorf("expected consumer %s to be registered", awId)
+	}
+
+	// Stale tree on every allocation, retried once and the consumer cleaned up
+	qm = buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	calls = 0
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		calls++
+		return nil, fmt.Errorf("unknown tree %s", testTreeName)
+	}
+	result, err = qm.Fits(context.Background(), aw, demand, nil)
+	if err == nil || result.Fits {
+		t.Fatalf("expected %s not to fit, got %v, err=%v", aw.Name, result, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 allocation attempts, got %d", calls)
+	}
+	consumers, _ := qm.ListConsumers()
+	if len(consumers) != 0 || len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no consumer, got backend consumers %v and specs %v", consumers, qm.consumerSpecs)
+	}
+}
+
+func TestQuotaManager_FitsCanceledAllocation(t *testing.T) {
+	defaultAllocateForest := allocateForest
+	defer func() { allocateForest = defaultAllocateForest }()
+
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+
+	// The context is canceled while the backend allocation blocks, Fits returns without waiting for it
+	ctx, cancel := context.WithCancel(context.Background())
+	started, release := make(chan struct{}), make(chan struct{})
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		close(started)
+		<-release
+		return defaultAllocateForest(backend, forestName, consumerID)
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	result, err := qm.Fits(ctx, aw, demand, nil)
+	if err != context.Canceled || result == nil || result.Fits || result.Reason != quota.Canceled {
+		t.Fatalf("expected %s to be canceled, got %v, err=%v", aw.Name, result, err)
+	}
+
+	// The late allocation is released once the backend call completes
+	close(release)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		qm.mutex.RLock()
+		abandoned := len(qm.abandonedAllocations)
+		qm.mutex.RUnlock()
+		if abandoned == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the abandoned allocation of %s to be released", awId)
+		}
+	}
+	if qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+		t.Errorf("expected consumer %s not to be allocated", awId)
+	}
+	consumers, _ := qm.ListConsumers()
+	if len(consumers) != 0 || len(qm.consumerSpecs) != 0 {
+		t.Errorf("expected no consumer, got backend consumers %v and specs %v", consumers, qm.consumerSpecs)
+	}
+
+	// The quota of the canceled allocation is available to the next evaluation
+	allocateForest = defaultAllocateForest
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Errorf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+}
+
+func TestQuotaManager_AllocateForestWithContext(t *testing.T) {
+	defaultAllocateForest := allocateForest
+	defer func() { allocateForest = defaultAllocateForest }()
+
+	qm := &QuotaManager{quotaManagerBackend: qmbackend.NewManager()}
+	started, release := make(chan struct{}), make(chan struct{})
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		close(started)
+		<-release
+		return defaultAllocateForest(backend, forestName, consumerID)
+	}
+
+	// The canceled allocation returns while the backend call blocks
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	qm.mutex.Lock()
+	response, err := qm.allocateForestWithContext(ctx, qm.quotaManagerBackend, QuotaManagerForestName, "default_aw")
+	abandoned := qm.abandonedAllocations["default_aw"]
+	qm.mutex.Unlock()
+	if err != context.Canceled || response != nil {
+		t.Fatalf("expected %v, got %v, err=%v", context.Canceled, response, err)
+	}
+	if !abandoned {
+		t.Errorf("expected the allocation to be abandoned")
+	}
+
+	// The abandoned allocation is released once the backend call completes
+	close(release)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		qm.mutex.RLock()
+		abandoned = qm.abandonedAllocations["default_aw"]
+		qm.mutex.RUnlock()
+		if !abandoned {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the abandoned allocation to be released")
+		}
+	}
+
+	// Allocations canceled before the backend call are not started
+	allocateForest = func(backend *qmbackend.Manager, forestName string, consumerID string) (*core.AllocationResponse, error) {
+		t.Errorf("unexpected allocation of consumer %s", consumerID)
+		return defaultAllocateForest(backend, forestName, consumerID)
+	}
+	qm.mutex.Lock()
+	response, err = qm.allocateForestWithContext(ctx, qm.quotaManagerBackend, QuotaManagerForestName, "default_aw")
+	qm.mutex.Unlock()
+	if err != context.Canceled || response != nil {
+		t.Errorf("expected %v, got %v, err=%v", context.Canceled, response, err)
+	}
+}
+
+func TestQuotaManager_DryRunFits(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "2000"}, "team-a")
+	cpuDemand := func(cpu string) *clusterstateapi.Resource {
+		return clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)})
+	}
+	allocated := buildAppWrapper("allocated", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), allocated, cpuDemand("1"), nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", allocated.Name, result, err)
+	}
+
+	if result, err := qm.DryRunFits(nil, cpuDemand("1")); !errors.Is(err, quota.ErrInvalidAppWrapper) ||
+		result == nil || result.Reason != quota.InvalidRequest {
+		t.Errorf("expected an invalid request without AppWrapper, got %v, err=%v", result, err)
+	}
+
+	tests := []struct {
+		name         string
+		aw           *arbv1.AppWrapper
+		demand       *clusterstateapi.Resource
+		expectedFits bool
+	}{
+		{
+			name:         "fits",
+			aw:           buildAppWrapper("aw", map[string]string{testTreeName: "team-a"}),
+			demand:       cpuDemand("1"),
+			expectedFits: true,
+		},
+		{
+			name:   "exceeds quota",
+			aw:     buildAppWrapper("aw", map[string]string{testTreeName: "team-a"}),
+			demand: cpuDemand("2"),
+		},
+		{
+			name:   "missing quota designation",
+			aw:     buildAppWrapper("aw", map[string]string{}),
+			demand: cpuDemand("1"),
+		},
+	}
+	for i, test := range tests {
+		result, err := qm.DryRunFits(test.aw, test.demand)
+		if fits := err == nil && result != nil && result.Fits; fits != test.expectedFits {
+			t.Errorf("case %d (%s): \n expected fits %v, \n got %v, err=%v \n", i, test.name, test.expectedFits, result, err)
+		}
+
+		// No residual consumer is left in the quota manager, even on error paths
+		awId := util.CreateId(test.aw.Namespace, test.aw.Name)
+		consumers, _ := qm.ListConsumers()
+		if len(consumers) != 1 || len(qm.consumerSpecs) != 1 || qm.quotaManagerBackend.IsAllocatedForest(qm.getConsumerForest(awId), awId) {
+			t.Errorf("case %d (%s): \n expected the allocated consumer only, \n got backend consumers %v and specs %v \n",
+				i, test.name, consumers, qm.consumerSpecs)
+		}
+	}
+}
+
+func TestQuotaManager_UpdateConsumer(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "2000"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	awId := util.CreateId(aw.Namespace, aw.Name)
+	cpuDemand := func(cpu string) *clusterstateapi.Resource {
+		return clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)})
+	}
+
+	if _, err := qm.UpdateConsumer(aw, cpuDemand("1")); err == nil {
+		t.Errorf("expected an error updating an AppWrapper without consumer")
+	}
+	if result, err := qm.Fits(context.Background(), aw, cpuDemand("1"), nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+
+	tests := []struct {
+		name            string
+		demand          string
+		expectedFits    bool
+		expectedRequest int
+	}{
+		{name: "grow", demand: "1500m", expectedFits: true, expectedRequest: 1500},
+		{name: "grow beyond quota", demand: "3", expectedFits: false, expectedRequest: 1500},
+		{name: "shrink", demand: "500m", expectedFits: true, expectedRequest: 500},
+	}
+
+	for i, test := range tests {
+		result, err := qm.UpdateConsumer(aw, cpuDemand(test.demand))
+		if err != nil || result.Fits != test.expectedFits {
+			t.Errorf("case %d (%s): \n expected fits %v, \n got %v, err=%v \n", i, test.name, test.expectedFits, result, err)
+		}
+		if !qm.quotaManagerBackend.IsAllocatedForest(QuotaManagerForestName, awId) {
+			t.Errorf("case %d (%s): expected consumer to stay allocated", i, test.name)
+		}
+		if request := qm.consumerSpecs[awId].Trees[0].Request["cpu"]; request != test.expectedRequest {
+			t.Errorf("case %d (%s): \n expected request %v, \n got %v \n", i, test.name, test.expectedRequest, request)
+		}
+	}
+}
+
+func TestQuotaManager_FitsCache(t *testing.T) {
+	// Quota for a single AppWrapper
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw1 := buildAppWrapper("aw-1", map[string]string{testTreeName: "team-a"})
+	aw2 := buildAppWrapper("aw-2", map[string]string{testTreeName: "team-a"})
+
+	if result, err := qm.Fits(context.Background(), aw1, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw1.Name, result, err)
+	}
+
+	hits := testutil.ToFloat64(quotaFitsCacheRequests.WithLabelValues("hit"))
+	for i := 0; i < 2; i++ {
+		if result, err := qm.Fits(context.Background(), aw2, demand, nil); err != nil || result.Fits {
+			t.Fatalf("attempt %d: expected %s not to fit, got %v, err=%v", i, aw2.Name, result, err)
+		}
+	}
+	if got := testutil.ToFloat64(quotaFitsCacheRequests.WithLabelValues("hit")) - hits; got != 1 {
+		t.Errorf("expected 1 cache hit, got %v", got)
+	}
+
+	// A changed demand is evaluated again
+	smallDemand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("500m")})
+	if getDemandHash(aw2, demand, nil, "", false) == getDemandHash(aw2, smallDemand, nil, "", false) {
+		t.Errorf("expected different demand hashes for different demands")
+	}
+
+	// Releasing quota invalidates the cached decision
+	qm.Release(aw1)
+	if result, err := qm.Fits(context.Background(), aw2, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit after release, got %v, err=%v", aw2.Name, result, err)
+	}
+}
+
+func TestQuotaManager_CacheFitResultTimeDependent(t *testing.T) {
+	tests := []struct {
+		name     string
+		qm       *QuotaManager
+		expected bool
+	}{
+		{
+			name:     "no time dependent settings",
+			qm:       &QuotaManager{},
+			expected: true,
+		},
+		{
+			name:     "minimum preemption age",
+			qm:       &QuotaManager{minPreemptionAge: time.Minute},
+			expected: false,
+		},
+		{
+			name:     "preemption cost threshold",
+			qm:       &QuotaManager{preemptionCostThreshold: time.Minute},
+			expected: false,
+		},
+		{
+			name:     "allocation deadline",
+			qm:       &QuotaManager{allocationDeadline: time.Minute},
+			expected: false,
+		},
+		{
+			name:     "tree allocation deadline",
+			qm:       &QuotaManager{treeAllocationDeadlines: map[string]time.Duration{testTreeName: time.Minute}},
+			expected: false,
+		},
+	}
+
+	for i, test := range tests {
+		test.qm.cacheFitResult("default_aw", "hash", &quota.FitResult{Fits: false, Reason: quota.QuotaExceeded})
+		if _, cached := test.qm.fitsCache["default_aw"]; cached != test.expected {
+			t.Errorf("case %d (%s): \n expected cached %v, \n got %v \n", i, test.name, test.expected, cached)
+		}
+	}
+}
+
+func TestQuotaManager_FitsInvalidAppWrapper(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw := buildAppWrapper("", map[string]string{testTreeName: "team-a"})
+
+	for _, invalidAW := range []*arbv1.AppWrapper{aw, nil} {
+		result, err := qm.Fits(context.Background(), invalidAW, demand, nil)
+		if !errors.Is(err, quota.ErrInvalidAppWrapper) {
+			t.Errorf("expected invalid AppWrapper error, got %v", err)
+		}
+		if result == nil || result.Fits || result.Reason != quota.InvalidRequest {
+			t.Errorf("expected invalid request result, got %v", result)
+		}
+	}
+}
+
+func TestQuotaManager_BestEffort(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+
+	// Best-effort AppWrappers fit without quota designation and hold no quota
+	bestEffortAW := buildAppWrapper("aw-best-effort", map[string]string{quota.BestEffortLabel: "true"})
+	result, err := qm.Fits(context.Background(), bestEffortAW, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.BestEffort {
+		t.Fatalf("expected best-effort AppWrapper to fit, got %v, err=%v", result, err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers, got %v", consumers)
+	}
+
+	// Best-effort AppWrappers proposed for preemption are preemption targets
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	demand = clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	result, err = qm.Fits(context.Background(), aw, demand, []*arbv1.AppWrapper{bestEffortAW})
+	if err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+	if len(result.PreemptionTargets) != 1 || result.PreemptionTargets[0] != bestEffortAW {
+		t.Errorf("expected best-effort AppWrapper preemption target, got %v", result.PreemptionTargets)
+	}
+}
+
+func TestQuotaManager_FitsNoQuotaTrees(t *testing.T) {
+	backend := qmbackend.NewManager()
+	if err := backend.AddForest(QuotaManagerForestName); err != nil {
+		t.Fatalf("failed to add forest: %v", err)
+	}
+	qm := &QuotaManager{
+		quotaManagerBackend:           backend,
+		resourcePlanManager:           &rpmanager.ResourcePlanManager{},
+		consumerSpecs:                 make(map[string]*qmbackendutils.JConsumerSpec),
+		missingDesignationGenerations: make(map[string]int64),
+		memoryUnit:                    "Mi",
+		memoryUnitBytes:               1024 * 1024,
+	}
+	backend.SetMode(qmbackend.Normal)
+	qm.initializationDone = true
+
+	// AppWrappers fit without registering a consumer in an empty forest
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	result, err := qm.Fits(context.Background(), aw, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.NoQuotaTrees || len(result.PreemptionTargets) != 0 {
+		t.Fatalf("expected AppWrapper to fit without quota trees, got %v, err=%v", result, err)
+	}
+	if result.Message != "no quota trees defined" {
+		t.Errorf("expected no quota trees message, got %q", result.Message)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers, got %v", consumers)
+	}
+}
+
+func TestQuotaManager_Exempt(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")})
+	exemptAW := buildAppWrapper("aw-exempt", map[string]string{quota.BestEffortLabel: "true"})
+	exemptAW.Annotations = map[string]string{quota.ExemptAnnotation: "true"}
+
+	// The exemption annotation is ignored unless quota exemption is allowed
+	result, err := qm.Fits(context.Background(), exemptAW, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.BestEffort {
+		t.Fatalf("expected exemption to be ignored, got %v, err=%v", result, err)
+	}
+
+	// Exempt AppWrappers fit without quota designation and hold no quota
+	qm.allowQuotaExemption = true
+	result, err = qm.Fits(context.Background(), exemptAW, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.Exempt {
+		t.Fatalf("expected exempt AppWrapper to fit, got %v, err=%v", result, err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers, got %v", consumers)
+	}
+
+	// Exempt AppWrappers proposed for preemption are never preemption targets
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	demand = clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	result, err = qm.Fits(context.Background(), aw, demand, []*arbv1.AppWrapper{exemptAW})
+	if err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+	if len(result.PreemptionTargets) != 0 {
+		t.Errorf("expected no preemption targets, got %v", result.PreemptionTargets)
+	}
+}
+
+func TestQuotaManager_FitsAppWrapperSelector(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	var err error
+	qm.appwrapperSelector, err = (&options.ServerOption{QuotaAppWrapperSelector: "quota-managed=true"}).QuotaAppWrapperLabelSelector()
+	if err != nil {
+		t.Fatalf("failed to parse selector: %v", err)
+	}
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	// AppWrappers not matching the selector fit without quota designation and hold no quota
+	unselectedAW := buildAppWrapper("aw-unselected", nil)
+	result, err := qm.Fits(context.Background(), unselectedAW, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.NotSelected {
+		t.Fatalf("expected unselected AppWrapper to fit without quota, got %v, err=%v", result, err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers, got %v", consumers)
+	}
+
+	// AppWrappers matching the selector are subject to quota
+	selectedAW := buildAppWrapper("aw-selected", map[string]string{"quota-managed": "true", testTreeName: "team-a"})
+	result, err = qm.Fits(context.Background(), selectedAW, demand, nil)
+	if err != nil || !result.Fits || result.Reason != quota.Allocated {
+		t.Fatalf("expected selected AppWrapper to be allocated, got %v, err=%v", result, err)
+	}
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 1 {
+		t.Errorf("expected 1 consumer, got %v", consumers)
+	}
+
+	// Selected AppWrappers without quota designation are rejected
+	undesignatedAW := buildAppWrapper("aw-undesignated", map[string]string{"quota-managed": "true"})
+	result, _ = qm.Fits(context.Background(), undesignatedAW, demand, nil)
+	if result.Fits {
+		t.Errorf("expected selected AppWrapper without quota designation not to fit, got %v", result)
+	}
+}
+
+func TestQuotaManager_ReleaseDeletedAppWrapper(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+
+	// Deleting the AppWrapper releases its consumer, repeated and tombstone deletes are ignored
+	qm.releaseDeletedAppWrapper(aw)
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers after delete, got %v", consumers)
+	}
+	qm.releaseDeletedAppWrapper(aw)
+	qm.releaseDeletedAppWrapper(cache.DeletedFinalStateUnknown{Key: "default/aw", Obj: aw})
+	qm.releaseDeletedAppWrapper("not an AppWrapper")
+	if consumers, _ := qm.ListConsumers(); len(consumers) != 0 {
+		t.Errorf("expected no consumers after repeated deletes, got %v", consumers)
+	}
+}
+
+func TestQuotaManager_SetUnpreemptable(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	qm.preemptionEnabled = true
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	if err := qm.SetUnpreemptable("", true); err == nil {
+		t.Errorf("expected error setting an empty consumer id unpreemptable")
+	}
+	awID := util.CreateId("default", "aw-low")
+	if err := qm.SetUnpreemptable(awID, true); err == nil {
+		t.Errorf("expected error setting an unknown consumer unpreemptable")
+	}
+
+	lowAW := buildAppWrapper("aw-low", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), lowAW, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", lowAW.Name, result, err)
+	}
+	if err := qm.SetUnpreemptable(awID, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, treeSpec := range qm.consumerSpecs[awID].Trees {
+		if !treeSpec.UnPreemptable {
+			t.Errorf("expected tree %s of consumer %s to be unpreemptable", treeSpec.TreeName, awID)
+		}
+	}
+	if ids := qm.getUnpreemptableIDs([]string{"other", awID}); !reflect.DeepEqual(ids, []string{awID}) {
+		t.Errorf("unpreemptable ids: \n expected %v, \n got %v \n", []string{awID}, ids)
+	}
+
+	// The unpreemptable consumer is not preempted by a consumer of higher priority
+	highAW := buildAppWrapper("aw-high", map[string]string{testTreeName: "team-a"})
+	highAW.Spec.Priority = 10
+	result, err := qm.Fits(context.Background(), highAW, demand, nil)
+	if err != nil || result.Fits || len(result.PreemptionTargets) != 0 {
+		t.Errorf("expected %s not to fit without preempting, got %v, err=%v", highAW.Name, result, err)
+	}
+	if !qm.quotaManagerBackend.IsAllocatedForest(QuotaManagerForestName, awID) {
+		t.Errorf("expected unpreemptable consumer %s to stay allocated", awID)
+	}
+
+	// The consumer is preemptable again once the flag is cleared
+	if err := qm.SetUnpreemptable(awID, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids := qm.getUnpreemptableIDs([]string{awID}); len(ids) != 0 {
+		t.Errorf("expected no unpreemptable ids, got %v", ids)
+	}
+	for _, treeSpec := range qm.consumerSpecs[awID].Trees {
+		if treeSpec.UnPreemptable {
+			t.Errorf("expected tree %s of consumer %s to be preemptable", treeSpec.TreeName, awID)
+		}
+	}
+}
+
+func TestQuotaManager_FlushConsumer(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+
+	// Flushing an unknown consumer is not an error
+	if existed, err := qm.FlushConsumer(util.CreateId("default", "unknown")); err != nil || existed {
+		t.Errorf("expected unknown consumer to be flushed without error, got existed=%v, err=%v", existed, err)
+	}
+	if _, err := qm.FlushConsumer(""); err == nil {
+		t.Errorf("expected error flushing an empty consumer id")
+	}
+
+	// Allocated consumers must be released
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	allocated := buildAppWrapper("allocated", map[string]string{testTreeName: "team-a"})
+	if result, err := qm.Fits(context.Background(), allocated, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", allocated.Name, result, err)
+	}
+	if _, err := qm.FlushConsumer(util.CreateId(allocated.Namespace, allocated.Name)); err == nil {
+		t.Errorf("expected error flushing an allocated consumer")
+	}
+
+	// Consumer definitions holding no allocation are flushed
+	pending := buildAppWrapper("pending", map[string]string{testTreeName: "team-a"})
+	if result, _ := qm.Fits(context.Background(), pending, demand, nil); result.Fits {
+		t.Fatalf("expected %s not to fit", pending.Name)
+	}
+	pendingID := util.CreateId(pending.Namespace, pending.Name)
+	if existed, err := qm.FlushConsumer(pendingID); err != nil || !existed {
+		t.Errorf("expected pending consumer to be flushed, got existed=%v, err=%v", existed, err)
+	}
+	if consumers, _ := qm.ListConsumers(); !reflect.DeepEqual(consumers, []string{util.CreateId(allocated.Namespace, allocated.Name)}) {
+		t.Errorf("expected only the allocated consumer after flush, got %v", consumers)
+	}
+}
+
+func TestQuotaManager_VerifyConsistency(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	dispatched := map[string]*arbv1.AppWrapper{}
+	for _, name := range []string{"aw-1", "aw-2"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+		}
+	}
+	// aw-1 is dispatched, aw-2 leaked and aw-3 was never allocated
+	for _, name := range []string{"aw-1", "aw-3"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		dispatched[util.CreateId(aw.Namespace, aw.Name)] = aw
+	}
+
+	report, err := qm.VerifyConsistency(dispatched)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &quota.DriftReport{
+		Leaked:       []quota.DriftEntry{{ID: util.CreateId("default", "aw-2"), Namespace: "default", Name: "aw-2"}},
+		UnderCounted: []quota.DriftEntry{{ID: util.CreateId("default", "aw-3"), Namespace: "default", Name: "aw-3"}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("drift report: \n expected %v, \n got %v \n", expected, report)
+	}
+
+	consumers, err := qm.ListConsumers()
+	if err != nil || len(consumers) != 2 {
+		t.Errorf("expected the forest consumers to be unchanged, got %v, err=%v", consumers, err)
+	}
+}
+
+func TestQuotaManager_FitsGroup(t *testing.T) {
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+
+	tests := []struct {
+		name     string
+		awNames  []string
+		expected bool
+	}{
+		{
+			name:     "group fits",
+			awNames:  []string{"aw-1", "aw-2"},
+			expected: true,
+		},
+		{
+			name:     "group exceeds the tree",
+			awNames:  []string{"aw-1", "aw-2", "aw-3"},
+			expected: false,
+		},
+	}
+
+	for i, test := range tests {
+		qm := buildQuotaManager(t, map[string]string{"cpu": "2000"}, "team-a")
+		var requests []quota.FitsRequest
+		for _, name := range test.awNames {
+			requests = append(requests, quota.FitsRequest{
+				AppWrapper: buildAppWrapper(name, map[string]string{testTreeName: "team-a"}),
+				Resources:  demand,
+			})
+		}
+
+		result, err := qm.FitsGroup(requests)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+			continue
+		}
+		if result.Fits != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, result.Fits)
+		}
+
+		// Either all or none of the AppWrappers are allocated
+		expectedAllocated := 0
+		if test.expected {
+			expectedAllocated = len(test.awNames)
+		}
+		allocated := 0
+		for _, name := range test.awNames {
+			if qm.quotaManagerBackend.IsAllocatedForest(QuotaManagerForestName, util.CreateId("default", name)) {
+				allocated++
+			}
+		}
+		if allocated != expectedAllocated {
+			t.Errorf("case %d (%s): allocated AppWrappers: \n expected %v, \n got %v \n", i, test.name, expectedAllocated, allocated)
+		}
+	}
+}
+
+func TestQuotaManager_LastRefresh(t *testing.T) {
+	if lastRefresh := (&QuotaManager{}).LastRefresh(); !lastRefresh.IsZero() {
+		t.Errorf("expected zero last refresh before the forest is updated, got %v", lastRefresh)
+	}
+
+	before := time.Now()
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	lastRefresh := qm.LastRefresh()
+	if lastRefresh.Before(before) || lastRefresh.After(time.Now()) {
+		t.Errorf("expected last refresh after %v, got %v", before, lastRefresh)
+	}
+
+	if err := qm.updateForestFromCache(); err != nil {
+		t.Fatalf("failed to update forest: %v", err)
+	}
+	if qm.LastRefresh().Before(lastRefresh) {
+		t.Errorf("expected last refresh not before %v, got %v", lastRefresh, qm.LastRefresh())
+	}
+}
+
+func TestBuildTreeNodes(t *testing.T) {
+	nodeSpecs := map[string]*qmbackendutils.JNodeSpec{
+		"root":    {Parent: "nil", Quota: map[string]string{"cpu": "10", "memory": "64"}, Hard: "false"},
+		"team-a":  {Parent: "root", Quota: map[string]string{"cpu": "6", "memory": "32"}, Hard: "true"},
+		"team-a1": {Parent: "team-a", Quota: map[string]string{"cpu": "4"}, Hard: "false"},
+		"team-b":  {Parent: "root", Quota: map[string]string{"cpu": "4", "memory": "32"}, Hard: "false"},
+	}
+	allocated := map[string]map[string]int{
+		"team-a":  {"cpu": 1},
+		"team-a1": {"cpu": 2, "memory": 8},
+		"team-b":  {"memory": 4},
+	}
+
+	expected := []TreeNode{
+		{
+			Name: "root", Parent: "nil", Quota: "[10 64]", Allocation: "[3 12]",
+			Children: []TreeNode{
+				{
+					Name: "team-a", Parent: "root", Quota: "[6 32]", Allocation: "[3 8]", Hard: true,
+					Children: []TreeNode{
+						{Name: "team-a1", Parent: "team-a", Quota: "[4 0]", Allocation: "[2 8]", Children: []TreeNode{}},
+					},
+				},
+				{Name: "team-b", Parent: "root", Quota: "[4 32]", Allocation: "[0 4]", Children: []TreeNode{}},
+			},
+		},
+	}
+
+	result := buildTreeNodes([]string{"cpu", "memory"}, nodeSpecs, allocated)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("tree nodes: \n expected %+v, \n got %+v \n", expected, result)
+	}
+}
+
+func TestGetSubtreeAllocation(t *testing.T) {
+	nodeSpecs := map[string]*qmbackendutils.JNodeSpec{
+		"root":    {Parent: "nil"},
+		"team-a":  {Parent: "root"},
+		"team-a1": {Parent: "team-a"},
+		"team-b":  {Parent: "root"},
+	}
+	allocated := map[string]map[string]int{
+		"team-a":  {"cpu": 1},
+		"team-a1": {"cpu": 2, "memory": 8},
+		"team-b":  {"memory": 4},
+	}
+
+	tests := []struct {
+		nodeName string
+		expected map[string]int
+	}{
+		{nodeName: "root", expected: map[string]int{"cpu": 3, "memory": 12}},
+		{nodeName: "team-a", expected: map[string]int{"cpu": 3, "memory": 8}},
+		{nodeName: "team-a1", expected: map[string]int{"cpu": 2, "memory": 8}},
+		{nodeName: "team-b", expected: map[string]int{"memory": 4}},
+	}
+
+	for i, test := range tests {
+		allocation := getSubtreeAllocation(test.nodeName, nodeSpecs, allocated)
+		if !reflect.DeepEqual(allocation, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.nodeName, test.expected, allocation)
+		}
+	}
+}
+
+func TestGetOversizedPodTrees(t *testing.T) {
+	treeNodeSpecs := map[string]map[string]*qmbackendutils.JNodeSpec{
+		testTreeName: {
+			testRootNode: {Parent: "nil", Quota: map[string]string{"nvidia.com/gpu": "16"}, Hard: "true"},
+			"team-a":     {Parent: testRootNode, Quota: map[string]string{"nvidia.com/gpu": "4"}, Hard: "false"},
+			"team-b":     {Parent: testRootNode, Quota: map[string]string{"nvidia.com/gpu": "8"}, Hard: "false"},
+		},
+	}
+	buildSpec := func(maxSinglePod int, groups ...string) *compositeConsumerSpec {
+		return &compositeConsumerSpec{
+			JConsumerSpec: buildConsumerSpec("aw", map[string]int{"nvidia.com/gpu": 12}, groups...),
+			MaxSinglePod:  map[string]map[string]int{testTreeName: {"nvidia.com/gpu": maxSinglePod}},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		consumerSpec *compositeConsumerSpec
+		expected     []string
+	}{
+		{name: "largest pod within group quota", consumerSpec: buildSpec(4, "team-a"), expected: nil},
+		{name: "largest pod above group quota", consumerSpec: buildSpec(6, "team-a"), expected: []string{testTreeName}},
+		{name: "largest pod within fallback group quota", consumerSpec: buildSpec(6, "team-a", "team-b"), expected: nil},
+		{name: "largest pod above all group quotas", consumerSpec: buildSpec(10, "team-a", "team-b"), expected: []string{testTreeName}},
+	}
+
+	for i, test := range tests {
+		if oversized := getOversizedPodTrees(test.consumerSpec, treeNodeSpecs); !reflect.DeepEqual(oversized, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, oversized)
+		}
+	}
+}
+
+func TestGetBlockingResources(t *testing.T) {
+	treeNodeSpecs := map[string]map[string]*qmbackendutils.JNodeSpec{
+		testTreeName: {
+			testRootNode: {Parent: "nil", Quota: map[string]string{"cpu": "10000", "nvidia.com/gpu": "8"}, Hard: "true"},
+			"team-a":     {Parent: testRootNode, Quota: map[string]string{"cpu": "4000", "nvidia.com/gpu": "4"}, Hard: "false"},
+			"team-b":     {Parent: testRootNode, Quota: map[string]string{"cpu": "3000", "nvidia.com/gpu": "4"}, Hard: "true"},
+		},
+	}
+	allocated := map[string]map[string]map[string]int{
+		testTreeName: {
+			"team-a": {"cpu": 2000, "nvidia.com/gpu": 4},
+			"team-b": {"cpu": 2000},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		consumerSpec *qmbackendutils.JConsumerSpec
+		expected     []string
+	}{
+		{
+			name:         "exhausted soft quota group",
+			consumerSpec: buildConsumerSpec("aw", map[string]int{"cpu": 1000, "nvidia.com/gpu": 2}, "team-a"),
+			expected:     []string{"blocked on tree " + testTreeName + " resource nvidia.com/gpu: requested 2, available 0"},
+		},
+		{
+			name:         "exhausted root",
+			consumerSpec: buildConsumerSpec("aw", map[string]int{"cpu": 1000, "nvidia.com/gpu": 6}, "team-a"),
+			expected:     []string{"blocked on tree " + testTreeName + " resource nvidia.com/gpu: requested 6, available 4"},
+		},
+		{
+			name:         "exhausted hard quota group",
+			consumerSpec: buildConsumerSpec("aw", map[string]int{"cpu": 2000}, "team-b"),
+			expected:     []string{"blocked on tree " + testTreeName + " resource cpu: requested 2000, available 1000"},
+		},
+		{
+			name:         "preferred fallback group",
+			consumerSpec: buildConsumerSpec("aw", map[string]int{"cpu": 2000}, "team-b", "team-a"),
+			expected:     []string{"blocked on tree " + testTreeName + " resource cpu: requested 2000, available 1000"},
+		},
+		{
+			name:         "available quota",
+			consumerSpec: buildConsumerSpec("aw", map[string]int{"cpu": 1000, "nvidia.com/gpu": 0}, "team-a"),
+			expected:     nil,
+		},
+	}
+
+	for i, test := range tests {
+		var blocked []string
+		for _, blocking := range getBlockingResources(test.consumerSpec, treeNodeSpecs, allocated) {
+			blocked = append(blocked, blocking.String())
+		}
+		if !reflect.DeepEqual(blocked, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, blocked)
+		}
+	}
+}
+
+func TestGetBorrowSpec(t *testing.T) {
+	treeNodeSpecs := map[string]map[string]*qmbackendutils.JNodeSpec{
+		testTreeName: {
+			testRootNode: {Parent: "nil", Quota: map[string]string{"cpu": "30"}, Hard: "true"},
+			"team-a":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10"}, Hard: "true"},
+			"team-b":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10"}, Hard: "true"},
+			"team-c":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10"}, Hard: "true"},
+			"team-d":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10"}, Hard: "false"},
+		},
+	}
+	allocated := map[string]map[string]map[string]int{
+		testTreeName: {"team-a": {"cpu": 8}, "team-b": {"cpu": 6}},
+	}
+
+	tests := []struct {
+		name       string
+		group      string
+		cpu        int
+		borrowable map[string]bool
+		expected   []string
+	}{
+		{name: "group with idle quota", group: "team-a", cpu: 2, borrowable: map[string]bool{"team-c": true}, expected: nil},
+		{name: "no borrowable sibling", group: "team-a", cpu: 4, borrowable: nil, expected: nil},
+		{name: "borrow from first sibling in name order", group: "team-a", cpu: 4,
+			borrowable: map[string]bool{"team-b": true, "team-c": true}, expected: []string{testTreeName + "/team-b"}},
+		{name: "borrow from sibling with enough idle quota", group: "team-a", cpu: 6,
+			borrowable: map[string]bool{"team-b": true, "team-c": true}, expected: []string{testTreeName + "/team-c"}},
+		{name: "no sibling with enough idle quota", group: "team-a", cpu: 12,
+			borrowable: map[string]bool{"team-b": true, "team-c": true}, expected: nil},
+		{name: "soft group does not borrow", group: "team-d", cpu: 12,
+			borrowable: map[string]bool{"team-c": true}, expected: nil},
+	}
+
+	for i, test := range tests {
+		consumerSpec := buildConsumerSpec("aw", map[string]int{"cpu": test.cpu}, test.group)
+		borrowSpec, lenders := getBorrowSpec(consumerSpec, treeNodeSpecs, map[string]map[string]bool{testTreeName: test.borrowable},
+			allocated)
+		if !reflect.DeepEqual(lenders, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, lenders)
+			continue
+		}
+		if borrowSpec != nil && testTreeName+"/"+borrowSpec.Trees[0].GroupID != lenders[0] {
+			t.Errorf("case %d (%s): \n expected group %s, \n got %s \n", i, test.name, lenders[0], borrowSpec.Trees[0].GroupID)
+		}
+	}
+}
+
+func TestQuotaManager_FitsForests(t *testing.T) {
+	rpList := &rpv1.ResourcePlanList{}
+	for _, forestName := range []string{"bu-a", "bu-b"} {
+		rpList.Items = append(rpList.Items, rpv1.ResourcePlan{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      forestName,
+				Namespace: "default",
+				Labels:    map[string]string{rputil.URMTreeLabel: forestName, rputil.URMForestLabel: forestName},
+			},
+			Spec: rpv1.ResourcePlanSpec{Parent: "nil", Children: []rpv1.Child{
+				{Name: "team-a", RunPodQuotas: rpv1.RunPodQuotas{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1000")}, HardLimit: true}},
+			}},
+		})
+	}
+	data, err := yaml.Marshal(rpList)
+	if err != nil {
+		t.Fatalf("failed to marshal ResourcePlans: %v", err)
+	}
+	quotaTreeFile := filepath.Join(t.TempDir(), "quota-trees.yaml")
+	if err := os.WriteFile(quotaTreeFile, data, 0644); err != nil {
+		t.Fatalf("failed to write ResourcePlans: %v", err)
+	}
+	backend := qmbackend.NewManager()
+	if err := backend.AddForest(QuotaManagerForestName); err != nil {
+		t.Fatalf("failed to add forest: %v", err)
+	}
+	rpm, err := rpmanager.NewStaticResourcePlanManager(quotaTreeFile, backend)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	qm, err := NewQuotaManagerWithBackend(backend, rpm, listersv1.NewAppWrapperLister(indexer),
+		&options.ServerOption{QuotaMemoryUnit: "Mi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedForests := map[string]string{"bu-a": "bu-a", "bu-b": "bu-b"}
+	if treeForests := rpm.GetTreeForests(); !reflect.DeepEqual(treeForests, expectedForests) {
+		t.Errorf("tree forests: \n expected %v, \n got %v \n", expectedForests, treeForests)
+	}
+
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	tests := []struct {
+		name   string
+		labels map[string]string
+		fits   bool
+		reason quota.FitReason
+		forest string
+	}{
+		{
+			name:   "named forest, trees of other forests not designated",
+			labels: map[string]string{quota.ForestLabel: "bu-a", "bu-a": "team-a"},
+			fits:   true,
+			reason: quota.Allocated,
+			forest: "bu-a",
+		},
+		{
+			name:   "default forest without quota trees",
+			labels: map[string]string{"bu-a": "team-a"},
+			fits:   true,
+			reason: quota.NoQuotaTrees,
+		},
+		{
+			name:   "unknown forest",
+			labels: map[string]string{quota.ForestLabel: "bu-c", "bu-a": "team-a"},
+			fits:   false,
+			reason: quota.InvalidRequest,
+		},
+	}
+	for i, tc := range tests {
+		aw := buildAppWrapper(fmt.Sprintf("aw-%d", i), tc.labels)
+		awId := util.CreateId(aw.Namespace, aw.Name)
+		result, _ := qm.Fits(context.Background(), aw, demand, nil)
+		if result.Fits != tc.fits || result.Reason != tc.reason {
+			t.Errorf("case %d (%s): \n expected %t %s, \n got %t %s \n", i, tc.name, tc.fits, tc.reason, result.Fits, result.Reason)
+		}
+		if len(tc.forest) == 0 {
+			continue
+		}
+		if !backend.IsAllocatedForest(tc.forest, awId) || backend.IsAllocatedForest(QuotaManagerForestName, awId) {
+			t.Errorf("case %d (%s): expected consumer %s allocated in forest %s only", i, tc.name, awId, tc.forest)
+		}
+		if !qm.Release(aw) || backend.IsAllocatedForest(tc.forest, awId) {
+			t.Errorf("case %d (%s): expected consumer %s released from forest %s", i, tc.name, awId, tc.forest)
+		}
+	}
+}
+
+func TestResourcePlanManager_PercentageQuotas(t *testing.T) {
+	rpList := &rpv1.ResourcePlanList{Items: []rpv1.ResourcePlan{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "percentage",
+			Namespace:   "default",
+			Labels:      map[string]string{rputil.URMTreeLabel: testTreeName},
+			Annotations: map[string]string{rputil.URMQuotaModeAnnotation: rputil.URMQuotaModePercentage},
+		},
+		Spec: rpv1.ResourcePlanSpec{Parent: "nil", Children: []rpv1.Child{
+			{Name: "team-a", RunPodQuotas: rpv1.RunPodQuotas{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("30")}}},
+			{Name: "team-b", RunPodQuotas: rpv1.RunPodQuotas{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("12.5")}}},
+		}},
+	}}}
+	data, err := yaml.Marshal(rpList)
+	if err != nil {
+		t.Fatalf("failed to marshal ResourcePlans: %v", err)
+	}
+	quotaTreeFile := filepath.Join(t.TempDir(), "quota-trees.yaml")
+	if err := os.WriteFile(quotaTreeFile, data, 0644); err != nil {
+		t.Fatalf("failed to write ResourcePlans: %v", err)
+	}
+	rpm, err := rpmanager.NewStaticResourcePlanManager(quotaTreeFile, qmbackend.NewManager())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	quotas := func() []string {
+		nodeSpecs := rpm.GetTreeNodeSpecs()[testTreeName]
+		return []string{nodeSpecs["team-a"].Quota["cpu"], nodeSpecs["team-b"].Quota["cpu"]}
+	}
+	tests := []struct {
+		name     string
+		update   func() bool
+		changed  bool
+		clamped  bool
+		expected []string
+	}{
+		{
+			name:     "capacity unknown",
+			update:   func() bool { return rpm.SetClusterCapacity(nil) },
+			changed:  false,
+			expected: []string{"0", "0"},
+		},
+		{
+			name:     "capacity known",
+			update:   func() bool { return rpm.SetClusterCapacity(map[string]int{"cpu": 10000}) },
+			changed:  true,
+			expected: []string{"3000", "1250"},
+		},
+		{
+			name:     "quotas rounded down",
+			update:   func() bool { return rpm.SetClusterCapacity(map[string]int{"cpu": 10003}) },
+			changed:  false,
+			expected: []string{"3000", "1250"},
+		},
+		{
+			name: "allocated quota below percentage",
+			update: func() bool {
+				return rpm.SetQuotaFloors(map[string]map[string]map[string]int{testTreeName: {"team-a": {"cpu": 2000}}})
+			},
+			changed:  false,
+			expected: []string{"3000", "1250"},
+		},
+		{
+			name:     "capacity shrinks below allocated quota",
+			update:   func() bool { return rpm.SetClusterCapacity(map[string]int{"cpu": 5000}) },
+			changed:  true,
+			clamped:  true,
+			expected: []string{"2000", "625"},
+		},
+		{
+			name:     "allocated quota released",
+			update:   func() bool { return rpm.SetQuotaFloors(nil) },
+			changed:  true,
+			expected: []string{"1500", "625"},
+		},
+	}
+
+	for i, test := range tests {
+		if changed := test.update(); changed != test.changed {
+			t.Errorf("case %d (%s): \n expected changed %v, \n got %v \n", i, test.name, test.changed, changed)
+		}
+		if clamped := rpm.IsQuotaClamped(); clamped != test.clamped {
+			t.Errorf("case %d (%s): \n expected clamped %v, \n got %v \n", i, test.name, test.clamped, clamped)
+		}
+		if result := quotas(); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, result)
+		}
+	}
+	if !rpm.IsResplanChanged() {
+		t.Errorf("expected changed percentage quotas to be loaded on the next refresh")
+	}
+}
+
+// countingCapacityProvider is a ResourcePlanProvider counting the cluster capacity updates.
+type countingCapacityProvider struct {
+	*rpmanager.ResourcePlanManager
+	updates int
+}
+
+func (p *countingCapacityProvider) SetClusterCapacity(capacity map[string]int) bool {
+	p.updates++
+	return p.ResourcePlanManager.SetClusterCapacity(capacity)
+}
+
+func TestQuotaManager_SetClusterCapacity(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	provider := &countingCapacityProvider{ResourcePlanManager: &rpmanager.ResourcePlanManager{}}
+	qm.resourcePlanManager = provider
+
+	capacity := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")})
+	qm.SetClusterCapacity(capacity)
+	qm.SetClusterCapacity(capacity.Clone())
+	if provider.updates != 1 {
+		t.Errorf("expected 1 capacity update for an unchanged capacity, got %d", provider.updates)
+	}
+
+	qm.SetClusterCapacity(clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("16")}))
+	if provider.updates != 2 {
+		t.Errorf("expected 2 capacity updates after a capacity change, got %d", provider.updates)
+	}
+}
+
+func TestSimulator_Run(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "2000"}, "team-a")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	qm.appwrapperLister = listersv1.NewAppWrapperLister(indexer)
+	qm.simulation = true
+	simulator := &Simulator{qm: qm, appwrapperIndexer: indexer}
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	aw1 := buildAppWrapper("aw-1", map[string]string{testTreeName: "team-a"})
+	aw2 := buildAppWrapper("aw-2", map[string]string{testTreeName: "team-a"})
+	aw3 := buildAppWrapper("aw-3", map[string]string{testTreeName: "team-a"})
+
+	// Requests are replayed in time order
+	report, err := simulator.Run([]SimulationRequest{
+		{Time: start.Add(1 * time.Minute), AppWrapper: aw2, Demand: demand},
+		{Time: start, AppWrapper: aw1, Demand: demand},
+		{Time: start.Add(2 * time.Minute), AppWrapper: aw3, Demand: demand},
+		{Time: start.Add(3 * time.Minute), AppWrapper: aw1, Release: true},
+		{Time: start.Add(4 * time.Minute), AppWrapper: aw3, Demand: demand},
+	})
+	if err != nil {
+		t.Fatalf("unexpected simulation error: %v", err)
+	}
+
+	if report.Fits != 3 || report.Rejected != 1 || report.Releases != 1 || report.Preemptions != 0 {
+		t.Errorf("expected 3 fits, 1 rejected, 1 release and no preemption, got %+v", report)
+	}
+	expectedAllocations := []int{1000, 2000, 2000, 1000, 2000}
+	if len(report.Samples) != len(expectedAllocations) {
+		t.Fatalf("expected %d samples, got %d", len(expectedAllocations), len(report.Samples))
+	}
+	for i, expected := range expectedAllocations {
+		sample := report.Samples[i]
+		if expectedTime := start.Add(time.Duration(i) * time.Minute); !sample.Time.Equal(expectedTime) {
+			t.Errorf("sample %d: expected time %v, got %v", i, expectedTime, sample.Time)
+		}
+		if allocated := sample.Allocated[testTreeName]["cpu"]; allocated != expected {
+			t.Errorf("sample %d: expected %d cpu allocated, got %d", i, expected, allocated)
+		}
+	}
+
+	if _, err := simulator.Run([]SimulationRequest{{Time: start}}); err == nil {
+		t.Errorf("expected error replaying a request without AppWrapper")
+	}
+}
+
+func TestQuotaManager_Healthy(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	if healthy, reason := qm.Healthy(); !healthy {
+		t.Errorf("expected healthy quota manager, got reason %q", reason)
+	}
+
+	qm.lastRefreshErr = errors.New("refresh failed")
+	if healthy, _ := qm.Healthy(); healthy {
+		t.Errorf("expected unhealthy quota manager after failed refresh")
+	}
+	qm.lastRefreshErr = nil
+
+	qm.quotaManagerBackend.SetMode(qmbackend.Maintenance)
+	if healthy, _ := qm.Healthy(); healthy {
+		t.Errorf("expected unhealthy quota manager in maintenance mode")
+	}
+
+	qm.quotaManagerBackend = nil
+	if healthy, _ := qm.Healthy(); healthy {
+		t.Errorf("expected unhealthy quota manager without backend")
+	}
+}
+
+func TestQuotaManager_Maintenance(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "1000"}, "team-a")
+	if mode := qm.Mode(); mode != "Normal" {
+		t.Errorf("expected Normal mode, got %s", mode)
+	}
+
+	// Quiescing waits for the quota evaluations in flight
+	qm.maintenanceMutex.RLock()
+	entered := make(chan error)
+	go func() {
+		entered <- qm.EnterMaintenance(true)
+	}()
+	select {
+	case <-entered:
+		t.Fatalf("expected maintenance mode to wait for the quota evaluations in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	qm.maintenanceMutex.RUnlock()
+	if err := <-entered; err != nil {
+		t.Fatalf("unexpected error entering maintenance mode: %v", err)
+	}
+	if mode := qm.Mode(); mode != "Maintenance" {
+		t.Errorf("expected Maintenance mode, got %s", mode)
+	}
+
+	// Quota evaluations fail in maintenance mode
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	if result, _ := qm.Fits(context.Background(), aw, demand, nil); result.Fits || result.Reason != quota.Maintenance {
+		t.Errorf("expected quota evaluation to fail in maintenance mode, got %v", result)
+	}
+
+	qm.ExitMaintenance()
+	if mode := qm.Mode(); mode != "Normal" {
+		t.Errorf("expected Normal mode, got %s", mode)
+	}
+}
+
+func TestQuotaManager_GetTreePriority(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.consumerSpecs["default_other"] = &qmbackendutils.JConsumerSpec{
+		ID: "default_other",
+		Trees: []qmbackendutils.JConsumerTreeSpec{
+			{ID: "default_other", TreeName: testTreeName, GroupID: "team-a", Priority: 10},
+			{ID: "default_other", TreeName: "other-tree", GroupID: "team-a", Priority: 20},
+		},
+	}
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		deadline      time.Duration
+		treeDeadlines map[string]time.Duration
+		pendingSince  time.Time
+		expected      int
+	}{
+		{name: "deadline disabled", pendingSince: now.Add(-2 * time.Hour), expected: 1},
+		{name: "waiting within deadline", deadline: time.Hour, pendingSince: now.Add(-30 * time.Minute), expected: 1},
+		{name: "waiting past deadline", deadline: time.Hour, pendingSince: now.Add(-2 * time.Hour), expected: 10},
+		{name: "waiting past tree deadline", deadline: 3 * time.Hour,
+			treeDeadlines: map[string]time.Duration{testTreeName: time.Hour}, pendingSince: now.Add(-2 * time.Hour), expected: 10},
+		{name: "tree deadline disabled", deadline: time.Hour,
+			treeDeadlines: map[string]time.Duration{testTreeName: 0}, pendingSince: now.Add(-2 * time.Hour), expected: 1},
+		{name: "unknown pending time", deadline: time.Hour, expected: 1},
+	}
+
+	for i, test := range tests {
+		qm.allocationDeadline = test.deadline
+		qm.treeAllocationDeadlines = test.treeDeadlines
+		aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+		aw.CreationTimestamp = metav1.NewTime(test.pendingSince)
+		if priority := qm.getTreePriority(aw, "default_aw", testTreeName, 1, now); priority != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, priority)
+		}
+	}
+
+	// The first time the controller saw the AppWrapper takes precedence over its creation
+	qm.allocationDeadline = time.Hour
+	qm.treeAllocationDeadlines = nil
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	aw.CreationTimestamp = metav1.NewTime(now.Add(-2 * time.Hour))
+	aw.Status.ControllerFirstTimestamp = metav1.NewMicroTime(now.Add(-30 * time.Minute))
+	if priority := qm.getTreePriority(aw, "default_aw", testTreeName, 1, now); priority != 1 {
+		t.Errorf("expected priority 1 within deadline since the controller first saw the AppWrapper, got %d", priority)
+	}
+
+	for _, invalid := range []string{"-1", "abc", "=60", "tree=60=1"} {
+		if _, _, err := (&options.ServerOption{QuotaAllocationDeadline: invalid}).QuotaAllocationDeadlineTable(); err == nil {
+			t.Errorf("expected error for quota allocation deadline %q", invalid)
+		}
+	}
+}
+
+func TestQuotaManager_GetYoungPreemptionTargets(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.minPreemptionAge = 10 * time.Minute
+	now := time.Now()
+
+	young := buildDispatchedAppWrapper("young", now.Add(-time.Minute))
+	old := buildDispatchedAppWrapper("old", now.Add(-time.Hour))
+	notDispatched := buildAppWrapper("not-dispatched", map[string]string{testTreeName: "team-a"})
+
+	got := qm.getYoungPreemptionTargets([]*arbv1.AppWrapper{young, old, notDispatched}, now)
+	if expected := []*arbv1.AppWrapper{young}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("young preemption targets: \n expected %v, \n got %v \n", expected, got)
+	}
+
+	qm.minPreemptionAge = 0
+	if got := qm.getYoungPreemptionTargets([]*arbv1.AppWrapper{young}, now); len(got) != 0 {
+		t.Errorf("expected no young preemption targets when disabled, got %v", got)
+	}
+}
+
+// treeQuotasProvider is a ResourcePlanProvider with fixed tree quotas.
+type treeQuotasProvider struct {
+	*rpmanager.ResourcePlanManager
+	treeQuotas map[string]map[string]int
+}
+
+func (p *treeQuotasProvider) GetTreeQuotas() map[string]map[string]int {
+	return p.treeQuotas
+}
+
+func TestQuotaManager_GetPreemptionCost(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000", "gpu": "8"}, "team-a")
+	qm.resourcePlanManager = &treeQuotasProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		treeQuotas:          map[string]map[string]int{testTreeName: {"cpu": 10000, "gpu": 8}},
+	}
+	now := time.Now()
+
+	half := buildDispatchedAppWrapper("half", now.Add(-time.Hour))
+	qm.consumerSpecs[util.CreateId("default", "half")] = buildConsumerSpec("half", map[string]int{"cpu": 5000}, "team-a")
+	gpu := buildDispatchedAppWrapper("gpu", now.Add(-10*time.Minute))
+	qm.consumerSpecs[util.CreateId("default", "gpu")] = buildConsumerSpec("gpu", map[string]int{"gpu": 2}, "team-a")
+	borrower := buildDispatchedAppWrapper("borrower", now.Add(-100*time.Second))
+	reclaimed := []*reclaimedBorrower{{
+		appWrapper:   borrower,
+		consumerSpec: buildConsumerSpec("borrower", map[string]int{"cpu": 1000}, "team-a"),
+	}}
+	bestEffort := buildDispatchedAppWrapper("best-effort", now.Add(-time.Minute))
+
+	// Shares 0.5, 0.25, 0.1 and none times seconds since dispatch
+	cost := qm.getPreemptionCost(buildConsumerSpec("aw", map[string]int{"cpu": 5000}, "team-a"),
+		[]*arbv1.AppWrapper{half, gpu, borrower, bestEffort}, reclaimed, now)
+	expected := &quota.PreemptionCost{
+		Demand:  map[string]int{"cpu": 6000, "gpu": 2},
+		Runtime: time.Hour + 10*time.Minute + 100*time.Second + time.Minute,
+		Cost:    0.5*3600 + 0.25*600 + 0.1*100,
+		Value:   0.5,
+	}
+	if cost == nil || !reflect.DeepEqual(cost.Demand, expected.Demand) || cost.Runtime != expected.Runtime ||
+		math.Abs(cost.Cost-expected.Cost) > 1e-6 || cost.Value != expected.Value {
+		t.Fatalf("preemption cost: \n expected %+v, \n got %+v \n", expected, cost)
+	}
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		expected  bool
+	}{
+		{name: "threshold disabled", threshold: 0, expected: false},
+		{name: "cost above threshold", threshold: time.Hour, expected: true},
+		{name: "cost below threshold", threshold: 2 * time.Hour, expected: false},
+	}
+	for i, test := range tests {
+		if exceeds := cost.Exceeds(test.threshold); exceeds != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, exceeds)
+		}
+	}
+
+	if cost := qm.getPreemptionCost(buildConsumerSpec("aw", map[string]int{"cpu": 5000}, "team-a"), nil, nil, now); cost != nil {
+		t.Errorf("expected no preemption cost without targets, got %+v", cost)
+	}
+	if (*quota.PreemptionCost)(nil).Exceeds(time.Second) {
+		t.Errorf("expected no preemption cost not to exceed any threshold")
+	}
+}
+
+// shrinkingTreeProvider is a ResourcePlanProvider loading the quota of the test tree into a backend.
+type shrinkingTreeProvider struct {
+	*rpmanager.ResourcePlanManager
+	backend *qmbackend.Manager
+	quota   map[string]string
+	changed bool
+}
+
+func (p *shrinkingTreeProvider) IsResplanChanged() bool {
+	return p.changed
+}
+
+func (p *shrinkingTreeProvider) LoadResourcePlansIntoBackend() error {
+	treeCache := p.backend.GetTreeCache(testTreeName)
+	treeCache.AddNodeSpec(testRootNode, qmbackendutils.JNodeSpec{Parent: "nil", Quota: p.quota, Hard: "true"})
+	treeCache.AddNodeSpec("team-a", qmbackendutils.JNodeSpec{Parent: testRootNode, Quota: p.quota, Hard: "false"})
+	p.changed = false
+	return nil
+}
+
+func (p *shrinkingTreeProvider) GetTreeQuotas() map[string]map[string]int {
+	treeQuotas := map[string]map[string]int{testTreeName: {}}
+	for resourceName, quota := range p.quota {
+		treeQuotas[testTreeName][resourceName], _ = strconv.Atoi(quota)
+	}
+	return treeQuotas
+}
+
+func TestQuotaManager_RefreshQuotaTrees(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+	for _, name := range []string{"aw-1", "aw-2"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", name, result, err)
+		}
+	}
+	provider := &shrinkingTreeProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		backend:             qm.quotaManagerBackend,
+	}
+	qm.resourcePlanManager = provider
+
+	tests := []struct {
+		name     string
+		quota    string
+		expected map[string]*clusterstateapi.Resource
+	}{
+		{
+			name:  "tree shrunk below usage",
+			quota: "5000",
+			expected: map[string]*clusterstateapi.Resource{
+				testTreeName: clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}),
+			},
+		},
+		{
+			name:     "tree grown above usage",
+			quota:    "10000",
+			expected: map[string]*clusterstateapi.Resource{},
+		},
+	}
+	for i, test := range tests {
+		provider.quota = map[string]string{"cpu": test.quota}
+		provider.changed = true
+		overSubscriptions, err := qm.RefreshQuotaTrees()
+		var consistencyErr *quota.ForestConsistencyError
+		if err != nil && !errors.As(err, &consistencyErr) {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if !reflect.DeepEqual(overSubscriptions, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, overSubscriptions)
+		}
+		expected := test.expected[testTreeName]
+		if expected == nil {
+			expected = clusterstateapi.EmptyResource()
+		}
+		if overSubscription := qm.OverSubscription(testTreeName); !reflect.DeepEqual(overSubscription, expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, expected, overSubscription)
+		}
+	}
+
+	if overSubscription := qm.OverSubscription("unknown"); !overSubscription.IsEmpty() {
+		t.Errorf("expected no over-subscription of an unknown tree, got %v", overSubscription)
+	}
+}
+
+func TestQuotaManager_ForceRefresh(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+	for _, name := range []string{"aw-1", "aw-2"} {
+		aw := buildAppWrapper(name, map[string]string{testTreeName: "team-a"})
+		if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+			t.Fatalf("expected %s to fit, got %v, err=%v", name, result, err)
+		}
+	}
+
+	// The ResourcePlans are reloaded although no change was detected
+	provider := &shrinkingTreeProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		backend:             qm.quotaManagerBackend,
+		quota:               map[string]string{"cpu": "5000"},
+	}
+	qm.resourcePlanManager = provider
+	err := qm.ForceRefresh()
+	var consistencyErr *quota.ForestConsistencyError
+	if !errors.As(err, &consistencyErr) || len(consistencyErr.UnallocatedConsumers) != 1 {
+		t.Errorf("expected a single unallocated consumer after shrinking the tree, got %v", err)
+	}
+	expected := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")})
+	if overSubscription := qm.OverSubscription(testTreeName); !reflect.DeepEqual(overSubscription, expected) {
+		t.Errorf("over-subscription: \n expected %v, \n got %v \n", expected, overSubscription)
+	}
+
+	// Reloads are serialized with the quota evaluations
+	provider.quota = map[string]string{"cpu": "10000"}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			qm.ForceRefresh()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			aw := buildAppWrapper(fmt.Sprintf("aw-concurrent-%d", i), map[string]string{testTreeName: "team-a"})
+			qm.Fits(context.Background(), aw, clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}), nil)
+		}(i)
+	}
+	wg.Wait()
+	if err := qm.ForceRefresh(); err != nil {
+		t.Errorf("unexpected error reloading the grown tree: %v", err)
+	}
+
+	if err := (&QuotaManager{}).ForceRefresh(); err == nil {
+		t.Errorf("expected error without a quota manager backend")
+	}
+}
+
+// treeNodeSpecsProvider is a ResourcePlanProvider returning fixed tree node specs.
+type treeNodeSpecsProvider struct {
+	*rpmanager.ResourcePlanManager
+	treeNodeSpecs map[string]map[string]*qmbackendutils.JNodeSpec
+}
+
+func (p *treeNodeSpecsProvider) GetTreeNodeSpecs() map[string]map[string]*qmbackendutils.JNodeSpec {
+	return p.treeNodeSpecs
+}
+
+func TestQuotaManager_Headroom(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000", "gpu": "8"}, "team-a", "team-b")
+	qm.resourcePlanManager = &treeNodeSpecsProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		treeNodeSpecs: map[string]map[string]*qmbackendutils.JNodeSpec{
+			testTreeName: {
+				testRootNode: {Parent: "nil", Quota: map[string]string{"cpu": "10000", "gpu": "8"}, Hard: "true"},
+				"team-a":     {Parent: testRootNode, Quota: map[string]string{"cpu": "6000", "gpu": "4"}, Hard: "false"},
+				"team-b":     {Parent: testRootNode, Quota: map[string]string{"cpu": "4000", "gpu": "4"}, Hard: "false"},
+			},
+		},
+	}
+
+	demands := map[string]*clusterstateapi.Resource{
+		"team-a": {MilliCPU: 2000, GPU: 1},
+		"team-b": {MilliCPU: 5000},
+	}
+	for _, group := range []string{"team-a", "team-b"} {
+		aw := buildAppWrapper("aw-"+group, map[string]string{testTreeName: group})
+		if result, err := qm.Fits(context.Background(), aw, demands[group], nil); err != nil || !result.Fits {
+			t.Fatalf("expected AppWrapper of %s to fit, got %v, err=%v", group, result, err)
+		}
+	}
+
+	tests := []struct {
+		groupId  string
+		expected *clusterstateapi.Resource
+	}{
+		{groupId: testRootNode, expected: &clusterstateapi.Resource{MilliCPU: 3000, GPU: 7}},
+		{groupId: "team-a", expected: &clusterstateapi.Resource{MilliCPU: 4000, GPU: 3}},
+		// Borrowing beyond the group quota leaves no headroom
+		{groupId: "team-b", expected: &clusterstateapi.Resource{MilliCPU: 0, GPU: 4}},
+	}
+	for i, test := range tests {
+		headroom, err := qm.Headroom(testTreeName, test.groupId)
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.groupId, err)
+		}
+		if !reflect.DeepEqual(headroom, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.groupId, test.expected, headroom)
+		}
+	}
+
+	// The headroom follows the releases
+	qm.Release(buildAppWrapper("aw-team-a", map[string]string{testTreeName: "team-a"}))
+	expected := &clusterstateapi.Resource{MilliCPU: 6000, GPU: 4}
+	if headroom, err := qm.Headroom(testTreeName, "team-a"); err != nil || !reflect.DeepEqual(headroom, expected) {
+		t.Errorf("after release: \n expected %v, \n got %v, err=%v \n", expected, headroom, err)
+	}
+
+	if _, err := qm.Headroom("unknown", "team-a"); err == nil {
+		t.Errorf("expected error for an unknown tree")
+	}
+	if _, err := qm.Headroom(testTreeName, "unknown"); err == nil {
+		t.Errorf("expected error for an unknown group")
+	}
+}
+
+func TestQuotaManager_GetQuotaDesignationRemap(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a", "team-b")
+	qm.treeRemap = map[string]string{"legacy": testTreeName}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected []QuotaGroup
+	}{
+		{
+			name:     "legacy label",
+			labels:   map[string]string{"legacy": "team-a"},
+			expected: []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-a"}},
+		},
+		{
+			name:     "renamed tree label takes precedence",
+			labels:   map[string]string{"legacy": "team-b", testTreeName: "team-a"},
+			expected: []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-a"}},
+		},
+	}
+
+	for i, test := range tests {
+		groups, _, err := qm.getQuotaDesignation(buildAppWrapper("aw", test.labels))
+		if err != nil {
+			t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+		}
+		if !reflect.DeepEqual(groups, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, groups)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaDesignationDefaultGroup(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a", "team-b")
+	qm.resourcePlanManager = &treeNodeSpecsProvider{
+		ResourcePlanManager: &rpmanager.ResourcePlanManager{},
+		treeNodeSpecs: map[string]map[string]*qmbackendutils.JNodeSpec{
+			testTreeName: {
+				testRootNode: {Parent: "nil", Quota: map[string]string{"cpu": "10000"}, Hard: "true"},
+				"team-a":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10000"}, Hard: "false"},
+				"team-b":     {Parent: testRootNode, Quota: map[string]string{"cpu": "10000"}, Hard: "false"},
+			},
+		},
+	}
+	teamA := []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-a"}}
+	teamB := []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-b"}}
+
+	tests := []struct {
+		name              string
+		defaultTree       string
+		defaultGroup      string
+		expectedInvalid   bool
+		expectedUnlabeled []QuotaGroup
+		expectedMissing   bool
+	}{
+		{
+			name:              "default group present",
+			defaultTree:       testTreeName,
+			defaultGroup:      "team-b",
+			expectedUnlabeled: teamB,
+		},
+		{
+			name:            "no default group",
+			expectedMissing: true,
+		},
+		{
+			name:            "default tree absent",
+			defaultTree:     "unknown",
+			defaultGroup:    "team-b",
+			expectedInvalid: true,
+			expectedMissing: true,
+		},
+		{
+			name:              "default group absent from the tree",
+			defaultTree:       testTreeName,
+			defaultGroup:      "team-c",
+			expectedInvalid:   true,
+			expectedUnlabeled: []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-c"}},
+		},
+	}
+
+	for i, test := range tests {
+		qm.defaultQuotaTree, qm.defaultQuotaGroup = test.defaultTree, test.defaultGroup
+		if err := qm.validateDefaultQuotaGroup(); (err != nil) != test.expectedInvalid {
+			t.Errorf("case %d (%s): \n expected invalid default %v, \n got error %v \n", i, test.name, test.expectedInvalid, err)
+		}
+
+		// AppWrappers without valid quota labels are designated the default group
+		for _, labels := range []map[string]string{{}, {"unknown": "team-a"}} {
+			groups, _, err := qm.getQuotaDesignation(buildAppWrapper("aw", labels))
+			if (err != nil) != test.expectedMissing {
+				t.Errorf("case %d (%s): \n expected missing designation %v, \n got error %v \n", i, test.name, test.expectedMissing, err)
+			}
+			if !reflect.DeepEqual(groups, test.expectedUnlabeled) {
+				t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expectedUnlabeled, groups)
+			}
+		}
+
+		// Quota labels take precedence over the default group
+		groups, _, err := qm.getQuotaDesignation(buildAppWrapper("aw", map[string]string{testTreeName: "team-a"}))
+		if err != nil || !reflect.DeepEqual(groups, teamA) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v, err=%v \n", i, test.name, teamA, groups, err)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaDesignationUnloadedTree(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.annotationPrefix = options.DefaultQuotaAnnotationPrefix
+
+	// A tree listed without a tree cache, e.g. during a refresh, is skipped instead of panicking
+	const unloadedTreeName = "unloaded"
+	if treeCache := qm.quotaManagerBackend.GetTreeCache(unloadedTreeName); treeCache != nil {
+		t.Fatalf("expected no tree cache for tree %s", unloadedTreeName)
+	}
+	qm.treeNames = append(append([]string{}, qm.getTreeNames()...), unloadedTreeName)
+
+	for _, designation := range []string{"label", "annotation"} {
+		aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+		if designation == "label" {
+			aw.Labels[unloadedTreeName] = "team-b"
+		} else {
+			aw.Annotations = map[string]string{options.DefaultQuotaAnnotationPrefix + unloadedTreeName: "team-b"}
+		}
+
+		groups, treeNameToResourceTypes, err := qm.getQuotaDesignation(aw)
+		if err == nil {
+			t.Errorf("%s: expected missing designation error for tree %s", designation, unloadedTreeName)
+		}
+		expected := []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-a"}}
+		if !reflect.DeepEqual(groups, expected) {
+			t.Errorf("%s: \n expected %v, \n got %v \n", designation, expected, groups)
+		}
+		if _, found := treeNameToResourceTypes[unloadedTreeName]; found {
+			t.Errorf("%s: expected no resource types of tree %s", designation, unloadedTreeName)
+		}
+	}
+}
+
+func TestQuotaManager_GetQuotaDesignationAnnotations(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	qm.annotationPrefix = options.DefaultQuotaAnnotationPrefix
+	annotationKey := options.DefaultQuotaAnnotationPrefix + testTreeName
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expected    []QuotaGroup
+		expectedErr bool
+	}{
+		{
+			name:        "annotation",
+			annotations: map[string]string{annotationKey: "org/team/project"},
+			expected:    []QuotaGroup{{GroupContext: testTreeName, GroupId: "org/team/project"}},
+		},
+		{
+			name:        "annotation with fallback groups",
+			annotations: map[string]string{annotationKey: "org/team/project, org/team"},
+			expected: []QuotaGroup{
+				{GroupContext: testTreeName, GroupId: "org/team/project"},
+				{GroupContext: testTreeName, GroupId: "org/team"},
+			},
+		},
+		{
+			name:        "label takes precedence",
+			labels:      map[string]string{testTreeName: "team-a"},
+			annotations: map[string]string{annotationKey: "org/team/project"},
+			expected:    []QuotaGroup{{GroupContext: testTreeName, GroupId: "team-a"}},
+		},
+		{
+			name:        "annotation of another prefix ignored",
+			annotations: map[string]string{"example.com/" + testTreeName: "org/team/project"},
+			expectedErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		aw := buildAppWrapper("aw", test.labels)
+		aw.Annotations = test.annotations
+		groups, _, err := qm.getQuotaDesignation(aw)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("case %d (%s): expected error %t, got %v", i, test.name, test.expectedErr, err)
+		}
+		if !reflect.DeepEqual(groups, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, groups)
+		}
+	}
+}
+
+func TestQuotaManager_ResourceTreeMapping(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000", "nvidia.com/gpu": "8"}, "team-a")
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+
+	mapping, err := qm.ResourceTreeMapping(aw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resourceTypes := mapping[testTreeName]; len(resourceTypes) != 2 {
+		t.Errorf("expected cpu and gpu resource types of tree %s, got %v", testTreeName, mapping)
+	}
+
+	// The mapping is the one used to build quota requests
+	_, expected, _ := qm.getQuotaDesignation(aw)
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("resource tree mapping: \n expected %v, \n got %v \n", expected, mapping)
+	}
+
+	// Missing quota designations are reported
+	if _, err := qm.ResourceTreeMapping(buildAppWrapper("aw-unlabeled", nil)); err == nil {
+		t.Errorf("expected missing quota designation error")
+	}
+}
+
+type recordingObserver struct {
+	allocated []string
+	released  []string
+}
+
+func (o *recordingObserver) OnAllocate(awId string, result *quota.FitResult) {
+	o.allocated = append(o.allocated, awId)
+}
+
+func (o *recordingObserver) OnRelease(awId string, success bool) {
+	o.released = append(o.released, awId)
+}
+
+type panickingObserver struct{}
+
+func (o *panickingObserver) OnAllocate(awId string, result *quota.FitResult) {
+	panic("observer failure")
+}
+
+func (o *panickingObserver) OnRelease(awId string, success bool) {
+	panic("observer failure")
+}
+
+func TestQuotaManager_Observers(t *testing.T) {
+	qm := buildQuotaManager(t, map[string]string{"cpu": "10000"}, "team-a")
+	observer := &recordingObserver{}
+	qm.RegisterObserver(&panickingObserver{})
+	qm.RegisterObserver(observer)
+
+	aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+	demand := clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")})
+	if result, err := qm.Fits(context.Background(), aw, demand, nil); err != nil || !result.Fits {
+		t.Fatalf("expected %s to fit, got %v, err=%v", aw.Name, result, err)
+	}
+	if !qm.Release(aw) {
+		t.Fatalf("expected %s to be released", aw.Name)
+	}
+
+	expected := []string{util.CreateId(aw.Namespace, aw.Name)}
+	if !reflect.DeepEqual(observer.allocated, expected) {
+		t.Errorf("allocate events: \n expected %v, \n got %v \n", expected, observer.allocated)
+	}
+	if !reflect.DeepEqual(observer.released, expected) {
+		t.Errorf("release events: \n expected %v, \n got %v \n", expected, observer.released)
+	}
+}
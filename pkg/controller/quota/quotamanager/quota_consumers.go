@@ -0,0 +1,67 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"sort"
+)
+
+// sortByConsumerOrder sorts consumer IDs by registration order, consumers of unknown order last by ID.
+func sortByConsumerOrder(consumerIDs []string, consumerOrder map[string]uint64) {
+	sort.SliceStable(consumerIDs, func(i, j int) bool {
+		orderI, foundI := consumerOrder[consumerIDs[i]]
+		orderJ, foundJ := consumerOrder[consumerIDs[j]]
+		if foundI != foundJ {
+			return foundI
+		}
+		if orderI != orderJ {
+			return orderI < orderJ
+		}
+		return consumerIDs[i] < consumerIDs[j]
+	})
+}
+
+// getAlternativeTreeSpecs expands the tree specs of a consumer with fallback groups, given by the tree
+// name of each tree spec, into the alternatives to try in order of preference.  Each alternative lists the
+// indexes of its tree specs, a single tree spec per tree.  The tree specs of the first designated tree
+// vary slowest, the tree specs of a tree are tried in order.
+func getAlternativeTreeSpecs(treeNames []string) [][]int {
+	// Group the tree specs by tree, keeping the order of the designations
+	var orderedTreeNames []string
+	treeSpecIndexes := make(map[string][]int)
+	for i, treeName := range treeNames {
+		if _, found := treeSpecIndexes[treeName]; !found {
+			orderedTreeNames = append(orderedTreeNames, treeName)
+		}
+		treeSpecIndexes[treeName] = append(treeSpecIndexes[treeName], i)
+	}
+
+	alternatives := [][]int{nil}
+	for _, treeName := range orderedTreeNames {
+		var expanded [][]int
+		for _, alternative := range alternatives {
+			for _, treeSpecIndex := range treeSpecIndexes[treeName] {
+				indexes := make([]int, len(alternative), len(alternative)+1)
+				copy(indexes, alternative)
+				expanded = append(expanded, append(indexes, treeSpecIndex))
+			}
+		}
+		alternatives = expanded
+	}
+
+	return alternatives
+}
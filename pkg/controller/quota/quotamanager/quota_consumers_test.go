@@ -0,0 +1,54 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortByConsumerOrder(t *testing.T) {
+	consumerIDs := []string{"d", "c", "b", "a", "e"}
+	sortByConsumerOrder(consumerIDs, map[string]uint64{"c": 1, "a": 2, "d": 3})
+	if expected := []string{"c", "a", "d", "b", "e"}; !reflect.DeepEqual(consumerIDs, expected) {
+		t.Errorf("consumer order: \n expected %v, \n got %v \n", expected, consumerIDs)
+	}
+}
+
+func TestGetAlternativeTreeSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		treeNames []string
+		expected  [][]int
+	}{
+		{name: "no tree", treeNames: nil, expected: [][]int{nil}},
+		{name: "single group", treeNames: []string{"tree-a"}, expected: [][]int{{0}}},
+		{name: "fallback groups", treeNames: []string{"tree-a", "tree-a"}, expected: [][]int{{0}, {1}}},
+		{name: "one group per tree", treeNames: []string{"tree-a", "tree-b"}, expected: [][]int{{0, 1}}},
+		{
+			name:      "first tree varies slowest",
+			treeNames: []string{"tree-a", "tree-b", "tree-a", "tree-b"},
+			expected:  [][]int{{0, 1}, {0, 3}, {2, 1}, {2, 3}},
+		},
+	}
+
+	for i, test := range tests {
+		if alternatives := getAlternativeTreeSpecs(test.treeNames); !reflect.DeepEqual(alternatives, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, alternatives)
+		}
+	}
+}
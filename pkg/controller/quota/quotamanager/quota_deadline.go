@@ -0,0 +1,50 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"time"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+)
+
+// getPendingSince returns the time an AppWrapper started waiting for quota: the first time the
+// controller saw it, or its creation time.
+func getPendingSince(aw *arbv1.AppWrapper) time.Time {
+	if !aw.Status.ControllerFirstTimestamp.IsZero() {
+		return aw.Status.ControllerFirstTimestamp.Time
+	}
+	return aw.CreationTimestamp.Time
+}
+
+// isPastAllocationDeadline returns whether an AppWrapper waiting for quota since the given time waited
+// longer than the allocation deadline of a quota tree.  A zero deadline or pending time never passes.
+func isPastAllocationDeadline(deadline time.Duration, pendingSince time.Time, now time.Time) bool {
+	return deadline > 0 && !pendingSince.IsZero() && now.Sub(pendingSince) >= deadline
+}
+
+// getBoostedPriority returns the priority of a consumer waiting past the allocation deadline of a quota
+// tree: its priority, boosted to the highest of the priorities of the other consumers of the tree.
+func getBoostedPriority(priority int, treePriorities []int) int {
+	boosted := priority
+	for _, treePriority := range treePriorities {
+		if treePriority > boosted {
+			boosted = treePriority
+		}
+	}
+	return boosted
+}
@@ -0,0 +1,82 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsPastAllocationDeadline(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		deadline     time.Duration
+		pendingSince time.Time
+		expected     bool
+	}{
+		{name: "deadline disabled", pendingSince: now.Add(-2 * time.Hour), expected: false},
+		{name: "waiting within deadline", deadline: time.Hour, pendingSince: now.Add(-30 * time.Minute), expected: false},
+		{name: "waiting until deadline", deadline: time.Hour, pendingSince: now.Add(-time.Hour), expected: true},
+		{name: "waiting past deadline", deadline: time.Hour, pendingSince: now.Add(-2 * time.Hour), expected: true},
+		{name: "unknown pending time", deadline: time.Hour, expected: false},
+	}
+
+	for i, test := range tests {
+		if past := isPastAllocationDeadline(test.deadline, test.pendingSince, now); past != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, past)
+		}
+	}
+}
+
+func TestGetBoostedPriority(t *testing.T) {
+	tests := []struct {
+		name           string
+		treePriorities []int
+		expected       int
+	}{
+		{name: "no other consumer", treePriorities: nil, expected: 5},
+		{name: "lower priorities", treePriorities: []int{1, 4}, expected: 5},
+		{name: "highest priority", treePriorities: []int{10, 1, 20}, expected: 20},
+	}
+
+	for i, test := range tests {
+		if priority := getBoostedPriority(5, test.treePriorities); priority != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, priority)
+		}
+	}
+}
+
+func TestGetPendingSince(t *testing.T) {
+	created := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	firstSeen := created.Add(time.Hour)
+
+	aw := buildAppWrapper("aw", nil)
+	aw.CreationTimestamp = metav1.NewTime(created)
+	if pendingSince := getPendingSince(aw); !pendingSince.Equal(created) {
+		t.Errorf("expected pending since creation %v, got %v", created, pendingSince)
+	}
+
+	// The first time the controller saw the AppWrapper takes precedence over its creation
+	aw.Status.ControllerFirstTimestamp = metav1.NewMicroTime(firstSeen)
+	if pendingSince := getPendingSince(aw); !pendingSince.Equal(firstSeen) {
+		t.Errorf("expected pending since first seen %v, got %v", firstSeen, pendingSince)
+	}
+}
@@ -0,0 +1,106 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Maximum number of cached quota decisions, the cache is cleared when exceeded
+const FitsCacheMaxSize = 1024
+
+// fitsCacheEntry is the last quota decision of an AppWrapper that did not fit.
+type fitsCacheEntry struct {
+	// Hash of the AppWrapper demand the decision was made for
+	demandHash string
+	// Forest generation the decision was made in
+	generation uint64
+	result     *quota.FitResult
+}
+
+// matches returns whether a cached quota decision was made for the given demand in the given forest
+// generation.
+func (entry *fitsCacheEntry) matches(demandHash string, generation uint64) bool {
+	return entry.demandHash == demandHash && entry.generation == generation
+}
+
+// getDemandHash returns a hash of the quota demand of an AppWrapper: the resource demands, the labels and
+// annotations designating the quota groups, the AppWrapper generation and the proposed preemptions.  Only
+// the annotations with the given prefix are hashed, and the quota exemption when exemptions are allowed.
+func getDemandHash(aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper, annotationPrefix string, allowQuotaExemption bool) string {
+	hash := fnv.New64a()
+
+	fmt.Fprintf(hash, "generation:%d\n", aw.Generation)
+	var labelKeys []string
+	for labelKey := range aw.Labels {
+		labelKeys = append(labelKeys, labelKey)
+	}
+	sort.Strings(labelKeys)
+	for _, labelKey := range labelKeys {
+		fmt.Fprintf(hash, "label:%s=%s\n", labelKey, aw.Labels[labelKey])
+	}
+	if len(annotationPrefix) > 0 {
+		var annotationKeys []string
+		for annotationKey := range aw.Annotations {
+			if strings.HasPrefix(annotationKey, annotationPrefix) {
+				annotationKeys = append(annotationKeys, annotationKey)
+			}
+		}
+		sort.Strings(annotationKeys)
+		for _, annotationKey := range annotationKeys {
+			fmt.Fprintf(hash, "annotation:%s=%s\n", annotationKey, aw.Annotations[annotationKey])
+		}
+	}
+	if allowQuotaExemption {
+		fmt.Fprintf(hash, "exempt:%t\n", quota.IsExempt(aw))
+	}
+
+	if awResDemands != nil {
+		fmt.Fprintf(hash, "cpu:%v\nmemory:%v\ngpu:%d\ngpu-memory:%d\n", awResDemands.MilliCPU,
+			awResDemands.Memory, awResDemands.GPU, awResDemands.GPUMemory)
+		var scalarNames []string
+		for scalarName := range awResDemands.ScalarResources {
+			scalarNames = append(scalarNames, string(scalarName))
+		}
+		sort.Strings(scalarNames)
+		for _, scalarName := range scalarNames {
+			fmt.Fprintf(hash, "scalar:%s=%v\n", scalarName, awResDemands.ScalarResources[v1.ResourceName(scalarName)])
+		}
+	}
+
+	var preemptionIds []string
+	for _, preemptedAW := range proposedPreemptions {
+		preemptionIds = append(preemptionIds, util.CreateId(preemptedAW.Namespace, preemptedAW.Name))
+	}
+	sort.Strings(preemptionIds)
+	for _, preemptionId := range preemptionIds {
+		fmt.Fprintf(hash, "preempt:%s\n", preemptionId)
+	}
+
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
@@ -0,0 +1,153 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"testing"
+
+	arbv1 "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/apis/controller/v1beta1"
+	clusterstateapi "github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/clusterstate/api"
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGetDemandHash(t *testing.T) {
+	const annotationPrefix = "quota.example.com/"
+	cpuDemand := func(cpu string) *clusterstateapi.Resource {
+		return clusterstateapi.NewResource(v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)})
+	}
+	build := func(mutate func(aw *arbv1.AppWrapper)) *arbv1.AppWrapper {
+		aw := buildAppWrapper("aw", map[string]string{testTreeName: "team-a"})
+		aw.Annotations = map[string]string{annotationPrefix + "tree": "team-a", "other": "value"}
+		if mutate != nil {
+			mutate(aw)
+		}
+		return aw
+	}
+	baseline := getDemandHash(build(nil), cpuDemand("1"), []*arbv1.AppWrapper{buildAppWrapper("a", nil),
+		buildAppWrapper("b", nil)}, annotationPrefix, true)
+
+	tests := []struct {
+		name                string
+		aw                  *arbv1.AppWrapper
+		demand              *clusterstateapi.Resource
+		preemptions         []*arbv1.AppWrapper
+		allowQuotaExemption bool
+		expected            bool
+	}{
+		{
+			name:                "same demand, preemptions reordered",
+			aw:                  build(nil),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("b", nil), buildAppWrapper("a", nil)},
+			allowQuotaExemption: true,
+			expected:            true,
+		},
+		{
+			name:                "annotation without prefix changed",
+			aw:                  build(func(aw *arbv1.AppWrapper) { aw.Annotations["other"] = "changed" }),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            true,
+		},
+		{
+			name:                "resource demand changed",
+			aw:                  build(nil),
+			demand:              cpuDemand("500m"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+		{
+			name:                "quota label changed",
+			aw:                  build(func(aw *arbv1.AppWrapper) { aw.Labels[testTreeName] = "team-b" }),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+		{
+			name:                "prefixed annotation changed",
+			aw:                  build(func(aw *arbv1.AppWrapper) { aw.Annotations[annotationPrefix+"tree"] = "team-b" }),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+		{
+			name:                "generation changed",
+			aw:                  build(func(aw *arbv1.AppWrapper) { aw.Generation = 2 }),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+		{
+			name:                "quota exemption changed",
+			aw:                  build(func(aw *arbv1.AppWrapper) { aw.Annotations[quota.ExemptAnnotation] = "true" }),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil), buildAppWrapper("b", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+		{
+			name:                "proposed preemptions changed",
+			aw:                  build(nil),
+			demand:              cpuDemand("1"),
+			preemptions:         []*arbv1.AppWrapper{buildAppWrapper("a", nil)},
+			allowQuotaExemption: true,
+			expected:            false,
+		},
+	}
+
+	for i, test := range tests {
+		hash := getDemandHash(test.aw, test.demand, test.preemptions, annotationPrefix, test.allowQuotaExemption)
+		if equal := hash == baseline; equal != test.expected {
+			t.Errorf("case %d (%s): \n expected same hash %v, \n got %v \n", i, test.name, test.expected, equal)
+		}
+	}
+
+	// The quota exemption is not part of the demand when exemptions are not allowed
+	exempt := build(func(aw *arbv1.AppWrapper) { aw.Annotations[quota.ExemptAnnotation] = "true" })
+	if getDemandHash(build(nil), cpuDemand("1"), nil, annotationPrefix, false) !=
+		getDemandHash(exempt, cpuDemand("1"), nil, annotationPrefix, false) {
+		t.Errorf("expected the quota exemption to be ignored when exemptions are not allowed")
+	}
+}
+
+func TestFitsCacheEntry_Matches(t *testing.T) {
+	entry := &fitsCacheEntry{demandHash: "hash", generation: 3, result: &quota.FitResult{Reason: quota.QuotaExceeded}}
+
+	tests := []struct {
+		name       string
+		demandHash string
+		generation uint64
+		expected   bool
+	}{
+		{name: "same demand and generation", demandHash: "hash", generation: 3, expected: true},
+		{name: "changed demand", demandHash: "other", generation: 3, expected: false},
+		{name: "changed forest", demandHash: "hash", generation: 4, expected: false},
+	}
+
+	for i, test := range tests {
+		if matches := entry.matches(test.demandHash, test.generation); matches != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, matches)
+		}
+	}
+}
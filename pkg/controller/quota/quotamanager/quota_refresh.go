@@ -0,0 +1,46 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// Initial and maximum delay before retrying a failed refresh of the quota trees
+	RefreshBackoffInitial = 1 * time.Second
+	RefreshBackoffMax     = 5 * time.Minute
+
+	// Maximum jitter factor added to the refresh retry delay
+	RefreshBackoffJitter = 0.5
+)
+
+// refreshBackoff returns the delay before retrying the refresh of the quota trees after the given
+// number of consecutive failures: an exponentially growing, jittered delay capped at RefreshBackoffMax.
+func refreshBackoff(failures int) time.Duration {
+	delay := RefreshBackoffInitial
+	for i := 1; i < failures && delay < RefreshBackoffMax; i++ {
+		delay *= 2
+	}
+	delay = wait.Jitter(delay, RefreshBackoffJitter)
+	if delay > RefreshBackoffMax {
+		delay = RefreshBackoffMax
+	}
+	return delay
+}
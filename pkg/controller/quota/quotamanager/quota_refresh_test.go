@@ -0,0 +1,41 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{failures: 1, min: RefreshBackoffInitial, max: 3 * RefreshBackoffInitial / 2},
+		{failures: 3, min: 4 * RefreshBackoffInitial, max: 6 * RefreshBackoffInitial},
+		{failures: 100, min: RefreshBackoffMax, max: RefreshBackoffMax},
+	}
+
+	for i, test := range tests {
+		delay := refreshBackoff(test.failures)
+		if delay < test.min || delay > test.max {
+			t.Errorf("case %d: %d failures: \n expected delay in [%v, %v], \n got %v \n", i, test.failures, test.min, test.max, delay)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package quotamanager
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/cmd/kar-controllers/app/options"
@@ -28,21 +29,39 @@ import (
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
 	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota/quotamanager/util"
 	"io/ioutil"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"math"
 	"net/http"
 	"net/http/httputil"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
 // QuotaManager implements a QuotaManagerInterface.
 type QuotaManager struct {
-	url 			string
-	appwrapperLister 	listersv1.AppWrapperLister
-	preemptionEnabled 	bool
+	url               string
+	appwrapperLister  listersv1.AppWrapperLister
+	preemptionEnabled bool
+	observers         quota.QuotaEventObservers
+	// Fractional millicore CPU demands are truncated instead of rounded up
+	truncateCPUDemand bool
+	// Bytes in the unit of the memory quota of the quota trees
+	memoryUnitBytes float64
+	// Order of the preemption targets of equal priority
+	preemptionOrder quota.PreemptionOrder
+	// Label selector of the AppWrappers subject to quota, nil for all AppWrappers
+	appwrapperSelector labels.Selector
+	// AppWrappers annotated as exempt from quota always fit without allocating quota
+	allowQuotaExemption bool
+	// IDs of the consumers allocated through this quota manager, the REST API does not list allocations
+	allocatedIDs      map[string]bool
+	allocatedIDsMutex sync.Mutex
 }
 
 type QuotaGroup struct {
@@ -114,17 +133,47 @@ func createId(ns string, n string) string {
 }
 
 func NewQuotaManager(dispatchedAWDemands map[string]*clusterstateapi.Resource, dispatchedAWs map[string]*arbv1.AppWrapper,
-			awJobLister listersv1.AppWrapperLister, config *rest.Config,
-				serverOptions *options.ServerOption) (*QuotaManager, error) {
+	awJobLister listersv1.AppWrapperLister, awInformer cache.SharedIndexInformer, config *rest.Config,
+	serverOptions *options.ServerOption, recorder record.EventRecorder) (*QuotaManager, error) {
 	if serverOptions.QuotaEnabled == false {
 		klog.Infof("[NewQuotaManager] Quota management is not enabled.")
 		return nil, nil
 	}
 
+	appwrapperSelector, err := serverOptions.QuotaAppWrapperLabelSelector()
+	if err != nil {
+		klog.Errorf("[NewQuotaManager] Invalid quota AppWrapper selector, err=%v", err)
+		return nil, err
+	}
+	memoryUnitBytes, err := serverOptions.QuotaMemoryUnitBytes()
+	if err != nil {
+		klog.Errorf("[NewQuotaManager] Invalid quota memory unit, err=%v", err)
+		return nil, err
+	}
+
 	qm := &QuotaManager{
 		url:                 serverOptions.QuotaRestURL,
 		appwrapperLister:    awJobLister,
 		preemptionEnabled:   serverOptions.Preemption,
+		truncateCPUDemand:   serverOptions.QuotaCPURounding == options.QuotaCPURoundingTrunc,
+		memoryUnitBytes:     memoryUnitBytes,
+		preemptionOrder:     quota.PreemptionOrder(serverOptions.PreemptionOrder),
+		appwrapperSelector:  appwrapperSelector,
+		allowQuotaExemption: serverOptions.AllowQuotaExemption,
+		allocatedIDs:        make(map[string]bool),
+	}
+	// Dispatched AppWrappers already hold quota in the quota manager
+	for _, aw := range dispatchedAWs {
+		if aw != nil {
+			qm.setAllocated(createId(aw.Namespace, aw.Name), true)
+		}
+	}
+
+	// Release the quota of deleted AppWrappers, closing the leak of AppWrappers deleted without a release
+	if serverOptions.AutoReleaseOnDelete && awInformer != nil {
+		awInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			DeleteFunc: qm.releaseDeletedAppWrapper,
+		})
 	}
 
 	return qm, nil
@@ -142,20 +191,19 @@ func (qm *QuotaManager) addChildrenNodes(parentNode TreeNode, treeIDs []string)
 	return treeIDs
 }
 
-func (qm *QuotaManager) getQuotaTreeIDs() ([]string) {
-	var treeIDs []string
-	// If a url does not exists then assume fits quota
+// DumpForest returns the root nodes of the quota trees, with the quota and the current allocation of
+// each tree node, as reported by the quota manager.
+func (qm *QuotaManager) DumpForest() ([]TreeNode, error) {
 	if len(qm.url) < 1 {
-		return treeIDs
+		return nil, fmt.Errorf("no quota manager url exists")
 	}
 
 	uri := qm.url + "/json"
 
-	klog.V(10).Infof("[getQuotaTreeIDs] Sending GET request to uri: %s", uri)
+	klog.V(10).Infof("[DumpForest] Sending GET request to uri: %s", uri)
 	req, err := http.NewRequest(http.MethodGet, uri, nil)
 	if err != nil {
-		klog.Errorf("[getQuotaTreeIDs] Fail to create client to access quota manager: %s, err=%#v.", uri, err)
-		return treeIDs
+		return nil, fmt.Errorf("failed to create client to access quota manager: %s, err=%w", uri, err)
 	}
 
 	quotaRestClient := http.Client{
@@ -163,34 +211,40 @@ func (qm *QuotaManager) getQuotaTreeIDs() ([]string) {
 	}
 	response, err := quotaRestClient.Do(req)
 	if err != nil {
-		klog.Errorf("[getQuotaTreeIDs] Fail to access quota manager: %s, err=%#v.", uri, err)
-		return treeIDs
-	} else {
+		return nil, fmt.Errorf("failed to access quota manager: %s, err=%w", uri, err)
+	}
+	defer response.Body.Close()
 
-		defer response.Body.Close()
+	klog.V(10).Infof("[DumpForest] Response from quota mananger status: %s", response.Status)
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota tree from the quota manager body: %s, err=%w", string(body), err)
+	}
 
-		body, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			klog.Errorf("[getQuotaTreeIDs] Failed to read quota tree from the quota manager body: %s, error=%#v",
-											string(body), err)
-			return treeIDs
-		}
+	var quotaTreesResponse []TreeNode
+	if err := json.Unmarshal(body, &quotaTreesResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode json from quota manager body: %s, err=%w", string(body), err)
+	}
+	return quotaTreesResponse, nil
+}
 
-		var quotaTreesResponse []TreeNode
-		if err := json.Unmarshal(body, &quotaTreesResponse); err != nil {
-			klog.Errorf("[getQuotaTreeIDs] Failed to decode json from quota manager body: %s, error=%#v", string(body), err)
-		} else {
-			// Loop over root nodes of trees and add names of each node in tree.
-			for _, treeroot := range quotaTreesResponse {
-				klog.V(6).Infof("[getQuotaTreeIDs] Quota tree response root node from quota mananger: %s", treeroot.Name)
-				treeIDs = qm.addChildrenNodes(treeroot, treeIDs)
-			}
-		}
+func (qm *QuotaManager) getQuotaTreeIDs() []string {
+	var treeIDs []string
+	// If a url does not exists then assume fits quota
+	if len(qm.url) < 1 {
+		return treeIDs
+	}
 
-		klog.V(10).Infof("[getQuotaTreeIDs] Response from quota mananger status: %s", response.Status)
-		statusCode := response.StatusCode
-		klog.V(8).Infof("[getQuotaTreeIDs] Response from quota mananger status code: %v", statusCode)
+	quotaTreesResponse, err := qm.DumpForest()
+	if err != nil {
+		klog.Errorf("[getQuotaTreeIDs] Failed to get quota trees from the quota manager, err=%#v.", err)
+		return treeIDs
+	}
 
+	// Loop over root nodes of trees and add names of each node in tree.
+	for _, treeroot := range quotaTreesResponse {
+		klog.V(6).Infof("[getQuotaTreeIDs] Quota tree response root node from quota mananger: %s", treeroot.Name)
+		treeIDs = qm.addChildrenNodes(treeroot, treeIDs)
 	}
 	return treeIDs
 }
@@ -254,23 +308,104 @@ func (qm *QuotaManager) getQuotaDesignation(aw *arbv1.AppWrapper) ([]QuotaGroup)
 	return groups
 }
 
-func (qm *QuotaManager) Fits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
-					proposedPreemptions []*arbv1.AppWrapper) (bool, []*arbv1.AppWrapper, string) {
+// Fits evaluates an AppWrapper against quota and notifies the registered observers of the result.
+func (qm *QuotaManager) Fits(ctx context.Context, aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+	if aw == nil {
+		err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+		return &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}, err
+	}
+	result, err := qm.fits(ctx, aw, awResDemands, proposedPreemptions)
+	awId := createId(aw.Namespace, aw.Name)
+	if len(qm.url) > 0 && result != nil && result.Reason == quota.Allocated {
+		qm.setAllocated(awId, true)
+	}
+	qm.observers.NotifyAllocate(awId, result)
+	return result, err
+}
+
+// setAllocated records whether the consumer with the given ID holds quota allocated through this
+// quota manager.
+func (qm *QuotaManager) setAllocated(awId string, allocated bool) {
+	qm.allocatedIDsMutex.Lock()
+	defer qm.allocatedIDsMutex.Unlock()
+	if qm.allocatedIDs == nil {
+		qm.allocatedIDs = make(map[string]bool)
+	}
+	if allocated {
+		qm.allocatedIDs[awId] = true
+	} else {
+		delete(qm.allocatedIDs, awId)
+	}
+}
+
+// isAllocated returns whether the consumer with the given ID holds quota allocated through this quota
+// manager.
+func (qm *QuotaManager) isAllocated(awId string) bool {
+	qm.allocatedIDsMutex.Lock()
+	defer qm.allocatedIDsMutex.Unlock()
+	return qm.allocatedIDs[awId]
+}
 
+func (qm *QuotaManager) fits(ctx context.Context, aw *arbv1.AppWrapper, awResDemands *clusterstateapi.Resource,
+	proposedPreemptions []*arbv1.AppWrapper) (*quota.FitResult, error) {
+
+	// Exempt AppWrappers always fit without allocating quota
+	if qm.allowQuotaExemption && quota.IsExempt(aw) {
+		klog.Infof("[Fits] Quota exemption applied to AppWrapper %s/%s.", aw.Namespace, aw.Name)
+		return &quota.FitResult{
+			Fits:    true,
+			Reason:  quota.Exempt,
+			Message: "AppWrapper is exempt from quota",
+		}, nil
+	}
 	// Handle uninitialized quota manager
 	if len(qm.url) <= 0 {
-		return true, proposedPreemptions, ""
+		if qm.allowQuotaExemption {
+			proposedPreemptions = quota.RemoveExemptTargets(proposedPreemptions)
+		}
+		return &quota.FitResult{
+			Fits:              true,
+			PreemptionTargets: proposedPreemptions,
+			Reason:            quota.Allocated,
+		}, nil
 	}
+	// AppWrappers not matching the quota AppWrapper selector always fit without allocating quota
+	if qm.appwrapperSelector != nil && !qm.appwrapperSelector.Matches(labels.Set(aw.Labels)) {
+		klog.V(4).Infof("[Fits] AppWrapper %s/%s does not match the quota AppWrapper selector, no quota applied.",
+			aw.Namespace, aw.Name)
+		return &quota.FitResult{
+			Fits:    true,
+			Reason:  quota.NotSelected,
+			Message: "AppWrapper does not match the quota AppWrapper selector, no quota applied",
+		}, nil
+	}
+	// Best-effort AppWrappers always fit without allocating quota
+	if quota.IsBestEffort(aw) {
+		klog.V(4).Infof("[Fits] Best-effort AppWrapper %s/%s bypasses quota.", aw.Namespace, aw.Name)
+		return &quota.FitResult{
+			Fits:    true,
+			Reason:  quota.BestEffort,
+			Message: "Best-effort AppWrapper bypasses quota",
+		}, nil
+	}
+
 	awId := createId(aw.Namespace, aw.Name)
 	if len(awId) <= 0 {
 		klog.Errorf("[Fits] Request failed due to invalid AppWrapper due to empty namespace: %s or name:%s.", aw.Namespace, aw.Name)
-		return false, nil, ""
+		err := fmt.Errorf("%w: empty namespace: %s or name: %s", quota.ErrInvalidAppWrapper, aw.Namespace, aw.Name)
+		return &quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()}, err
 	}
 
 	groups := qm.getQuotaDesignation(aw)
 	preemptable := qm.preemptionEnabled
-	awCPU_Demand := int(math.Trunc(awResDemands.MilliCPU))
-	awMem_Demand := int(math.Trunc(awResDemands.Memory)/1000000)
+	// Round fractional millicores up unless CPU demands are configured to be truncated
+	awCPU_Demand := int(math.Ceil(awResDemands.MilliCPU))
+	if qm.truncateCPUDemand {
+		awCPU_Demand = int(math.Trunc(awResDemands.MilliCPU))
+	}
+	// Round memory up to the quota memory unit so the demand is never under-counted
+	awMem_Demand := int(math.Ceil(awResDemands.Memory / qm.memoryUnitBytes))
 	var demand []int
 	demand = append(demand, awCPU_Demand)
 	demand = append(demand, awMem_Demand)
@@ -287,7 +422,7 @@ func (qm *QuotaManager) Fits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi
 	// If a url does not exists then assume fits quota
 	if len(qm.url) < 1 {
 		klog.V(4).Infof("[Fits] No quota manager exists, %#v meets quota by default.", awResDemands)
-		return doesFit, nil, ""
+		return &quota.FitResult{Fits: doesFit, Reason: quota.NoBackend}, nil
 	}
 
 	uri := qm.url + "/quota/alloc"
@@ -295,20 +430,31 @@ func (qm *QuotaManager) Fits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi
 	err := json.NewEncoder(buf).Encode(req)
 	if err != nil {
 		klog.Errorf("[Fits] Failed encoding of request: %v, err=%#v.", req, err)
-		return doesFit, nil, ""
+		return &quota.FitResult{Fits: doesFit, Reason: quota.InvalidRequest, Message: err.Error()}, err
 	}
 
 	var preemptIds []*arbv1.AppWrapper
 	klog.V(4).Infof("[Fits] Sending request: %v in buffer: %v, buffer size: %v, to uri: %s", req, buf, buf.Len(), uri)
-	response, err := http.Post(uri, "application/json; charset=utf-8", buf)
-	defer response.Body.Close()
-	dump, err := httputil.DumpResponse(response, true)
-	klog.V(10).Infof("[getQuotaTreeIDs] POST Response dump: %q", dump)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, buf)
+	if err != nil {
+		klog.Errorf("[Fits] Failed to create http post request for: %s, err=%#v.", awId, err)
+		return &quota.FitResult{Fits: doesFit, Reason: quota.InvalidRequest, Message: err.Error()}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	response, err := http.DefaultClient.Do(httpReq)
 
 	if err != nil {
 		klog.Errorf("[Fits] Fail to add access quotamanager: %s, err=%#v.", uri, err)
+		// Abort the request when the context is canceled
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return &quota.FitResult{Fits: doesFit, Reason: quota.Canceled, Message: ctxErr.Error()}, ctxErr
+		}
 		preemptIds = nil
 	} else {
+		defer response.Body.Close()
+		dump, _ := httputil.DumpResponse(response, true)
+		klog.V(10).Infof("[getQuotaTreeIDs] POST Response dump: %q", dump)
+
 		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
 			klog.Errorf("[Fits] Failed to read preemption Ids from the quota manager body: %s, error=%#v", string(body), err)
@@ -327,7 +473,21 @@ func (qm *QuotaManager) Fits(aw *arbv1.AppWrapper, awResDemands *clusterstateapi
 			preemptIds = qm.getAppWrappers(quotaResponse.PreemptIds)
 		}
 	}
-	return doesFit, preemptIds, ""
+
+	result := &quota.FitResult{
+		Fits:              doesFit,
+		PreemptionTargets: preemptIds,
+		Reason:            quota.QuotaExceeded,
+	}
+	if doesFit {
+		result.Reason = quota.Allocated
+		// Best-effort AppWrappers hold no quota, they are preempted when their capacity is reclaimed
+		result.PreemptionTargets = quota.AddBestEffortTargets(result.PreemptionTargets, proposedPreemptions)
+		if qm.allowQuotaExemption {
+			result.PreemptionTargets = quota.RemoveExemptTargets(result.PreemptionTargets)
+		}
+	}
+	return result, nil
 }
 
 
@@ -356,20 +516,132 @@ func  (qm *QuotaManager) getAppWrappers(preemptIds []string) []*arbv1.AppWrapper
 	if len(preemptIds) != len(aws) {
 		klog.Warningf("[getAppWrappers] Preemption list size of %d from quota manager does not match size of generated list of AppWrapper: %d", len(preemptIds), len(aws))
 	}
+	quota.SortPreemptionTargets(aws, qm.preemptionOrder, func(aw *arbv1.AppWrapper) int {
+		return int(aw.Spec.Priority)
+	}, nil)
 	return aws
 }
+
+// FitsGroup evaluates a group of AppWrappers against quota, allocating either all of them or none.
+// AppWrappers are allocated in request order and the allocations made by the group are released when
+// any of them does not fit.  AppWrappers of the group already holding quota keep their allocation.
+func (qm *QuotaManager) FitsGroup(requests []quota.FitsRequest) (*quota.GroupFitResult, error) {
+	result := &quota.GroupFitResult{
+		Fits: false,
+	}
+
+	var allocated []*arbv1.AppWrapper
+	rollback := func() {
+		for _, aw := range allocated {
+			qm.Release(aw)
+		}
+		result.PreemptionTargets = nil
+	}
+	for _, request := range requests {
+		if request.AppWrapper == nil {
+			klog.V(4).Infof("[FitsGroup] Group request without AppWrapper, releasing %d allocated AppWrappers of the group.",
+				len(allocated))
+			rollback()
+			err := fmt.Errorf("%w: no AppWrapper", quota.ErrInvalidAppWrapper)
+			result.Results = append(result.Results,
+				&quota.FitResult{Fits: false, Reason: quota.InvalidRequest, Message: err.Error()})
+			result.Message = "AppWrapper of the group is missing"
+			return result, err
+		}
+		wasAllocated := qm.isAllocated(createId(request.AppWrapper.Namespace, request.AppWrapper.Name))
+		fitResult, err := qm.Fits(context.Background(), request.AppWrapper, request.Resources, request.ProposedPreemptions)
+		result.Results = append(result.Results, fitResult)
+		if err != nil || fitResult == nil || !fitResult.Fits {
+			klog.V(4).Infof("[FitsGroup] AppWrapper %s/%s does not fit, releasing %d allocated AppWrappers of the group.",
+				request.AppWrapper.Namespace, request.AppWrapper.Name, len(allocated))
+			rollback()
+			result.Message = fmt.Sprintf("AppWrapper %s/%s of the group does not fit",
+				request.AppWrapper.Namespace, request.AppWrapper.Name)
+			return result, err
+		}
+		// Only quota allocated by this group is released on failure
+		if fitResult.Reason == quota.Allocated && len(qm.url) > 0 && !wasAllocated {
+			allocated = append(allocated, request.AppWrapper)
+		}
+		result.PreemptionTargets = append(result.PreemptionTargets, fitResult.PreemptionTargets...)
+	}
+
+	result.Fits = true
+	return result, nil
+}
+
+// Release releases the quota of an AppWrapper and returns whether quota was released, see
+// ReleaseWithResult.
 func (qm *QuotaManager) Release(aw *arbv1.AppWrapper) bool {
+	return qm.ReleaseWithResult(aw) == quota.Released
+}
+
+// ReleaseWithResult releases the quota of an AppWrapper and returns whether quota was released, no quota
+// was allocated to the AppWrapper, or the release failed.
+func (qm *QuotaManager) ReleaseWithResult(aw *arbv1.AppWrapper) quota.ReleaseResult {
 
 	// Handle uninitialized quota manager
 	if len(qm.url) <= 0 {
-		return true
+		return quota.Released
 	}
 
-	released := false
 	awId := createId(aw.Namespace, aw.Name)
 	if len(awId) <= 0 {
 		klog.Errorf("[Release] Request failed due to invalid AppWrapper due to empty namespace: %s or name:%s.", aw.Namespace, aw.Name)
-		return false
+		return quota.ReleaseError
+	}
+
+	return qm.ReleaseByIDWithResult(awId)
+}
+
+// ReleaseByID releases the quota of the consumer with the given ID, as produced by createId, e.g. to
+// clean up consumers of AppWrappers deleted while the controller was down.  The registered observers
+// are notified of the release.  Returns whether quota was released, see ReleaseByIDWithResult.
+func (qm *QuotaManager) ReleaseByID(awId string) bool {
+	return qm.ReleaseByIDWithResult(awId) == quota.Released
+}
+
+// ReleaseByIDWithResult releases the quota of the consumer with the given ID as ReleaseByID, and returns
+// whether quota was released, the quota manager reported the consumer as not found, or the release
+// failed.
+func (qm *QuotaManager) ReleaseByIDWithResult(awId string) quota.ReleaseResult {
+	result := qm.releaseByID(awId)
+	qm.observers.NotifyRelease(awId, result == quota.Released)
+	return result
+}
+
+// releaseDeletedAppWrapper is the AppWrapper delete event handler releasing the quota of the deleted
+// AppWrapper.  Releasing quota already released has no effect.
+func (qm *QuotaManager) releaseDeletedAppWrapper(obj interface{}) {
+	aw, ok := obj.(*arbv1.AppWrapper)
+	if !ok {
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if !isTombstone {
+			klog.Errorf("[releaseDeletedAppWrapper] obj is not AppWrapper. obj=%+v", obj)
+			return
+		}
+		aw, ok = tombstone.Obj.(*arbv1.AppWrapper)
+		if !ok {
+			klog.Errorf("[releaseDeletedAppWrapper] Tombstone obj is not AppWrapper. obj=%+v", tombstone.Obj)
+			return
+		}
+	}
+
+	klog.V(4).Infof("[releaseDeletedAppWrapper] Releasing quota of deleted AppWrapper %s/%s.", aw.Namespace, aw.Name)
+	qm.Release(aw)
+}
+
+func (qm *QuotaManager) releaseByID(awId string) quota.ReleaseResult {
+
+	// Handle uninitialized quota manager
+	if len(qm.url) <= 0 {
+		return quota.Released
+	}
+
+	released := quota.ReleaseError
+	if len(awId) <= 0 {
+		klog.Errorf("[ReleaseByID] Request failed due to empty consumer id.")
+		return released
 	}
 
 	uri := qm.url + "/quota/release/" + awId
@@ -405,8 +677,101 @@ func (qm *QuotaManager) Release(aw *arbv1.AppWrapper) bool {
 	statusCode := resp.StatusCode
 	klog.V(4).Infof("[Release] Response from quota mananger status code: %v", statusCode)
 	if statusCode == 204 {
-		released = true
+		released = quota.Released
+	} else if statusCode == 404 {
+		released = quota.NotFound
+	}
+	if released != quota.ReleaseError {
+		qm.setAllocated(awId, false)
 	}
 
 	return released
 }
+
+// Preempt releases the quota of each target AppWrapper and returns the targets successfully released.
+// Release failures do not stop the remaining targets from being released and are returned aggregated.
+func (qm *QuotaManager) Preempt(targets []*arbv1.AppWrapper) ([]*arbv1.AppWrapper, error) {
+	var err error
+	err = nil
+	released := []*arbv1.AppWrapper{}
+
+	for _, target := range targets {
+		if target == nil {
+			continue
+		}
+		if qm.Release(target) {
+			released = append(released, target)
+		} else if err == nil {
+			err = fmt.Errorf("quota release failed for AppWrapper %s/%s", target.Namespace, target.Name)
+		} else {
+			err = fmt.Errorf("%w; next error quota release failed for AppWrapper %s/%s",
+				err, target.Namespace, target.Name)
+		}
+	}
+
+	return released, err
+}
+
+// Healthy returns true, the health of the quota manager REST service is not tracked.
+func (qm *QuotaManager) Healthy() (bool, string) {
+	return true, ""
+}
+
+// RegisterObserver registers an observer notified of the quota allocations and releases.
+func (qm *QuotaManager) RegisterObserver(observer quota.QuotaEventObserver) {
+	qm.observers.Register(observer)
+}
+
+// ListConsumers returns the IDs of the consumers holding quota.  Listing consumers is not supported by
+// the quota manager REST API.
+func (qm *QuotaManager) ListConsumers() ([]string, error) {
+	// Handle uninitialized quota manager
+	if len(qm.url) <= 0 {
+		return []string{}, nil
+	}
+
+	return nil, fmt.Errorf("listing consumers is not supported by quota manager: %s", qm.url)
+}
+
+// FlushConsumer removes the definition of a consumer without releasing its quota.  The quota manager
+// REST API keeps no consumer definitions apart from their allocations, consumers are removed by Release.
+func (qm *QuotaManager) FlushConsumer(awId string) (bool, error) {
+	// Handle uninitialized quota manager
+	if len(qm.url) <= 0 {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("flushing consumers is not supported by quota manager: %s", qm.url)
+}
+
+// SetUnpreemptable makes the consumer of a dispatched AppWrapper unpreemptable without allocating it again.
+// The preemptability of a consumer is set by its allocation request in the quota manager REST API.
+func (qm *QuotaManager) SetUnpreemptable(awId string, unpreemptable bool) error {
+	// Handle uninitialized quota manager
+	if len(qm.url) <= 0 {
+		return nil
+	}
+
+	return fmt.Errorf("changing the preemptability of consumers is not supported by quota manager: %s", qm.url)
+}
+
+// SetClusterCapacity sets the cluster capacity the percentage quotas are computed from.  Percentage
+// quotas are not supported by the quota manager REST API.
+func (qm *QuotaManager) SetClusterCapacity(capacity *clusterstateapi.Resource) {
+}
+
+// SetGPUSharingFactor sets the number of GPU replicas advertised per physical GPU.  Physical GPU quotas
+// are not supported by the quota manager REST API.
+func (qm *QuotaManager) SetGPUSharingFactor(factor int) {
+}
+
+// VerifyConsistency compares the consumers holding quota with the dispatched AppWrappers.  Consistency
+// checks are not supported by the quota manager REST API.
+func (qm *QuotaManager) VerifyConsistency(dispatchedAWs map[string]*arbv1.AppWrapper) (*quota.DriftReport, error) {
+	// Handle uninitialized quota manager
+	if len(qm.url) <= 0 {
+		return &quota.DriftReport{}, nil
+	}
+
+	return nil, fmt.Errorf("consistency checks are not supported by quota manager: %s", qm.url)
+}
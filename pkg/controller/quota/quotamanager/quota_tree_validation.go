@@ -0,0 +1,203 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+	"k8s.io/klog/v2"
+)
+
+// Parent of the root nodes of the quota trees
+const rootParentName = "nil"
+
+// hardQuotaNode is the quota of a quota tree node checked by the hard quota rollup validation.
+type hardQuotaNode struct {
+	parent string
+	hard   bool
+	// Quota of each resource, an integer amount
+	quota map[string]string
+}
+
+// ValidateTreeNodes validates the nodes of a quota tree: node names are unique, parent references resolve
+// to a node of the tree, quota and allocation amounts are non-negative numbers and the hierarchy is
+// acyclic.  Root nodes have no parent, or the parent "nil".  The children of a node are validated as well,
+// a child without parent references the enclosing node.  Returns a quota.TreeValidationError listing every
+// problem found, nil when the nodes are valid.
+func ValidateTreeNodes(treeNodes []TreeNode) error {
+	var errs []error
+
+	// Flatten the tree, resolving the parents of children without parent references
+	parents := make(map[string]string)
+	seen := make(map[string]bool)
+	var names []string
+	var visit func(treeNode TreeNode, enclosing string)
+	visit = func(treeNode TreeNode, enclosing string) {
+		parent := treeNode.Parent
+		if len(parent) <= 0 {
+			parent = enclosing
+		}
+		switch {
+		case len(treeNode.Name) <= 0:
+			errs = append(errs, fmt.Errorf("node with parent %q has no name", parent))
+		case seen[treeNode.Name]:
+			errs = append(errs, fmt.Errorf("node %s is defined more than once", treeNode.Name))
+		default:
+			seen[treeNode.Name] = true
+			names = append(names, treeNode.Name)
+			parents[treeNode.Name] = parent
+		}
+		if len(enclosing) > 0 && len(treeNode.Parent) > 0 && treeNode.Parent != enclosing {
+			errs = append(errs, fmt.Errorf("node %s is a child of node %s but references parent %s",
+				treeNode.Name, enclosing, treeNode.Parent))
+		}
+		if err := validateTreeNodeAmounts(treeNode.Quota); err != nil {
+			errs = append(errs, fmt.Errorf("node %s quota %q: %w", treeNode.Name, treeNode.Quota, err))
+		}
+		if err := validateTreeNodeAmounts(treeNode.Allocation); err != nil {
+			errs = append(errs, fmt.Errorf("node %s allocation %q: %w", treeNode.Name, treeNode.Allocation, err))
+		}
+		for _, child := range treeNode.Children {
+			visit(child, treeNode.Name)
+		}
+	}
+	for _, treeNode := range treeNodes {
+		visit(treeNode, "")
+	}
+
+	for _, name := range names {
+		parent := parents[name]
+		if isRootParent(parent) {
+			continue
+		}
+		if !seen[parent] {
+			errs = append(errs, fmt.Errorf("node %s references nonexistent parent %s", name, parent))
+			continue
+		}
+
+		// Walk up the ancestors, bounded by the number of nodes
+		ancestor := parent
+		for i := 0; i < len(names) && !isRootParent(ancestor); i++ {
+			if ancestor == name {
+				errs = append(errs, fmt.Errorf("node %s is its own ancestor", name))
+				break
+			}
+			ancestor = parents[ancestor]
+		}
+	}
+
+	if len(errs) > 0 {
+		return &quota.TreeValidationError{Errs: errs}
+	}
+	return nil
+}
+
+// isRootParent returns whether a parent reference is the parent of a root node.
+func isRootParent(parent string) bool {
+	return len(parent) <= 0 || parent == rootParentName
+}
+
+// validateTreeNodeAmounts validates the amounts of a quota or an allocation, a list of numbers separated by
+// spaces or commas, optionally in brackets, e.g. "[1000 2048]".  An empty list is valid.
+func validateTreeNodeAmounts(amounts string) error {
+	fields := strings.FieldsFunc(strings.Trim(strings.TrimSpace(amounts), "[]"), func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, field := range fields {
+		amount, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("%s is not a number", field)
+		}
+		if amount < 0 {
+			return fmt.Errorf("%s is negative", field)
+		}
+	}
+	return nil
+}
+
+// validateHardQuotaRollup walks each quota tree from the leaves to the roots and returns the hard quota
+// nodes, formatted as <tree name>/<node name>, whose hard quota children add up to more than the node
+// quota for any resource.
+func validateHardQuotaRollup(treeNodes map[string]map[string]*hardQuotaNode) []string {
+	var overCommittedNodes []string
+	for treeName, nodes := range treeNodes {
+		var rootNodes []string
+		childNodes := make(map[string][]string)
+		for nodeName, node := range nodes {
+			if _, found := nodes[node.parent]; found {
+				childNodes[node.parent] = append(childNodes[node.parent], nodeName)
+			} else {
+				rootNodes = append(rootNodes, nodeName)
+			}
+		}
+		for _, rootNode := range rootNodes {
+			overCommittedNodes = addOverCommittedNodes(treeName, rootNode, nodes, childNodes, overCommittedNodes)
+		}
+	}
+	sort.Strings(overCommittedNodes)
+	return overCommittedNodes
+}
+
+// Recursive call to add names of hard quota nodes over committed by their children
+func addOverCommittedNodes(treeName string, nodeName string, nodes map[string]*hardQuotaNode,
+	childNodes map[string][]string, overCommittedNodes []string) []string {
+	for _, childNode := range childNodes[nodeName] {
+		overCommittedNodes = addOverCommittedNodes(treeName, childNode, nodes, childNodes, overCommittedNodes)
+	}
+
+	node := nodes[nodeName]
+	if !node.hard {
+		return overCommittedNodes
+	}
+
+	childrenQuota := make(map[string]int)
+	for _, childNode := range childNodes[nodeName] {
+		child := nodes[childNode]
+		if !child.hard {
+			continue
+		}
+		for resourceName, quantity := range child.quota {
+			value, err := strconv.Atoi(quantity)
+			if err != nil {
+				klog.Warningf("[addOverCommittedNodes] Invalid %s quota %s of tree node %s/%s ignored.",
+					resourceName, quantity, treeName, childNode)
+				continue
+			}
+			childrenQuota[resourceName] += value
+		}
+	}
+
+	var resourceNames []string
+	for resourceName := range childrenQuota {
+		resourceNames = append(resourceNames, resourceName)
+	}
+	sort.Strings(resourceNames)
+	for _, resourceName := range resourceNames {
+		// A resource missing from the node quota counts as zero
+		value, _ := strconv.Atoi(node.quota[resourceName])
+		if childrenQuota[resourceName] > value {
+			klog.Errorf("[addOverCommittedNodes] Hard %s quota %d of tree node %s/%s is exceeded by the sum %d of its children hard quotas.",
+				resourceName, value, treeName, nodeName, childrenQuota[resourceName])
+			return append(overCommittedNodes, treeName+"/"+nodeName)
+		}
+	}
+	return overCommittedNodes
+}
@@ -0,0 +1,184 @@
+// ------------------------------------------------------ {COPYRIGHT-TOP} ---
+// Copyright 2022 The Multi-Cluster App Dispatcher Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// ------------------------------------------------------ {COPYRIGHT-END} ---
+
+package quotamanager
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/project-codeflare/multi-cluster-app-dispatcher/pkg/controller/quota"
+)
+
+func TestValidateTreeNodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		treeNodes []TreeNode
+		expected  int
+	}{
+		{
+			name: "valid tree",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[10 64]", Allocation: "[3 12]",
+					Children: []TreeNode{
+						{Name: "team-a", Quota: "[6 32]"},
+						{Name: "team-b", Parent: "root", Quota: "[4 32]"},
+					},
+				},
+			},
+			expected: 0,
+		},
+		{
+			name: "valid flat tree",
+			treeNodes: []TreeNode{
+				{Name: "team-a", Parent: "root", Quota: "[6]"},
+				{Name: "root", Parent: "nil", Quota: "[10]"},
+			},
+			expected: 0,
+		},
+		{
+			name: "duplicate node names",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[10]"},
+				{Name: "team-a", Parent: "root", Quota: "[6]"},
+				{Name: "team-a", Parent: "root", Quota: "[4]"},
+			},
+			expected: 1,
+		},
+		{
+			name: "nonexistent parent",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[10]"},
+				{Name: "team-a", Parent: "team-x", Quota: "[6]"},
+			},
+			expected: 1,
+		},
+		{
+			name: "invalid amounts",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[10 ten]", Allocation: "[-1 0]"},
+			},
+			expected: 2,
+		},
+		{
+			name: "cyclic parents",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[10]"},
+				{Name: "team-a", Parent: "team-b", Quota: "[6]"},
+				{Name: "team-b", Parent: "team-a", Quota: "[4]"},
+			},
+			expected: 2,
+		},
+		{
+			name: "every problem listed",
+			treeNodes: []TreeNode{
+				{Name: "root", Parent: "nil", Quota: "[x]",
+					Children: []TreeNode{
+						{Name: "root", Quota: "[1]"},
+						{Name: "team-a", Parent: "team-b", Quota: "[1]"},
+					},
+				},
+			},
+			expected: 4,
+		},
+	}
+
+	for i, test := range tests {
+		err := ValidateTreeNodes(test.treeNodes)
+		var validationErr *quota.TreeValidationError
+		if test.expected == 0 {
+			if err != nil {
+				t.Errorf("case %d (%s): unexpected error: %v", i, test.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &validationErr) {
+			t.Errorf("case %d (%s): \n expected a TreeValidationError, \n got %v \n", i, test.name, err)
+			continue
+		}
+		if len(validationErr.Errs) != test.expected {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, validationErr.Errs)
+		}
+	}
+}
+
+func TestValidateHardQuotaRollup(t *testing.T) {
+	node := func(parent string, cpu string, hard bool) *hardQuotaNode {
+		return &hardQuotaNode{parent: parent, quota: map[string]string{"cpu": cpu}, hard: hard}
+	}
+
+	tests := []struct {
+		name     string
+		nodes    map[string]*hardQuotaNode
+		expected []string
+	}{
+		{
+			name: "children within hard parent quota",
+			nodes: map[string]*hardQuotaNode{
+				"root":   node("nil", "10", true),
+				"team-a": node("root", "6", true),
+				"team-b": node("root", "4", true),
+			},
+			expected: nil,
+		},
+		{
+			name: "hard children exceed hard parent quota",
+			nodes: map[string]*hardQuotaNode{
+				"root":   node("nil", "10", true),
+				"team-a": node("root", "6", true),
+				"team-b": node("root", "5", true),
+			},
+			expected: []string{testTreeName + "/root"},
+		},
+		{
+			name: "soft children ignored",
+			nodes: map[string]*hardQuotaNode{
+				"root":   node("nil", "10", true),
+				"team-a": node("root", "10", false),
+				"team-b": node("root", "10", false),
+			},
+			expected: nil,
+		},
+		{
+			name: "soft parent ignored",
+			nodes: map[string]*hardQuotaNode{
+				"root":   node("nil", "10", false),
+				"team-a": node("root", "6", true),
+				"team-b": node("root", "5", true),
+			},
+			expected: nil,
+		},
+		{
+			name: "nested nodes exceed quota",
+			nodes: map[string]*hardQuotaNode{
+				"root":    node("nil", "10", true),
+				"team-a":  node("root", "4", true),
+				"team-a1": node("team-a", "3", true),
+				"team-a2": node("team-a", "3", true),
+				"team-b":  node("root", "8", true),
+			},
+			expected: []string{testTreeName + "/root", testTreeName + "/team-a"},
+		},
+	}
+
+	for i, test := range tests {
+		result := validateHardQuotaRollup(map[string]map[string]*hardQuotaNode{testTreeName: test.nodes})
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("case %d (%s): \n expected %v, \n got %v \n", i, test.name, test.expected, result)
+		}
+	}
+}